@@ -2,16 +2,64 @@
 package ai
 
 import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/jery0843/torforge/internal/bypass"
+	"github.com/jery0843/torforge/pkg/logger"
 )
 
+// aiDataMagic prefixes encrypted data files so loadData can tell them
+// apart from legacy plaintext JSON
+var aiDataMagic = []byte("TFAI\x01")
+
+// aiDataVersion is the current schema version of the persisted data files.
+// v0 was a bare profile map without an envelope, v1 introduced the
+// envelope, v2 added the routing-stat counters, v3 replaced the
+// "counter = 100" user-pin hack with the explicit UserPolicy field.
+const aiDataVersion = 3
+
+// errDataTooNew is returned when a data file was written by a newer
+// TorForge than the one reading it
+var errDataTooNew = errors.New("data file version newer than supported")
+
+// profilesEnvelope wraps app_profiles.json with a schema version so old
+// files can be migrated instead of silently dropping fields
+type profilesEnvelope struct {
+	Version  int                    `json:"version"`
+	Profiles map[string]*AppProfile `json:"profiles"`
+}
+
+// domainsEnvelope wraps custom_domains.json the same way
+type domainsEnvelope struct {
+	Version   int             `json:"version"`
+	Sensitive map[string]bool `json:"sensitive"`
+	Speed     map[string]bool `json:"speed"`
+}
+
+// defaultMaxProfiles caps the appProfiles map so short-lived build tools
+// and scripts don't accumulate profiles forever
+const defaultMaxProfiles = 500
+
+// defaultMinBucketObs is the minimum observations an hourly bucket needs
+// before hour-aware decisions trust it over the global ratios
+const defaultMinBucketObs = 10
+
 // AppCategory represents the sensitivity category of an application
 type AppCategory int
 
@@ -23,6 +71,15 @@ const (
 	CategoryUserDefined             // Manually configured by user
 )
 
+// UserPolicy records the explicit routing choice behind a
+// CategoryUserDefined profile
+type UserPolicy string
+
+const (
+	UserPolicyTor    UserPolicy = "tor"
+	UserPolicyDirect UserPolicy = "direct"
+)
+
 func (c AppCategory) String() string {
 	switch c {
 	case CategoryAlwaysTor:
@@ -50,6 +107,26 @@ type AppProfile struct {
 	SpeedSensitive    int      `json:"speed_sensitive"`    // E.g., streaming, large downloads
 	Domains           []string `json:"domains"`            // Domains accessed
 
+	// Routing statistics (missing in old data files; zero values are fine)
+	TorConnections    int   `json:"tor_connections,omitempty"`
+	DirectConnections int   `json:"direct_connections,omitempty"`
+	TorBytes          int64 `json:"tor_bytes,omitempty"`
+	DirectBytes       int64 `json:"direct_bytes,omitempty"`
+
+	// Hourly observation histograms, indexed by hour of day. These let
+	// an app be treated as sensitive during work hours but speed-critical
+	// in the evening once enough per-bucket data exists.
+	HourlyTotal     [24]int `json:"hourly_total"`
+	HourlySensitive [24]int `json:"hourly_sensitive"`
+	HourlySpeed     [24]int `json:"hourly_speed"`
+
+	// User pinning provenance, set by SetAppCategory for
+	// CategoryUserDefined profiles
+	UserPolicy UserPolicy `json:"user_policy,omitempty"`
+	Note       string     `json:"note,omitempty"`
+	SetBy      string     `json:"set_by,omitempty"`
+	SetAt      time.Time  `json:"set_at,omitzero"`
+
 	// Confidence score (0-1)
 	Confidence  float64   `json:"confidence"`
 	LastUpdated time.Time `json:"last_updated"`
@@ -70,36 +147,200 @@ type SplitTunnelAI struct {
 	dataDir             string
 	learningEnabled     bool
 	confidenceThreshold float64
+	maxProfiles         int
+
+	// Hour-of-day awareness
+	hourAware    bool
+	minBucketObs int
+	now          func() time.Time // Injectable for tests
+
+	// Optional custom classifier; nil means the built-in heuristic
+	classifier Classifier
+
+	// Normalizes raw process identity into a stable profile key
+	identify AppIdentifier
 
 	// Sensitive domains (always Tor)
 	sensitiveDomains map[string]bool
 
 	// Speed domains (prefer direct)
 	speedDomains map[string]bool
+
+	// Optional at-rest encryption for persisted data files
+	dataCipher cipher.AEAD
+
+	// Category-change subscribers
+	subscribers   map[int]chan CategoryChangeEvent
+	nextSubID     int
+	droppedEvents int64
+
+	// Non-blocking ingest path for callers in the packet path
+	ingestCh       chan ConnectionRecord
+	ingestStop     chan struct{}
+	ingestDone     chan struct{} // Closed when ingestLoop exits
+	closeOnce      sync.Once
+	saveWG         sync.WaitGroup // Tracks in-flight async saveData calls
+	droppedRecords int64          // atomic
+}
+
+// CategoryChangeEvent notifies subscribers when an app's routing category
+// changes, e.g. when it crosses the confidence threshold and flips from
+// learning to direct. Routing behavior changes silently otherwise, which
+// is scary for a privacy tool.
+type CategoryChangeEvent struct {
+	App         string
+	OldCategory AppCategory
+	NewCategory AppCategory
+	Confidence  float64
+	Trigger     string // "learned" or "user"
 }
 
 // NewSplitTunnelAI creates a new split tunnel intelligence system
 func NewSplitTunnelAI(dataDir string) *SplitTunnelAI {
+	ai, _ := NewSplitTunnelAIWithKey(dataDir, nil)
+	return ai
+}
+
+// NewSplitTunnelAIWithKey creates a split tunnel system that encrypts its
+// persisted data (app profiles are effectively a browsing-history summary)
+// with AES-256-GCM. The key may be any length; it is hashed to 256 bits so
+// passphrases work too. A nil key disables encryption.
+func NewSplitTunnelAIWithKey(dataDir string, key []byte) (*SplitTunnelAI, error) {
 	ai := &SplitTunnelAI{
 		appProfiles:         make(map[string]*AppProfile),
 		dataDir:             dataDir,
 		learningEnabled:     true,
 		confidenceThreshold: 0.7,
+		maxProfiles:         defaultMaxProfiles,
 		sensitiveDomains:    make(map[string]bool),
 		speedDomains:        make(map[string]bool),
+		subscribers:         make(map[int]chan CategoryChangeEvent),
+		ingestCh:            make(chan ConnectionRecord, ingestBufferSize),
+		ingestStop:          make(chan struct{}),
+		ingestDone:          make(chan struct{}),
+		minBucketObs:        defaultMinBucketObs,
+		now:                 time.Now,
+		identify:            DefaultAppIdentifier,
 	}
 
-	// Initialize known patterns
+	if len(key) > 0 {
+		derived := sha256.Sum256(key)
+		block, err := aes.NewCipher(derived[:])
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		ai.dataCipher = aead
+	}
+
+	// Initialize known patterns, then apply any patterns.d overrides
 	ai.initPatterns()
+	if errs := ai.reloadPatternsLocked(); len(errs) > 0 {
+		log := logger.WithComponent("split-tunnel")
+		for _, err := range errs {
+			log.Warn().Err(err).Msg("invalid pattern definition")
+		}
+	}
 
 	// Load saved data
-	ai.loadData()
+	if err := ai.loadData(); err != nil {
+		return nil, err
+	}
 
-	return ai
+	go ai.ingestLoop()
+
+	return ai, nil
+}
+
+// Close stops the background ingest worker. Records still buffered are
+// dropped; callers that need them flushed should stop producing first.
+func (ai *SplitTunnelAI) Close() {
+	ai.closeOnce.Do(func() {
+		close(ai.ingestStop)
+	})
+	// Wait for the worker to exit and for any in-flight background
+	// saves so callers can safely remove the data directory afterwards
+	<-ai.ingestDone
+	ai.saveWG.Wait()
+}
+
+// sealData encrypts a data file payload when a key is configured,
+// prefixing the magic header so loadData can detect the format
+func (ai *SplitTunnelAI) sealData(plaintext []byte) ([]byte, error) {
+	if ai.dataCipher == nil {
+		return plaintext, nil
+	}
+
+	nonce := make([]byte, ai.dataCipher.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(aiDataMagic)+len(nonce)+len(plaintext)+16)
+	out = append(out, aiDataMagic...)
+	out = append(out, nonce...)
+	out = ai.dataCipher.Seal(out, nonce, plaintext, nil)
+	return out, nil
+}
+
+// openData decrypts a data file if it carries the encrypted-format magic,
+// and passes legacy plaintext files through unchanged
+func (ai *SplitTunnelAI) openData(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, aiDataMagic) {
+		// Legacy plaintext file; it will be migrated on the next save
+		return data, nil
+	}
+
+	if ai.dataCipher == nil {
+		return nil, fmt.Errorf("data file is encrypted but no key was provided")
+	}
+
+	data = data[len(aiDataMagic):]
+	nonceSize := ai.dataCipher.NonceSize()
+	if len(data) < nonceSize {
+		return nil, fmt.Errorf("encrypted data file too short")
+	}
+
+	plaintext, err := ai.dataCipher.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt data file (wrong key?): %w", err)
+	}
+	return plaintext, nil
 }
 
 // initPatterns sets up known sensitive and speed patterns
 func (ai *SplitTunnelAI) initPatterns() {
+	ai.initBuiltinPatterns()
+
+	// Known sensitive domains
+	ai.sensitiveDomains = map[string]bool{
+		"protonmail.com":  true,
+		"tutanota.com":    true,
+		"signal.org":      true,
+		"duckduckgo.com":  true,
+		"privacytools.io": true,
+		"torproject.org":  true,
+		"eff.org":         true,
+	}
+
+	// Known speed domains
+	ai.speedDomains = map[string]bool{
+		"youtube.com":      true,
+		"googlevideo.com":  true,
+		"netflix.com":      true,
+		"nflxvideo.net":    true,
+		"spotify.com":      true,
+		"steampowered.com": true,
+		"steamcontent.com": true,
+		"akamaized.net":    true,
+	}
+}
+
+// initBuiltinPatterns compiles the built-in regex lists
+func (ai *SplitTunnelAI) initBuiltinPatterns() {
 	// Sensitive patterns - ALWAYS through Tor
 	sensitivePatternStrs := []string{
 		`\.onion$`,                   // Onion sites
@@ -136,105 +377,394 @@ func (ai *SplitTunnelAI) initPatterns() {
 			ai.speedPatterns = append(ai.speedPatterns, re)
 		}
 	}
+}
 
-	// Known sensitive domains
-	ai.sensitiveDomains = map[string]bool{
-		"protonmail.com":  true,
-		"tutanota.com":    true,
-		"signal.org":      true,
-		"duckduckgo.com":  true,
-		"privacytools.io": true,
-		"torproject.org":  true,
-		"eff.org":         true,
+// PatternDef defines one pattern in a dataDir/patterns.d/*.json file.
+// Weight is parsed for forward compatibility but not yet used in
+// matching.
+type PatternDef struct {
+	Name   string  `json:"name"`
+	Regex  string  `json:"regex"`
+	Class  string  `json:"class"` // "sensitive" or "speed"
+	Weight float64 `json:"weight,omitempty"`
+}
+
+// patternsFile is the on-disk layout of a patterns.d file. Replace drops
+// the built-in lists instead of merging with them.
+type patternsFile struct {
+	Replace  bool         `json:"replace,omitempty"`
+	Patterns []PatternDef `json:"patterns"`
+}
+
+// ReloadPatterns re-reads dataDir/patterns.d/*.json so the daemon picks
+// up edits at runtime. Invalid entries are reported in the returned
+// list; valid ones still apply.
+func (ai *SplitTunnelAI) ReloadPatterns() []error {
+	ai.mu.Lock()
+	defer ai.mu.Unlock()
+	return ai.reloadPatternsLocked()
+}
+
+// reloadPatternsLocked rebuilds the pattern lists from the built-ins
+// plus any patterns.d files. The caller holds the write lock.
+func (ai *SplitTunnelAI) reloadPatternsLocked() []error {
+	files, _ := filepath.Glob(filepath.Join(ai.dataDir, "patterns.d", "*.json"))
+	sort.Strings(files)
+
+	var errs []error
+	replace := false
+	var sensitive, speed []*regexp.Regexp
+
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f, err))
+			continue
+		}
+
+		var pf patternsFile
+		if err := json.Unmarshal(data, &pf); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f, err))
+			continue
+		}
+		if pf.Replace {
+			replace = true
+		}
+
+		for _, def := range pf.Patterns {
+			re, err := regexp.Compile(def.Regex)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: pattern %q: %w", f, def.Name, err))
+				continue
+			}
+			switch def.Class {
+			case "sensitive":
+				sensitive = append(sensitive, re)
+			case "speed":
+				speed = append(speed, re)
+			default:
+				errs = append(errs, fmt.Errorf("%s: pattern %q: unknown class %q", f, def.Name, def.Class))
+			}
+		}
 	}
 
-	// Known speed domains
-	ai.speedDomains = map[string]bool{
-		"youtube.com":      true,
-		"googlevideo.com":  true,
-		"netflix.com":      true,
-		"nflxvideo.net":    true,
-		"spotify.com":      true,
-		"steampowered.com": true,
-		"steamcontent.com": true,
-		"akamaized.net":    true,
+	// Rebuild: built-ins first unless a file asked to replace them
+	ai.sensitivePatterns = nil
+	ai.speedPatterns = nil
+	if !replace {
+		ai.initBuiltinPatterns()
 	}
+	ai.sensitivePatterns = append(ai.sensitivePatterns, sensitive...)
+	ai.speedPatterns = append(ai.speedPatterns, speed...)
+
+	return errs
+}
+
+// DecisionStep records one check performed while deciding a route
+type DecisionStep struct {
+	Check    string `json:"check"`
+	Matched  bool   `json:"matched"`
+	Detail   string `json:"detail,omitempty"`
+	Decisive bool   `json:"decisive"`
+}
+
+// DecisionTrace is a full dry-run explanation of a split-tunnel decision
+type DecisionTrace struct {
+	App        string         `json:"app"`
+	Domain     string         `json:"domain"`
+	UseTor     bool           `json:"use_tor"`
+	Confidence float64        `json:"confidence"`
+	Reason     string         `json:"reason"`
+	Steps      []DecisionStep `json:"steps"`
 }
 
 // ShouldUseTor determines if an app/domain should use Tor
 func (ai *SplitTunnelAI) ShouldUseTor(appName, domain string) (useTor bool, confidence float64, reason string) {
+	ai.mu.RLock()
+	useTor, confidence, reason = ai.evaluate(appName, domain, nil)
+	ai.mu.RUnlock()
+
+	logger.RouteDecision(appName, domain, useTor, confidence, reason)
+	return useTor, confidence, reason
+}
+
+// Explain performs a dry-run decision for an app/domain pair and returns
+// the full trace of checks performed. It shares the evaluation path with
+// ShouldUseTor so the explanation can never diverge from real behavior.
+func (ai *SplitTunnelAI) Explain(appName, domain string) DecisionTrace {
 	ai.mu.RLock()
 	defer ai.mu.RUnlock()
 
-	// Check known sensitive domains first
-	domainLower := strings.ToLower(domain)
+	trace := &DecisionTrace{
+		App:    appName,
+		Domain: domain,
+	}
+	trace.UseTor, trace.Confidence, trace.Reason = ai.evaluate(appName, domain, trace)
+	return *trace
+}
+
+// evaluate is the single decision path behind ShouldUseTor and Explain.
+// The caller holds the read lock; trace may be nil.
+func (ai *SplitTunnelAI) evaluate(appName, domain string, trace *DecisionTrace) (useTor bool, confidence float64, reason string) {
+	step := func(check string, matched bool, detail string, decisive bool) {
+		if trace != nil {
+			trace.Steps = append(trace.Steps, DecisionStep{
+				Check:    check,
+				Matched:  matched,
+				Detail:   detail,
+				Decisive: decisive,
+			})
+		}
+	}
+
+	// Check known sensitive domains first, against the same canonical
+	// form the bypass engine matches on
+	domainLower := bypass.NormalizeDomain(domain)
 	for d := range ai.sensitiveDomains {
 		if strings.Contains(domainLower, d) {
+			step("sensitive_domain", true, d, true)
 			return true, 1.0, "sensitive_domain"
 		}
 	}
+	step("sensitive_domain", false, "", false)
 
 	// Check known speed domains
 	for d := range ai.speedDomains {
 		if strings.Contains(domainLower, d) {
+			step("speed_domain", true, d, true)
 			return false, 1.0, "speed_domain"
 		}
 	}
+	step("speed_domain", false, "", false)
 
 	// Check sensitive patterns
 	for _, pattern := range ai.sensitivePatterns {
 		if pattern.MatchString(domainLower) {
+			step("sensitive_pattern", true, pattern.String(), true)
 			return true, 0.9, "sensitive_pattern"
 		}
 	}
+	step("sensitive_pattern", false, "", false)
 
 	// Check speed patterns
 	for _, pattern := range ai.speedPatterns {
 		if pattern.MatchString(domainLower) {
+			step("speed_pattern", true, pattern.String(), true)
 			return false, 0.9, "speed_pattern"
 		}
 	}
+	step("speed_pattern", false, "", false)
 
-	// Check learned app profile
-	if profile, exists := ai.appProfiles[appName]; exists {
+	// Check learned app profile under the normalized identity
+	if profile, exists := ai.appProfiles[ai.identify("", appName)]; exists {
 		if profile.Category == CategoryUserDefined {
-			return profile.Category == CategoryAlwaysTor, 1.0, "user_defined"
+			useTor = profile.UserPolicy == UserPolicyTor
+			step("user_defined", true,
+				fmt.Sprintf("policy=%s", profile.UserPolicy), true)
+			return useTor, 1.0, "user_defined"
+		}
+
+		// Hour-aware decision: trust this hour's histogram bucket over
+		// the global ratios once it has enough observations
+		if ai.hourAware {
+			hour := ai.now().Hour()
+			total := profile.HourlyTotal[hour]
+			if total >= ai.minBucketObs {
+				sensRatio := float64(profile.HourlySensitive[hour]) / float64(total)
+				speedRatio := float64(profile.HourlySpeed[hour]) / float64(total)
+				if sensRatio > 0.3 {
+					step("hourly", true,
+						fmt.Sprintf("hour=%d sensitive_ratio=%.2f observations=%d", hour, sensRatio, total), true)
+					return true, 0.5 + sensRatio*0.5, "hourly"
+				}
+				if speedRatio > 0.5 && sensRatio < 0.1 {
+					step("hourly", true,
+						fmt.Sprintf("hour=%d speed_ratio=%.2f observations=%d", hour, speedRatio, total), true)
+					return false, 0.5 + speedRatio*0.4, "hourly"
+				}
+				step("hourly", false,
+					fmt.Sprintf("hour=%d mixed usage", hour), false)
+			} else {
+				step("hourly", false,
+					fmt.Sprintf("hour=%d observations=%d below minimum %d", hour, total, ai.minBucketObs), false)
+			}
 		}
 
 		if profile.Confidence >= ai.confidenceThreshold {
+			step("learned", true,
+				fmt.Sprintf("category=%s confidence=%.2f connections=%d sensitive=%d speed=%d",
+					profile.Category, profile.Confidence, profile.ConnectionCount,
+					profile.SensitivePatterns, profile.SpeedSensitive), true)
 			useTor = profile.Category == CategoryAlwaysTor
 			return useTor, profile.Confidence, "learned"
 		}
+
+		step("learned", false,
+			fmt.Sprintf("confidence=%.2f below threshold %.2f", profile.Confidence, ai.confidenceThreshold), false)
+	} else {
+		step("learned", false, "no profile", false)
 	}
 
 	// Default: use Tor for safety
+	step("default_safe", true, "", true)
 	return true, 0.5, "default_safe"
 }
 
+// AppIdentifier normalizes a raw process identity (executable path plus
+// comm name) into a stable profile key, so "chrome --type=renderer" and
+// flatpak-wrapped binaries don't fragment into separate profiles
+type AppIdentifier func(execPath, comm string) string
+
+// appWrappers are launcher binaries whose name says nothing about the
+// actual application; the comm name is more useful for these
+var appWrappers = map[string]bool{
+	"flatpak": true,
+	"snap":    true,
+	"bwrap":   true,
+	"wine":    true,
+	"wine64":  true,
+}
+
+// DefaultAppIdentifier strips arguments, resolves symlinks, collapses
+// known wrapper binaries to the comm name, and falls back to the
+// lowercased basename
+func DefaultAppIdentifier(execPath, comm string) string {
+	path := strings.TrimSpace(execPath)
+	// Strip arguments: "/opt/google/chrome/chrome --type=renderer"
+	if i := strings.IndexAny(path, " \t"); i >= 0 {
+		path = path[:i]
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+
+	name := strings.ToLower(filepath.Base(path))
+	if name == "." || name == "/" || appWrappers[name] {
+		name = strings.ToLower(strings.TrimSpace(comm))
+	}
+	return name
+}
+
+// SetAppIdentifier installs a custom app-identity normalizer. A nil
+// identifier reverts to DefaultAppIdentifier.
+func (ai *SplitTunnelAI) SetAppIdentifier(fn AppIdentifier) {
+	ai.mu.Lock()
+	defer ai.mu.Unlock()
+	if fn == nil {
+		fn = DefaultAppIdentifier
+	}
+	ai.identify = fn
+}
+
+// ConnectionRecord is one observed connection for batch ingestion
+type ConnectionRecord struct {
+	AppName         string
+	ExecPath        string
+	Domain          string
+	IsSensitive     bool
+	IsSpeedCritical bool
+}
+
 // RecordConnection records a connection for learning
 func (ai *SplitTunnelAI) RecordConnection(appName, execPath, domain string, isSensitive, isSpeedCritical bool) {
-	if !ai.learningEnabled {
+	ai.RecordConnections([]ConnectionRecord{{
+		AppName:         appName,
+		ExecPath:        execPath,
+		Domain:          domain,
+		IsSensitive:     isSensitive,
+		IsSpeedCritical: isSpeedCritical,
+	}})
+}
+
+// RecordConnections records a batch of connections under a single write
+// lock acquisition, so high-throughput callers don't serialize against
+// ShouldUseTor readers once per flow
+func (ai *SplitTunnelAI) RecordConnections(batch []ConnectionRecord) {
+	if len(batch) == 0 || !ai.learningEnabled {
 		return
 	}
 
 	ai.mu.Lock()
-	defer ai.mu.Unlock()
+	save := false
+	for _, rec := range batch {
+		if ai.recordConnectionLocked(rec) {
+			save = true
+		}
+	}
+	ai.mu.Unlock()
+
+	if save {
+		ai.spawnSave()
+	}
+}
+
+// TryRecord enqueues a connection for the background ingest worker
+// without blocking. It returns false (and counts the drop) when the
+// buffer is full, so the packet path never stalls on learning.
+func (ai *SplitTunnelAI) TryRecord(rec ConnectionRecord) bool {
+	select {
+	case ai.ingestCh <- rec:
+		return true
+	default:
+		atomic.AddInt64(&ai.droppedRecords, 1)
+		return false
+	}
+}
 
-	// Get or create profile
-	profile, exists := ai.appProfiles[appName]
+// DroppedRecords returns the number of connections dropped because the
+// ingest buffer was full
+func (ai *SplitTunnelAI) DroppedRecords() int64 {
+	return atomic.LoadInt64(&ai.droppedRecords)
+}
+
+// ingestBufferSize bounds the non-blocking ingest channel
+const ingestBufferSize = 4096
+
+// ingestLoop drains the ingest channel in opportunistic batches
+func (ai *SplitTunnelAI) ingestLoop() {
+	defer close(ai.ingestDone)
+
+	batch := make([]ConnectionRecord, 0, 256)
+	for {
+		select {
+		case <-ai.ingestStop:
+			return
+		case rec := <-ai.ingestCh:
+			batch = append(batch[:0], rec)
+		drain:
+			for len(batch) < cap(batch) {
+				select {
+				case r := <-ai.ingestCh:
+					batch = append(batch, r)
+				default:
+					break drain
+				}
+			}
+			ai.RecordConnections(batch)
+		}
+	}
+}
+
+// recordConnectionLocked applies one connection record. The caller holds
+// the write lock. It returns true when the profile hit a save interval.
+func (ai *SplitTunnelAI) recordConnectionLocked(rec ConnectionRecord) bool {
+	// Get or create profile, keyed by normalized identity
+	name := ai.identify(rec.ExecPath, rec.AppName)
+	profile, exists := ai.appProfiles[name]
 	if !exists {
 		profile = &AppProfile{
-			Name:           appName,
-			ExecutablePath: execPath,
+			Name:           name,
+			ExecutablePath: rec.ExecPath, // Original path, for display
 			Category:       CategoryLearning,
 			Domains:        []string{},
 		}
-		ai.appProfiles[appName] = profile
+		ai.appProfiles[name] = profile
+		ai.enforceProfileCap()
 	}
 
 	// Skip if user-defined
 	if profile.Category == CategoryUserDefined {
-		return
+		return false
 	}
 
 	// Update stats
@@ -242,15 +772,18 @@ func (ai *SplitTunnelAI) RecordConnection(appName, execPath, domain string, isSe
 	profile.LastUpdated = time.Now()
 
 	// Track domain
-	if !contains(profile.Domains, domain) && len(profile.Domains) < 100 {
-		profile.Domains = append(profile.Domains, domain)
+	if !contains(profile.Domains, rec.Domain) && len(profile.Domains) < 100 {
+		profile.Domains = append(profile.Domains, rec.Domain)
 	}
 
 	// Check patterns for this domain
-	domainLower := strings.ToLower(domain)
+	sensitiveHit := rec.IsSensitive
+	speedHit := rec.IsSpeedCritical
+	domainLower := strings.ToLower(rec.Domain)
 	for _, pattern := range ai.sensitivePatterns {
 		if pattern.MatchString(domainLower) {
 			profile.SensitivePatterns++
+			sensitiveHit = true
 			break
 		}
 	}
@@ -258,97 +791,382 @@ func (ai *SplitTunnelAI) RecordConnection(appName, execPath, domain string, isSe
 	for _, pattern := range ai.speedPatterns {
 		if pattern.MatchString(domainLower) {
 			profile.SpeedSensitive++
+			speedHit = true
 			break
 		}
 	}
 
-	if isSensitive {
+	if rec.IsSensitive {
 		profile.SensitivePatterns++
 	}
-	if isSpeedCritical {
+	if rec.IsSpeedCritical {
 		profile.SpeedSensitive++
 	}
 
+	// Update hourly histograms
+	hour := ai.now().Hour()
+	profile.HourlyTotal[hour]++
+	if sensitiveHit {
+		profile.HourlySensitive[hour]++
+	}
+	if speedHit {
+		profile.HourlySpeed[hour]++
+	}
+
 	// Update category and confidence
 	ai.updateCategory(profile)
 
 	// Save periodically
-	if profile.ConnectionCount%20 == 0 {
-		go ai.saveData()
+	return profile.ConnectionCount%20 == 0
+}
+
+// Subscribe returns a channel of category-change events and an
+// unsubscribe function. Events are dropped (and counted) when the
+// subscriber is slow rather than blocking the learning path.
+func (ai *SplitTunnelAI) Subscribe() (<-chan CategoryChangeEvent, func()) {
+	ai.mu.Lock()
+	defer ai.mu.Unlock()
+
+	id := ai.nextSubID
+	ai.nextSubID++
+	ch := make(chan CategoryChangeEvent, 16)
+	ai.subscribers[id] = ch
+
+	unsubscribe := func() {
+		ai.mu.Lock()
+		defer ai.mu.Unlock()
+		if sub, ok := ai.subscribers[id]; ok {
+			delete(ai.subscribers, id)
+			close(sub)
+		}
 	}
+	return ch, unsubscribe
 }
 
-// updateCategory updates the category based on learned behavior
-func (ai *SplitTunnelAI) updateCategory(profile *AppProfile) {
-	if profile.ConnectionCount < 10 {
-		profile.Category = CategoryLearning
-		profile.Confidence = float64(profile.ConnectionCount) / 10.0 * 0.5
-		return
+// DroppedEvents returns the number of category-change events dropped
+// because subscribers were slow
+func (ai *SplitTunnelAI) DroppedEvents() int64 {
+	ai.mu.RLock()
+	defer ai.mu.RUnlock()
+	return ai.droppedEvents
+}
+
+// publishCategoryChange emits an event to all subscribers without
+// blocking. The caller holds the write lock.
+func (ai *SplitTunnelAI) publishCategoryChange(event CategoryChangeEvent) {
+	for _, ch := range ai.subscribers {
+		select {
+		case ch <- event:
+		default:
+			ai.droppedEvents++
+		}
+	}
+}
+
+// Classifier decides an app's category and confidence from its profile.
+// Implementations are given a copy of the profile and must not retain it.
+type Classifier interface {
+	Classify(p *AppProfile) (AppCategory, float64)
+}
+
+// DefaultClassifier is the built-in ratio heuristic
+type DefaultClassifier struct{}
+
+// Classify implements the stock category calculation
+func (DefaultClassifier) Classify(p *AppProfile) (AppCategory, float64) {
+	if p.ConnectionCount < 10 {
+		return CategoryLearning, float64(p.ConnectionCount) / 10.0 * 0.5
 	}
 
 	// Calculate ratios
-	sensitiveRatio := float64(profile.SensitivePatterns) / float64(profile.ConnectionCount)
-	speedRatio := float64(profile.SpeedSensitive) / float64(profile.ConnectionCount)
+	sensitiveRatio := float64(p.SensitivePatterns) / float64(p.ConnectionCount)
+	speedRatio := float64(p.SpeedSensitive) / float64(p.ConnectionCount)
 
 	// Decision logic
+	var category AppCategory
+	var confidence float64
 	if sensitiveRatio > 0.3 {
-		profile.Category = CategoryAlwaysTor
-		profile.Confidence = 0.5 + sensitiveRatio*0.5
+		category = CategoryAlwaysTor
+		confidence = 0.5 + sensitiveRatio*0.5
 	} else if speedRatio > 0.5 && sensitiveRatio < 0.1 {
-		profile.Category = CategoryNeverTor
-		profile.Confidence = 0.5 + speedRatio*0.4
+		category = CategoryNeverTor
+		confidence = 0.5 + speedRatio*0.4
 	} else {
 		// Mixed usage - default to Tor for safety
-		profile.Category = CategoryAlwaysTor
-		profile.Confidence = 0.6
+		category = CategoryAlwaysTor
+		confidence = 0.6
 	}
 
 	// Cap confidence
-	if profile.Confidence > 0.95 {
-		profile.Confidence = 0.95
+	if confidence > 0.95 {
+		confidence = 0.95
 	}
+	return category, confidence
+}
+
+// SetClassifier installs a custom classifier. A nil classifier reverts
+// to the built-in heuristic.
+func (ai *SplitTunnelAI) SetClassifier(c Classifier) {
+	ai.mu.Lock()
+	defer ai.mu.Unlock()
+	ai.classifier = c
+}
+
+// updateCategory updates the category based on learned behavior
+func (ai *SplitTunnelAI) updateCategory(profile *AppProfile) {
+	oldCategory := profile.Category
+	ai.recalculateCategory(profile)
+	if profile.Category != oldCategory {
+		ai.publishCategoryChange(CategoryChangeEvent{
+			App:         profile.Name,
+			OldCategory: oldCategory,
+			NewCategory: profile.Category,
+			Confidence:  profile.Confidence,
+			Trigger:     "learned",
+		})
+	}
+}
+
+// recalculateCategory computes category and confidence, delegating to a
+// custom classifier when one is installed. The classifier receives a copy
+// of the profile so it cannot mutate internal state, and a panic inside
+// it falls back to the default heuristic instead of crashing the daemon.
+func (ai *SplitTunnelAI) recalculateCategory(profile *AppProfile) {
+	category, confidence := ai.classify(profile)
+	profile.Category = category
+	profile.Confidence = confidence
+}
+
+func (ai *SplitTunnelAI) classify(profile *AppProfile) (category AppCategory, confidence float64) {
+	if ai.classifier == nil {
+		return DefaultClassifier{}.Classify(profile)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			log := logger.WithComponent("split-tunnel")
+			log.Error().Interface("panic", r).Str("app", profile.Name).
+				Msg("custom classifier panicked, using default heuristic")
+			category, confidence = DefaultClassifier{}.Classify(profile)
+		}
+	}()
+
+	// Deep-enough copy: Domains is the only reference field external
+	// code could mutate
+	clone := *profile
+	clone.Domains = append([]string(nil), profile.Domains...)
+	return ai.classifier.Classify(&clone)
+}
+
+// AppStats summarizes routing statistics for one application
+type AppStats struct {
+	Name              string    `json:"name"`
+	TorConnections    int       `json:"tor_connections"`
+	DirectConnections int       `json:"direct_connections"`
+	TorBytes          int64     `json:"tor_bytes"`
+	DirectBytes       int64     `json:"direct_bytes"`
+	LastUpdated       time.Time `json:"last_updated"`
+}
+
+// RecordDecision records the routing outcome for an app so per-app
+// Tor vs direct statistics can be shown on a dashboard
+func (ai *SplitTunnelAI) RecordDecision(appName string, usedTor bool, bytes int64) {
+	ai.mu.Lock()
+	defer ai.mu.Unlock()
+
+	name := ai.identify("", appName)
+	profile, exists := ai.appProfiles[name]
+	if !exists {
+		profile = &AppProfile{
+			Name:     name,
+			Category: CategoryLearning,
+			Domains:  []string{},
+		}
+		ai.appProfiles[name] = profile
+	}
+
+	if usedTor {
+		profile.TorConnections++
+		profile.TorBytes += bytes
+	} else {
+		profile.DirectConnections++
+		profile.DirectBytes += bytes
+	}
+	profile.LastUpdated = time.Now()
+}
+
+// GetAppStats returns routing statistics for a single app
+func (ai *SplitTunnelAI) GetAppStats(appName string) (AppStats, bool) {
+	ai.mu.RLock()
+	defer ai.mu.RUnlock()
+
+	profile, exists := ai.appProfiles[ai.identify("", appName)]
+	if !exists {
+		return AppStats{}, false
+	}
+
+	return AppStats{
+		Name:              profile.Name,
+		TorConnections:    profile.TorConnections,
+		DirectConnections: profile.DirectConnections,
+		TorBytes:          profile.TorBytes,
+		DirectBytes:       profile.DirectBytes,
+		LastUpdated:       profile.LastUpdated,
+	}, true
+}
+
+// GetTotals returns aggregate routing statistics across all apps
+func (ai *SplitTunnelAI) GetTotals() AppStats {
+	ai.mu.RLock()
+	defer ai.mu.RUnlock()
+
+	var totals AppStats
+	for _, p := range ai.appProfiles {
+		totals.TorConnections += p.TorConnections
+		totals.DirectConnections += p.DirectConnections
+		totals.TorBytes += p.TorBytes
+		totals.DirectBytes += p.DirectBytes
+	}
+	return totals
+}
+
+// SetHourAwareness enables or disables hour-of-day decisions. When
+// enabled, a profile's hourly histogram bucket overrides the global
+// ratios once it has at least minObservations entries; a value <= 0
+// keeps the default minimum.
+func (ai *SplitTunnelAI) SetHourAwareness(enabled bool, minObservations int) {
+	ai.mu.Lock()
+	defer ai.mu.Unlock()
+	ai.hourAware = enabled
+	if minObservations > 0 {
+		ai.minBucketObs = minObservations
+	}
+}
+
+// SetMaxProfiles sets the cap on learned app profiles
+func (ai *SplitTunnelAI) SetMaxProfiles(n int) {
+	ai.mu.Lock()
+	defer ai.mu.Unlock()
+	ai.maxProfiles = n
+	ai.enforceProfileCap()
+}
+
+// enforceProfileCap evicts the least-recently-updated profiles when the
+// map exceeds maxProfiles. User-defined profiles are never evicted.
+// The caller holds the write lock.
+func (ai *SplitTunnelAI) enforceProfileCap() {
+	if ai.maxProfiles <= 0 || len(ai.appProfiles) <= ai.maxProfiles {
+		return
+	}
+
+	// Collect evictable profiles, oldest-updated first
+	evictable := make([]*AppProfile, 0, len(ai.appProfiles))
+	for _, p := range ai.appProfiles {
+		if p.Category != CategoryUserDefined {
+			evictable = append(evictable, p)
+		}
+	}
+	sort.Slice(evictable, func(i, j int) bool {
+		return evictable[i].LastUpdated.Before(evictable[j].LastUpdated)
+	})
+
+	log := logger.WithComponent("split-tunnel")
+	for _, p := range evictable {
+		if len(ai.appProfiles) <= ai.maxProfiles {
+			break
+		}
+		delete(ai.appProfiles, p.Name)
+		log.Debug().Str("app", p.Name).Int("connections", p.ConnectionCount).Msg("evicted stale app profile")
+	}
+}
+
+// PruneStale removes non-user-defined profiles not updated within the
+// given duration
+func (ai *SplitTunnelAI) PruneStale(olderThan time.Duration) int {
+	ai.mu.Lock()
+	defer ai.mu.Unlock()
+
+	log := logger.WithComponent("split-tunnel")
+	cutoff := time.Now().Add(-olderThan)
+	pruned := 0
+	for name, p := range ai.appProfiles {
+		if p.Category == CategoryUserDefined {
+			continue
+		}
+		if p.LastUpdated.Before(cutoff) {
+			delete(ai.appProfiles, name)
+			log.Debug().Str("app", name).Msg("pruned stale app profile")
+			pruned++
+		}
+	}
+	return pruned
 }
 
 // SetAppCategory manually sets an app's category
 func (ai *SplitTunnelAI) SetAppCategory(appName string, alwaysTor bool) {
+	ai.SetAppCategoryWithNote(appName, alwaysTor, "", "user")
+}
+
+// SetAppCategoryWithNote pins an app's category and records why and when
+// the choice was made, so the CLI can later show e.g.
+// "pinned by you on 2024-05-01: banking app"
+func (ai *SplitTunnelAI) SetAppCategoryWithNote(appName string, alwaysTor bool, note, setBy string) {
 	ai.mu.Lock()
 	defer ai.mu.Unlock()
 
-	profile, exists := ai.appProfiles[appName]
+	// Pins must land under the same normalized key evaluate() looks up,
+	// or a mixed-case pin would be silently ignored
+	name := ai.identify("", appName)
+	profile, exists := ai.appProfiles[name]
 	if !exists {
 		profile = &AppProfile{
-			Name:     appName,
-			Category: CategoryUserDefined,
+			Name:     name,
+			Category: CategoryUnknown,
+			Domains:  []string{},
 		}
-		ai.appProfiles[appName] = profile
+		ai.appProfiles[name] = profile
 	}
 
+	oldCategory := profile.Category
 	profile.Category = CategoryUserDefined
 	if alwaysTor {
-		profile.SensitivePatterns = 100 // Force Tor
+		profile.UserPolicy = UserPolicyTor
 	} else {
-		profile.SpeedSensitive = 100 // Force direct
+		profile.UserPolicy = UserPolicyDirect
 	}
+	profile.Note = note
+	profile.SetBy = setBy
+	profile.SetAt = ai.now()
 	profile.Confidence = 1.0
 	profile.LastUpdated = time.Now()
 
-	go ai.saveData()
+	if oldCategory != CategoryUserDefined {
+		ai.publishCategoryChange(CategoryChangeEvent{
+			App:         name,
+			OldCategory: oldCategory,
+			NewCategory: CategoryUserDefined,
+			Confidence:  profile.Confidence,
+			Trigger:     "user",
+		})
+	}
+
+	ai.spawnSave()
 }
 
 // AddSensitiveDomain adds a domain to always route through Tor
 func (ai *SplitTunnelAI) AddSensitiveDomain(domain string) {
 	ai.mu.Lock()
 	defer ai.mu.Unlock()
-	ai.sensitiveDomains[strings.ToLower(domain)] = true
-	go ai.saveData()
+	ai.sensitiveDomains[bypass.NormalizeDomain(domain)] = true
+	ai.spawnSave()
 }
 
 // AddSpeedDomain adds a domain to prefer direct connection
 func (ai *SplitTunnelAI) AddSpeedDomain(domain string) {
 	ai.mu.Lock()
 	defer ai.mu.Unlock()
-	ai.speedDomains[strings.ToLower(domain)] = true
-	go ai.saveData()
+	ai.speedDomains[bypass.NormalizeDomain(domain)] = true
+	ai.spawnSave()
 }
 
 // GetAppProfiles returns all learned app profiles
@@ -363,8 +1181,21 @@ func (ai *SplitTunnelAI) GetAppProfiles() map[string]*AppProfile {
 	return result
 }
 
-// GetTopApps returns the top apps by connection count
-func (ai *SplitTunnelAI) GetTopApps(count int) []*AppProfile {
+// ProfileSortKey selects the ordering for GetTopApps
+type ProfileSortKey string
+
+const (
+	SortByConnectionCount   ProfileSortKey = "connection_count"
+	SortBySensitivePatterns ProfileSortKey = "sensitive_patterns"
+	SortBySpeedSensitive    ProfileSortKey = "speed_sensitive"
+	SortByLastUpdated       ProfileSortKey = "last_updated"
+	SortByConfidence        ProfileSortKey = "confidence"
+)
+
+// GetTopApps returns the top apps ordered by the given key, descending.
+// Ties break by name so CLI tables are stable across runs. An unknown
+// key falls back to connection count.
+func (ai *SplitTunnelAI) GetTopApps(count int, sortBy ProfileSortKey) []*AppProfile {
 	ai.mu.RLock()
 	defer ai.mu.RUnlock()
 
@@ -373,8 +1204,34 @@ func (ai *SplitTunnelAI) GetTopApps(count int) []*AppProfile {
 		apps = append(apps, p)
 	}
 
+	less := func(a, b *AppProfile) bool {
+		switch sortBy {
+		case SortBySensitivePatterns:
+			if a.SensitivePatterns != b.SensitivePatterns {
+				return a.SensitivePatterns > b.SensitivePatterns
+			}
+		case SortBySpeedSensitive:
+			if a.SpeedSensitive != b.SpeedSensitive {
+				return a.SpeedSensitive > b.SpeedSensitive
+			}
+		case SortByLastUpdated:
+			if !a.LastUpdated.Equal(b.LastUpdated) {
+				return a.LastUpdated.After(b.LastUpdated)
+			}
+		case SortByConfidence:
+			if a.Confidence != b.Confidence {
+				return a.Confidence > b.Confidence
+			}
+		default:
+			if a.ConnectionCount != b.ConnectionCount {
+				return a.ConnectionCount > b.ConnectionCount
+			}
+		}
+		return a.Name < b.Name
+	}
+
 	sort.Slice(apps, func(i, j int) bool {
-		return apps[i].ConnectionCount > apps[j].ConnectionCount
+		return less(apps[i], apps[j])
 	})
 
 	if count > len(apps) {
@@ -383,22 +1240,207 @@ func (ai *SplitTunnelAI) GetTopApps(count int) []*AppProfile {
 	return apps[:count]
 }
 
+// GetTopAppsByConnections returns the top apps by connection count,
+// preserving the original GetTopApps behavior
+func (ai *SplitTunnelAI) GetTopAppsByConnections(count int) []*AppProfile {
+	return ai.GetTopApps(count, SortByConnectionCount)
+}
+
+// decodeAppProfiles parses an app_profiles.json payload, migrating
+// historical formats up to the current schema version
+func decodeAppProfiles(plain []byte) (map[string]*AppProfile, error) {
+	var probe struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(plain, &probe); err != nil {
+		return nil, fmt.Errorf("corrupt app profiles: %w", err)
+	}
+	if probe.Version > aiDataVersion {
+		return nil, fmt.Errorf("%w: app profiles version %d, supported up to %d",
+			errDataTooNew, probe.Version, aiDataVersion)
+	}
+
+	profiles := make(map[string]*AppProfile)
+	version := probe.Version
+	if version == 0 {
+		// v0: legacy bare map without an envelope
+		if err := json.Unmarshal(plain, &profiles); err != nil {
+			return nil, fmt.Errorf("corrupt app profiles: %w", err)
+		}
+		version = 1
+	} else {
+		var env profilesEnvelope
+		if err := json.Unmarshal(plain, &env); err != nil {
+			return nil, fmt.Errorf("corrupt app profiles: %w", err)
+		}
+		if env.Profiles != nil {
+			profiles = env.Profiles
+		}
+	}
+
+	// Walk the migration chain up to the current version
+	for ; version < aiDataVersion; version++ {
+		switch version {
+		case 1:
+			migrateProfilesV1toV2(profiles)
+		case 2:
+			migrateProfilesV2toV3(profiles)
+		}
+	}
+
+	return profiles, nil
+}
+
+// migrateProfilesV1toV2 fills invariants introduced in v2. The routing-stat
+// counters added in v2 are correct at their zero values, but v2 guarantees
+// the Domains slice is always non-nil.
+func migrateProfilesV1toV2(profiles map[string]*AppProfile) {
+	for _, p := range profiles {
+		if p.Domains == nil {
+			p.Domains = []string{}
+		}
+	}
+}
+
+// migrateProfilesV2toV3 converts the old "counter = 100" encoding of a
+// user pin into the explicit UserPolicy field introduced in v3. The
+// forced counter value carried no real observations, so it is cleared.
+func migrateProfilesV2toV3(profiles map[string]*AppProfile) {
+	for _, p := range profiles {
+		if p.Category != CategoryUserDefined || p.UserPolicy != "" {
+			continue
+		}
+		if p.SensitivePatterns >= 100 {
+			p.UserPolicy = UserPolicyTor
+			p.SensitivePatterns = 0
+		} else if p.SpeedSensitive >= 100 {
+			p.UserPolicy = UserPolicyDirect
+			p.SpeedSensitive = 0
+		} else {
+			// Pinned by an even older build with no hint either way;
+			// default to Tor for safety
+			p.UserPolicy = UserPolicyTor
+		}
+	}
+}
+
+// backupCorruptFile moves a file that failed to parse aside so the user
+// can inspect it and the next save doesn't clobber the evidence
+func backupCorruptFile(path string) {
+	log := logger.WithComponent("split-tunnel")
+	if err := os.Rename(path, path+".bad"); err != nil {
+		log.Warn().Err(err).Str("file", path).Msg("failed to back up corrupt data file")
+		return
+	}
+	log.Warn().Str("file", path).Str("backup", path+".bad").Msg("backed up corrupt data file")
+}
+
+// suggestionMinConnections is the minimum observations before an app is
+// worth suggesting for pinning
+const suggestionMinConnections = 10
+
+// Suggestion proposes pinning an app whose learned classification is
+// already confident, so a UI can ask the user to confirm and call
+// SetAppCategory
+type Suggestion struct {
+	App               string      `json:"app"`
+	ProposedCategory  AppCategory `json:"proposed_category"`
+	Confidence        float64     `json:"confidence"`
+	ConnectionCount   int         `json:"connection_count"`
+	SensitivePatterns int         `json:"sensitive_patterns"`
+	SpeedSensitive    int         `json:"speed_sensitive"`
+	ExampleDomains    []string    `json:"example_domains"`
+}
+
+// GetCategorySuggestions returns apps whose learned classification
+// exceeds minConfidence, ordered by confidence descending. Apps the user
+// already pinned and apps with fewer than suggestionMinConnections
+// observations are excluded.
+func (ai *SplitTunnelAI) GetCategorySuggestions(minConfidence float64) []Suggestion {
+	ai.mu.RLock()
+	defer ai.mu.RUnlock()
+
+	var suggestions []Suggestion
+	for _, p := range ai.appProfiles {
+		if p.Category == CategoryUserDefined {
+			continue
+		}
+		if p.ConnectionCount < suggestionMinConnections {
+			continue
+		}
+		if p.Confidence < minConfidence {
+			continue
+		}
+		if p.Category != CategoryAlwaysTor && p.Category != CategoryNeverTor {
+			continue
+		}
+
+		examples := p.Domains
+		if len(examples) > 3 {
+			examples = examples[:3]
+		}
+
+		suggestions = append(suggestions, Suggestion{
+			App:               p.Name,
+			ProposedCategory:  p.Category,
+			Confidence:        p.Confidence,
+			ConnectionCount:   p.ConnectionCount,
+			SensitivePatterns: p.SensitivePatterns,
+			SpeedSensitive:    p.SpeedSensitive,
+			ExampleDomains:    append([]string(nil), examples...),
+		})
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Confidence != suggestions[j].Confidence {
+			return suggestions[i].Confidence > suggestions[j].Confidence
+		}
+		return suggestions[i].App < suggestions[j].App
+	})
+
+	return suggestions
+}
+
 // loadData loads saved AI data
-func (ai *SplitTunnelAI) loadData() {
+func (ai *SplitTunnelAI) loadData() error {
+	log := logger.WithComponent("split-tunnel")
+
 	// Load app profiles
 	profilesPath := filepath.Join(ai.dataDir, "app_profiles.json")
 	if data, err := os.ReadFile(profilesPath); err == nil {
-		json.Unmarshal(data, &ai.appProfiles)
+		plain, err := ai.openData(data)
+		if err != nil {
+			return fmt.Errorf("app_profiles.json: %w", err)
+		}
+		profiles, err := decodeAppProfiles(plain)
+		switch {
+		case errors.Is(err, errDataTooNew):
+			return fmt.Errorf("app_profiles.json: %w", err)
+		case err != nil:
+			log.Warn().Err(err).Str("file", profilesPath).Msg("app profiles file unreadable, starting fresh")
+			backupCorruptFile(profilesPath)
+		default:
+			ai.appProfiles = ai.normalizeProfileKeys(profiles)
+		}
 	}
 
 	// Load custom domains
 	customPath := filepath.Join(ai.dataDir, "custom_domains.json")
 	if data, err := os.ReadFile(customPath); err == nil {
-		var custom struct {
-			Sensitive map[string]bool `json:"sensitive"`
-			Speed     map[string]bool `json:"speed"`
+		plain, err := ai.openData(data)
+		if err != nil {
+			return fmt.Errorf("custom_domains.json: %w", err)
 		}
-		if json.Unmarshal(data, &custom) == nil {
+		var custom domainsEnvelope
+		switch err := json.Unmarshal(plain, &custom); {
+		case err != nil:
+			log.Warn().Err(err).Str("file", customPath).Msg("custom domains file unreadable, starting fresh")
+			backupCorruptFile(customPath)
+		case custom.Version > aiDataVersion:
+			return fmt.Errorf("custom_domains.json: %w: version %d, supported up to %d",
+				errDataTooNew, custom.Version, aiDataVersion)
+		default:
+			// v0 files carry the same fields without a version; no migration needed
 			for k, v := range custom.Sensitive {
 				ai.sensitiveDomains[k] = v
 			}
@@ -407,6 +1449,79 @@ func (ai *SplitTunnelAI) loadData() {
 			}
 		}
 	}
+
+	return nil
+}
+
+// normalizeProfileKeys re-keys loaded profiles through the identity
+// normalizer. Older versions stored profiles under raw app names, so a
+// data file can hold e.g. both "Firefox" and "firefox"; those are
+// merged into the one key evaluate() actually looks up.
+func (ai *SplitTunnelAI) normalizeProfileKeys(profiles map[string]*AppProfile) map[string]*AppProfile {
+	out := make(map[string]*AppProfile, len(profiles))
+	for key, profile := range profiles {
+		name := ai.identify("", key)
+		if name == "" {
+			name = key
+		}
+		if existing, ok := out[name]; ok {
+			mergeAppProfile(existing, profile)
+			continue
+		}
+		profile.Name = name
+		out[name] = profile
+	}
+	return out
+}
+
+// mergeAppProfile folds src's learning data and counters into dst when
+// two raw-keyed profiles normalize to the same identity. A user pin on
+// either side survives the merge.
+func mergeAppProfile(dst, src *AppProfile) {
+	dst.ConnectionCount += src.ConnectionCount
+	dst.SensitivePatterns += src.SensitivePatterns
+	dst.SpeedSensitive += src.SpeedSensitive
+	dst.TorConnections += src.TorConnections
+	dst.DirectConnections += src.DirectConnections
+	dst.TorBytes += src.TorBytes
+	dst.DirectBytes += src.DirectBytes
+	for i := 0; i < 24; i++ {
+		dst.HourlyTotal[i] += src.HourlyTotal[i]
+		dst.HourlySensitive[i] += src.HourlySensitive[i]
+		dst.HourlySpeed[i] += src.HourlySpeed[i]
+	}
+
+	seen := make(map[string]bool, len(dst.Domains))
+	for _, d := range dst.Domains {
+		seen[d] = true
+	}
+	for _, d := range src.Domains {
+		if !seen[d] {
+			dst.Domains = append(dst.Domains, d)
+		}
+	}
+
+	if dst.Category != CategoryUserDefined && src.Category == CategoryUserDefined {
+		dst.Category = CategoryUserDefined
+		dst.UserPolicy = src.UserPolicy
+		dst.Note = src.Note
+		dst.SetBy = src.SetBy
+		dst.SetAt = src.SetAt
+		dst.Confidence = src.Confidence
+	}
+	if src.LastUpdated.After(dst.LastUpdated) {
+		dst.LastUpdated = src.LastUpdated
+	}
+}
+
+// spawnSave runs saveData on a goroutine tracked by saveWG so Close
+// can wait for pending writes
+func (ai *SplitTunnelAI) spawnSave() {
+	ai.saveWG.Add(1)
+	go func() {
+		defer ai.saveWG.Done()
+		ai.saveData()
+	}()
 }
 
 // saveData persists AI data to disk
@@ -417,20 +1532,26 @@ func (ai *SplitTunnelAI) saveData() {
 	os.MkdirAll(ai.dataDir, 0700)
 
 	// Save app profiles
-	if data, err := json.MarshalIndent(ai.appProfiles, "", "  "); err == nil {
-		os.WriteFile(filepath.Join(ai.dataDir, "app_profiles.json"), data, 0600)
+	env := profilesEnvelope{
+		Version:  aiDataVersion,
+		Profiles: ai.appProfiles,
+	}
+	if data, err := json.MarshalIndent(env, "", "  "); err == nil {
+		if sealed, err := ai.sealData(data); err == nil {
+			os.WriteFile(filepath.Join(ai.dataDir, "app_profiles.json"), sealed, 0600)
+		}
 	}
 
 	// Save custom domains
-	custom := struct {
-		Sensitive map[string]bool `json:"sensitive"`
-		Speed     map[string]bool `json:"speed"`
-	}{
+	custom := domainsEnvelope{
+		Version:   aiDataVersion,
 		Sensitive: ai.sensitiveDomains,
 		Speed:     ai.speedDomains,
 	}
 	if data, err := json.MarshalIndent(custom, "", "  "); err == nil {
-		os.WriteFile(filepath.Join(ai.dataDir, "custom_domains.json"), data, 0600)
+		if sealed, err := ai.sealData(data); err == nil {
+			os.WriteFile(filepath.Join(ai.dataDir, "custom_domains.json"), sealed, 0600)
+		}
 	}
 }
 
@@ -453,7 +1574,7 @@ func (ai *SplitTunnelAI) ResetLearning() {
 		}
 	}
 
-	go ai.saveData()
+	ai.spawnSave()
 }
 
 // Helper function
@@ -0,0 +1,963 @@
+package ai
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestProfileCap tests that the appProfiles map stays bounded and that
+// user-defined profiles survive eviction
+func TestProfileCap(t *testing.T) {
+	ai := NewSplitTunnelAI(t.TempDir())
+	ai.SetMaxProfiles(100)
+
+	// Pin one app so it can never be evicted
+	ai.SetAppCategory("my-banking-app", true)
+
+	// Simulate hundreds of short-lived tools each making a connection
+	for i := 0; i < 600; i++ {
+		ai.RecordConnection(fmt.Sprintf("tool-%d", i), "", "example.com", false, false)
+	}
+
+	profiles := ai.GetAppProfiles()
+	if len(profiles) > 100 {
+		t.Errorf("profile count = %d, want <= 100", len(profiles))
+	}
+
+	if _, exists := profiles["my-banking-app"]; !exists {
+		t.Error("user-defined profile should survive eviction")
+	}
+
+	// PruneStale with a zero age removes everything learned
+	pruned := ai.PruneStale(0)
+	if pruned == 0 {
+		t.Error("expected PruneStale to remove learned profiles")
+	}
+	if _, exists := ai.GetAppProfiles()["my-banking-app"]; !exists {
+		t.Error("user-defined profile should survive pruning")
+	}
+
+	// Let the async save triggered by SetAppCategory finish before the
+	// test's temp dir is cleaned up
+	time.Sleep(100 * time.Millisecond)
+}
+
+// TestCategoryChangeSubscription tests category-change notifications
+func TestCategoryChangeSubscription(t *testing.T) {
+	ai := NewSplitTunnelAI(t.TempDir())
+
+	events, unsubscribe := ai.Subscribe()
+	defer unsubscribe()
+
+	// User pinning an app emits a "user" trigger event
+	ai.SetAppCategory("firefox", true)
+
+	select {
+	case ev := <-events:
+		if ev.App != "firefox" {
+			t.Errorf("event app = %s, want firefox", ev.App)
+		}
+		if ev.NewCategory != CategoryUserDefined {
+			t.Errorf("event new category = %v, want user_defined", ev.NewCategory)
+		}
+		if ev.Trigger != "user" {
+			t.Errorf("event trigger = %s, want user", ev.Trigger)
+		}
+	default:
+		t.Fatal("expected a category-change event")
+	}
+
+	// Learning path: enough speed-heavy connections flip the category
+	for i := 0; i < 20; i++ {
+		ai.RecordConnection("steam", "/usr/bin/steam", "cdn.steamcontent.com", false, true)
+	}
+
+	sawLearned := false
+	for {
+		select {
+		case ev := <-events:
+			if ev.App == "steam" && ev.Trigger == "learned" {
+				sawLearned = true
+			}
+			continue
+		default:
+		}
+		break
+	}
+	if !sawLearned {
+		t.Error("expected a learned category-change event for steam")
+	}
+
+	// Let the async save triggered by SetAppCategory finish before the
+	// test's temp dir is cleaned up
+	time.Sleep(100 * time.Millisecond)
+}
+
+// TestExplain tests the dry-run decision trace
+func TestExplain(t *testing.T) {
+	ai := NewSplitTunnelAI(t.TempDir())
+
+	// A known speed domain: the speed_domain step must be decisive
+	trace := ai.Explain("firefox", "youtube.com")
+	if trace.UseTor {
+		t.Error("youtube.com should not use Tor")
+	}
+	if trace.Reason != "speed_domain" {
+		t.Errorf("reason = %s, want speed_domain", trace.Reason)
+	}
+
+	// Step order: sensitive_domain is always evaluated first
+	if len(trace.Steps) < 2 {
+		t.Fatalf("expected at least 2 steps, got %d", len(trace.Steps))
+	}
+	if trace.Steps[0].Check != "sensitive_domain" || trace.Steps[0].Matched {
+		t.Errorf("first step should be a non-matching sensitive_domain check, got %+v", trace.Steps[0])
+	}
+
+	// The decisive step must match the returned reason
+	var decisive *DecisionStep
+	for i := range trace.Steps {
+		if trace.Steps[i].Decisive {
+			decisive = &trace.Steps[i]
+		}
+	}
+	if decisive == nil {
+		t.Fatal("no decisive step recorded")
+	}
+	if decisive.Check != trace.Reason {
+		t.Errorf("decisive step %s does not match reason %s", decisive.Check, trace.Reason)
+	}
+
+	// Explain must agree with ShouldUseTor
+	useTor, confidence, reason := ai.ShouldUseTor("firefox", "youtube.com")
+	if useTor != trace.UseTor || confidence != trace.Confidence || reason != trace.Reason {
+		t.Error("Explain diverged from ShouldUseTor")
+	}
+
+	// The trace marshals to JSON for the CLI
+	if _, err := json.Marshal(trace); err != nil {
+		t.Errorf("trace should marshal to JSON: %v", err)
+	}
+
+	// Unknown domain/app falls through to the safe default
+	trace = ai.Explain("unknown-app", "unknown.example")
+	if !trace.UseTor || trace.Reason != "default_safe" {
+		t.Errorf("unknown domain should default to Tor, got %+v", trace)
+	}
+
+	// A user-pinned direct app: the user_defined step must be marked
+	// decisive even though the decision is not to use Tor
+	ai.SetAppCategory("pinned-app", false)
+	trace = ai.Explain("pinned-app", "unknown.example")
+	if trace.UseTor {
+		t.Error("user-pinned direct app should not use Tor")
+	}
+	if trace.Reason != "user_defined" {
+		t.Errorf("reason = %s, want user_defined", trace.Reason)
+	}
+	decisive = nil
+	for i := range trace.Steps {
+		if trace.Steps[i].Decisive {
+			decisive = &trace.Steps[i]
+		}
+	}
+	if decisive == nil {
+		t.Fatal("no decisive step recorded for user-pinned app")
+	}
+	if decisive.Check != "user_defined" {
+		t.Errorf("decisive step = %s, want user_defined", decisive.Check)
+	}
+}
+
+func writePatternsFile(t *testing.T, dataDir, name, content string) {
+	t.Helper()
+	dir := filepath.Join(dataDir, "patterns.d")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestPatternFilesMerge tests that patterns.d entries merge with built-ins
+func TestPatternFilesMerge(t *testing.T) {
+	dataDir := t.TempDir()
+	writePatternsFile(t, dataDir, "banks.json",
+		`{"patterns":[{"name":"regional-bank","regex":"sparkasse\\.de","class":"sensitive"}]}`)
+
+	ai := NewSplitTunnelAI(dataDir)
+	defer ai.Close()
+
+	// Custom pattern applies
+	useTor, _, reason := ai.ShouldUseTor("firefox", "www.sparkasse.de")
+	if !useTor || reason != "sensitive_pattern" {
+		t.Errorf("custom pattern: useTor=%v reason=%s", useTor, reason)
+	}
+
+	// Built-ins are still active
+	useTor, _, _ = ai.ShouldUseTor("firefox", "site.onion")
+	if !useTor {
+		t.Error("built-in .onion pattern should survive a merge")
+	}
+}
+
+// TestPatternFilesReplace tests that the replace flag drops the built-ins
+func TestPatternFilesReplace(t *testing.T) {
+	dataDir := t.TempDir()
+	writePatternsFile(t, dataDir, "minimal.json",
+		`{"replace":true,"patterns":[{"name":"onion","regex":"\\.onion$","class":"sensitive"}]}`)
+
+	ai := NewSplitTunnelAI(dataDir)
+	defer ai.Close()
+
+	// The overly broad built-in (vpn|proxy) rule is gone
+	_, _, reason := ai.ShouldUseTor("firefox", "openvpn-howto.example")
+	if reason == "sensitive_pattern" {
+		t.Error("built-in vpn pattern should be dropped in replace mode")
+	}
+
+	// The replacement list still works
+	useTor, _, reason := ai.ShouldUseTor("firefox", "site.onion")
+	if !useTor || reason != "sensitive_pattern" {
+		t.Errorf("replacement pattern: useTor=%v reason=%s", useTor, reason)
+	}
+}
+
+// TestPatternFilesBadRegex tests error reporting for invalid definitions
+func TestPatternFilesBadRegex(t *testing.T) {
+	dataDir := t.TempDir()
+	ai := NewSplitTunnelAI(dataDir)
+	defer ai.Close()
+
+	writePatternsFile(t, dataDir, "broken.json",
+		`{"patterns":[
+			{"name":"bad","regex":"[unclosed","class":"sensitive"},
+			{"name":"badclass","regex":"ok","class":"nonsense"},
+			{"name":"good","regex":"mybank\\.example","class":"sensitive"}
+		]}`)
+
+	errs := ai.ReloadPatterns()
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %d: %v", len(errs), errs)
+	}
+
+	// The valid entry from the same file still applies
+	useTor, _, reason := ai.ShouldUseTor("firefox", "mybank.example")
+	if !useTor || reason != "sensitive_pattern" {
+		t.Errorf("valid pattern alongside bad ones: useTor=%v reason=%s", useTor, reason)
+	}
+}
+
+// TestGetCategorySuggestions tests filtering and ordering of pin suggestions
+func TestGetCategorySuggestions(t *testing.T) {
+	ai := NewSplitTunnelAI(t.TempDir())
+	defer ai.Close()
+
+	ai.mu.Lock()
+	ai.appProfiles = map[string]*AppProfile{
+		// Confident Tor candidate
+		"signal-desktop": {Name: "signal-desktop", Category: CategoryAlwaysTor,
+			Confidence: 0.9, ConnectionCount: 50, SensitivePatterns: 40,
+			Domains: []string{"signal.org", "cdn.signal.org", "updates.signal.org", "extra.example"}},
+		// Confident direct candidate
+		"steam": {Name: "steam", Category: CategoryNeverTor,
+			Confidence: 0.85, ConnectionCount: 80, SpeedSensitive: 70,
+			Domains: []string{"steamcontent.com"}},
+		// Already pinned by the user: never suggested
+		"firefox": {Name: "firefox", Category: CategoryUserDefined,
+			Confidence: 1.0, ConnectionCount: 200},
+		// Too few observations
+		"newapp": {Name: "newapp", Category: CategoryAlwaysTor,
+			Confidence: 0.9, ConnectionCount: 5},
+		// Below the requested confidence
+		"meh": {Name: "meh", Category: CategoryAlwaysTor,
+			Confidence: 0.6, ConnectionCount: 30},
+	}
+	ai.mu.Unlock()
+
+	suggestions := ai.GetCategorySuggestions(0.8)
+	if len(suggestions) != 2 {
+		t.Fatalf("expected 2 suggestions, got %d: %+v", len(suggestions), suggestions)
+	}
+
+	// Ordered by confidence descending
+	if suggestions[0].App != "signal-desktop" || suggestions[1].App != "steam" {
+		t.Errorf("order = %s, %s; want signal-desktop, steam", suggestions[0].App, suggestions[1].App)
+	}
+	if suggestions[0].ProposedCategory != CategoryAlwaysTor {
+		t.Errorf("proposed category = %v, want AlwaysTor", suggestions[0].ProposedCategory)
+	}
+	if len(suggestions[0].ExampleDomains) != 3 {
+		t.Errorf("example domains = %d, want capped at 3", len(suggestions[0].ExampleDomains))
+	}
+	if suggestions[1].SpeedSensitive != 70 {
+		t.Errorf("speed counter = %d, want 70", suggestions[1].SpeedSensitive)
+	}
+}
+
+// TestDefaultAppIdentifier tests normalization of /proc-style identities
+func TestDefaultAppIdentifier(t *testing.T) {
+	tests := []struct {
+		execPath string
+		comm     string
+		want     string
+	}{
+		// Arguments are stripped
+		{"/opt/google/chrome/chrome --type=renderer --enable-gpu", "chrome", "chrome"},
+		// Plain binary
+		{"/usr/bin/firefox", "firefox", "firefox"},
+		// Wrapper binaries collapse to the comm name
+		{"/usr/bin/flatpak run org.mozilla.firefox", "firefox", "firefox"},
+		{"/snap/bin/snap", "spotify", "spotify"},
+		{"/usr/bin/wine64", "game.exe", "game.exe"},
+		// Missing exec path falls back to comm
+		{"", "curl", "curl"},
+		// Case is normalized
+		{"/usr/bin/Firefox-ESR", "Firefox-ESR", "firefox-esr"},
+	}
+
+	for _, tt := range tests {
+		if got := DefaultAppIdentifier(tt.execPath, tt.comm); got != tt.want {
+			t.Errorf("DefaultAppIdentifier(%q, %q) = %q, want %q", tt.execPath, tt.comm, got, tt.want)
+		}
+	}
+}
+
+// TestAppIdentityMerging tests that renderer processes and wrapped
+// binaries land in one profile
+func TestAppIdentityMerging(t *testing.T) {
+	ai := NewSplitTunnelAI(t.TempDir())
+	defer ai.Close()
+
+	ai.RecordConnection("chrome", "/opt/google/chrome/chrome", "example.com", false, false)
+	ai.RecordConnection("chrome", "/opt/google/chrome/chrome --type=renderer", "example.com", false, false)
+	ai.RecordConnection("firefox", "/usr/bin/flatpak run org.mozilla.firefox", "example.com", false, false)
+
+	profiles := ai.GetAppProfiles()
+	if len(profiles) != 2 {
+		t.Fatalf("expected 2 profiles, got %d: %v", len(profiles), profiles)
+	}
+	if got := profiles["chrome"].ConnectionCount; got != 2 {
+		t.Errorf("chrome connection count = %d, want 2", got)
+	}
+	if _, exists := profiles["firefox"]; !exists {
+		t.Error("flatpak-wrapped firefox should land in the firefox profile")
+	}
+	// The raw executable path is kept for display
+	if profiles["chrome"].ExecutablePath != "/opt/google/chrome/chrome" {
+		t.Errorf("executable path = %q", profiles["chrome"].ExecutablePath)
+	}
+}
+
+// stubClassifier forces every app into a fixed category
+type stubClassifier struct {
+	category   AppCategory
+	confidence float64
+	sawCopy    *AppProfile
+}
+
+func (s *stubClassifier) Classify(p *AppProfile) (AppCategory, float64) {
+	s.sawCopy = p
+	return s.category, s.confidence
+}
+
+// panicClassifier always panics to exercise the recovery path
+type panicClassifier struct{}
+
+func (panicClassifier) Classify(p *AppProfile) (AppCategory, float64) {
+	panic("experimental model exploded")
+}
+
+// TestSetClassifier tests that a custom classifier overrides decisions
+func TestSetClassifier(t *testing.T) {
+	ai := NewSplitTunnelAI(t.TempDir())
+	defer ai.Close()
+
+	stub := &stubClassifier{category: CategoryNeverTor, confidence: 0.99}
+	ai.SetClassifier(stub)
+
+	// Sensitive flags would normally push this to AlwaysTor
+	for i := 0; i < 15; i++ {
+		ai.RecordConnection("experiment", "", "intranet.example", true, false)
+	}
+
+	profile := ai.GetAppProfiles()["experiment"]
+	if profile.Category != CategoryNeverTor {
+		t.Errorf("category = %v, want classifier override NeverTor", profile.Category)
+	}
+	if profile.Confidence != 0.99 {
+		t.Errorf("confidence = %v, want 0.99", profile.Confidence)
+	}
+
+	// The classifier must receive a copy, not the internal profile
+	if stub.sawCopy == profile {
+		t.Error("classifier was handed the internal profile pointer")
+	}
+	stub.sawCopy.ConnectionCount = -1
+	if profile.ConnectionCount == -1 {
+		t.Error("mutating the classifier's copy leaked into internal state")
+	}
+
+	// Reverting to nil restores the built-in heuristic
+	ai.SetClassifier(nil)
+	for i := 0; i < 15; i++ {
+		ai.RecordConnection("experiment", "", "intranet.example", true, false)
+	}
+	if got := ai.GetAppProfiles()["experiment"].Category; got != CategoryAlwaysTor {
+		t.Errorf("category = %v, want AlwaysTor from default heuristic", got)
+	}
+}
+
+// TestClassifierPanicRecovered tests that a panicking classifier does not
+// crash the daemon and the default heuristic takes over
+func TestClassifierPanicRecovered(t *testing.T) {
+	ai := NewSplitTunnelAI(t.TempDir())
+	defer ai.Close()
+	ai.SetClassifier(panicClassifier{})
+
+	for i := 0; i < 15; i++ {
+		ai.RecordConnection("crashy", "", "intranet.example", true, false)
+	}
+
+	if got := ai.GetAppProfiles()["crashy"].Category; got != CategoryAlwaysTor {
+		t.Errorf("category = %v, want AlwaysTor from fallback heuristic", got)
+	}
+}
+
+// TestGetTopAppsSortKeys tests each supported sort key
+func TestGetTopAppsSortKeys(t *testing.T) {
+	ai := NewSplitTunnelAI(t.TempDir())
+	defer ai.Close()
+
+	ai.mu.Lock()
+	ai.appProfiles = map[string]*AppProfile{
+		"alpha": {Name: "alpha", ConnectionCount: 5, SensitivePatterns: 9, SpeedSensitive: 1,
+			Confidence: 0.3, LastUpdated: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+		"beta": {Name: "beta", ConnectionCount: 9, SensitivePatterns: 1, SpeedSensitive: 5,
+			Confidence: 0.9, LastUpdated: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)},
+		"gamma": {Name: "gamma", ConnectionCount: 7, SensitivePatterns: 5, SpeedSensitive: 9,
+			Confidence: 0.5, LastUpdated: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	ai.mu.Unlock()
+
+	tests := []struct {
+		key  ProfileSortKey
+		want string // Expected top app
+	}{
+		{SortByConnectionCount, "beta"},
+		{SortBySensitivePatterns, "alpha"},
+		{SortBySpeedSensitive, "gamma"},
+		{SortByLastUpdated, "beta"},
+		{SortByConfidence, "beta"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.key), func(t *testing.T) {
+			apps := ai.GetTopApps(3, tt.key)
+			if len(apps) != 3 {
+				t.Fatalf("expected 3 apps, got %d", len(apps))
+			}
+			if apps[0].Name != tt.want {
+				t.Errorf("top app = %s, want %s", apps[0].Name, tt.want)
+			}
+		})
+	}
+
+	// Compatibility wrapper preserves the original ordering
+	apps := ai.GetTopAppsByConnections(1)
+	if len(apps) != 1 || apps[0].Name != "beta" {
+		t.Errorf("GetTopAppsByConnections top = %v, want beta", apps)
+	}
+}
+
+// TestGetTopAppsTieBreak tests deterministic name ordering on ties
+func TestGetTopAppsTieBreak(t *testing.T) {
+	ai := NewSplitTunnelAI(t.TempDir())
+	defer ai.Close()
+
+	ai.mu.Lock()
+	ai.appProfiles = map[string]*AppProfile{
+		"zeta":  {Name: "zeta", ConnectionCount: 5},
+		"alpha": {Name: "alpha", ConnectionCount: 5},
+		"mu":    {Name: "mu", ConnectionCount: 5},
+	}
+	ai.mu.Unlock()
+
+	for i := 0; i < 10; i++ {
+		apps := ai.GetTopApps(3, SortByConnectionCount)
+		if apps[0].Name != "alpha" || apps[1].Name != "mu" || apps[2].Name != "zeta" {
+			t.Fatalf("tied apps not in name order: %s, %s, %s", apps[0].Name, apps[1].Name, apps[2].Name)
+		}
+	}
+}
+
+// TestHourAwareDecisions tests that decisions can flip by hour of day
+// once enough per-bucket observations exist
+func TestHourAwareDecisions(t *testing.T) {
+	ai := NewSplitTunnelAI(t.TempDir())
+	defer ai.Close()
+	ai.SetHourAwareness(true, 10)
+
+	// Fake clock: train sensitive usage at 10:00, speed usage at 22:00
+	clock := time.Date(2026, 1, 5, 10, 0, 0, 0, time.UTC)
+	ai.now = func() time.Time { return clock }
+
+	for i := 0; i < 15; i++ {
+		ai.RecordConnection("workapp", "", "intranet.example", true, false)
+	}
+
+	clock = time.Date(2026, 1, 5, 22, 0, 0, 0, time.UTC)
+	for i := 0; i < 15; i++ {
+		ai.RecordConnection("workapp", "", "intranet.example", false, true)
+	}
+
+	// During work hours the app routes through Tor
+	clock = time.Date(2026, 1, 6, 10, 30, 0, 0, time.UTC)
+	useTor, _, reason := ai.ShouldUseTor("workapp", "intranet.example")
+	if !useTor || reason != "hourly" {
+		t.Errorf("at 10:30 got useTor=%v reason=%s, want Tor via hourly", useTor, reason)
+	}
+
+	// In the evening the same app goes direct
+	clock = time.Date(2026, 1, 6, 22, 30, 0, 0, time.UTC)
+	useTor, _, reason = ai.ShouldUseTor("workapp", "intranet.example")
+	if useTor || reason != "hourly" {
+		t.Errorf("at 22:30 got useTor=%v reason=%s, want direct via hourly", useTor, reason)
+	}
+
+	// An hour with no training data falls back to the global decision
+	clock = time.Date(2026, 1, 6, 3, 0, 0, 0, time.UTC)
+	_, _, reason = ai.ShouldUseTor("workapp", "intranet.example")
+	if reason == "hourly" {
+		t.Error("hour without enough observations should not decide hourly")
+	}
+
+	// Disabled, the hourly buckets are ignored entirely
+	ai.SetHourAwareness(false, 0)
+	clock = time.Date(2026, 1, 6, 22, 30, 0, 0, time.UTC)
+	_, _, reason = ai.ShouldUseTor("workapp", "intranet.example")
+	if reason == "hourly" {
+		t.Error("hourly reason with awareness disabled")
+	}
+}
+
+// TestTryRecord tests the non-blocking ingest path
+func TestTryRecord(t *testing.T) {
+	ai := NewSplitTunnelAI(t.TempDir())
+	defer ai.Close()
+
+	if !ai.TryRecord(ConnectionRecord{AppName: "curl", Domain: "example.com"}) {
+		t.Fatal("TryRecord should succeed with an empty buffer")
+	}
+
+	// The background worker eventually applies the record
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, exists := ai.GetAppProfiles()["curl"]; exists {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("ingested record never applied")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestTryRecordDropsWhenFull tests that a full buffer drops with a metric
+// instead of blocking
+func TestTryRecordDropsWhenFull(t *testing.T) {
+	ai := NewSplitTunnelAI(t.TempDir())
+
+	// Stop the worker so nothing drains the buffer
+	ai.Close()
+
+	dropped := false
+	for i := 0; i < ingestBufferSize+10; i++ {
+		if !ai.TryRecord(ConnectionRecord{AppName: "flood", Domain: "example.com"}) {
+			dropped = true
+		}
+	}
+
+	if !dropped {
+		t.Fatal("expected drops once the buffer filled")
+	}
+	if ai.DroppedRecords() == 0 {
+		t.Error("dropped records should be counted")
+	}
+}
+
+func BenchmarkRecordConnection(b *testing.B) {
+	ai := NewSplitTunnelAI(b.TempDir())
+	defer ai.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ai.RecordConnection("firefox", "/usr/bin/firefox", "example.com", false, false)
+	}
+	b.StopTimer()
+	time.Sleep(100 * time.Millisecond)
+}
+
+func BenchmarkRecordConnectionsBatch(b *testing.B) {
+	ai := NewSplitTunnelAI(b.TempDir())
+	defer ai.Close()
+
+	batch := make([]ConnectionRecord, 100)
+	for i := range batch {
+		batch[i] = ConnectionRecord{AppName: "firefox", Domain: "example.com"}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ai.RecordConnections(batch)
+	}
+	b.StopTimer()
+	time.Sleep(100 * time.Millisecond)
+}
+
+// TestDataVersioning tests loading each historical data file format
+func TestDataVersioning(t *testing.T) {
+	fixtures := []struct {
+		name     string
+		content  string
+		migrated bool
+	}{
+		{
+			name:     "v0 bare map",
+			content:  `{"chromium":{"name":"chromium","category":3,"connection_count":7}}`,
+			migrated: true,
+		},
+		{
+			name:     "v1 envelope",
+			content:  `{"version":1,"profiles":{"chromium":{"name":"chromium","category":3,"connection_count":7}}}`,
+			migrated: true,
+		},
+		{
+			name:    "v2 envelope",
+			content: `{"version":2,"profiles":{"chromium":{"name":"chromium","category":3,"connection_count":7,"tor_connections":3}}}`,
+		},
+	}
+
+	for _, f := range fixtures {
+		t.Run(f.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			path := filepath.Join(tmpDir, "app_profiles.json")
+			if err := os.WriteFile(path, []byte(f.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			ai, err := NewSplitTunnelAIWithKey(tmpDir, nil)
+			if err != nil {
+				t.Fatalf("load failed: %v", err)
+			}
+
+			profile, exists := ai.GetAppProfiles()["chromium"]
+			if !exists {
+				t.Fatal("profile not loaded")
+			}
+			if profile.ConnectionCount != 7 {
+				t.Errorf("connection count = %d, want 7", profile.ConnectionCount)
+			}
+			// Migrated profiles always carry a non-nil Domains slice
+			if f.migrated && profile.Domains == nil {
+				t.Error("migration should initialize the Domains slice")
+			}
+		})
+	}
+}
+
+// TestUserPolicyMigration tests that v2 profiles using the old
+// "counter = 100" pin encoding gain an explicit UserPolicy on load
+func TestUserPolicyMigration(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "app_profiles.json")
+	content := `{"version":2,"profiles":{
+		"bank":{"name":"bank","category":4,"sensitive_patterns":100},
+		"game":{"name":"game","category":4,"speed_sensitive":100}}}`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ai, err := NewSplitTunnelAIWithKey(tmpDir, nil)
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+
+	profiles := ai.GetAppProfiles()
+	if p := profiles["bank"]; p.UserPolicy != UserPolicyTor || p.SensitivePatterns != 0 {
+		t.Errorf("bank: policy=%q sensitive=%d, want tor/0", p.UserPolicy, p.SensitivePatterns)
+	}
+	if p := profiles["game"]; p.UserPolicy != UserPolicyDirect || p.SpeedSensitive != 0 {
+		t.Errorf("game: policy=%q speed=%d, want direct/0", p.UserPolicy, p.SpeedSensitive)
+	}
+
+	if useTor, _, _ := ai.ShouldUseTor("bank", "example.com"); !useTor {
+		t.Error("migrated Tor pin should route through Tor")
+	}
+	if useTor, _, _ := ai.ShouldUseTor("game", "example.com"); useTor {
+		t.Error("migrated direct pin should bypass Tor")
+	}
+}
+
+// TestSetAppCategoryWithNote tests that pinning records provenance
+func TestSetAppCategoryWithNote(t *testing.T) {
+	ai, err := NewSplitTunnelAIWithKey(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pinned := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	ai.now = func() time.Time { return pinned }
+
+	ai.SetAppCategoryWithNote("mybank", true, "banking app", "you")
+
+	profile := ai.GetAppProfiles()["mybank"]
+	if profile == nil {
+		t.Fatal("profile not created")
+	}
+	if profile.UserPolicy != UserPolicyTor {
+		t.Errorf("UserPolicy = %q, want %q", profile.UserPolicy, UserPolicyTor)
+	}
+	if profile.Note != "banking app" {
+		t.Errorf("Note = %q, want %q", profile.Note, "banking app")
+	}
+	if profile.SetBy != "you" {
+		t.Errorf("SetBy = %q, want %q", profile.SetBy, "you")
+	}
+	if !profile.SetAt.Equal(pinned) {
+		t.Errorf("SetAt = %v, want %v", profile.SetAt, pinned)
+	}
+	if profile.SensitivePatterns != 0 {
+		t.Errorf("pinning should not fake SensitivePatterns, got %d", profile.SensitivePatterns)
+	}
+
+	// Let the async save finish before TempDir cleanup
+	time.Sleep(100 * time.Millisecond)
+}
+
+// TestDataVersionTooNew tests that files from a newer version are refused
+func TestDataVersionTooNew(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "app_profiles.json")
+	if err := os.WriteFile(path, []byte(`{"version":99,"profiles":{}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewSplitTunnelAIWithKey(tmpDir, nil); err == nil {
+		t.Error("expected error loading a newer data file version")
+	}
+}
+
+// TestCorruptDataBackup tests that an unparseable data file is backed up
+// instead of being silently ignored
+func TestCorruptDataBackup(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "app_profiles.json")
+	if err := os.WriteFile(path, []byte(`{not json`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ai, err := NewSplitTunnelAIWithKey(tmpDir, nil)
+	if err != nil {
+		t.Fatalf("corrupt file should not abort startup: %v", err)
+	}
+	if len(ai.GetAppProfiles()) != 0 {
+		t.Error("expected a fresh profile map")
+	}
+
+	if _, err := os.Stat(path + ".bad"); err != nil {
+		t.Errorf("corrupt file should be backed up to .bad: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("corrupt file should have been moved aside")
+	}
+}
+
+// TestEncryptedDataMigration tests that legacy plaintext data files are
+// loaded and migrated to the encrypted format on the next save
+func TestEncryptedDataMigration(t *testing.T) {
+	tmpDir := t.TempDir()
+	profilesPath := filepath.Join(tmpDir, "app_profiles.json")
+
+	// Simulate a legacy plaintext data file
+	legacy := []byte(`{"firefox":{"name":"firefox","category":3,"connection_count":5}}`)
+	if err := os.WriteFile(profilesPath, legacy, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	key := []byte("test-passphrase")
+	ai, err := NewSplitTunnelAIWithKey(tmpDir, key)
+	if err != nil {
+		t.Fatalf("NewSplitTunnelAIWithKey() error = %v", err)
+	}
+
+	// Legacy data must have loaded
+	profile, exists := ai.GetAppProfiles()["firefox"]
+	if !exists {
+		t.Fatal("legacy profile not loaded")
+	}
+	if profile.ConnectionCount != 5 {
+		t.Errorf("connection count = %d, want 5", profile.ConnectionCount)
+	}
+
+	// First save migrates the file to the encrypted format
+	ai.saveData()
+
+	data, err := os.ReadFile(profilesPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, aiDataMagic) {
+		t.Error("saved file should carry the encrypted-format magic header")
+	}
+
+	// Reloading with the same key works
+	ai2, err := NewSplitTunnelAIWithKey(tmpDir, key)
+	if err != nil {
+		t.Fatalf("reload with correct key failed: %v", err)
+	}
+	if _, exists := ai2.GetAppProfiles()["firefox"]; !exists {
+		t.Error("profile lost after encrypted round-trip")
+	}
+
+	// A wrong key must fail, not silently return garbage
+	if _, err := NewSplitTunnelAIWithKey(tmpDir, []byte("wrong-key")); err == nil {
+		t.Error("expected error when loading with wrong key")
+	}
+}
+
+// TestRecordDecision tests per-app routing statistics from user perspective
+func TestRecordDecision(t *testing.T) {
+	ai := NewSplitTunnelAI(t.TempDir())
+
+	// Record a mix of Tor and direct traffic
+	ai.RecordDecision("firefox", true, 1024)
+	ai.RecordDecision("firefox", true, 2048)
+	ai.RecordDecision("firefox", false, 512)
+	ai.RecordDecision("steam", false, 4096)
+
+	stats, ok := ai.GetAppStats("firefox")
+	if !ok {
+		t.Fatal("expected stats for firefox")
+	}
+
+	if stats.TorConnections != 2 {
+		t.Errorf("firefox tor connections = %d, want 2", stats.TorConnections)
+	}
+	if stats.DirectConnections != 1 {
+		t.Errorf("firefox direct connections = %d, want 1", stats.DirectConnections)
+	}
+	if stats.TorBytes != 3072 {
+		t.Errorf("firefox tor bytes = %d, want 3072", stats.TorBytes)
+	}
+	if stats.DirectBytes != 512 {
+		t.Errorf("firefox direct bytes = %d, want 512", stats.DirectBytes)
+	}
+
+	// Unknown app
+	if _, ok := ai.GetAppStats("unknown"); ok {
+		t.Error("expected no stats for unknown app")
+	}
+
+	// Aggregate totals
+	totals := ai.GetTotals()
+	if totals.TorConnections != 2 {
+		t.Errorf("total tor connections = %d, want 2", totals.TorConnections)
+	}
+	if totals.DirectBytes != 4608 {
+		t.Errorf("total direct bytes = %d, want 4608", totals.DirectBytes)
+	}
+}
+
+func TestShouldUseTorIDNNormalization(t *testing.T) {
+	ai := NewSplitTunnelAI(t.TempDir())
+	defer ai.Close()
+	ai.AddSensitiveDomain("münchen-bank.de")
+
+	// Unicode, punycode, mixed-case, and trailing-dot forms of the same
+	// name must all hit the sensitive-domain rule
+	for _, domain := range []string{
+		"münchen-bank.de",
+		"MÜNCHEN-BANK.DE.",
+		"xn--mnchen-bank-thb.de",
+		"login.xn--mnchen-bank-thb.de",
+	} {
+		useTor, confidence, reason := ai.ShouldUseTor("firefox", domain)
+		if !useTor || reason != "sensitive_domain" {
+			t.Errorf("ShouldUseTor(%q) = (%v, %v, %s), want sensitive-domain Tor routing", domain, useTor, confidence, reason)
+		}
+	}
+}
+
+func TestMixedCasePinHonored(t *testing.T) {
+	ai := NewSplitTunnelAI(t.TempDir())
+	defer ai.Close()
+
+	// A pin set under the raw process name must be found by evaluation,
+	// which looks profiles up under the normalized identity
+	ai.SetAppCategory("Firefox", true)
+
+	useTor, confidence, reason := ai.ShouldUseTor("Firefox", "neutral.example")
+	if !useTor || reason != "user_defined" {
+		t.Errorf("ShouldUseTor(Firefox) = (%v, %v, %s), want user-pinned Tor routing", useTor, confidence, reason)
+	}
+	useTor, _, reason = ai.ShouldUseTor("firefox", "neutral.example")
+	if !useTor || reason != "user_defined" {
+		t.Errorf("ShouldUseTor(firefox) = (%v, %s), want the same pin under the normalized name", useTor, reason)
+	}
+
+	// Stats and decisions share the key regardless of casing
+	ai.RecordDecision("FIREFOX", true, 1024)
+	stats, ok := ai.GetAppStats("Firefox")
+	if !ok {
+		t.Fatal("expected stats for Firefox")
+	}
+	if stats.TorConnections != 1 {
+		t.Errorf("tor connections = %d, want 1", stats.TorConnections)
+	}
+}
+
+func TestNormalizeProfileKeysMergesRawEntries(t *testing.T) {
+	ai := NewSplitTunnelAI(t.TempDir())
+	defer ai.Close()
+
+	// Simulate an old data file holding the same app under two raw keys
+	merged := ai.normalizeProfileKeys(map[string]*AppProfile{
+		"Firefox": {
+			Name:           "Firefox",
+			Category:       CategoryUserDefined,
+			UserPolicy:     UserPolicyTor,
+			Domains:        []string{"a.example"},
+			TorConnections: 2,
+		},
+		"firefox": {
+			Name:              "firefox",
+			Category:          CategoryLearning,
+			Domains:           []string{"a.example", "b.example"},
+			DirectConnections: 3,
+		},
+	})
+
+	if len(merged) != 1 {
+		t.Fatalf("got %d profiles after normalization, want 1", len(merged))
+	}
+	profile, ok := merged["firefox"]
+	if !ok {
+		t.Fatal("merged profile should live under the normalized key")
+	}
+	if profile.Category != CategoryUserDefined || profile.UserPolicy != UserPolicyTor {
+		t.Errorf("user pin lost in merge: category=%s policy=%s", profile.Category, profile.UserPolicy)
+	}
+	if profile.TorConnections != 2 || profile.DirectConnections != 3 {
+		t.Errorf("counters = %d/%d, want 2/3", profile.TorConnections, profile.DirectConnections)
+	}
+	if len(profile.Domains) != 2 {
+		t.Errorf("domains = %v, want the union of both lists", profile.Domains)
+	}
+}
@@ -0,0 +1,114 @@
+// Package bypass - unified decision facade over the rule engine and the
+// learned smart bypass
+package bypass
+
+import (
+	"fmt"
+	"net"
+)
+
+// Decision is the combined routing verdict for one connection
+type Decision struct {
+	Action Action  `json:"action"` // bypass, block, or tor
+	Source string  `json:"source"` // "rule", "learned", or "default"
+	Score  float64 `json:"score"`  // Learned bypass score; 1 for rules, 0 for the default
+	Reason string  `json:"reason"`
+}
+
+// Decider combines the rule engine and the optional smart bypass into
+// one decision with a fixed precedence:
+//
+//  1. an explicit engine block always wins
+//  2. then any engine bypass rule
+//  3. then a smart-bypass suggestion at or above the configured
+//     confidence
+//  4. otherwise the default: route through Tor
+//
+// The daemon asks the Decider instead of guessing how to combine the
+// two brains itself.
+type Decider struct {
+	engine        *Engine
+	smart         *SmartBypass // Optional; nil disables learned suggestions
+	minConfidence float64
+}
+
+// NewDecider creates a decider. The engine is required; smart may be
+// nil. minConfidence gates learned suggestions (0 accepts everything,
+// 1 only fully confident patterns).
+func NewDecider(engine *Engine, smart *SmartBypass, minConfidence float64) (*Decider, error) {
+	if engine == nil {
+		return nil, fmt.Errorf("decider requires a rule engine")
+	}
+	if minConfidence < 0 || minConfidence > 1 {
+		return nil, fmt.Errorf("min confidence must be in [0, 1], got %v", minConfidence)
+	}
+	return &Decider{
+		engine:        engine,
+		smart:         smart,
+		minConfidence: minConfidence,
+	}, nil
+}
+
+// Decide returns the routing decision for a connection, applying the
+// documented precedence
+func (d *Decider) Decide(domain string, ip net.IP, port int, proto, app string) Decision {
+	result := d.engine.Match(MatchRequest{
+		Domain:   domain,
+		IP:       ip,
+		Port:     port,
+		Protocol: proto,
+		App:      app,
+	})
+
+	// Engine rules decide outright: block first, then any explicit
+	// bypass or tor rule
+	if result.Matched && result.Action != "" {
+		reason := result.Reason
+		if reason == "" {
+			reason = fmt.Sprintf("engine %s rule", result.Action)
+		}
+		return Decision{
+			Action: result.Action,
+			Source: "rule",
+			Score:  1,
+			Reason: reason,
+		}
+	}
+
+	if decision, ok := d.learnedSuggestion(domain, ip, port, proto); ok {
+		return decision
+	}
+
+	return Decision{
+		Action: ActionTor,
+		Source: "default",
+		Reason: "no rule or learned pattern matched",
+	}
+}
+
+// learnedSuggestion asks the smart bypass, filtering its answer by the
+// configured confidence. Built-in heuristics (local domains, app
+// signatures) count as fully confident; learned patterns carry their
+// recorded score and confidence.
+func (d *Decider) learnedSuggestion(domain string, ip net.IP, port int, proto string) (Decision, bool) {
+	if d.smart == nil || !d.smart.ShouldBypass(domain, ip, port, proto) {
+		return Decision{}, false
+	}
+
+	score, confidence := 1.0, 1.0
+	d.smart.mu.RLock()
+	if pattern, ok := d.smart.patterns[domain]; ok {
+		score, confidence = pattern.BypassScore, pattern.Confidence
+	}
+	d.smart.mu.RUnlock()
+
+	if confidence < d.minConfidence {
+		return Decision{}, false
+	}
+	return Decision{
+		Action: ActionBypass,
+		Source: "learned",
+		Score:  score,
+		Reason: fmt.Sprintf("learned pattern for %s (confidence %.2f)", domain, confidence),
+	}, true
+}
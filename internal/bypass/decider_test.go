@@ -0,0 +1,155 @@
+package bypass
+
+import (
+	"net"
+	"testing"
+
+	"github.com/jery0843/torforge/pkg/config"
+)
+
+func newDeciderFixtures(t *testing.T, minConfidence float64) (*Decider, *Engine, *SmartBypass) {
+	t.Helper()
+
+	eng, err := NewEngine(&config.BypassConfig{Enabled: true})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	t.Cleanup(eng.Close)
+
+	smart, err := NewSmartBypass(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewSmartBypass() error = %v", err)
+	}
+
+	decider, err := NewDecider(eng, smart, minConfidence)
+	if err != nil {
+		t.Fatalf("NewDecider() error = %v", err)
+	}
+	return decider, eng, smart
+}
+
+func TestDeciderValidation(t *testing.T) {
+	eng, err := NewEngine(&config.BypassConfig{Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer eng.Close()
+
+	if _, err := NewDecider(nil, nil, 0.5); err == nil {
+		t.Error("NewDecider without engine expected error")
+	}
+	if _, err := NewDecider(eng, nil, 1.5); err == nil {
+		t.Error("NewDecider with out-of-range confidence expected error")
+	}
+	if _, err := NewDecider(eng, nil, 0.5); err != nil {
+		t.Errorf("NewDecider without smart bypass error = %v", err)
+	}
+}
+
+func TestDecideBlockRuleBeatsLearnedBypass(t *testing.T) {
+	decider, eng, smart := newDeciderFixtures(t, 0)
+
+	// The smart bypass has fully learned this domain should bypass...
+	smart.AddManualBypass("ads.corp.example")
+
+	// ...but an explicit block rule must still win
+	if err := eng.AddRule(Rule{
+		Name:    "block-ads",
+		Type:    RuleTypeDomain,
+		Pattern: "ads.corp.example",
+		Action:  ActionBlock,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	decision := decider.Decide("ads.corp.example", nil, 443, "tcp", "")
+	if decision.Action != ActionBlock || decision.Source != "rule" {
+		t.Errorf("Decide() = %+v, want block from rule", decision)
+	}
+}
+
+func TestDecideEngineBypassBeatsLearned(t *testing.T) {
+	decider, eng, smart := newDeciderFixtures(t, 0)
+
+	smart.mu.Lock()
+	smart.patterns["printer.corp.example"] = &TrafficPattern{
+		Domain: "printer.corp.example", BypassScore: 0.8, Confidence: 0.6,
+	}
+	smart.mu.Unlock()
+
+	if err := eng.AddRule(Rule{
+		Name:    "corp-printer",
+		Type:    RuleTypeDomain,
+		Pattern: "printer.corp.example",
+		Action:  ActionBypass,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	decision := decider.Decide("printer.corp.example", nil, 631, "tcp", "")
+	if decision.Action != ActionBypass || decision.Source != "rule" || decision.Score != 1 {
+		t.Errorf("Decide() = %+v, want bypass from rule with score 1", decision)
+	}
+}
+
+func TestDecideLearnedAboveConfidence(t *testing.T) {
+	decider, _, smart := newDeciderFixtures(t, 0.5)
+
+	smart.mu.Lock()
+	smart.patterns["nas.corp.example"] = &TrafficPattern{
+		Domain: "nas.corp.example", BypassScore: 0.9, Confidence: 0.8,
+	}
+	smart.mu.Unlock()
+
+	decision := decider.Decide("nas.corp.example", nil, 445, "tcp", "")
+	if decision.Action != ActionBypass || decision.Source != "learned" {
+		t.Errorf("Decide() = %+v, want learned bypass", decision)
+	}
+	if decision.Score != 0.9 {
+		t.Errorf("Decide() score = %v, want the learned 0.9", decision.Score)
+	}
+}
+
+func TestDecideLearnedBelowConfidenceFallsThrough(t *testing.T) {
+	decider, _, smart := newDeciderFixtures(t, 0.8)
+
+	// Confident enough for the smart bypass itself, but below the
+	// decider's bar
+	smart.mu.Lock()
+	smart.patterns["nas.corp.example"] = &TrafficPattern{
+		Domain: "nas.corp.example", BypassScore: 0.9, Confidence: 0.6,
+	}
+	smart.mu.Unlock()
+
+	decision := decider.Decide("nas.corp.example", nil, 445, "tcp", "")
+	if decision.Action != ActionTor || decision.Source != "default" {
+		t.Errorf("Decide() = %+v, want the Tor default", decision)
+	}
+}
+
+func TestDecideDefault(t *testing.T) {
+	decider, _, _ := newDeciderFixtures(t, 0.5)
+
+	decision := decider.Decide("unknown.example", net.ParseIP("198.51.100.1"), 443, "tcp", "")
+	if decision.Action != ActionTor || decision.Source != "default" || decision.Score != 0 {
+		t.Errorf("Decide() = %+v, want the Tor default with score 0", decision)
+	}
+}
+
+func TestDecideWithoutSmartBypass(t *testing.T) {
+	eng, err := NewEngine(&config.BypassConfig{Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer eng.Close()
+
+	decider, err := NewDecider(eng, nil, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decision := decider.Decide("unknown.example", nil, 443, "tcp", "")
+	if decision.Action != ActionTor || decision.Source != "default" {
+		t.Errorf("Decide() = %+v, want the Tor default", decision)
+	}
+}
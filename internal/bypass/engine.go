@@ -5,33 +5,176 @@ import (
 	"fmt"
 	"net"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/jery0843/torforge/pkg/config"
 	"github.com/jery0843/torforge/pkg/logger"
 )
 
+// ruleStats counts how often a rule or built-in pattern fires. Updated
+// with atomics so the match path never needs the write lock.
+type ruleStats struct {
+	hits    atomic.Int64
+	lastHit atomic.Int64 // UnixNano; 0 means never
+}
+
+func (s *ruleStats) record() {
+	s.hits.Add(1)
+	s.lastHit.Store(time.Now().UnixNano())
+}
+
+// RuleStats is a point-in-time snapshot of one rule's usage
+type RuleStats struct {
+	Name    string
+	Type    RuleType
+	Pattern string
+	Hits    int64
+	LastHit time.Time // Zero when the rule never fired
+}
+
+// domainPattern is one compiled built-in bypass domain glob. The regex
+// is only set for genuinely complex globs; plain names and *.suffix
+// patterns are matched through the domainIndex maps instead.
+type domainPattern struct {
+	glob  string
+	re    *regexp.Regexp
+	stats *ruleStats
+}
+
+// domainIndex matches the built-in domain patterns without scanning a
+// regex per pattern. The overwhelmingly common forms — an exact name or
+// "*.suffix" — become map lookups; only globs with embedded wildcards
+// stay on the regex path.
+type domainIndex struct {
+	exact   map[string]*domainPattern // Keyed by the full lowercased name
+	suffix  map[string]*domainPattern // Keyed by the labels after "*." or "**."
+	complex []*domainPattern
+}
+
+func newDomainIndex() *domainIndex {
+	return &domainIndex{
+		exact:  make(map[string]*domainPattern),
+		suffix: make(map[string]*domainPattern),
+	}
+}
+
+// add classifies and indexes one glob pattern
+func (idx *domainIndex) add(pattern string) (*domainPattern, error) {
+	p := normalizeGlobPattern(pattern)
+	dp := &domainPattern{glob: pattern, stats: &ruleStats{}}
+	switch {
+	case !strings.ContainsAny(p, "*?["):
+		idx.exact[p] = dp
+	case strings.HasPrefix(p, "**.") && !strings.ContainsAny(p[3:], "*?["):
+		idx.suffix[p[3:]] = dp
+	case strings.HasPrefix(p, "*.") && !strings.ContainsAny(p[2:], "*?["):
+		idx.suffix[p[2:]] = dp
+	default:
+		re, err := compileGlobToRegex(p)
+		if err != nil {
+			return nil, err
+		}
+		dp.re = re
+		idx.complex = append(idx.complex, dp)
+	}
+	return dp, nil
+}
+
+// lookup returns the first pattern matching the (lowercased) domain, or
+// nil. Suffix patterns are found by walking the query's parent domains,
+// so "*.corp.example" hits for "a.b.corp.example" without touching any
+// other pattern.
+func (idx *domainIndex) lookup(domain string) *domainPattern {
+	if dp, ok := idx.exact[domain]; ok {
+		return dp
+	}
+
+	// "*.suffix" and "**.suffix" match strict subdomains at any depth
+	rest := domain
+	for {
+		i := strings.Index(rest, ".")
+		if i < 0 {
+			break
+		}
+		rest = rest[i+1:]
+		if dp, ok := idx.suffix[rest]; ok {
+			return dp
+		}
+	}
+
+	for _, dp := range idx.complex {
+		if dp.re.MatchString(domain) {
+			return dp
+		}
+	}
+	return nil
+}
+
+// appPattern is one compiled application glob. Exact entries stay in
+// the applications set; only entries with wildcards land here.
+type appPattern struct {
+	glob string
+	re   *regexp.Regexp
+}
+
+// compileAppGlob compiles an application glob with '/' as the boundary
+// character, so "/opt/games/*" stays inside one path segment while
+// "/opt/games/**" recurses
+func compileAppGlob(pattern string) (*regexp.Regexp, error) {
+	return compileGlobSep(pattern, false, '/')
+}
+
+// cidrNet is one parsed built-in bypass CIDR
+type cidrNet struct {
+	ipNet *net.IPNet
+	stats *ruleStats
+}
+
 // Engine manages bypass rules
 type Engine struct {
 	cfg            *config.BypassConfig
-	domainPatterns []*regexp.Regexp
-	cidrNets       []*net.IPNet
+	domainPatterns []*domainPattern // Declaration order, for stats
+	domainIdx      *domainIndex
+	cidrNets       []*cidrNet // Declaration order, for stats
+	cidrTrie       *cidrTrie
 	protocols      map[string]bool
 	applications   map[string]bool
+	appGlobs       []*appPattern
+	runtimeApps    []string // Added via AddApplication; survive Reload
 	customRules    []Rule
 	geoIP          *GeoIPMatcher
+	rulesPath      string // Dynamic rules file; empty disables persistence
+	subs           map[string]*subscription
+	janitorStop    chan struct{}
+	now            func() time.Time // Injectable for tests
+	metrics        metricsRegistry
 	mu             sync.RWMutex
 }
 
 // Rule represents a bypass rule
 type Rule struct {
-	Name        string
-	Type        RuleType
-	Pattern     string
-	Action      Action
-	Description string
-	compiled    interface{} // Compiled pattern (regexp, IPNet, etc.)
+	Name          string
+	Type          RuleType
+	Pattern       string
+	Action        Action
+	Priority      int       // Lower values evaluate first; ties keep insertion order
+	ExpiresAt     time.Time // Zero means the rule never expires
+	Description   string
+	AllowCatchAll bool        // Permit patterns like "*" that match everything
+	compiled      interface{} // Compiled pattern (regexp, IPNet, etc.)
+	runtime       bool        // Added via AddRule rather than config; survives Reload
+	subscription  string      // Owning subscription name, empty for local rules
+	stats         *ruleStats
+}
+
+// expired reports whether the rule's TTL has passed
+func (r *Rule) expired(now time.Time) bool {
+	return !r.ExpiresAt.IsZero() && now.After(r.ExpiresAt)
 }
 
 // RuleType defines the type of bypass rule
@@ -44,6 +187,7 @@ const (
 	RuleTypeProtocol RuleType = "protocol"
 	RuleTypeApp      RuleType = "application"
 	RuleTypeGeoIP    RuleType = "geoip"
+	RuleTypeASN      RuleType = "asn"
 )
 
 // Action defines what to do with matched traffic
@@ -57,100 +201,251 @@ const (
 
 // MatchResult contains the result of a rule match
 type MatchResult struct {
-	Matched bool
-	Rule    *Rule
-	Action  Action
-	Reason  string
+	Matched   bool
+	Rule      *Rule
+	Action    Action
+	Reason    string
+	Dimension string // Which dimension decided: domain, ip, port, protocol, application
+}
+
+// MatchRequest carries everything known about a connection so Match can
+// evaluate all rule dimensions in one call
+type MatchRequest struct {
+	Domain   string
+	IP       net.IP
+	Port     int
+	Protocol string
+	App      string
+}
+
+// compiledRules is one config snapshot's worth of rule state, built on
+// the side so it can be swapped into the engine atomically
+type compiledRules struct {
+	domainPatterns []*domainPattern
+	domainIdx      *domainIndex
+	cidrNets       []*cidrNet
+	protocols      map[string]bool
+	applications   map[string]bool
+	appGlobs       []*appPattern
+	customRules    []Rule
+	geoIP          *GeoIPMatcher
 }
 
 // NewEngine creates a new bypass engine
 func NewEngine(cfg *config.BypassConfig) (*Engine, error) {
-	e := &Engine{
-		cfg:          cfg,
-		protocols:    make(map[string]bool),
-		applications: make(map[string]bool),
-	}
-
-	if err := e.compileRules(); err != nil {
+	state, err := compileConfig(cfg)
+	if err != nil {
 		return nil, err
 	}
 
+	e := &Engine{cfg: cfg, now: time.Now}
+	e.applyLocked(state)
+
 	return e, nil
 }
 
-func (e *Engine) compileRules() error {
+// Reload swaps in rules recompiled from a new config. The new state is
+// built entirely on the side, so concurrent Match calls only ever see
+// the complete old or complete new rule set. Rules added at runtime via
+// AddRule are preserved.
+func (e *Engine) Reload(cfg *config.BypassConfig) error {
+	log := logger.WithComponent("bypass")
+
+	state, err := compileConfig(cfg)
+	if err != nil {
+		return err
+	}
+
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	// Carry over runtime-added and subscription rules
+	for _, r := range e.customRules {
+		if r.runtime || r.subscription != "" {
+			state.customRules = append(state.customRules, r)
+		}
+	}
+	sortRules(state.customRules)
+
+	// Carry over runtime-added applications
+	for _, a := range e.runtimeApps {
+		if !strings.ContainsAny(a, "*?[") {
+			state.applications[a] = true
+			continue
+		}
+		if re, err := compileAppGlob(a); err == nil {
+			state.appGlobs = append(state.appGlobs, &appPattern{glob: a, re: re})
+		}
+	}
+
+	e.cfg = cfg
+	e.applyLocked(state)
+
+	log.Info().
+		Int("domains", len(e.domainPatterns)).
+		Int("cidrs", len(e.cidrNets)).
+		Int("rules", len(e.customRules)).
+		Msg("bypass rules reloaded")
+	return nil
+}
+
+// applyLocked installs a compiled snapshot. Callers must hold the write
+// lock (or own the engine exclusively, as in NewEngine).
+func (e *Engine) applyLocked(state *compiledRules) {
+	e.domainPatterns = state.domainPatterns
+	e.domainIdx = state.domainIdx
+	e.cidrNets = state.cidrNets
+	e.protocols = state.protocols
+	e.applications = state.applications
+	e.appGlobs = state.appGlobs
+	e.customRules = state.customRules
+	e.geoIP = state.geoIP
+	e.rebuildCIDRTrieLocked()
+}
+
+// rebuildCIDRTrieLocked reindexes the built-in CIDRs and every custom
+// CIDR rule. Called after any mutation of the rule set, since trie
+// entries hold pointers into customRules. Callers must hold the write
+// lock (or own the engine exclusively).
+func (e *Engine) rebuildCIDRTrieLocked() {
+	t := newCIDRTrie()
+	for _, cn := range e.cidrNets {
+		t.insert(cn.ipNet, &trieEntry{ipNet: cn.ipNet, stats: cn.stats})
+	}
+	for i := range e.customRules {
+		rule := &e.customRules[i]
+		if rule.Type != RuleTypeCIDR {
+			continue
+		}
+		if ipNet, ok := rule.compiled.(*net.IPNet); ok {
+			t.insert(ipNet, &trieEntry{ipNet: ipNet, rule: rule, stats: rule.stats})
+		}
+	}
+	e.cidrTrie = t
+}
+
+func compileConfig(cfg *config.BypassConfig) (*compiledRules, error) {
 	log := logger.WithComponent("bypass")
 
-	// Compile domain patterns
-	for _, pattern := range e.cfg.Domains {
-		re, err := compileGlobToRegex(pattern)
+	state := &compiledRules{
+		protocols:    make(map[string]bool),
+		applications: make(map[string]bool),
+	}
+
+	// Compile domain patterns into the suffix/exact index
+	state.domainIdx = newDomainIndex()
+	for _, pattern := range cfg.Domains {
+		dp, err := state.domainIdx.add(pattern)
 		if err != nil {
 			log.Warn().Err(err).Str("pattern", pattern).Msg("invalid domain pattern")
 			continue
 		}
-		e.domainPatterns = append(e.domainPatterns, re)
+		state.domainPatterns = append(state.domainPatterns, dp)
 	}
-	log.Debug().Int("count", len(e.domainPatterns)).Msg("compiled domain patterns")
+	log.Debug().Int("count", len(state.domainPatterns)).Msg("compiled domain patterns")
 
 	// Parse CIDR ranges
-	for _, cidr := range e.cfg.CIDRs {
+	for _, cidr := range cfg.CIDRs {
 		_, ipNet, err := net.ParseCIDR(cidr)
 		if err != nil {
 			log.Warn().Err(err).Str("cidr", cidr).Msg("invalid CIDR")
 			continue
 		}
-		e.cidrNets = append(e.cidrNets, ipNet)
+		state.cidrNets = append(state.cidrNets, &cidrNet{
+			ipNet: ipNet,
+			stats: &ruleStats{},
+		})
 	}
-	log.Debug().Int("count", len(e.cidrNets)).Msg("parsed CIDR ranges")
+	log.Debug().Int("count", len(state.cidrNets)).Msg("parsed CIDR ranges")
 
 	// Build protocol set
-	for _, proto := range e.cfg.Protocols {
-		e.protocols[strings.ToLower(proto)] = true
+	for _, proto := range cfg.Protocols {
+		state.protocols[strings.ToLower(proto)] = true
 	}
 
-	// Build application set
-	for _, app := range e.cfg.Applications {
-		e.applications[strings.ToLower(app)] = true
+	// Build application set; wildcard entries compile into path globs
+	for _, app := range cfg.Applications {
+		a := strings.ToLower(app)
+		if !strings.ContainsAny(a, "*?[") {
+			state.applications[a] = true
+			continue
+		}
+		re, err := compileAppGlob(a)
+		if err != nil {
+			log.Warn().Err(err).Str("application", app).Msg("invalid application pattern")
+			continue
+		}
+		state.appGlobs = append(state.appGlobs, &appPattern{glob: a, re: re})
+	}
+
+	// Deny lists compile into block rules so they ride the same
+	// precedence machinery (block always wins)
+	for _, pattern := range cfg.DenyDomains {
+		rule := Rule{
+			Name:        fmt.Sprintf("deny-domain:%s", pattern),
+			Type:        RuleTypeDomain,
+			Pattern:     pattern,
+			Action:      ActionBlock,
+			Description: fmt.Sprintf("deny list domain %s", pattern),
+		}
+		if err := compileRule(&rule); err != nil {
+			log.Warn().Err(err).Str("pattern", pattern).Msg("invalid deny domain")
+			continue
+		}
+		state.customRules = append(state.customRules, rule)
+	}
+	for _, cidr := range cfg.DenyCIDRs {
+		rule := Rule{
+			Name:        fmt.Sprintf("deny-cidr:%s", cidr),
+			Type:        RuleTypeCIDR,
+			Pattern:     cidr,
+			Action:      ActionBlock,
+			Description: fmt.Sprintf("deny list CIDR %s", cidr),
+		}
+		if err := compileRule(&rule); err != nil {
+			log.Warn().Err(err).Str("cidr", cidr).Msg("invalid deny CIDR")
+			continue
+		}
+		state.customRules = append(state.customRules, rule)
 	}
 
 	// Compile custom rules
-	for _, r := range e.cfg.CustomRules {
+	for _, r := range cfg.CustomRules {
 		rule := Rule{
 			Name:        r.Name,
 			Type:        RuleType(r.Type),
 			Pattern:     r.Pattern,
 			Action:      Action(r.Action),
+			Priority:    r.Priority,
 			Description: r.Description,
 		}
 
-		if err := e.compileRule(&rule); err != nil {
+		if err := compileRule(&rule); err != nil {
 			log.Warn().Err(err).Str("rule", r.Name).Msg("failed to compile rule")
 			continue
 		}
 
-		e.customRules = append(e.customRules, rule)
+		state.customRules = append(state.customRules, rule)
 	}
+	sortRules(state.customRules)
 
 	// Initialize GeoIP if enabled
-	if e.cfg.GeoIP.Enabled {
-		geoIP, err := NewGeoIPMatcher(e.cfg.GeoIP.DatabasePath, e.cfg.GeoIP.Countries)
+	if cfg.GeoIP.Enabled {
+		geoIP, err := NewGeoIPMatcherWithASN(cfg.GeoIP.DatabasePath, cfg.GeoIP.ASNDatabasePath, cfg.GeoIP.Countries, cfg.GeoIP.ASNs, cfg.GeoIP.ExcludeASNs)
 		if err != nil {
 			log.Warn().Err(err).Msg("failed to initialize GeoIP")
 		} else {
-			e.geoIP = geoIP
+			state.geoIP = geoIP
 		}
 	}
 
-	return nil
+	return state, nil
 }
 
-func (e *Engine) compileRule(r *Rule) error {
+func compileRule(r *Rule) error {
 	switch r.Type {
 	case RuleTypeDomain:
-		re, err := compileGlobToRegex(r.Pattern)
+		re, err := compileGlob(normalizeGlobPattern(r.Pattern), r.AllowCatchAll)
 		if err != nil {
 			return err
 		}
@@ -161,206 +456,933 @@ func (e *Engine) compileRule(r *Rule) error {
 			return err
 		}
 		r.compiled = ipNet
+	case RuleTypeASN:
+		asn, err := strconv.ParseUint(r.Pattern, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid ASN %q: %w", r.Pattern, err)
+		}
+		r.compiled = uint(asn)
+	case RuleTypePort:
+		set, err := parsePortSet(r.Pattern)
+		if err != nil {
+			return err
+		}
+		r.compiled = set
+	case RuleTypeProtocol:
+		proto := strings.ToLower(strings.TrimSpace(r.Pattern))
+		if proto == "" {
+			return fmt.Errorf("empty protocol pattern")
+		}
+		r.compiled = proto
+	default:
+		return fmt.Errorf("unsupported rule type %q", r.Type)
 	}
+	r.stats = &ruleStats{}
 	return nil
 }
 
+// portRange is one inclusive span of a compiled port rule
+type portRange struct {
+	lo, hi int
+}
+
+// portSet is the compiled form of a port rule pattern such as "443",
+// "6881-6999" or "80,443,8080-8090"
+type portSet []portRange
+
+func (s portSet) contains(port int) bool {
+	for _, r := range s {
+		if port >= r.lo && port <= r.hi {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePortSet parses single ports, comma-separated lists and lo-hi
+// ranges into a portSet
+func parsePortSet(pattern string) (portSet, error) {
+	var set portSet
+	for _, part := range strings.Split(pattern, ",") {
+		part = strings.TrimSpace(part)
+		lo, hi := part, part
+		if before, after, found := strings.Cut(part, "-"); found {
+			lo, hi = strings.TrimSpace(before), strings.TrimSpace(after)
+		}
+
+		loPort, err := parsePort(lo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port pattern %q: %w", pattern, err)
+		}
+		hiPort, err := parsePort(hi)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port pattern %q: %w", pattern, err)
+		}
+		if loPort > hiPort {
+			return nil, fmt.Errorf("invalid port pattern %q: range %s is backwards", pattern, part)
+		}
+
+		set = append(set, portRange{lo: loPort, hi: hiPort})
+	}
+	return set, nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a port number", s)
+	}
+	if port < 1 || port > 65535 {
+		return 0, fmt.Errorf("port %d out of range", port)
+	}
+	return port, nil
+}
+
+// Match evaluates every dimension of a connection in one call with a
+// fixed precedence: block rules beat everything, then user custom rules,
+// then the built-in bypass lists; an unmatched request defaults to Tor.
+// The returned result says which dimension and rule decided.
+func (e *Engine) Match(req MatchRequest) MatchResult {
+	e.mu.RLock()
+
+	if !e.cfg.Enabled {
+		e.mu.RUnlock()
+		return MatchResult{Matched: false}
+	}
+
+	var results []MatchResult
+	if req.Domain != "" {
+		results = append(results, e.matchDomainLocked(req.Domain)...)
+	}
+	if req.IP != nil {
+		results = append(results, e.matchIPLocked(req.IP)...)
+	}
+	if req.Port != 0 {
+		results = append(results, e.matchPortLocked(req.Port)...)
+	}
+	if req.Protocol != "" {
+		results = append(results, e.matchProtocolLocked(req.Protocol)...)
+	}
+	if req.App != "" {
+		results = append(results, e.matchApplicationLocked(req.App, "")...)
+	}
+	result := pickResult(results)
+	e.mu.RUnlock()
+
+	e.recordMatch(result)
+	return result
+}
+
+// IsBlocked reports whether any dimension of the request hits a block
+// rule. Blocked traffic must be dropped, not routed through Tor.
+func (e *Engine) IsBlocked(req MatchRequest) bool {
+	result := e.Match(req)
+	return result.Matched && result.Action == ActionBlock
+}
+
+// pickResult applies the match precedence to a set of candidate results:
+// block > custom rules > built-in bypass lists. Ties go to the earlier
+// candidate, which keeps rule declaration order meaningful.
+func pickResult(results []MatchResult) MatchResult {
+	rank := func(r MatchResult) int {
+		switch {
+		case r.Action == ActionBlock:
+			return 3
+		case r.Rule != nil:
+			return 2
+		default:
+			return 1
+		}
+	}
+
+	best := MatchResult{Matched: false}
+	for _, r := range results {
+		if !r.Matched {
+			continue
+		}
+		if !best.Matched || rank(r) > rank(best) {
+			best = r
+		}
+	}
+	return best
+}
+
 // MatchDomain checks if a domain matches any bypass rule
 func (e *Engine) MatchDomain(domain string) MatchResult {
 	e.mu.RLock()
-	defer e.mu.RUnlock()
 
 	if !e.cfg.Enabled {
+		e.mu.RUnlock()
 		return MatchResult{Matched: false}
 	}
 
-	domain = strings.ToLower(domain)
+	result := pickResult(e.matchDomainLocked(domain))
+	e.mu.RUnlock()
+
+	e.recordMatch(result)
+	return result
+}
+
+func (e *Engine) matchDomainLocked(domain string) []MatchResult {
+	var results []MatchResult
+	domain = NormalizeDomain(domain)
 
 	// Check domain patterns
-	for _, re := range e.domainPatterns {
-		if re.MatchString(domain) {
-			return MatchResult{
-				Matched: true,
-				Action:  ActionBypass,
-				Reason:  fmt.Sprintf("matches pattern %s", re.String()),
-			}
-		}
+	if dp := e.domainIdx.lookup(domain); dp != nil {
+		dp.stats.record()
+		results = append(results, MatchResult{
+			Matched:   true,
+			Action:    ActionBypass,
+			Reason:    fmt.Sprintf("matches pattern %s", dp.glob),
+			Dimension: "domain",
+		})
 	}
 
 	// Check custom rules
+	now := e.now()
 	for _, rule := range e.customRules {
+		if rule.expired(now) {
+			continue
+		}
 		if rule.Type == RuleTypeDomain {
 			if re, ok := rule.compiled.(*regexp.Regexp); ok {
 				if re.MatchString(domain) {
-					return MatchResult{
-						Matched: true,
-						Rule:    &rule,
-						Action:  rule.Action,
-						Reason:  rule.Description,
-					}
+					rule.stats.record()
+					results = append(results, MatchResult{
+						Matched:   true,
+						Rule:      &rule,
+						Action:    rule.Action,
+						Reason:    rule.Description,
+						Dimension: "domain",
+					})
 				}
 			}
 		}
 	}
 
-	return MatchResult{Matched: false}
+	return results
 }
 
 // MatchIP checks if an IP matches any bypass rule
 func (e *Engine) MatchIP(ip net.IP) MatchResult {
 	e.mu.RLock()
-	defer e.mu.RUnlock()
 
 	if !e.cfg.Enabled {
+		e.mu.RUnlock()
 		return MatchResult{Matched: false}
 	}
 
-	// Check CIDR ranges
-	for _, ipNet := range e.cidrNets {
-		if ipNet.Contains(ip) {
-			return MatchResult{
-				Matched: true,
-				Action:  ActionBypass,
-				Reason:  fmt.Sprintf("matches CIDR %s", ipNet.String()),
-			}
+	result := pickResult(e.matchIPLocked(ip))
+	e.mu.RUnlock()
+
+	e.recordMatch(result)
+	return result
+}
+
+func (e *Engine) matchIPLocked(ip net.IP) []MatchResult {
+	// An IPv4-mapped IPv6 address (::ffff:a.b.c.d) is the same
+	// destination as its v4 form; normalize so it hits the v4 rules
+	// instead of falling through the v6 side
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+
+	var results []MatchResult
+	now := e.now()
+
+	// One trie walk covers the built-in CIDRs and every custom CIDR
+	// rule at once
+	for _, entry := range e.cidrTrie.lookup(ip) {
+		if entry.rule == nil {
+			entry.stats.record()
+			results = append(results, MatchResult{
+				Matched:   true,
+				Action:    ActionBypass,
+				Reason:    fmt.Sprintf("matches CIDR %s", entry.ipNet.String()),
+				Dimension: "ip",
+			})
+			continue
+		}
+		if entry.rule.expired(now) {
+			continue
 		}
+		entry.stats.record()
+		rule := *entry.rule
+		results = append(results, MatchResult{
+			Matched:   true,
+			Rule:      &rule,
+			Action:    rule.Action,
+			Reason:    rule.Description,
+			Dimension: "ip",
+		})
 	}
 
-	// Check GeoIP
+	// Check GeoIP: ASN before country, so an excluded ASN (say, a VPS
+	// provider) is never bypassed by a broad country rule
 	if e.geoIP != nil {
-		if country, ok := e.geoIP.Match(ip); ok {
-			return MatchResult{
-				Matched: true,
-				Action:  ActionBypass,
-				Reason:  fmt.Sprintf("matches country %s", country),
+		asn, org, asnBypassed := e.geoIP.MatchASN(ip)
+		switch {
+		case asnBypassed:
+			results = append(results, MatchResult{
+				Matched:   true,
+				Action:    ActionBypass,
+				Reason:    fmt.Sprintf("matches ASN %d (%s)", asn, org),
+				Dimension: "ip",
+			})
+		case asn != 0 && e.geoIP.ASNExcluded(asn):
+			// Excluded ASN: skip the country check entirely
+		default:
+			if country, action, ok := e.geoIP.Match(ip); ok {
+				results = append(results, MatchResult{
+					Matched:   true,
+					Action:    action,
+					Reason:    fmt.Sprintf("matches country %s", country),
+					Dimension: "ip",
+				})
 			}
 		}
 	}
 
-	// Check custom rules
+	// Check the custom rules the trie can't index
 	for _, rule := range e.customRules {
-		if rule.Type == RuleTypeCIDR {
-			if ipNet, ok := rule.compiled.(*net.IPNet); ok {
-				if ipNet.Contains(ip) {
-					return MatchResult{
-						Matched: true,
-						Rule:    &rule,
-						Action:  rule.Action,
-						Reason:  rule.Description,
-					}
+		if rule.expired(now) {
+			continue
+		}
+		switch rule.Type {
+		case RuleTypeASN:
+			if asn, ok := rule.compiled.(uint); ok && e.geoIP != nil {
+				if e.geoIP.GetASN(ip) == asn {
+					rule.stats.record()
+					results = append(results, MatchResult{
+						Matched:   true,
+						Rule:      &rule,
+						Action:    rule.Action,
+						Reason:    rule.Description,
+						Dimension: "ip",
+					})
 				}
 			}
 		}
 	}
 
-	return MatchResult{Matched: false}
+	return results
 }
 
 // MatchProtocol checks if a protocol should bypass
 func (e *Engine) MatchProtocol(proto string) MatchResult {
 	e.mu.RLock()
-	defer e.mu.RUnlock()
 
 	if !e.cfg.Enabled {
+		e.mu.RUnlock()
 		return MatchResult{Matched: false}
 	}
 
+	result := pickResult(e.matchProtocolLocked(proto))
+	e.mu.RUnlock()
+
+	e.recordMatch(result)
+	return result
+}
+
+func (e *Engine) matchProtocolLocked(proto string) []MatchResult {
+	var results []MatchResult
+
 	proto = strings.ToLower(proto)
 	if e.protocols[proto] {
-		return MatchResult{
-			Matched: true,
-			Action:  ActionBypass,
-			Reason:  fmt.Sprintf("protocol %s is bypassed", proto),
+		results = append(results, MatchResult{
+			Matched:   true,
+			Action:    ActionBypass,
+			Reason:    fmt.Sprintf("protocol %s is bypassed", proto),
+			Dimension: "protocol",
+		})
+	}
+
+	now := e.now()
+	for _, rule := range e.customRules {
+		if rule.Type != RuleTypeProtocol || rule.expired(now) {
+			continue
+		}
+		if compiled, ok := rule.compiled.(string); ok && compiled == proto {
+			rule.stats.record()
+			results = append(results, MatchResult{
+				Matched:   true,
+				Rule:      &rule,
+				Action:    rule.Action,
+				Reason:    rule.Description,
+				Dimension: "protocol",
+			})
 		}
 	}
 
-	return MatchResult{Matched: false}
+	return results
+}
+
+// MatchPort checks if a destination port matches any custom port rule.
+// A non-empty proto also consults the protocol rules, so callers that
+// know both get a single answer.
+func (e *Engine) MatchPort(port int, proto string) MatchResult {
+	e.mu.RLock()
+
+	if !e.cfg.Enabled {
+		e.mu.RUnlock()
+		return MatchResult{Matched: false}
+	}
+
+	results := e.matchPortLocked(port)
+	if proto != "" {
+		results = append(results, e.matchProtocolLocked(proto)...)
+	}
+	result := pickResult(results)
+	e.mu.RUnlock()
+
+	e.recordMatch(result)
+	return result
+}
+
+func (e *Engine) matchPortLocked(port int) []MatchResult {
+	var results []MatchResult
+
+	now := e.now()
+	for _, rule := range e.customRules {
+		if rule.Type != RuleTypePort || rule.expired(now) {
+			continue
+		}
+		if set, ok := rule.compiled.(portSet); ok && set.contains(port) {
+			rule.stats.record()
+			results = append(results, MatchResult{
+				Matched:   true,
+				Rule:      &rule,
+				Action:    rule.Action,
+				Reason:    rule.Description,
+				Dimension: "port",
+			})
+		}
+	}
+
+	return results
 }
 
 // MatchApplication checks if an application should bypass
 func (e *Engine) MatchApplication(appName string) MatchResult {
+	return e.MatchApplicationPath(appName, "")
+}
+
+// MatchApplicationPath checks an application by process name and, when
+// known, its full executable path, so config entries like "/opt/games/*"
+// match without enumerating every binary
+func (e *Engine) MatchApplicationPath(appName, execPath string) MatchResult {
 	e.mu.RLock()
-	defer e.mu.RUnlock()
 
 	if !e.cfg.Enabled {
+		e.mu.RUnlock()
 		return MatchResult{Matched: false}
 	}
 
+	result := pickResult(e.matchApplicationLocked(appName, execPath))
+	e.mu.RUnlock()
+
+	e.recordMatch(result)
+	return result
+}
+
+func (e *Engine) matchApplicationLocked(appName, execPath string) []MatchResult {
 	appName = strings.ToLower(appName)
+	execPath = strings.ToLower(execPath)
+
+	bypassed := func(reason string) []MatchResult {
+		return []MatchResult{{
+			Matched:   true,
+			Action:    ActionBypass,
+			Reason:    reason,
+			Dimension: "application",
+		}}
+	}
+
 	if e.applications[appName] {
-		return MatchResult{
-			Matched: true,
-			Action:  ActionBypass,
-			Reason:  fmt.Sprintf("application %s is bypassed", appName),
+		return bypassed(fmt.Sprintf("application %s is bypassed", appName))
+	}
+	if execPath != "" && e.applications[execPath] {
+		return bypassed(fmt.Sprintf("application %s is bypassed", execPath))
+	}
+	for _, ap := range e.appGlobs {
+		if ap.re.MatchString(appName) || (execPath != "" && ap.re.MatchString(execPath)) {
+			return bypassed(fmt.Sprintf("application matches pattern %s", ap.glob))
+		}
+	}
+	return nil
+}
+
+// AddApplication registers an application name, executable path, or glob
+// for bypass at runtime, symmetrical to AddRule. Runtime additions
+// survive Reload.
+func (e *Engine) AddApplication(app string) error {
+	log := logger.WithComponent("bypass")
+
+	a := strings.ToLower(strings.TrimSpace(app))
+	if a == "" {
+		return fmt.Errorf("empty application pattern")
+	}
+
+	var ap *appPattern
+	if strings.ContainsAny(a, "*?[") {
+		re, err := compileAppGlob(a)
+		if err != nil {
+			return err
 		}
+		ap = &appPattern{glob: a, re: re}
+	}
+
+	e.mu.Lock()
+	if ap != nil {
+		e.appGlobs = append(e.appGlobs, ap)
+	} else {
+		e.applications[a] = true
 	}
+	e.runtimeApps = append(e.runtimeApps, a)
+	e.mu.Unlock()
 
-	return MatchResult{Matched: false}
+	log.Info().Str("application", a).Msg("added bypass application")
+	return nil
 }
 
-// AddRule dynamically adds a bypass rule
-func (e *Engine) AddRule(rule Rule) error {
+// RemoveApplication removes an application entry added via config or
+// AddApplication, returning whether anything was removed
+func (e *Engine) RemoveApplication(app string) bool {
+	a := strings.ToLower(strings.TrimSpace(app))
+
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	removed := false
+	if e.applications[a] {
+		delete(e.applications, a)
+		removed = true
+	}
+	kept := e.appGlobs[:0]
+	for _, ap := range e.appGlobs {
+		if ap.glob == a {
+			removed = true
+			continue
+		}
+		kept = append(kept, ap)
+	}
+	e.appGlobs = kept
+
+	for i, ra := range e.runtimeApps {
+		if ra == a {
+			e.runtimeApps = append(e.runtimeApps[:i], e.runtimeApps[i+1:]...)
+			break
+		}
+	}
+
+	return removed
+}
+
+// AddRule dynamically adds a bypass rule
+func (e *Engine) AddRule(rule Rule) error {
 	log := logger.WithComponent("bypass")
 
-	if err := e.compileRule(&rule); err != nil {
+	if err := compileRule(&rule); err != nil {
 		return err
 	}
 
+	e.mu.Lock()
+	rule.runtime = true
 	e.customRules = append(e.customRules, rule)
+	sortRules(e.customRules)
+	e.rebuildCIDRTrieLocked()
+	e.mu.Unlock()
+
 	log.Info().Str("name", rule.Name).Str("type", string(rule.Type)).Msg("added bypass rule")
+	e.persistRules()
 
 	return nil
 }
 
-// RemoveRule removes a bypass rule by name
-func (e *Engine) RemoveRule(name string) bool {
+// janitorInterval is how often expired temporary rules are swept out
+const janitorInterval = time.Minute
+
+// AddTemporaryRule adds a rule that expires after ttl, for short-lived
+// needs like lab networks that must not linger as an anonymity hole.
+// The expiry janitor starts with the first temporary rule.
+func (e *Engine) AddTemporaryRule(rule Rule, ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("temporary rule TTL must be positive, got %v", ttl)
+	}
+
+	rule.ExpiresAt = e.now().Add(ttl)
+	if err := e.AddRule(rule); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	e.startJanitorLocked()
+	e.mu.Unlock()
+	return nil
+}
+
+// startJanitorLocked launches the expiry sweeper if it isn't running.
+// Callers must hold the write lock.
+func (e *Engine) startJanitorLocked() {
+	if e.janitorStop != nil {
+		return
+	}
+	e.janitorStop = make(chan struct{})
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(janitorInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				e.removeExpiredRules()
+			case <-stop:
+				return
+			}
+		}
+	}(e.janitorStop)
+}
+
+// Close stops the engine's background work. Matching still works on a
+// closed engine; the expiry janitor and subscription refreshes go away.
+func (e *Engine) Close() {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	if e.janitorStop != nil {
+		close(e.janitorStop)
+		e.janitorStop = nil
+	}
+	for name, sub := range e.subs {
+		close(sub.stop)
+		delete(e.subs, name)
+	}
+}
+
+// removeExpiredRules drops every rule past its TTL, with an audit trail
+// since an expiring rule changes what traffic goes through Tor
+func (e *Engine) removeExpiredRules() {
+	e.mu.Lock()
+	now := e.now()
+	var removed []Rule
+	kept := e.customRules[:0]
+	for _, r := range e.customRules {
+		if r.expired(now) {
+			removed = append(removed, r)
+		} else {
+			kept = append(kept, r)
+		}
+	}
+	e.customRules = kept
+	if len(removed) > 0 {
+		e.rebuildCIDRTrieLocked()
+	}
+	e.mu.Unlock()
+
+	for _, r := range removed {
+		logger.Audit("bypass_rule_expired").
+			Str("rule", r.Name).
+			Str("type", string(r.Type)).
+			Str("pattern", r.Pattern).
+			Time("expired_at", r.ExpiresAt).
+			Msg("temporary bypass rule expired")
+	}
+	if len(removed) > 0 {
+		e.persistRules()
+	}
+}
+
+// sortRules orders rules by priority, keeping insertion order for ties
+func sortRules(rules []Rule) {
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].Priority < rules[j].Priority
+	})
+}
+
+// ReorderRule changes a rule's priority and re-sorts the rule list.
+// Returns false when no rule with that name exists.
+func (e *Engine) ReorderRule(name string, priority int) bool {
+	e.mu.Lock()
+	found := false
+	for i := range e.customRules {
+		if e.customRules[i].Name == name {
+			e.customRules[i].Priority = priority
+			sortRules(e.customRules)
+			e.rebuildCIDRTrieLocked()
+			found = true
+			break
+		}
+	}
+	e.mu.Unlock()
+
+	if found {
+		e.persistRules()
+	}
+	return found
+}
+
+// RemoveRule removes a bypass rule by name
+func (e *Engine) RemoveRule(name string) bool {
+	e.mu.Lock()
+	found := false
 	for i, r := range e.customRules {
 		if r.Name == name {
 			e.customRules = append(e.customRules[:i], e.customRules[i+1:]...)
+			e.rebuildCIDRTrieLocked()
+			found = true
+			break
+		}
+	}
+	e.mu.Unlock()
+
+	if found {
+		e.persistRules()
+	}
+	return found
+}
+
+// LintRules reports rules that can never decide a match because an
+// earlier rule of the same type already covers everything they match
+// with an equal or stronger action. Coverage detection is conservative:
+// it only flags provable shadowing, not every possible overlap.
+func (e *Engine) LintRules() []string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	var problems []string
+	for j := 1; j < len(e.customRules); j++ {
+		inner := &e.customRules[j]
+		for i := 0; i < j; i++ {
+			outer := &e.customRules[i]
+			if !ruleCovers(outer, inner) {
+				continue
+			}
+			// A later block rule still outranks an earlier bypass rule
+			if outer.Action != ActionBlock && inner.Action == ActionBlock {
+				continue
+			}
+			problems = append(problems, fmt.Sprintf(
+				"rule %q is unreachable: shadowed by earlier rule %q (%s %s)",
+				inner.Name, outer.Name, outer.Type, outer.Pattern))
+			break
+		}
+	}
+	return problems
+}
+
+// ruleCovers reports whether everything matched by inner is provably
+// also matched by outer
+func ruleCovers(outer, inner *Rule) bool {
+	if outer.Type != inner.Type {
+		return false
+	}
+	if outer.Pattern == inner.Pattern {
+		return true
+	}
+
+	switch outer.Type {
+	case RuleTypeDomain:
+		// A literal later pattern is covered when the earlier glob
+		// matches it; glob-on-glob is only provable for the catch-all
+		if outer.Pattern == "*" || outer.Pattern == "**" {
 			return true
 		}
+		if strings.ContainsAny(inner.Pattern, "*?[") {
+			return false
+		}
+		re, ok := outer.compiled.(*regexp.Regexp)
+		return ok && re.MatchString(NormalizeDomain(inner.Pattern))
+	case RuleTypeCIDR:
+		outNet, okOut := outer.compiled.(*net.IPNet)
+		inNet, okIn := inner.compiled.(*net.IPNet)
+		if !okOut || !okIn {
+			return false
+		}
+		outOnes, outBits := outNet.Mask.Size()
+		inOnes, inBits := inNet.Mask.Size()
+		return outBits == inBits && outOnes <= inOnes && outNet.Contains(inNet.IP)
+	case RuleTypePort:
+		outSet, okOut := outer.compiled.(portSet)
+		inSet, okIn := inner.compiled.(portSet)
+		if !okOut || !okIn {
+			return false
+		}
+		for _, r := range inSet {
+			covered := false
+			for _, o := range outSet {
+				if r.lo >= o.lo && r.hi <= o.hi {
+					covered = true
+					break
+				}
+			}
+			if !covered {
+				return false
+			}
+		}
+		return true
 	}
+
+	// Protocol and ASN rules only cover each other on equal patterns,
+	// which the early return above already handled
 	return false
 }
 
-// GetRules returns all active rules
+// GetRules returns all active rules in effective evaluation order.
+// Rules past their TTL are omitted even if the janitor hasn't removed
+// them yet.
 func (e *Engine) GetRules() []Rule {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 
-	rules := make([]Rule, len(e.customRules))
-	copy(rules, e.customRules)
+	now := e.now()
+	rules := make([]Rule, 0, len(e.customRules))
+	for _, r := range e.customRules {
+		if r.expired(now) {
+			continue
+		}
+		rules = append(rules, r)
+	}
 	return rules
 }
 
-// compileGlobToRegex converts glob patterns to regex
+// GetRuleStats returns usage snapshots for the built-in patterns and
+// every custom rule, in evaluation order
+func (e *Engine) GetRuleStats() []RuleStats {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	snapshot := func(name string, rtype RuleType, pattern string, s *ruleStats) RuleStats {
+		stat := RuleStats{
+			Name:    name,
+			Type:    rtype,
+			Pattern: pattern,
+			Hits:    s.hits.Load(),
+		}
+		if ns := s.lastHit.Load(); ns != 0 {
+			stat.LastHit = time.Unix(0, ns)
+		}
+		return stat
+	}
+
+	stats := make([]RuleStats, 0, len(e.domainPatterns)+len(e.cidrNets)+len(e.customRules))
+	for _, dp := range e.domainPatterns {
+		stats = append(stats, snapshot("domain:"+dp.glob, RuleTypeDomain, dp.glob, dp.stats))
+	}
+	for _, cn := range e.cidrNets {
+		pattern := cn.ipNet.String()
+		stats = append(stats, snapshot("cidr:"+pattern, RuleTypeCIDR, pattern, cn.stats))
+	}
+	for i := range e.customRules {
+		rule := &e.customRules[i]
+		stats = append(stats, snapshot(rule.Name, rule.Type, rule.Pattern, rule.stats))
+	}
+	return stats
+}
+
+// ResetRuleStats zeroes all hit counters and last-hit timestamps
+func (e *Engine) ResetRuleStats() {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	for _, dp := range e.domainPatterns {
+		dp.stats.hits.Store(0)
+		dp.stats.lastHit.Store(0)
+	}
+	for _, cn := range e.cidrNets {
+		cn.stats.hits.Store(0)
+		cn.stats.lastHit.Store(0)
+	}
+	for i := range e.customRules {
+		e.customRules[i].stats.hits.Store(0)
+		e.customRules[i].stats.lastHit.Store(0)
+	}
+}
+
+// compileGlobToRegex converts glob patterns to regex with catch-all
+// patterns rejected
 func compileGlobToRegex(pattern string) (*regexp.Regexp, error) {
-	// Escape special regex characters except * and ?
+	return compileGlob(pattern, false)
+}
+
+// compileGlob converts a glob pattern to an anchored regex. `*` matches
+// within a single DNS label, `**` crosses label boundaries, `?` matches
+// one non-dot character, and `[a-z]` character classes pass through.
+// Patterns made purely of wildcards match every domain and are rejected
+// unless allowCatchAll is set.
+func compileGlob(pattern string, allowCatchAll bool) (*regexp.Regexp, error) {
+	return compileGlobSep(pattern, allowCatchAll, '.')
+}
+
+// compileGlobSep is the glob compiler behind both domain and
+// application patterns; sep is the boundary character a single `*` or
+// `?` will not cross ('.' for domains, '/' for executable paths)
+func compileGlobSep(pattern string, allowCatchAll bool, sep rune) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("empty glob pattern")
+	}
+
+	notSep := "[^" + regexp.QuoteMeta(string(sep)) + "]"
+
 	var regexPattern strings.Builder
 	regexPattern.WriteString("^")
 
-	for _, c := range pattern {
-		switch c {
+	literal := false // Saw anything that constrains the match
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch c := runes[i]; c {
 		case '*':
-			regexPattern.WriteString(".*")
+			if i+1 < len(runes) && runes[i+1] == '*' {
+				regexPattern.WriteString(".*")
+				i++
+			} else {
+				regexPattern.WriteString(notSep + "*")
+			}
 		case '?':
-			regexPattern.WriteString(".")
+			regexPattern.WriteString(notSep)
+		case '[':
+			// Character class: copy up to the closing bracket and let
+			// the regex engine validate its contents
+			j := i + 1
+			if j < len(runes) && runes[j] == '^' {
+				j++
+			}
+			if j < len(runes) && runes[j] == ']' {
+				j++ // A leading ] is a literal member
+			}
+			for j < len(runes) && runes[j] != ']' {
+				j++
+			}
+			if j >= len(runes) {
+				return nil, fmt.Errorf("glob %q: character class opened at position %d is never closed", pattern, i)
+			}
+			class := string(runes[i : j+1])
+			if _, err := regexp.Compile("^" + class + "$"); err != nil {
+				return nil, fmt.Errorf("glob %q: invalid character class %s", pattern, class)
+			}
+			regexPattern.WriteString(class)
+			literal = true
+			i = j
 		case '.':
 			regexPattern.WriteString(`\.`)
-		case '[', ']', '(', ')', '{', '}', '^', '$', '+', '|', '\\':
+			literal = true
+		case ']', '(', ')', '{', '}', '^', '$', '+', '|', '\\':
 			regexPattern.WriteRune('\\')
 			regexPattern.WriteRune(c)
+			literal = true
 		default:
 			regexPattern.WriteRune(c)
+			literal = true
 		}
 	}
 
 	regexPattern.WriteString("$")
+
+	if !literal {
+		if !allowCatchAll {
+			return nil, fmt.Errorf("glob %q matches every domain; set AllowCatchAll if that is intended", pattern)
+		}
+		// An allowed catch-all keeps its traditional match-everything
+		// meaning rather than the per-label semantics
+		return regexp.Compile("^.*$")
+	}
 	return regexp.Compile(regexPattern.String())
 }
@@ -1,8 +1,11 @@
 package bypass
 
 import (
+	"fmt"
 	"net"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/jery0843/torforge/pkg/config"
 )
@@ -124,6 +127,458 @@ func TestEngineMatchProtocol(t *testing.T) {
 	}
 }
 
+func TestEngineMatchPort(t *testing.T) {
+	cfg := &config.BypassConfig{
+		Enabled: true,
+		CustomRules: []config.BypassRule{
+			{Name: "torrent", Type: "port", Pattern: "6881-6999", Action: "bypass"},
+			{Name: "web", Type: "port", Pattern: "80,443,8080-8090", Action: "bypass"},
+			{Name: "smtp", Type: "port", Pattern: "25", Action: "block"},
+			{Name: "quic", Type: "protocol", Pattern: "quic", Action: "bypass"},
+		},
+	}
+
+	engine, err := NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		port    int
+		proto   string
+		matched bool
+		action  Action
+	}{
+		{"range start", 6881, "", true, ActionBypass},
+		{"range end", 6999, "", true, ActionBypass},
+		{"outside range", 7000, "", false, ""},
+		{"list single", 443, "", true, ActionBypass},
+		{"list range", 8085, "", true, ActionBypass},
+		{"single port", 25, "", true, ActionBlock},
+		{"no match", 22, "", false, ""},
+		{"protocol fallback", 22, "quic", true, ActionBypass},
+		{"no protocol fallback", 22, "tcp", false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := engine.MatchPort(tt.port, tt.proto)
+			if result.Matched != tt.matched {
+				t.Fatalf("MatchPort(%d, %q) matched = %v, want %v", tt.port, tt.proto, result.Matched, tt.matched)
+			}
+			if tt.matched && result.Action != tt.action {
+				t.Errorf("MatchPort(%d, %q) action = %v, want %v", tt.port, tt.proto, result.Action, tt.action)
+			}
+		})
+	}
+}
+
+func TestEngineMatchPortOverlap(t *testing.T) {
+	cfg := &config.BypassConfig{
+		Enabled: true,
+		CustomRules: []config.BypassRule{
+			{Name: "block-high", Type: "port", Pattern: "8000-9000", Action: "block"},
+			{Name: "bypass-high", Type: "port", Pattern: "8080", Action: "bypass"},
+		},
+	}
+
+	engine, err := NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	// Overlapping rules resolve in declaration order
+	result := engine.MatchPort(8080, "")
+	if !result.Matched || result.Action != ActionBlock {
+		t.Errorf("MatchPort(8080) = %+v, want first (block) rule to win", result)
+	}
+}
+
+func TestParsePortSetErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"abc",
+		"80,",
+		"0",
+		"70000",
+		"9000-8000",
+		"80-",
+		"-80",
+	}
+
+	for _, pattern := range tests {
+		t.Run(pattern, func(t *testing.T) {
+			if _, err := parsePortSet(pattern); err == nil {
+				t.Errorf("parsePortSet(%q) should have failed", pattern)
+			}
+		})
+	}
+}
+
+func TestEngineMatchProtocolCustomRule(t *testing.T) {
+	cfg := &config.BypassConfig{
+		Enabled:   true,
+		Protocols: []string{"icmp"},
+		CustomRules: []config.BypassRule{
+			{Name: "no-irc", Type: "protocol", Pattern: "irc", Action: "block"},
+		},
+	}
+
+	engine, err := NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	// The protocols list still works alongside custom rules
+	if result := engine.MatchProtocol("icmp"); !result.Matched || result.Action != ActionBypass {
+		t.Errorf("MatchProtocol(icmp) = %+v, want bypass", result)
+	}
+	if result := engine.MatchProtocol("IRC"); !result.Matched || result.Action != ActionBlock {
+		t.Errorf("MatchProtocol(IRC) = %+v, want block", result)
+	}
+}
+
+func TestAddRuleRejectsMalformed(t *testing.T) {
+	engine, err := NewEngine(&config.BypassConfig{Enabled: true})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	bad := []Rule{
+		{Name: "bad-port", Type: RuleTypePort, Pattern: "not-a-port", Action: ActionBypass},
+		{Name: "bad-proto", Type: RuleTypeProtocol, Pattern: "  ", Action: ActionBypass},
+		{Name: "bad-type", Type: RuleType("bogus"), Pattern: "x", Action: ActionBypass},
+	}
+	for _, rule := range bad {
+		if err := engine.AddRule(rule); err == nil {
+			t.Errorf("AddRule(%s) should have failed", rule.Name)
+		}
+	}
+}
+
+func TestEngineMatchPrecedence(t *testing.T) {
+	cfg := &config.BypassConfig{
+		Enabled: true,
+		Domains: []string{"*.local"},
+		CIDRs:   []string{"10.0.0.0/8"},
+		CustomRules: []config.BypassRule{
+			{Name: "deny-net", Type: "cidr", Pattern: "192.0.2.0/24", Action: "block", Description: "blocked range"},
+			{Name: "lan-domain", Type: "domain", Pattern: "*.corp", Action: "bypass"},
+		},
+	}
+
+	engine, err := NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		req       MatchRequest
+		matched   bool
+		action    Action
+		dimension string
+	}{
+		{
+			name:      "block rule overrides bypass domain pattern",
+			req:       MatchRequest{Domain: "printer.local", IP: net.ParseIP("192.0.2.10")},
+			matched:   true,
+			action:    ActionBlock,
+			dimension: "ip",
+		},
+		{
+			name:      "custom rule beats built-in list",
+			req:       MatchRequest{Domain: "files.corp", IP: net.ParseIP("10.1.2.3")},
+			matched:   true,
+			action:    ActionBypass,
+			dimension: "domain",
+		},
+		{
+			name:      "built-in list matches alone",
+			req:       MatchRequest{Domain: "nas.local"},
+			matched:   true,
+			action:    ActionBypass,
+			dimension: "domain",
+		},
+		{
+			name:    "nothing matches defaults to tor",
+			req:     MatchRequest{Domain: "example.org", IP: net.ParseIP("8.8.8.8")},
+			matched: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := engine.Match(tt.req)
+			if result.Matched != tt.matched {
+				t.Fatalf("Match(%+v) matched = %v, want %v", tt.req, result.Matched, tt.matched)
+			}
+			if !tt.matched {
+				return
+			}
+			if result.Action != tt.action {
+				t.Errorf("action = %v, want %v", result.Action, tt.action)
+			}
+			if result.Dimension != tt.dimension {
+				t.Errorf("dimension = %q, want %q", result.Dimension, tt.dimension)
+			}
+		})
+	}
+}
+
+func TestRulePriorityOrdering(t *testing.T) {
+	cfg := &config.BypassConfig{
+		Enabled: true,
+		CustomRules: []config.BypassRule{
+			{Name: "late", Type: "domain", Pattern: "*.example", Action: "bypass", Priority: 10},
+			{Name: "early", Type: "domain", Pattern: "*.example", Action: "tor", Priority: 1},
+		},
+	}
+
+	engine, err := NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	rules := engine.GetRules()
+	if len(rules) != 2 || rules[0].Name != "early" || rules[1].Name != "late" {
+		t.Fatalf("rules not in priority order: %v", ruleNames(rules))
+	}
+
+	// The earlier (lower priority value) rule decides ties
+	if result := engine.MatchDomain("a.example"); result.Rule == nil || result.Rule.Name != "early" {
+		t.Errorf("expected rule %q to decide, got %+v", "early", result)
+	}
+
+	// Reordering moves the other rule to the front
+	if !engine.ReorderRule("late", 0) {
+		t.Fatal("ReorderRule() returned false for existing rule")
+	}
+	if rules := engine.GetRules(); rules[0].Name != "late" {
+		t.Errorf("rules after reorder: %v", ruleNames(rules))
+	}
+	if result := engine.MatchDomain("a.example"); result.Rule == nil || result.Rule.Name != "late" {
+		t.Errorf("expected rule %q to decide after reorder, got %+v", "late", result)
+	}
+
+	if engine.ReorderRule("missing", 5) {
+		t.Error("ReorderRule() returned true for non-existent rule")
+	}
+}
+
+func ruleNames(rules []Rule) []string {
+	names := make([]string, len(rules))
+	for i, r := range rules {
+		names[i] = r.Name
+	}
+	return names
+}
+
+func TestLintRules(t *testing.T) {
+	cfg := &config.BypassConfig{
+		Enabled: true,
+		CustomRules: []config.BypassRule{
+			{Name: "broad-net", Type: "cidr", Pattern: "10.0.0.0/8", Action: "bypass"},
+			{Name: "narrow-net", Type: "cidr", Pattern: "10.1.0.0/16", Action: "bypass"},
+			{Name: "broad-domain", Type: "domain", Pattern: "*.example", Action: "bypass"},
+			{Name: "narrow-domain", Type: "domain", Pattern: "www.example", Action: "bypass"},
+			{Name: "all-ports", Type: "port", Pattern: "1-65535", Action: "bypass"},
+			{Name: "one-port", Type: "port", Pattern: "443", Action: "bypass"},
+			{Name: "blocked-host", Type: "domain", Pattern: "bad.example", Action: "block"},
+			{Name: "other-net", Type: "cidr", Pattern: "192.168.0.0/16", Action: "bypass"},
+		},
+	}
+
+	engine, err := NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	problems := engine.LintRules()
+
+	shadowed := map[string]bool{}
+	for _, p := range problems {
+		for _, name := range []string{"narrow-net", "narrow-domain", "one-port", "blocked-host", "other-net"} {
+			if strings.Contains(p, "\""+name+"\"") {
+				shadowed[name] = true
+			}
+		}
+	}
+
+	for _, want := range []string{"narrow-net", "narrow-domain", "one-port"} {
+		if !shadowed[want] {
+			t.Errorf("expected rule %q to be reported as shadowed, problems: %v", want, problems)
+		}
+	}
+	// A block rule under a broader bypass rule still wins, and the
+	// unrelated network overlaps nothing
+	for _, notWant := range []string{"blocked-host", "other-net"} {
+		if shadowed[notWant] {
+			t.Errorf("rule %q should not be reported as shadowed, problems: %v", notWant, problems)
+		}
+	}
+}
+
+func TestDenyListsBlock(t *testing.T) {
+	cfg := &config.BypassConfig{
+		Enabled:     true,
+		Domains:     []string{"*.example"}, // Bypass
+		DenyDomains: []string{"*.telemetry.example"},
+		DenyCIDRs:   []string{"203.0.113.0/24"},
+	}
+
+	engine, err := NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	// Matches both the bypass pattern and the deny list: block wins
+	result := engine.MatchDomain("ping.telemetry.example")
+	if !result.Matched || result.Action != ActionBlock {
+		t.Errorf("MatchDomain(ping.telemetry.example) = %+v, want block", result)
+	}
+
+	// Plain bypass domains are unaffected
+	if result := engine.MatchDomain("files.example"); !result.Matched || result.Action != ActionBypass {
+		t.Errorf("MatchDomain(files.example) = %+v, want bypass", result)
+	}
+
+	if !engine.IsBlocked(MatchRequest{Domain: "ping.telemetry.example"}) {
+		t.Error("IsBlocked should report the denied domain")
+	}
+	if !engine.IsBlocked(MatchRequest{IP: net.ParseIP("203.0.113.7")}) {
+		t.Error("IsBlocked should report the denied CIDR")
+	}
+	if engine.IsBlocked(MatchRequest{Domain: "files.example"}) {
+		t.Error("IsBlocked should not report a bypass-only match")
+	}
+}
+
+func TestEngineReload(t *testing.T) {
+	engine, err := NewEngine(&config.BypassConfig{
+		Enabled: true,
+		Domains: []string{"*.old"},
+	})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	// A rule added at runtime must survive the reload
+	if err := engine.AddRule(Rule{
+		Name:    "runtime-rule",
+		Type:    RuleTypeDomain,
+		Pattern: "*.dynamic",
+		Action:  ActionBypass,
+	}); err != nil {
+		t.Fatalf("AddRule() error = %v", err)
+	}
+
+	if err := engine.Reload(&config.BypassConfig{
+		Enabled: true,
+		Domains: []string{"*.new"},
+	}); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+
+	if engine.MatchDomain("host.old").Matched {
+		t.Error("old pattern should be gone after reload")
+	}
+	if !engine.MatchDomain("host.new").Matched {
+		t.Error("new pattern should match after reload")
+	}
+	if !engine.MatchDomain("host.dynamic").Matched {
+		t.Error("runtime-added rule should survive reload")
+	}
+}
+
+func TestEngineReloadConcurrent(t *testing.T) {
+	cfg := &config.BypassConfig{
+		Enabled: true,
+		Domains: []string{"*.local"},
+	}
+
+	engine, err := NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	stop := make(chan struct{})
+	misses := make(chan int, 1)
+	go func() {
+		count := 0
+		for {
+			select {
+			case <-stop:
+				misses <- count
+				return
+			default:
+				if !engine.MatchDomain("printer.local").Matched {
+					count++
+				}
+			}
+		}
+	}()
+
+	// Hammer reloads with an equivalent config; the matcher must never
+	// observe a half-compiled state
+	for i := 0; i < 200; i++ {
+		if err := engine.Reload(&config.BypassConfig{
+			Enabled: true,
+			Domains: []string{"*.local"},
+		}); err != nil {
+			t.Fatalf("Reload() error = %v", err)
+		}
+	}
+
+	close(stop)
+	if count := <-misses; count != 0 {
+		t.Errorf("MatchDomain missed %d times during reloads", count)
+	}
+}
+
+func TestRuleStats(t *testing.T) {
+	cfg := &config.BypassConfig{
+		Enabled: true,
+		Domains: []string{"*.local", "*.htb"},
+		CustomRules: []config.BypassRule{
+			{Name: "lan", Type: "cidr", Pattern: "10.0.0.0/8", Action: "bypass"},
+		},
+	}
+
+	engine, err := NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	engine.MatchDomain("nas.local")
+	engine.MatchDomain("printer.local")
+	engine.MatchIP(net.ParseIP("10.1.2.3"))
+
+	byName := map[string]RuleStats{}
+	for _, s := range engine.GetRuleStats() {
+		byName[s.Name] = s
+	}
+
+	if s := byName["domain:*.local"]; s.Hits != 2 || s.LastHit.IsZero() {
+		t.Errorf("domain:*.local stats = %+v, want 2 hits with a last-hit time", s)
+	}
+	if s := byName["lan"]; s.Hits != 1 {
+		t.Errorf("lan rule hits = %d, want 1", s.Hits)
+	}
+	// Rules that never fired report zero
+	if s := byName["domain:*.htb"]; s.Hits != 0 || !s.LastHit.IsZero() {
+		t.Errorf("domain:*.htb stats = %+v, want no hits", s)
+	}
+
+	engine.ResetRuleStats()
+	for _, s := range engine.GetRuleStats() {
+		if s.Hits != 0 || !s.LastHit.IsZero() {
+			t.Errorf("stats after reset = %+v, want zeroed", s)
+		}
+	}
+}
+
 func TestEngineDisabled(t *testing.T) {
 	cfg := &config.BypassConfig{
 		Enabled: false,
@@ -200,14 +655,26 @@ func TestCompileGlobToRegex(t *testing.T) {
 		match   bool
 	}{
 		{"*.local", "test.local", true},
-		{"*.local", "sub.test.local", true},
+		{"*.local", "sub.test.local", false}, // Single * stays in one label
 		{"*.local", "local", false},
+		{"**.local", "test.local", true},
+		{"**.local", "sub.test.local", true}, // ** crosses label boundaries
+		{"**.local", "local", false},
 		{"test.*", "test.com", true},
 		{"test.*", "test.anything", true},
 		{"test.?", "test.a", true},
 		{"test.?", "test.ab", false},
+		{"test.?", "test..", false}, // ? never matches a dot
 		{"exact.com", "exact.com", true},
 		{"exact.com", "notexact.com", false},
+		{"cdn*.example.com", "cdn.example.com", true},
+		{"cdn*.example.com", "cdn42.example.com", true},
+		{"cdn*.example.com", "cdn42.eu.example.com", false},
+		{"cdn**.example.com", "cdn42.eu.example.com", true},
+		{"[a-c]dn.example", "bdn.example", true},
+		{"[a-c]dn.example", "xdn.example", false},
+		{"node[0-9][0-9].lan", "node07.lan", true},
+		{"node[0-9][0-9].lan", "node7.lan", false},
 	}
 
 	for _, tt := range tests {
@@ -225,6 +692,138 @@ func TestCompileGlobToRegex(t *testing.T) {
 	}
 }
 
+func TestCompileGlobRejectsBadPatterns(t *testing.T) {
+	bad := []string{"", "*", "**", "?", "*?**", "[a-z.example", "[z-a].example"}
+	for _, pattern := range bad {
+		if _, err := compileGlobToRegex(pattern); err == nil {
+			t.Errorf("compileGlobToRegex(%q) expected error", pattern)
+		}
+	}
+
+	// The same catch-alls compile when explicitly allowed
+	for _, pattern := range []string{"*", "**"} {
+		re, err := compileGlob(pattern, true)
+		if err != nil {
+			t.Fatalf("compileGlob(%q, true) error = %v", pattern, err)
+		}
+		if !re.MatchString("anything.example") {
+			t.Errorf("allowed catch-all %q did not match", pattern)
+		}
+	}
+
+	// And an AllowCatchAll rule accepts them end to end
+	rule := Rule{Name: "all", Type: RuleTypeDomain, Pattern: "*", AllowCatchAll: true}
+	if err := compileRule(&rule); err != nil {
+		t.Fatalf("compileRule() with AllowCatchAll error = %v", err)
+	}
+	denied := Rule{Name: "all", Type: RuleTypeDomain, Pattern: "*"}
+	if err := compileRule(&denied); err == nil {
+		t.Fatal("compileRule() without AllowCatchAll expected error")
+	}
+}
+
+func TestMatchApplicationGlobs(t *testing.T) {
+	cfg := &config.BypassConfig{
+		Enabled:      true,
+		Applications: []string{"spotify", "steam*", "/opt/games/**"},
+	}
+
+	engine, err := NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		path  string
+		match bool
+	}{
+		{"spotify", "", true}, // Exact entry still works
+		{"Spotify", "", true}, // Case-insensitive
+		{"spotifyd", "", false},
+		{"steam", "", true}, // Name glob
+		{"steamwebhelper", "", true},
+		{"firefox", "", false},
+		{"doom3.x86", "/opt/games/doom3.x86", true},      // Path glob
+		{"launcher", "/opt/games/ut2004/launcher", true}, // ** recurses
+		{"vim", "/usr/bin/vim", false},
+	}
+	for _, tt := range tests {
+		if got := engine.MatchApplicationPath(tt.name, tt.path).Matched; got != tt.match {
+			t.Errorf("MatchApplicationPath(%q, %q) = %v, want %v", tt.name, tt.path, got, tt.match)
+		}
+	}
+
+	// MatchApplication remains the name-only form
+	if !engine.MatchApplication("steamcmd").Matched {
+		t.Error("MatchApplication() should match the steam* glob")
+	}
+}
+
+func TestAddRemoveApplication(t *testing.T) {
+	engine, err := NewEngine(&config.BypassConfig{Enabled: true})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	if err := engine.AddApplication("Chromium"); err != nil {
+		t.Fatalf("AddApplication() error = %v", err)
+	}
+	if err := engine.AddApplication("/opt/tools/*"); err != nil {
+		t.Fatalf("AddApplication() glob error = %v", err)
+	}
+	if err := engine.AddApplication(""); err == nil {
+		t.Error("AddApplication(\"\") expected error")
+	}
+
+	if !engine.MatchApplication("chromium").Matched {
+		t.Error("runtime application entry did not match")
+	}
+	if !engine.MatchApplicationPath("nmap", "/opt/tools/nmap").Matched {
+		t.Error("runtime application glob did not match")
+	}
+
+	// Runtime additions survive a config reload
+	if err := engine.Reload(&config.BypassConfig{Enabled: true}); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if !engine.MatchApplication("chromium").Matched || !engine.MatchApplicationPath("nmap", "/opt/tools/nmap").Matched {
+		t.Error("runtime applications lost across Reload()")
+	}
+
+	if !engine.RemoveApplication("chromium") {
+		t.Error("RemoveApplication() returned false for existing entry")
+	}
+	if !engine.RemoveApplication("/opt/tools/*") {
+		t.Error("RemoveApplication() returned false for existing glob")
+	}
+	if engine.RemoveApplication("chromium") {
+		t.Error("RemoveApplication() returned true for missing entry")
+	}
+	if engine.MatchApplication("chromium").Matched || engine.MatchApplicationPath("nmap", "/opt/tools/nmap").Matched {
+		t.Error("removed applications still match")
+	}
+}
+
+func FuzzCompileGlobToRegex(f *testing.F) {
+	for _, seed := range []string{"*.local", "**.example", "cdn*.example.com", "[a-c]dn.example", "test.?", "*", "[", "]", `\`, "a|b", "(x)"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, pattern string) {
+		re, err := compileGlobToRegex(pattern)
+		if err != nil {
+			return
+		}
+		src := re.String()
+		if !strings.HasPrefix(src, "^") || !strings.HasSuffix(src, "$") {
+			t.Fatalf("compiled regex %q for pattern %q is not anchored", src, pattern)
+		}
+		// Matching must never panic, whatever the pattern compiled to
+		re.MatchString("sub.test.example.com")
+		re.MatchString("")
+	})
+}
+
 func TestGetRules(t *testing.T) {
 	cfg := &config.BypassConfig{
 		Enabled: true,
@@ -273,6 +872,29 @@ func BenchmarkMatchDomain(b *testing.B) {
 	}
 }
 
+// BenchmarkMatchDomainParallel exercises the hit counters under
+// concurrent matching; the atomics must not serialize the read path
+func BenchmarkMatchDomainParallel(b *testing.B) {
+	cfg := &config.BypassConfig{
+		Enabled: true,
+		Domains: []string{
+			"*.local",
+			"*.htb",
+			"*.thm",
+			"*.internal",
+			"*.corp",
+		},
+	}
+
+	engine, _ := NewEngine(cfg)
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			engine.MatchDomain("test.htb")
+		}
+	})
+}
+
 func BenchmarkMatchIP(b *testing.B) {
 	cfg := &config.BypassConfig{
 		Enabled: true,
@@ -293,3 +915,301 @@ func BenchmarkMatchIP(b *testing.B) {
 		engine.MatchIP(ip)
 	}
 }
+
+func TestTemporaryRuleExpiry(t *testing.T) {
+	eng, err := NewEngine(&config.BypassConfig{Enabled: true})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	t.Cleanup(eng.Close)
+
+	current := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	eng.now = func() time.Time { return current }
+
+	if err := eng.AddTemporaryRule(Rule{
+		Name:    "ctf",
+		Type:    RuleTypeCIDR,
+		Pattern: "10.10.0.0/16",
+		Action:  ActionBypass,
+	}, 4*time.Hour); err != nil {
+		t.Fatalf("AddTemporaryRule() error = %v", err)
+	}
+
+	if err := eng.AddTemporaryRule(Rule{Name: "bad"}, 0); err == nil {
+		t.Error("AddTemporaryRule() with zero TTL returned nil error")
+	}
+
+	ip := net.ParseIP("10.10.5.5")
+	if result := eng.MatchIP(ip); !result.Matched {
+		t.Fatal("temporary rule did not match before expiry")
+	}
+	if len(eng.GetRules()) != 1 {
+		t.Fatalf("GetRules() = %d rules, want 1", len(eng.GetRules()))
+	}
+
+	// Past the TTL the rule must stop matching immediately, before the
+	// janitor has had a chance to run
+	current = current.Add(4*time.Hour + time.Minute)
+	if result := eng.MatchIP(ip); result.Matched {
+		t.Error("expired rule still matched")
+	}
+	if len(eng.GetRules()) != 0 {
+		t.Errorf("GetRules() = %d rules, want 0 after expiry", len(eng.GetRules()))
+	}
+
+	// The janitor sweep physically removes it
+	eng.removeExpiredRules()
+	eng.mu.RLock()
+	remaining := len(eng.customRules)
+	eng.mu.RUnlock()
+	if remaining != 0 {
+		t.Errorf("janitor left %d rules behind", remaining)
+	}
+}
+
+func TestTemporaryRuleUnexpiredSurvivesJanitor(t *testing.T) {
+	eng, err := NewEngine(&config.BypassConfig{Enabled: true})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	t.Cleanup(eng.Close)
+
+	current := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	eng.now = func() time.Time { return current }
+
+	if err := eng.AddTemporaryRule(Rule{
+		Name:    "lab",
+		Type:    RuleTypeDomain,
+		Pattern: "*.lab.example",
+		Action:  ActionBypass,
+	}, time.Hour); err != nil {
+		t.Fatalf("AddTemporaryRule() error = %v", err)
+	}
+
+	current = current.Add(30 * time.Minute)
+	eng.removeExpiredRules()
+	if result := eng.MatchDomain("box.lab.example"); !result.Matched {
+		t.Error("janitor removed a rule that had not expired")
+	}
+}
+
+func TestDomainIndexClassification(t *testing.T) {
+	eng, err := NewEngine(&config.BypassConfig{
+		Enabled: true,
+		Domains: []string{
+			"printer.lan",    // Exact
+			"*.corp.example", // Suffix
+			"web-?.example",  // Complex: embedded ?
+			"*.cdn*.example", // Complex: embedded *
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	tests := []struct {
+		domain string
+		want   bool
+	}{
+		{"printer.lan", true},
+		{"other.lan", false},
+		{"a.corp.example", true},
+		{"a.b.corp.example", true},
+		{"corp.example", false}, // *.suffix matches strict subdomains only
+		{"web-1.example", true},
+		{"web-12.example", false},
+		{"img.cdn2.example", true},
+		{"unrelated.example", false},
+	}
+	for _, tt := range tests {
+		if got := eng.MatchDomain(tt.domain).Matched; got != tt.want {
+			t.Errorf("MatchDomain(%q) = %v, want %v", tt.domain, got, tt.want)
+		}
+	}
+}
+
+// BenchmarkMatchDomain500Patterns measures the suffix-map path with a
+// realistic large pattern set, where the old per-pattern regex scan was
+// the DNS resolver's hottest loop
+func BenchmarkMatchDomain500Patterns(b *testing.B) {
+	cfg := &config.BypassConfig{Enabled: true}
+	for i := 0; i < 500; i++ {
+		cfg.Domains = append(cfg.Domains, fmt.Sprintf("*.dom%03d.example", i))
+	}
+
+	engine, _ := NewEngine(cfg)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		engine.MatchDomain("host.dom499.example")
+	}
+}
+
+func TestCIDRTrieLookup(t *testing.T) {
+	eng, err := NewEngine(&config.BypassConfig{
+		Enabled: true,
+		CIDRs: []string{
+			"10.0.0.0/8",
+			"192.168.0.0/16",
+			"fd00::/8",
+		},
+		CustomRules: []config.BypassRule{
+			{Name: "block-guest", Type: "cidr", Pattern: "10.99.0.0/16", Action: "block"},
+			{Name: "v6-lab", Type: "cidr", Pattern: "2001:db8::/32", Action: "bypass"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	tests := []struct {
+		ip      string
+		matched bool
+		action  Action
+	}{
+		{"10.1.2.3", true, ActionBypass},
+		{"10.99.4.5", true, ActionBlock}, // Custom block inside built-in /8
+		{"192.168.1.1", true, ActionBypass},
+		{"172.16.0.1", false, ""},
+		{"fd12::1", true, ActionBypass},
+		{"2001:db8::42", true, ActionBypass},
+		{"2001:db9::42", false, ""},
+	}
+	for _, tt := range tests {
+		result := eng.MatchIP(net.ParseIP(tt.ip))
+		if result.Matched != tt.matched {
+			t.Errorf("MatchIP(%s).Matched = %v, want %v", tt.ip, result.Matched, tt.matched)
+			continue
+		}
+		if tt.matched && result.Action != tt.action {
+			t.Errorf("MatchIP(%s).Action = %s, want %s", tt.ip, result.Action, tt.action)
+		}
+	}
+
+	// The custom rule reference survives the trie path
+	if result := eng.MatchIP(net.ParseIP("10.99.4.5")); result.Rule == nil || result.Rule.Name != "block-guest" {
+		t.Errorf("MatchIP(10.99.4.5).Rule = %+v, want block-guest", result.Rule)
+	}
+}
+
+func TestCIDRTrieMappedV4(t *testing.T) {
+	// IPv4-mapped IPv6 CIDRs keep a 128-bit mask while the key shrinks
+	// to 4 bytes; these used to panic in insert
+	eng, err := NewEngine(&config.BypassConfig{
+		Enabled: true,
+		CIDRs: []string{
+			"::ffff:10.0.0.0/104", // 10.0.0.0/8 in mapped form
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	tests := []struct {
+		ip      string
+		matched bool
+	}{
+		{"10.1.2.3", true},
+		{"::ffff:10.1.2.3", true},
+		{"11.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if result := eng.MatchIP(net.ParseIP(tt.ip)); result.Matched != tt.matched {
+			t.Errorf("MatchIP(%s).Matched = %v, want %v", tt.ip, result.Matched, tt.matched)
+		}
+	}
+
+	// AddRule goes through the same insert path under the write lock
+	if err := eng.AddRule(Rule{Name: "mapped", Type: RuleTypeCIDR, Pattern: "::ffff:192.168.0.0/112", Action: ActionBlock}); err != nil {
+		t.Fatalf("AddRule() error = %v", err)
+	}
+	if result := eng.MatchIP(net.ParseIP("192.168.1.1")); !result.Matched || result.Action != ActionBlock {
+		t.Errorf("MatchIP(192.168.1.1) = %+v, want mapped block rule", result)
+	}
+}
+
+func TestCIDRTrieTracksRuleMutations(t *testing.T) {
+	eng, err := NewEngine(&config.BypassConfig{Enabled: true})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	ip := net.ParseIP("100.64.1.1")
+	if eng.MatchIP(ip).Matched {
+		t.Fatal("unexpected match before AddRule")
+	}
+
+	if err := eng.AddRule(Rule{
+		Name: "cgnat", Type: RuleTypeCIDR, Pattern: "100.64.0.0/10", Action: ActionBypass,
+	}); err != nil {
+		t.Fatalf("AddRule() error = %v", err)
+	}
+	if !eng.MatchIP(ip).Matched {
+		t.Error("added CIDR rule not matched via trie")
+	}
+
+	eng.RemoveRule("cgnat")
+	if eng.MatchIP(ip).Matched {
+		t.Error("removed CIDR rule still matched")
+	}
+}
+
+// BenchmarkMatchIP10kPrefixes measures the trie walk against a large
+// rule set, where the old linear scan was O(n) per packet decision
+func BenchmarkMatchIP10kPrefixes(b *testing.B) {
+	cfg := &config.BypassConfig{Enabled: true}
+	for i := 0; i < 10000; i++ {
+		cfg.CIDRs = append(cfg.CIDRs, fmt.Sprintf("10.%d.%d.0/24", i/256, i%256))
+	}
+
+	engine, _ := NewEngine(cfg)
+	ip := net.ParseIP("10.39.15.77")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		engine.MatchIP(ip)
+	}
+}
+
+func TestMatchIPNormalizesMappedAddresses(t *testing.T) {
+	eng, err := NewEngine(&config.BypassConfig{
+		Enabled: true,
+		CIDRs:   []string{"192.168.0.0/16"},
+		CustomRules: []config.BypassRule{
+			{Name: "block-host", Type: "cidr", Pattern: "203.0.113.7/32", Action: "block"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	// A v4-mapped v6 destination is the same host as its v4 form
+	if result := eng.MatchIP(net.ParseIP("::ffff:192.168.1.5")); !result.Matched {
+		t.Error("mapped address did not match the v4 CIDR")
+	}
+	if !eng.IsBlocked(MatchRequest{IP: net.ParseIP("::ffff:203.0.113.7")}) {
+		t.Error("mapped address escaped the v4 block rule")
+	}
+}
+
+func TestDefaultConfigBypassesIPv6LocalRanges(t *testing.T) {
+	eng, err := NewEngine(&config.DefaultConfig().Bypass)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"::1", true},          // Loopback
+		{"fe80::1c2f:1", true}, // Link-local
+		{"fd42:abcd::7", true}, // Unique-local
+		{"2606:4700::1111", false},
+	}
+	for _, tt := range tests {
+		if got := eng.MatchIP(net.ParseIP(tt.ip)).Matched; got != tt.want {
+			t.Errorf("MatchIP(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
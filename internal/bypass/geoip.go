@@ -2,22 +2,116 @@
 package bypass
 
 import (
+	"container/list"
+	"fmt"
 	"net"
+	"os"
 	"sync"
+	"time"
 
 	"github.com/jery0843/torforge/pkg/logger"
 	"github.com/oschwald/geoip2-golang"
 )
 
+// defaultGeoCacheSize bounds the IP→country lookup cache
+const defaultGeoCacheSize = 1024
+
+// geoIPCache is a small LRU cache of IP string → country code, avoiding
+// repeated mmdb reads when the same few IPs are classified over and over.
+// It has its own lock so it can be updated while lookups hold the
+// matcher's read lock.
+type geoIPCache struct {
+	mu      sync.Mutex
+	max     int
+	entries map[string]*list.Element
+	order   *list.List // front = most recently used
+}
+
+type geoCacheEntry struct {
+	key     string
+	country string
+}
+
+func newGeoIPCache(max int) *geoIPCache {
+	return &geoIPCache{
+		max:     max,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func (c *geoIPCache) get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*geoCacheEntry).country, true
+}
+
+func (c *geoIPCache) put(key, country string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.max <= 0 {
+		return
+	}
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*geoCacheEntry).country = country
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&geoCacheEntry{key: key, country: country})
+
+	for c.order.Len() > c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*geoCacheEntry).key)
+	}
+}
+
+func (c *geoIPCache) resize(max int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.max = max
+	for c.order.Len() > max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*geoCacheEntry).key)
+	}
+}
+
 // GeoIPMatcher matches IPs to countries
 type GeoIPMatcher struct {
-	db        *geoip2.Reader
-	countries map[string]bool
-	mu        sync.RWMutex
+	db          *geoip2.Reader
+	asnDB       *geoip2.Reader
+	countries   map[string]Action // Per-country action: bypass, tor, or block
+	continents  map[string]bool
+	asns        map[uint]bool
+	excludeASNs map[uint]bool // Never bypass these, even on a country match
+	cache       *geoIPCache
+	maxDBAge    time.Duration // Warn when the database is older than this; 0 disables
+	watcherStop chan struct{}
+	updaterStop chan struct{}
+	mu          sync.RWMutex
 }
 
 // NewGeoIPMatcher creates a new GeoIP matcher
 func NewGeoIPMatcher(dbPath string, countries []string) (*GeoIPMatcher, error) {
+	return NewGeoIPMatcherWithASN(dbPath, "", countries, nil, nil)
+}
+
+// NewGeoIPMatcherWithASN creates a GeoIP matcher with an optional ASN database.
+// Either database may be missing; the matcher works with whichever is present.
+// ASNs on the exclude list are never bypassed, even when the country matches —
+// that's how "bypass my country, but never known VPS providers" is expressed.
+func NewGeoIPMatcherWithASN(dbPath, asnDBPath string, countries []string, asns, excludeASNs []uint) (*GeoIPMatcher, error) {
 	log := logger.WithComponent("geoip")
 
 	if dbPath == "" {
@@ -37,35 +131,176 @@ func NewGeoIPMatcher(dbPath string, countries []string) (*GeoIPMatcher, error) {
 		}
 	}
 
-	if dbPath == "" {
+	if asnDBPath == "" {
+		// Try default locations for the ASN database
+		defaultASNPaths := []string{
+			"/usr/share/GeoIP/GeoLite2-ASN.mmdb",
+			"/var/lib/GeoIP/GeoLite2-ASN.mmdb",
+			"./GeoLite2-ASN.mmdb",
+		}
+		for _, p := range defaultASNPaths {
+			db, err := geoip2.Open(p)
+			if err == nil {
+				asnDBPath = p
+				db.Close()
+				break
+			}
+		}
+	}
+
+	if dbPath == "" && asnDBPath == "" {
 		log.Warn().Msg("GeoIP database not found, country-based bypass disabled")
 		return nil, nil
 	}
 
-	db, err := geoip2.Open(dbPath)
-	if err != nil {
-		return nil, err
+	var db *geoip2.Reader
+	var err error
+	if dbPath != "" {
+		db, err = geoip2.Open(dbPath)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	// Build country set
-	countrySet := make(map[string]bool)
+	var asnDB *geoip2.Reader
+	if asnDBPath != "" {
+		asnDB, err = geoip2.Open(asnDBPath)
+		if err != nil {
+			if db != nil {
+				db.Close()
+			}
+			return nil, err
+		}
+	}
+
+	// Build country action map; a plain config list means bypass
+	countrySet := make(map[string]Action)
 	for _, c := range countries {
-		countrySet[c] = true
+		countrySet[c] = ActionBypass
+	}
+
+	// Build ASN sets
+	asnSet := make(map[uint]bool)
+	for _, a := range asns {
+		asnSet[a] = true
+	}
+	excludeSet := make(map[uint]bool)
+	for _, a := range excludeASNs {
+		excludeSet[a] = true
 	}
 
 	log.Info().
 		Str("database", dbPath).
+		Str("asn_database", asnDBPath).
 		Int("countries", len(countries)).
+		Int("asns", len(asns)).
 		Msg("GeoIP matcher initialized")
 
 	return &GeoIPMatcher{
-		db:        db,
-		countries: countrySet,
+		db:          db,
+		asnDB:       asnDB,
+		countries:   countrySet,
+		continents:  make(map[string]bool),
+		asns:        asnSet,
+		excludeASNs: excludeSet,
+		cache:       newGeoIPCache(defaultGeoCacheSize),
 	}, nil
 }
 
-// Match checks if an IP is in one of the configured countries
-func (g *GeoIPMatcher) Match(ip net.IP) (string, bool) {
+// SetCacheSize sets the maximum number of cached IP→country lookups.
+// A size of 0 disables caching.
+func (g *GeoIPMatcher) SetCacheSize(n int) {
+	if g == nil || g.cache == nil {
+		return
+	}
+	g.cache.resize(n)
+}
+
+// lookupCountry returns the country code for an IP, consulting the cache
+// before touching the memory-mapped database. Callers hold the read lock.
+func (g *GeoIPMatcher) lookupCountry(ip net.IP) string {
+	key := ip.String()
+	if g.cache != nil {
+		if country, ok := g.cache.get(key); ok {
+			return country
+		}
+	}
+
+	record, err := g.db.Country(ip)
+	if err != nil {
+		return ""
+	}
+
+	country := record.Country.IsoCode
+	if g.cache != nil {
+		g.cache.put(key, country)
+	}
+	return country
+}
+
+// Match returns the configured action for an IP's country. Countries
+// without an explicit action don't match — the connection falls through
+// to the default routing (Tor).
+func (g *GeoIPMatcher) Match(ip net.IP) (string, Action, bool) {
+	if g == nil || g.db == nil {
+		return "", "", false
+	}
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	country := g.lookupCountry(ip)
+	if action, ok := g.countries[country]; ok {
+		return country, action, true
+	}
+
+	// Fall back to continent-level matching (e.g. "bypass everything in Europe")
+	if len(g.continents) > 0 {
+		record, err := g.db.Country(ip)
+		if err != nil {
+			return "", "", false
+		}
+		continent := record.Continent.Code
+		if g.continents[continent] {
+			return continent, ActionBypass, true
+		}
+	}
+
+	return "", "", false
+}
+
+// SetCountryAction assigns an action to a country code, expressing
+// policies like "bypass my own country, block X, force Y through Tor"
+func (g *GeoIPMatcher) SetCountryAction(code string, action Action) error {
+	if g == nil {
+		return fmt.Errorf("geoip matcher not initialized")
+	}
+	if action != ActionBypass && action != ActionBlock && action != ActionTor {
+		return fmt.Errorf("unsupported country action %q", action)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.countries[code] = action
+	return nil
+}
+
+// GetCountryAction returns the configured action for a country, if any
+func (g *GeoIPMatcher) GetCountryAction(code string) (Action, bool) {
+	if g == nil {
+		return "", false
+	}
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	action, ok := g.countries[code]
+	return action, ok
+}
+
+// MatchContinent checks if an IP is in one of the configured continents
+func (g *GeoIPMatcher) MatchContinent(ip net.IP) (string, bool) {
 	if g == nil || g.db == nil {
 		return "", false
 	}
@@ -78,9 +313,9 @@ func (g *GeoIPMatcher) Match(ip net.IP) (string, bool) {
 		return "", false
 	}
 
-	country := record.Country.IsoCode
-	if g.countries[country] {
-		return country, true
+	continent := record.Continent.Code
+	if g.continents[continent] {
+		return continent, true
 	}
 
 	return "", false
@@ -95,19 +330,139 @@ func (g *GeoIPMatcher) GetCountry(ip net.IP) string {
 	g.mu.RLock()
 	defer g.mu.RUnlock()
 
-	record, err := g.db.Country(ip)
+	return g.lookupCountry(ip)
+}
+
+// MatchASN looks up an IP's autonomous system and reports whether it
+// should bypass under the include/exclude lists. The ASN and
+// organization name are returned even on a non-match so callers can log
+// why a decision went the way it did.
+func (g *GeoIPMatcher) MatchASN(ip net.IP) (uint, string, bool) {
+	if g == nil || g.asnDB == nil {
+		return 0, "", false
+	}
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	record, err := g.asnDB.ASN(ip)
 	if err != nil {
-		return ""
+		return 0, "", false
+	}
+
+	asn := record.AutonomousSystemNumber
+	org := record.AutonomousSystemOrganization
+	return asn, org, !g.excludeASNs[asn] && g.asns[asn]
+}
+
+// ASNBypassed reports whether an ASN would bypass under the
+// include/exclude lists, independent of any database lookup. Exclusion
+// always wins over inclusion.
+func (g *GeoIPMatcher) ASNBypassed(asn uint) bool {
+	if g == nil {
+		return false
+	}
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	return !g.excludeASNs[asn] && g.asns[asn]
+}
+
+// ASNExcluded reports whether an ASN is on the never-bypass list
+func (g *GeoIPMatcher) ASNExcluded(asn uint) bool {
+	if g == nil {
+		return false
 	}
 
-	return record.Country.IsoCode
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	return g.excludeASNs[asn]
+}
+
+// GetASN returns the autonomous system number for an IP
+func (g *GeoIPMatcher) GetASN(ip net.IP) uint {
+	if g == nil || g.asnDB == nil {
+		return 0
+	}
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	record, err := g.asnDB.ASN(ip)
+	if err != nil {
+		return 0
+	}
+
+	return record.AutonomousSystemNumber
+}
+
+// AddASN adds an ASN to the bypass list
+func (g *GeoIPMatcher) AddASN(asn uint) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.asns[asn] = true
+}
+
+// RemoveASN removes an ASN from the bypass list
+func (g *GeoIPMatcher) RemoveASN(asn uint) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.asns, asn)
+}
+
+// AddExcludedASN adds an ASN to the never-bypass list
+func (g *GeoIPMatcher) AddExcludedASN(asn uint) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.excludeASNs[asn] = true
+}
+
+// RemoveExcludedASN removes an ASN from the never-bypass list
+func (g *GeoIPMatcher) RemoveExcludedASN(asn uint) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.excludeASNs, asn)
+}
+
+// GetExcludedASNs returns the list of never-bypass ASNs
+func (g *GeoIPMatcher) GetExcludedASNs() []uint {
+	if g == nil {
+		return nil
+	}
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	asns := make([]uint, 0, len(g.excludeASNs))
+	for a := range g.excludeASNs {
+		asns = append(asns, a)
+	}
+	return asns
+}
+
+// GetBypassedASNs returns the list of bypassed ASNs
+func (g *GeoIPMatcher) GetBypassedASNs() []uint {
+	if g == nil {
+		return nil
+	}
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	asns := make([]uint, 0, len(g.asns))
+	for a := range g.asns {
+		asns = append(asns, a)
+	}
+	return asns
 }
 
 // AddCountry adds a country to the bypass list
 func (g *GeoIPMatcher) AddCountry(code string) {
 	g.mu.Lock()
 	defer g.mu.Unlock()
-	g.countries[code] = true
+	g.countries[code] = ActionBypass
 }
 
 // RemoveCountry removes a country from the bypass list
@@ -117,15 +472,218 @@ func (g *GeoIPMatcher) RemoveCountry(code string) {
 	delete(g.countries, code)
 }
 
-// Close closes the GeoIP database
+// AddContinent adds a continent code (e.g. "EU") to the bypass list
+func (g *GeoIPMatcher) AddContinent(code string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.continents[code] = true
+}
+
+// RemoveContinent removes a continent from the bypass list
+func (g *GeoIPMatcher) RemoveContinent(code string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.continents, code)
+}
+
+// GetBypassedContinents returns the list of bypassed continents
+func (g *GeoIPMatcher) GetBypassedContinents() []string {
+	if g == nil {
+		return nil
+	}
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	continents := make([]string, 0, len(g.continents))
+	for c := range g.continents {
+		continents = append(continents, c)
+	}
+	return continents
+}
+
+// Reload swaps in a new country database file, e.g. after a weekly
+// GeoLite2 update. If opening the new file fails the old database is
+// kept so lookups never break.
+func (g *GeoIPMatcher) Reload(path string) error {
+	if g == nil {
+		return fmt.Errorf("geoip matcher not initialized")
+	}
+
+	newDB, err := geoip2.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open new GeoIP database: %w", err)
+	}
+
+	g.mu.Lock()
+	oldDB := g.db
+	g.db = newDB
+	g.mu.Unlock()
+
+	// The new database may map IPs differently
+	if g.cache != nil {
+		g.cache.resize(0)
+		g.cache.resize(defaultGeoCacheSize)
+	}
+
+	if oldDB != nil {
+		oldDB.Close()
+	}
+
+	log := logger.WithComponent("geoip")
+	log.Info().Str("database", path).Msg("GeoIP database reloaded")
+	g.warnIfStale()
+	return nil
+}
+
+// ReloadDatabase is the older name for Reload, kept for callers that
+// predate the watcher
+func (g *GeoIPMatcher) ReloadDatabase(path string) error {
+	return g.Reload(path)
+}
+
+// DatabaseBuildTime returns when the loaded country database was built,
+// from the mmdb metadata. Zero when no database is loaded.
+func (g *GeoIPMatcher) DatabaseBuildTime() time.Time {
+	if g == nil {
+		return time.Time{}
+	}
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if g.db == nil {
+		return time.Time{}
+	}
+	return time.Unix(int64(g.db.Metadata().BuildEpoch), 0)
+}
+
+// SetMaxDatabaseAge configures how old the database may grow before a
+// staleness warning is logged; 0 disables the check. The current
+// database is checked immediately.
+func (g *GeoIPMatcher) SetMaxDatabaseAge(maxAge time.Duration) {
+	if g == nil {
+		return
+	}
+
+	g.mu.Lock()
+	g.maxDBAge = maxAge
+	g.mu.Unlock()
+
+	g.warnIfStale()
+}
+
+// databaseStale reports whether a database built at buildTime exceeds
+// maxAge as of now. A zero buildTime or maxAge never counts as stale.
+func databaseStale(buildTime time.Time, maxAge time.Duration, now time.Time) bool {
+	return maxAge > 0 && !buildTime.IsZero() && now.Sub(buildTime) > maxAge
+}
+
+// warnIfStale logs when the loaded database is older than the
+// configured maximum age
+func (g *GeoIPMatcher) warnIfStale() {
+	g.mu.RLock()
+	maxAge := g.maxDBAge
+	g.mu.RUnlock()
+
+	buildTime := g.DatabaseBuildTime()
+	if !databaseStale(buildTime, maxAge, time.Now()) {
+		return
+	}
+
+	log := logger.WithComponent("geoip")
+	log.Warn().
+		Time("build_time", buildTime).
+		Dur("max_age", maxAge).
+		Msg("GeoIP database is stale, consider updating it")
+}
+
+// WatchDatabase polls the database file's mtime on an interval and
+// reloads automatically when it changes, so a weekly GeoLite2 refresh
+// takes effect without a restart. Only one watcher runs at a time.
+func (g *GeoIPMatcher) WatchDatabase(path string, interval time.Duration) error {
+	if g == nil {
+		return fmt.Errorf("geoip matcher not initialized")
+	}
+	if interval <= 0 {
+		return fmt.Errorf("watch interval must be positive, got %v", interval)
+	}
+
+	var lastMtime time.Time
+	if info, err := os.Stat(path); err == nil {
+		lastMtime = info.ModTime()
+	}
+
+	g.mu.Lock()
+	if g.watcherStop != nil {
+		close(g.watcherStop)
+	}
+	g.watcherStop = make(chan struct{})
+	stop := g.watcherStop
+	g.mu.Unlock()
+
+	go func() {
+		log := logger.WithComponent("geoip")
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				info, err := os.Stat(path)
+				if err != nil {
+					continue
+				}
+				if !info.ModTime().After(lastMtime) {
+					continue
+				}
+				lastMtime = info.ModTime()
+				if err := g.Reload(path); err != nil {
+					log.Warn().Err(err).Str("database", path).
+						Msg("auto-reload of GeoIP database failed")
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// StopWatcher stops the database file watcher, if one is running
+func (g *GeoIPMatcher) StopWatcher() {
+	if g == nil {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.watcherStop != nil {
+		close(g.watcherStop)
+		g.watcherStop = nil
+	}
+}
+
+// Close stops the file watcher and closes the GeoIP databases
 func (g *GeoIPMatcher) Close() error {
-	if g == nil || g.db == nil {
+	if g == nil {
 		return nil
 	}
-	return g.db.Close()
+	g.StopWatcher()
+	g.StopAutoUpdate()
+	var err error
+	if g.db != nil {
+		err = g.db.Close()
+	}
+	if g.asnDB != nil {
+		if asnErr := g.asnDB.Close(); err == nil {
+			err = asnErr
+		}
+	}
+	return err
 }
 
-// GetBypassedCountries returns the list of bypassed countries
+// GetBypassedCountries returns the countries whose action is bypass
 func (g *GeoIPMatcher) GetBypassedCountries() []string {
 	if g == nil {
 		return nil
@@ -135,8 +693,10 @@ func (g *GeoIPMatcher) GetBypassedCountries() []string {
 	defer g.mu.RUnlock()
 
 	countries := make([]string, 0, len(g.countries))
-	for c := range g.countries {
-		countries = append(countries, c)
+	for c, action := range g.countries {
+		if action == ActionBypass {
+			countries = append(countries, c)
+		}
 	}
 	return countries
 }
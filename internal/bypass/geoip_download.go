@@ -0,0 +1,212 @@
+// Package bypass - GeoIP database download and refresh
+package bypass
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jery0843/torforge/pkg/logger"
+)
+
+// maxGeoIPDownload caps the database download size
+const maxGeoIPDownload = 128 << 20 // 128MB
+
+// GeoIPDownloadOptions configures where the database comes from and
+// where it lands
+type GeoIPDownloadOptions struct {
+	URL        string        // Download URL; "{license_key}" expands to LicenseKey
+	LicenseKey string        // MaxMind license key, for the official URL template
+	SHA256     string        // Optional hex pin of the downloaded archive
+	DestPath   string        // Where the mmdb is written
+	Interval   time.Duration // Refresh schedule; 0 downloads once
+	Client     *http.Client  // Tor-aware client so the download doesn't leak
+}
+
+// DownloadGeoIPDatabase fetches the database, verifies the optional
+// SHA256 pin, unpacks MaxMind's tar.gz form when needed, and writes the
+// mmdb atomically so a crash never leaves a torn file behind. The fetch
+// goes through the supplied client — callers who care about leaking the
+// request route it through the Tor SOCKS proxy.
+func DownloadGeoIPDatabase(opts GeoIPDownloadOptions) error {
+	if opts.URL == "" {
+		return fmt.Errorf("download URL is required")
+	}
+	if opts.DestPath == "" {
+		return fmt.Errorf("download destination path is required")
+	}
+	if opts.Client == nil {
+		return fmt.Errorf("download requires an http.Client")
+	}
+
+	url := strings.ReplaceAll(opts.URL, "{license_key}", opts.LicenseKey)
+
+	resp, err := opts.Client.Get(url)
+	if err != nil {
+		return fmt.Errorf("download failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download returned status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxGeoIPDownload))
+	if err != nil {
+		return fmt.Errorf("failed to read download body: %w", err)
+	}
+
+	if opts.SHA256 != "" {
+		sum := sha256.Sum256(body)
+		if !strings.EqualFold(hex.EncodeToString(sum[:]), opts.SHA256) {
+			return fmt.Errorf("download does not match pinned SHA256")
+		}
+	}
+
+	mmdb, err := extractMMDB(body)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(opts.DestPath), 0755); err != nil {
+		return fmt.Errorf("failed to create database dir: %w", err)
+	}
+	tmp := opts.DestPath + ".tmp"
+	if err := os.WriteFile(tmp, mmdb, 0644); err != nil {
+		return fmt.Errorf("failed to write database: %w", err)
+	}
+	if err := os.Rename(tmp, opts.DestPath); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace database: %w", err)
+	}
+
+	log := logger.WithComponent("geoip")
+	log.Info().Str("database", opts.DestPath).Int("bytes", len(mmdb)).
+		Msg("GeoIP database downloaded")
+	return nil
+}
+
+// extractMMDB returns the mmdb payload from a download. MaxMind's
+// official endpoint serves a tar.gz with the mmdb inside; plain mirrors
+// serve the raw file.
+func extractMMDB(body []byte) ([]byte, error) {
+	// Gzip magic says this is the MaxMind tar.gz form
+	if len(body) < 2 || body[0] != 0x1f || body[1] != 0x8b {
+		return body, nil
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress download: %w", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no .mmdb file in downloaded archive")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read downloaded archive: %w", err)
+		}
+		if !strings.HasSuffix(hdr.Name, ".mmdb") {
+			continue
+		}
+		mmdb, err := io.ReadAll(io.LimitReader(tr, maxGeoIPDownload))
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract database: %w", err)
+		}
+		return mmdb, nil
+	}
+}
+
+// NewGeoIPMatcherWithDownload behaves like NewGeoIPMatcher but, when no
+// database exists locally, downloads one with opts before giving up.
+// When opts.Interval is set the matcher keeps refreshing the database
+// on that schedule and reloads it in place.
+func NewGeoIPMatcherWithDownload(dbPath string, countries []string, opts *GeoIPDownloadOptions) (*GeoIPMatcher, error) {
+	matcher, err := NewGeoIPMatcher(dbPath, countries)
+	if err != nil || matcher != nil || opts == nil {
+		return matcher, err
+	}
+
+	log := logger.WithComponent("geoip")
+
+	if err := DownloadGeoIPDatabase(*opts); err != nil {
+		log.Warn().Err(err).Msg("GeoIP database download failed, country-based bypass disabled")
+		return nil, nil
+	}
+
+	matcher, err = NewGeoIPMatcher(opts.DestPath, countries)
+	if err != nil {
+		log.Warn().Err(err).Str("database", opts.DestPath).
+			Msg("downloaded GeoIP database unusable, country-based bypass disabled")
+		return nil, nil
+	}
+	if matcher != nil && opts.Interval > 0 {
+		matcher.StartAutoUpdate(*opts)
+	}
+	return matcher, nil
+}
+
+// StartAutoUpdate refreshes the database from opts.URL on the given
+// interval and reloads it in place. Only one updater runs at a time;
+// Close stops it.
+func (g *GeoIPMatcher) StartAutoUpdate(opts GeoIPDownloadOptions) {
+	if g == nil || opts.Interval <= 0 {
+		return
+	}
+
+	g.mu.Lock()
+	if g.updaterStop != nil {
+		close(g.updaterStop)
+	}
+	g.updaterStop = make(chan struct{})
+	stop := g.updaterStop
+	g.mu.Unlock()
+
+	go func() {
+		log := logger.WithComponent("geoip")
+		ticker := time.NewTicker(opts.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := DownloadGeoIPDatabase(opts); err != nil {
+					log.Warn().Err(err).Msg("scheduled GeoIP database refresh failed")
+					continue
+				}
+				if err := g.Reload(opts.DestPath); err != nil {
+					log.Warn().Err(err).Msg("reload after GeoIP refresh failed")
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// StopAutoUpdate stops the refresh schedule, if one is running
+func (g *GeoIPMatcher) StopAutoUpdate() {
+	if g == nil {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.updaterStop != nil {
+		close(g.updaterStop)
+		g.updaterStop = nil
+	}
+}
@@ -0,0 +1,178 @@
+package bypass
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDownloadGeoIPDatabasePlainMirror(t *testing.T) {
+	content := []byte("fixture mmdb payload")
+	var gotURL string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotURL = r.URL.String()
+		w.Write(content)
+	}))
+	defer srv.Close()
+
+	dest := filepath.Join(t.TempDir(), "GeoLite2-Country.mmdb")
+	err := DownloadGeoIPDatabase(GeoIPDownloadOptions{
+		URL:        srv.URL + "/db?license_key={license_key}",
+		LicenseKey: "testkey123",
+		DestPath:   dest,
+		Client:     srv.Client(),
+	})
+	if err != nil {
+		t.Fatalf("DownloadGeoIPDatabase() error = %v", err)
+	}
+
+	got, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatalf("failed to read downloaded database: %v", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Error("downloaded database does not match served content")
+	}
+	if gotURL != "/db?license_key=testkey123" {
+		t.Errorf("license key template not expanded, request URL = %q", gotURL)
+	}
+	if _, err := os.Stat(dest + ".tmp"); !os.IsNotExist(err) {
+		t.Error("temp file left behind after download")
+	}
+}
+
+func TestDownloadGeoIPDatabaseTarGz(t *testing.T) {
+	// The MaxMind endpoint serves a tar.gz with the mmdb inside
+	mmdb := []byte("fixture mmdb inside archive")
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Name: "GeoLite2-Country_20260801/COPYRIGHT.txt", Mode: 0644, Size: 4})
+	tw.Write([]byte("none"))
+	tw.WriteHeader(&tar.Header{Name: "GeoLite2-Country_20260801/GeoLite2-Country.mmdb", Mode: 0644, Size: int64(len(mmdb))})
+	tw.Write(mmdb)
+	tw.Close()
+	gz.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(buf.Bytes())
+	}))
+	defer srv.Close()
+
+	dest := filepath.Join(t.TempDir(), "GeoLite2-Country.mmdb")
+	err := DownloadGeoIPDatabase(GeoIPDownloadOptions{
+		URL:      srv.URL,
+		DestPath: dest,
+		Client:   srv.Client(),
+	})
+	if err != nil {
+		t.Fatalf("DownloadGeoIPDatabase() error = %v", err)
+	}
+
+	got, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatalf("failed to read downloaded database: %v", err)
+	}
+	if !bytes.Equal(got, mmdb) {
+		t.Error("mmdb not extracted from tar.gz archive")
+	}
+}
+
+func TestDownloadGeoIPDatabaseSHA256(t *testing.T) {
+	content := []byte("pinned payload")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer srv.Close()
+
+	dest := filepath.Join(t.TempDir(), "db.mmdb")
+	sum := sha256.Sum256(content)
+
+	opts := GeoIPDownloadOptions{
+		URL:      srv.URL,
+		DestPath: dest,
+		SHA256:   hex.EncodeToString(sum[:]),
+		Client:   srv.Client(),
+	}
+	if err := DownloadGeoIPDatabase(opts); err != nil {
+		t.Fatalf("DownloadGeoIPDatabase() with matching pin error = %v", err)
+	}
+
+	opts.SHA256 = "deadbeef"
+	opts.DestPath = filepath.Join(t.TempDir(), "bad.mmdb")
+	if err := DownloadGeoIPDatabase(opts); err == nil {
+		t.Error("expected error for SHA256 mismatch")
+	}
+	if _, err := os.Stat(opts.DestPath); !os.IsNotExist(err) {
+		t.Error("database written despite SHA256 mismatch")
+	}
+}
+
+func TestDownloadGeoIPDatabaseValidation(t *testing.T) {
+	cases := []GeoIPDownloadOptions{
+		{DestPath: "/tmp/x.mmdb", Client: http.DefaultClient},       // No URL
+		{URL: "http://example.invalid", Client: http.DefaultClient}, // No dest
+		{URL: "http://example.invalid", DestPath: "/tmp/x.mmdb"},    // No client
+	}
+	for _, opts := range cases {
+		if err := DownloadGeoIPDatabase(opts); err == nil {
+			t.Errorf("DownloadGeoIPDatabase(%+v) expected error", opts)
+		}
+	}
+}
+
+func TestDownloadGeoIPDatabaseServerError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	err := DownloadGeoIPDatabase(GeoIPDownloadOptions{
+		URL:      srv.URL,
+		DestPath: filepath.Join(t.TempDir(), "db.mmdb"),
+		Client:   srv.Client(),
+	})
+	if err == nil {
+		t.Error("expected error for non-200 response")
+	}
+}
+
+func TestNewGeoIPMatcherWithDownloadUnusableDatabase(t *testing.T) {
+	// The server hands back bytes that aren't a valid mmdb; the matcher
+	// must degrade to the usual nil, nil "no database" contract
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("not an mmdb"))
+	}))
+	defer srv.Close()
+
+	if m, _ := NewGeoIPMatcher("", nil); m != nil {
+		m.Close()
+		t.Skip("system GeoIP database present, download fallback not reachable")
+	}
+
+	dest := filepath.Join(t.TempDir(), "GeoLite2-Country.mmdb")
+	matcher, err := NewGeoIPMatcherWithDownload("", []string{"US"}, &GeoIPDownloadOptions{
+		URL:      srv.URL,
+		DestPath: dest,
+		Client:   srv.Client(),
+	})
+	if err != nil {
+		t.Errorf("NewGeoIPMatcherWithDownload() error = %v, want graceful nil", err)
+	}
+	if matcher != nil {
+		t.Error("expected nil matcher for unusable database")
+		matcher.Close()
+	}
+
+	// The download itself still happened
+	if _, statErr := os.Stat(dest); statErr != nil {
+		t.Errorf("database file not written: %v", statErr)
+	}
+}
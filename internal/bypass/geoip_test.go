@@ -2,7 +2,10 @@ package bypass
 
 import (
 	"net"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 // TestGeoIPMatcherNil tests nil matcher behavior from user perspective
@@ -12,7 +15,7 @@ func TestGeoIPMatcherNil(t *testing.T) {
 	var matcher *GeoIPMatcher = nil
 
 	// Match should return false for nil matcher
-	if country, matched := matcher.Match(net.ParseIP("8.8.8.8")); matched {
+	if country, _, matched := matcher.Match(net.ParseIP("8.8.8.8")); matched {
 		t.Errorf("nil matcher should not match, got country %s", country)
 	}
 
@@ -72,7 +75,7 @@ func TestGeoIPMatcherCountryManagement(t *testing.T) {
 
 	matcher := &GeoIPMatcher{
 		db:        nil, // No database
-		countries: make(map[string]bool),
+		countries: make(map[string]Action),
 	}
 
 	// Initially no countries
@@ -116,15 +119,190 @@ func TestGeoIPMatcherCountryManagement(t *testing.T) {
 	}
 }
 
+// TestGeoIPMatcherReloadFailure tests that a failed reload keeps the old database
+func TestGeoIPMatcherReloadFailure(t *testing.T) {
+	matcher := &GeoIPMatcher{
+		db:        nil,
+		countries: map[string]Action{"US": ActionBypass},
+	}
+
+	// Reloading from a bad path must error and leave state untouched
+	if err := matcher.ReloadDatabase("/nonexistent/GeoLite2-Country.mmdb"); err == nil {
+		t.Error("ReloadDatabase with invalid path should error")
+	}
+
+	if len(matcher.GetBypassedCountries()) != 1 {
+		t.Error("country set should survive a failed reload")
+	}
+
+	// Nil matcher returns an error rather than panicking
+	var nilMatcher *GeoIPMatcher
+	if err := nilMatcher.ReloadDatabase("/tmp/whatever.mmdb"); err == nil {
+		t.Error("nil matcher ReloadDatabase should error")
+	}
+}
+
+// TestGeoIPCacheLRU tests the IP→country lookup cache eviction behavior
+func TestGeoIPCacheLRU(t *testing.T) {
+	cache := newGeoIPCache(2)
+
+	cache.put("1.1.1.1", "AU")
+	cache.put("8.8.8.8", "US")
+
+	// Touch 1.1.1.1 so it becomes most recently used
+	if country, ok := cache.get("1.1.1.1"); !ok || country != "AU" {
+		t.Fatalf("expected cache hit AU, got %q %v", country, ok)
+	}
+
+	// Inserting a third entry evicts the least recently used (8.8.8.8)
+	cache.put("9.9.9.9", "CH")
+
+	if _, ok := cache.get("8.8.8.8"); ok {
+		t.Error("8.8.8.8 should have been evicted")
+	}
+	if _, ok := cache.get("1.1.1.1"); !ok {
+		t.Error("1.1.1.1 should still be cached")
+	}
+	if _, ok := cache.get("9.9.9.9"); !ok {
+		t.Error("9.9.9.9 should be cached")
+	}
+
+	// Resizing down trims entries
+	cache.resize(1)
+	cache.mu.Lock()
+	size := cache.order.Len()
+	cache.mu.Unlock()
+	if size != 1 {
+		t.Errorf("expected 1 entry after resize, got %d", size)
+	}
+}
+
+func BenchmarkGeoIPCacheHit(b *testing.B) {
+	cache := newGeoIPCache(defaultGeoCacheSize)
+	cache.put("8.8.8.8", "US")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cache.get("8.8.8.8")
+	}
+}
+
+// TestGeoIPMatcherContinentManagement tests adding/removing continents at runtime
+func TestGeoIPMatcherContinentManagement(t *testing.T) {
+	matcher := &GeoIPMatcher{
+		db:         nil,
+		countries:  make(map[string]Action),
+		continents: make(map[string]bool),
+	}
+
+	// Initially no continents
+	if continents := matcher.GetBypassedContinents(); len(continents) != 0 {
+		t.Errorf("expected 0 continents initially, got %d", len(continents))
+	}
+
+	// User bypasses everything in Europe and North America
+	matcher.AddContinent("EU")
+	matcher.AddContinent("NA")
+
+	if continents := matcher.GetBypassedContinents(); len(continents) != 2 {
+		t.Errorf("expected 2 continents, got %d", len(continents))
+	}
+
+	matcher.RemoveContinent("NA")
+
+	continents := matcher.GetBypassedContinents()
+	if len(continents) != 1 {
+		t.Fatalf("expected 1 continent after removal, got %d", len(continents))
+	}
+	if continents[0] != "EU" {
+		t.Errorf("expected EU to remain, got %s", continents[0])
+	}
+
+	// Without a database, MatchContinent should return false
+	if _, matched := matcher.MatchContinent(net.ParseIP("8.8.8.8")); matched {
+		t.Error("MatchContinent without database should return false")
+	}
+
+	// Nil-receiver safety (same contract as TestGeoIPMatcherNil)
+	var nilMatcher *GeoIPMatcher
+	if _, matched := nilMatcher.MatchContinent(net.ParseIP("8.8.8.8")); matched {
+		t.Error("nil matcher MatchContinent should return false")
+	}
+	if continents := nilMatcher.GetBypassedContinents(); continents != nil {
+		t.Errorf("nil matcher GetBypassedContinents should return nil, got %v", continents)
+	}
+}
+
+// TestGeoIPMatcherASNManagement tests adding/removing ASNs at runtime
+func TestGeoIPMatcherASNManagement(t *testing.T) {
+	matcher := &GeoIPMatcher{
+		db:        nil,
+		asnDB:     nil,
+		countries: make(map[string]Action),
+		asns:      make(map[uint]bool),
+	}
+
+	// Initially no ASNs
+	if asns := matcher.GetBypassedASNs(); len(asns) != 0 {
+		t.Errorf("expected 0 ASNs initially, got %d", len(asns))
+	}
+
+	// User adds ASNs (e.g. a cloud provider and their ISP)
+	matcher.AddASN(16509)
+	matcher.AddASN(13335)
+
+	if asns := matcher.GetBypassedASNs(); len(asns) != 2 {
+		t.Errorf("expected 2 ASNs, got %d", len(asns))
+	}
+
+	// User removes one
+	matcher.RemoveASN(13335)
+
+	asns := matcher.GetBypassedASNs()
+	if len(asns) != 1 {
+		t.Fatalf("expected 1 ASN after removal, got %d", len(asns))
+	}
+	if asns[0] != 16509 {
+		t.Errorf("expected ASN 16509 to remain, got %d", asns[0])
+	}
+}
+
+// TestGeoIPMatcherASNWithoutDB tests ASN matching without a database
+func TestGeoIPMatcherASNWithoutDB(t *testing.T) {
+	matcher := &GeoIPMatcher{
+		asnDB: nil,
+		asns:  map[uint]bool{16509: true},
+	}
+
+	// Without an ASN database, MatchASN should return false
+	if _, _, matched := matcher.MatchASN(net.ParseIP("8.8.8.8")); matched {
+		t.Error("MatchASN without database should return false")
+	}
+
+	// GetASN should return zero
+	if asn := matcher.GetASN(net.ParseIP("8.8.8.8")); asn != 0 {
+		t.Errorf("GetASN without database should return 0, got %d", asn)
+	}
+
+	// Nil matcher safety
+	var nilMatcher *GeoIPMatcher
+	if _, _, matched := nilMatcher.MatchASN(net.ParseIP("8.8.8.8")); matched {
+		t.Error("nil matcher MatchASN should return false")
+	}
+	if asns := nilMatcher.GetBypassedASNs(); asns != nil {
+		t.Errorf("nil matcher GetBypassedASNs should return nil, got %v", asns)
+	}
+}
+
 // TestGeoIPMatcherMatchWithoutDB tests Match behavior without database
 func TestGeoIPMatcherMatchWithoutDB(t *testing.T) {
 	matcher := &GeoIPMatcher{
 		db:        nil,
-		countries: map[string]bool{"US": true},
+		countries: map[string]Action{"US": ActionBypass},
 	}
 
 	// Without a database, Match should return false
-	_, matched := matcher.Match(net.ParseIP("8.8.8.8"))
+	_, _, matched := matcher.Match(net.ParseIP("8.8.8.8"))
 	if matched {
 		t.Error("Match without database should return false")
 	}
@@ -134,7 +312,7 @@ func TestGeoIPMatcherMatchWithoutDB(t *testing.T) {
 func TestGeoIPMatcherAddDuplicateCountry(t *testing.T) {
 	matcher := &GeoIPMatcher{
 		db:        nil,
-		countries: make(map[string]bool),
+		countries: make(map[string]Action),
 	}
 
 	// Add same country multiple times
@@ -152,7 +330,7 @@ func TestGeoIPMatcherAddDuplicateCountry(t *testing.T) {
 func TestGeoIPMatcherRemoveNonexistent(t *testing.T) {
 	matcher := &GeoIPMatcher{
 		db:        nil,
-		countries: map[string]bool{"US": true},
+		countries: map[string]Action{"US": ActionBypass},
 	}
 
 	// Should not panic when removing non-existent country
@@ -163,3 +341,190 @@ func TestGeoIPMatcherRemoveNonexistent(t *testing.T) {
 		t.Errorf("expected 1 country after removing nonexistent, got %d", len(countries))
 	}
 }
+
+// TestASNIncludeExcludeSemantics tests the include/exclude decision
+// independent of any database lookup
+func TestASNIncludeExcludeSemantics(t *testing.T) {
+	matcher := &GeoIPMatcher{
+		asns:        map[uint]bool{64512: true, 64513: true},
+		excludeASNs: map[uint]bool{64513: true, 64514: true},
+	}
+
+	if !matcher.ASNBypassed(64512) {
+		t.Error("included ASN 64512 should bypass")
+	}
+	if matcher.ASNBypassed(64513) {
+		t.Error("ASN 64513 is excluded; exclusion must win over inclusion")
+	}
+	if matcher.ASNBypassed(64515) {
+		t.Error("unlisted ASN 64515 should not bypass")
+	}
+
+	if !matcher.ASNExcluded(64514) {
+		t.Error("ASN 64514 should report excluded")
+	}
+	if matcher.ASNExcluded(64512) {
+		t.Error("ASN 64512 should not report excluded")
+	}
+}
+
+// TestExcludedASNManagement tests runtime exclude list changes
+func TestExcludedASNManagement(t *testing.T) {
+	matcher := &GeoIPMatcher{
+		asns:        make(map[uint]bool),
+		excludeASNs: make(map[uint]bool),
+	}
+
+	matcher.AddASN(64512)
+	matcher.AddExcludedASN(64512)
+	if matcher.ASNBypassed(64512) {
+		t.Error("ASN should stop bypassing once excluded")
+	}
+
+	matcher.RemoveExcludedASN(64512)
+	if !matcher.ASNBypassed(64512) {
+		t.Error("ASN should bypass again after exclusion removed")
+	}
+
+	matcher.AddExcludedASN(64513)
+	excluded := matcher.GetExcludedASNs()
+	if len(excluded) != 1 || excluded[0] != 64513 {
+		t.Errorf("GetExcludedASNs() = %v, want [64513]", excluded)
+	}
+
+	// Nil matcher stays safe
+	var nilMatcher *GeoIPMatcher
+	if nilMatcher.ASNBypassed(64512) || nilMatcher.ASNExcluded(64512) {
+		t.Error("nil matcher should never match")
+	}
+	if nilMatcher.GetExcludedASNs() != nil {
+		t.Error("nil matcher GetExcludedASNs should return nil")
+	}
+}
+
+// TestDatabaseStale tests the staleness decision in isolation
+func TestDatabaseStale(t *testing.T) {
+	now := time.Date(2026, 8, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		buildTime time.Time
+		maxAge    time.Duration
+		want      bool
+	}{
+		{"fresh", now.Add(-24 * time.Hour), 30 * 24 * time.Hour, false},
+		{"stale", now.Add(-60 * 24 * time.Hour), 30 * 24 * time.Hour, true},
+		{"check disabled", now.Add(-365 * 24 * time.Hour), 0, false},
+		{"no database", time.Time{}, 30 * 24 * time.Hour, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := databaseStale(tt.buildTime, tt.maxAge, now); got != tt.want {
+				t.Errorf("databaseStale() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestDatabaseWatcherLifecycle tests watcher start/stop and nil-safety
+// without a real database
+func TestDatabaseWatcherLifecycle(t *testing.T) {
+	var nilMatcher *GeoIPMatcher
+	if err := nilMatcher.WatchDatabase("/tmp/whatever.mmdb", time.Second); err == nil {
+		t.Error("nil matcher WatchDatabase should error")
+	}
+	nilMatcher.StopWatcher() // Must not panic
+	if !nilMatcher.DatabaseBuildTime().IsZero() {
+		t.Error("nil matcher DatabaseBuildTime should be zero")
+	}
+	nilMatcher.SetMaxDatabaseAge(time.Hour) // Must not panic
+
+	matcher := &GeoIPMatcher{cache: newGeoIPCache(defaultGeoCacheSize)}
+	if err := matcher.WatchDatabase("/nonexistent/GeoLite2-Country.mmdb", 0); err == nil {
+		t.Error("non-positive watch interval should error")
+	}
+	if err := matcher.WatchDatabase("/nonexistent/GeoLite2-Country.mmdb", 10*time.Millisecond); err != nil {
+		t.Errorf("WatchDatabase() error = %v", err)
+	}
+	// Starting again replaces the previous watcher
+	if err := matcher.WatchDatabase("/nonexistent/GeoLite2-Country.mmdb", 10*time.Millisecond); err != nil {
+		t.Errorf("second WatchDatabase() error = %v", err)
+	}
+	matcher.StopWatcher()
+	matcher.StopWatcher() // Idempotent
+
+	if !matcher.DatabaseBuildTime().IsZero() {
+		t.Error("DatabaseBuildTime without database should be zero")
+	}
+}
+
+// TestReloadRejectsInvalidFile tests that a bad file never replaces the
+// current database
+func TestReloadRejectsInvalidFile(t *testing.T) {
+	bad := filepath.Join(t.TempDir(), "not-a-database.mmdb")
+	if err := os.WriteFile(bad, []byte("definitely not an mmdb"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	matcher := &GeoIPMatcher{cache: newGeoIPCache(defaultGeoCacheSize)}
+	if err := matcher.Reload(bad); err == nil {
+		t.Error("Reload with invalid file should error")
+	}
+}
+
+// TestCountryActions tests the per-country action map: bypass, tor,
+// block, and the unknown-country default
+func TestCountryActions(t *testing.T) {
+	matcher := &GeoIPMatcher{countries: map[string]Action{}}
+
+	if err := matcher.SetCountryAction("DE", ActionBypass); err != nil {
+		t.Fatalf("SetCountryAction(DE, bypass) error = %v", err)
+	}
+	if err := matcher.SetCountryAction("US", ActionTor); err != nil {
+		t.Fatalf("SetCountryAction(US, tor) error = %v", err)
+	}
+	if err := matcher.SetCountryAction("KP", ActionBlock); err != nil {
+		t.Fatalf("SetCountryAction(KP, block) error = %v", err)
+	}
+	if err := matcher.SetCountryAction("FR", Action("redirect")); err == nil {
+		t.Error("SetCountryAction with unknown action expected error")
+	}
+
+	tests := []struct {
+		code       string
+		wantAction Action
+		wantOK     bool
+	}{
+		{"DE", ActionBypass, true},
+		{"US", ActionTor, true},
+		{"KP", ActionBlock, true},
+		{"JP", "", false}, // Unconfigured country: no match, default routing
+	}
+	for _, tt := range tests {
+		action, ok := matcher.GetCountryAction(tt.code)
+		if action != tt.wantAction || ok != tt.wantOK {
+			t.Errorf("GetCountryAction(%s) = (%q, %v), want (%q, %v)",
+				tt.code, action, ok, tt.wantAction, tt.wantOK)
+		}
+	}
+
+	// Only bypass countries show up in the bypass list
+	bypassed := matcher.GetBypassedCountries()
+	if len(bypassed) != 1 || bypassed[0] != "DE" {
+		t.Errorf("GetBypassedCountries() = %v, want [DE]", bypassed)
+	}
+
+	// The plain country list from config migrates as bypass
+	matcher.AddCountry("CH")
+	if action, ok := matcher.GetCountryAction("CH"); !ok || action != ActionBypass {
+		t.Errorf("AddCountry action = (%q, %v), want bypass", action, ok)
+	}
+
+	// Nil-safety
+	var nilMatcher *GeoIPMatcher
+	if err := nilMatcher.SetCountryAction("DE", ActionBypass); err == nil {
+		t.Error("nil matcher SetCountryAction should error")
+	}
+	if _, ok := nilMatcher.GetCountryAction("DE"); ok {
+		t.Error("nil matcher GetCountryAction should not match")
+	}
+}
@@ -0,0 +1,129 @@
+package bypass
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Metrics is a point-in-time snapshot of the engine's match counters,
+// suitable for feeding a metrics exporter
+type Metrics struct {
+	Decisions  int64              // Every Match* call that ran
+	Unmatched  int64              // Decisions that hit no rule
+	ByAction   map[Action]int64   // Matches by resulting action
+	ByRuleType map[RuleType]int64 // Matches by rule type / dimension
+	ByRuleName map[string]int64   // Matches by named custom rule
+}
+
+// MetricsHook receives every recorded match decision so the daemon can
+// bridge to an external exporter without this package importing it.
+// Implementations must be fast and non-blocking; they run on the match
+// path (after locks are released).
+type MetricsHook interface {
+	MatchRecorded(result MatchResult)
+}
+
+// metricsRegistry counts match outcomes with atomics, mirroring how
+// ruleStats works, so the hot path never takes a lock for accounting
+type metricsRegistry struct {
+	decisions atomic.Int64
+	unmatched atomic.Int64
+	byAction  sync.Map     // Action -> *atomic.Int64
+	byType    sync.Map     // RuleType -> *atomic.Int64
+	byName    sync.Map     // rule name -> *atomic.Int64
+	hook      atomic.Value // of hookBox
+}
+
+// hookBox wraps the hook interface so atomic.Value always stores one
+// concrete type, including the nil (cleared) case
+type hookBox struct{ h MetricsHook }
+
+func bumpCounter(m *sync.Map, key any) {
+	c, ok := m.Load(key)
+	if !ok {
+		c, _ = m.LoadOrStore(key, &atomic.Int64{})
+	}
+	c.(*atomic.Int64).Add(1)
+}
+
+// record accounts one decision and invokes the hook, if any
+func (r *metricsRegistry) record(result MatchResult) {
+	r.decisions.Add(1)
+
+	if !result.Matched {
+		r.unmatched.Add(1)
+	} else {
+		bumpCounter(&r.byAction, result.Action)
+		bumpCounter(&r.byType, matchRuleType(result))
+		if result.Rule != nil && result.Rule.Name != "" {
+			bumpCounter(&r.byName, result.Rule.Name)
+		}
+	}
+
+	if box, ok := r.hook.Load().(hookBox); ok && box.h != nil {
+		box.h.MatchRecorded(result)
+	}
+}
+
+// matchRuleType maps a result to the rule type dimension: the matched
+// rule's own type when one exists, otherwise derived from the built-in
+// dimension that decided
+func matchRuleType(result MatchResult) RuleType {
+	if result.Rule != nil {
+		return result.Rule.Type
+	}
+	switch result.Dimension {
+	case "ip":
+		return RuleTypeCIDR
+	case "port":
+		return RuleTypePort
+	case "protocol":
+		return RuleTypeProtocol
+	case "application":
+		return RuleTypeApp
+	case "geoip":
+		return RuleTypeGeoIP
+	default:
+		return RuleTypeDomain
+	}
+}
+
+// snapshot copies the counters into an exportable Metrics value
+func (r *metricsRegistry) snapshot() Metrics {
+	m := Metrics{
+		Decisions:  r.decisions.Load(),
+		Unmatched:  r.unmatched.Load(),
+		ByAction:   make(map[Action]int64),
+		ByRuleType: make(map[RuleType]int64),
+		ByRuleName: make(map[string]int64),
+	}
+	r.byAction.Range(func(k, v any) bool {
+		m.ByAction[k.(Action)] = v.(*atomic.Int64).Load()
+		return true
+	})
+	r.byType.Range(func(k, v any) bool {
+		m.ByRuleType[k.(RuleType)] = v.(*atomic.Int64).Load()
+		return true
+	})
+	r.byName.Range(func(k, v any) bool {
+		m.ByRuleName[k.(string)] = v.(*atomic.Int64).Load()
+		return true
+	})
+	return m
+}
+
+// Metrics returns a snapshot of the engine's match counters
+func (e *Engine) Metrics() Metrics {
+	return e.metrics.snapshot()
+}
+
+// SetMetricsHook installs (or, with nil, clears) the exporter hook
+func (e *Engine) SetMetricsHook(h MetricsHook) {
+	e.metrics.hook.Store(hookBox{h: h})
+}
+
+// recordMatch accounts one public match decision after locks are
+// released
+func (e *Engine) recordMatch(result MatchResult) {
+	e.metrics.record(result)
+}
@@ -0,0 +1,123 @@
+package bypass
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/jery0843/torforge/pkg/config"
+)
+
+// recordingHook collects every decision the engine reports
+type recordingHook struct {
+	mu      sync.Mutex
+	results []MatchResult
+}
+
+func (h *recordingHook) MatchRecorded(result MatchResult) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.results = append(h.results, result)
+}
+
+func TestEngineMetrics(t *testing.T) {
+	cfg := &config.BypassConfig{
+		Enabled: true,
+		Domains: []string{"*.local"},
+		CIDRs:   []string{"10.0.0.0/8"},
+		CustomRules: []config.BypassRule{
+			{Name: "no-smtp", Type: "port", Pattern: "25", Action: "block"},
+		},
+	}
+
+	engine, err := NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	hook := &recordingHook{}
+	engine.SetMetricsHook(hook)
+
+	// Scripted sequence: 2 domain bypasses, 1 IP bypass, 1 port block,
+	// 2 misses
+	engine.MatchDomain("printer.local")
+	engine.MatchDomain("nas.local")
+	engine.MatchIP(net.ParseIP("10.1.2.3"))
+	engine.MatchPort(25, "")
+	engine.MatchDomain("example.org")
+	engine.MatchIP(net.ParseIP("8.8.8.8"))
+
+	m := engine.Metrics()
+	if m.Decisions != 6 {
+		t.Errorf("Decisions = %d, want 6", m.Decisions)
+	}
+	if m.Unmatched != 2 {
+		t.Errorf("Unmatched = %d, want 2", m.Unmatched)
+	}
+	if m.ByAction[ActionBypass] != 3 {
+		t.Errorf("ByAction[bypass] = %d, want 3", m.ByAction[ActionBypass])
+	}
+	if m.ByAction[ActionBlock] != 1 {
+		t.Errorf("ByAction[block] = %d, want 1", m.ByAction[ActionBlock])
+	}
+	if m.ByRuleType[RuleTypeDomain] != 2 {
+		t.Errorf("ByRuleType[domain] = %d, want 2", m.ByRuleType[RuleTypeDomain])
+	}
+	if m.ByRuleType[RuleTypeCIDR] != 1 {
+		t.Errorf("ByRuleType[cidr] = %d, want 1", m.ByRuleType[RuleTypeCIDR])
+	}
+	if m.ByRuleType[RuleTypePort] != 1 {
+		t.Errorf("ByRuleType[port] = %d, want 1", m.ByRuleType[RuleTypePort])
+	}
+	if m.ByRuleName["no-smtp"] != 1 {
+		t.Errorf("ByRuleName[no-smtp] = %d, want 1", m.ByRuleName["no-smtp"])
+	}
+
+	// The hook saw the same sequence
+	hook.mu.Lock()
+	defer hook.mu.Unlock()
+	if len(hook.results) != 6 {
+		t.Fatalf("hook saw %d results, want 6", len(hook.results))
+	}
+	if !hook.results[0].Matched || hook.results[0].Dimension != "domain" {
+		t.Errorf("first hooked result = %+v, want a domain match", hook.results[0])
+	}
+	if hook.results[5].Matched {
+		t.Errorf("last hooked result = %+v, want a miss", hook.results[5])
+	}
+}
+
+func TestEngineMetricsConcurrent(t *testing.T) {
+	engine, err := NewEngine(&config.BypassConfig{
+		Enabled: true,
+		Domains: []string{"*.lan"},
+	})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				engine.MatchDomain("host.lan")
+				engine.MatchDomain("host.example")
+			}
+		}()
+	}
+	wg.Wait()
+
+	m := engine.Metrics()
+	if m.Decisions != 1600 {
+		t.Errorf("Decisions = %d, want 1600", m.Decisions)
+	}
+	if m.ByAction[ActionBypass] != 800 || m.Unmatched != 800 {
+		t.Errorf("ByAction[bypass] = %d, Unmatched = %d, want 800 each", m.ByAction[ActionBypass], m.Unmatched)
+	}
+
+	// Clearing the hook is safe mid-flight
+	engine.SetMetricsHook(nil)
+	engine.MatchDomain("host.lan")
+}
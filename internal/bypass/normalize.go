@@ -0,0 +1,46 @@
+package bypass
+
+import (
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// NormalizeDomain converts a domain to the canonical form the matching
+// code agrees on: trimmed, lowercased, trailing root dot stripped, and
+// internationalized names folded to their xn-- punycode ASCII form. A
+// rule written as "münchen-bank.de" then matches the wire-format query
+// and vice versa. Invalid names come back lowercased but otherwise
+// untouched so lookups against them still fail closed.
+func NormalizeDomain(domain string) string {
+	d := strings.ToLower(strings.TrimSpace(domain))
+	d = strings.TrimSuffix(d, ".")
+	if d == "" {
+		return d
+	}
+
+	ascii, err := idna.Lookup.ToASCII(d)
+	if err != nil {
+		return d
+	}
+	return ascii
+}
+
+// normalizeGlobPattern applies NormalizeDomain per label so glob
+// metacharacters survive: "*.münchen.de" becomes "*.xn--mnchen-3ya.de"
+// rather than failing IDNA conversion outright.
+func normalizeGlobPattern(pattern string) string {
+	p := strings.ToLower(strings.TrimSpace(pattern))
+	p = strings.TrimSuffix(p, ".")
+
+	labels := strings.Split(p, ".")
+	for i, label := range labels {
+		if label == "" || strings.ContainsAny(label, "*?[") {
+			continue
+		}
+		if ascii, err := idna.Lookup.ToASCII(label); err == nil {
+			labels[i] = ascii
+		}
+	}
+	return strings.Join(labels, ".")
+}
@@ -0,0 +1,90 @@
+package bypass
+
+import (
+	"testing"
+
+	"github.com/jery0843/torforge/pkg/config"
+)
+
+func TestNormalizeDomain(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"example.com", "example.com"},
+		{"ExAmple.COM", "example.com"},
+		{"example.com.", "example.com"},
+		{"  Example.Com.  ", "example.com"},
+		{"münchen.de", "xn--mnchen-3ya.de"},
+		{"MÜNCHEN.DE.", "xn--mnchen-3ya.de"},
+		{"xn--mnchen-3ya.de", "xn--mnchen-3ya.de"},
+		{"XN--MNCHEN-3YA.DE", "xn--mnchen-3ya.de"},
+		{"", ""},
+		{".", ""},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeDomain(tt.input); got != tt.want {
+			t.Errorf("NormalizeDomain(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeGlobPattern(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"*.münchen.de", "*.xn--mnchen-3ya.de"},
+		{"*.Example.COM.", "*.example.com"},
+		{"cdn*.münchen.de", "cdn*.xn--mnchen-3ya.de"},
+		{"[a-c]dn.example", "[a-c]dn.example"},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeGlobPattern(tt.input); got != tt.want {
+			t.Errorf("normalizeGlobPattern(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestMatchDomainIDN(t *testing.T) {
+	cfg := &config.BypassConfig{
+		Enabled: true,
+		Domains: []string{"münchen-bank.de", "*.münchen.de"},
+	}
+
+	engine, err := NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	// Unicode, punycode, mixed-case, and trailing-dot queries all hit
+	// the rule written in Unicode
+	queries := []string{
+		"münchen-bank.de",
+		"MÜNCHEN-BANK.DE.",
+		NormalizeDomain("münchen-bank.de"),
+		"api.münchen.de",
+		"api.xn--mnchen-3ya.de",
+		"API.MÜNCHEN.DE.",
+	}
+	for _, q := range queries {
+		if result := engine.MatchDomain(q); !result.Matched {
+			t.Errorf("MatchDomain(%q) did not match an IDN rule", q)
+		}
+	}
+
+	// A punycode rule matches a Unicode query just the same
+	if err := engine.AddRule(Rule{
+		Name:    "idn-rule",
+		Type:    RuleTypeDomain,
+		Pattern: "xn--mnchen-3ya.example",
+		Action:  ActionBypass,
+	}); err != nil {
+		t.Fatalf("AddRule() error = %v", err)
+	}
+	if result := engine.MatchDomain("MÜNCHEN.example."); !result.Matched {
+		t.Error("punycode rule did not match the Unicode query form")
+	}
+}
@@ -0,0 +1,167 @@
+// Package bypass - persistence for dynamically added rules
+package bypass
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jery0843/torforge/pkg/config"
+	"github.com/jery0843/torforge/pkg/logger"
+)
+
+// persistedRule is the on-disk form of one dynamic rule
+type persistedRule struct {
+	Name        string    `json:"name"`
+	Type        string    `json:"type"`
+	Pattern     string    `json:"pattern"`
+	Action      string    `json:"action"`
+	Priority    int       `json:"priority,omitempty"`
+	ExpiresAt   time.Time `json:"expires_at,omitzero"`
+	Description string    `json:"description,omitempty"`
+}
+
+// rulesFile is the on-disk container, versioned so the format can grow
+type rulesFile struct {
+	Version int             `json:"version"`
+	Rules   []persistedRule `json:"rules"`
+}
+
+const rulesFileVersion = 1
+
+// NewEngineWithRulesFile creates a bypass engine that persists rules
+// added at runtime to a JSON file in the data dir. Rules from an
+// existing file are loaded on startup and win over same-named config
+// rules; a missing file is fine, and a malformed one is logged and
+// skipped so the engine still starts with the config rules.
+func NewEngineWithRulesFile(cfg *config.BypassConfig, path string) (*Engine, error) {
+	e, err := NewEngine(cfg)
+	if err != nil {
+		return nil, err
+	}
+	e.rulesPath = path
+
+	if err := e.LoadRules(path); err != nil && !os.IsNotExist(err) {
+		log := logger.WithComponent("bypass")
+		log.Warn().Err(err).Str("path", path).
+			Msg("failed to load dynamic rules, continuing with config rules")
+	}
+	return e, nil
+}
+
+// SaveRules writes every runtime-added rule to a JSON file. The write
+// goes through a temp file and rename so a crash never leaves a
+// half-written rules file behind.
+func (e *Engine) SaveRules(path string) error {
+	e.mu.RLock()
+	now := e.now()
+	file := rulesFile{Version: rulesFileVersion}
+	for _, r := range e.customRules {
+		if !r.runtime || r.expired(now) {
+			continue
+		}
+		file.Rules = append(file.Rules, persistedRule{
+			Name:        r.Name,
+			Type:        string(r.Type),
+			Pattern:     r.Pattern,
+			Action:      string(r.Action),
+			Priority:    r.Priority,
+			ExpiresAt:   r.ExpiresAt,
+			Description: r.Description,
+		})
+	}
+	e.mu.RUnlock()
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal rules: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create rules dir: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write rules file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace rules file: %w", err)
+	}
+	return nil
+}
+
+// LoadRules loads dynamic rules from a JSON file and installs them as
+// runtime rules. A rule whose name collides with an existing config rule
+// replaces it with a warning — the dynamic file reflects the operator's
+// latest intent. A missing or malformed file leaves the engine unchanged
+// and returns the error.
+func (e *Engine) LoadRules(path string) error {
+	log := logger.WithComponent("bypass")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var file rulesFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse rules file %s: %w", path, err)
+	}
+
+	// Compile everything before touching the engine, so a bad rule
+	// can't leave a half-applied load behind
+	rules := make([]Rule, 0, len(file.Rules))
+	for _, pr := range file.Rules {
+		rule := Rule{
+			Name:        pr.Name,
+			Type:        RuleType(pr.Type),
+			Pattern:     pr.Pattern,
+			Action:      Action(pr.Action),
+			Priority:    pr.Priority,
+			ExpiresAt:   pr.ExpiresAt,
+			Description: pr.Description,
+			runtime:     true,
+		}
+		if err := compileRule(&rule); err != nil {
+			return fmt.Errorf("invalid rule %q in %s: %w", pr.Name, path, err)
+		}
+		rules = append(rules, rule)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, rule := range rules {
+		for i, existing := range e.customRules {
+			if existing.Name == rule.Name {
+				log.Warn().Str("rule", rule.Name).
+					Msg("dynamic rule overrides same-named config rule")
+				e.customRules = append(e.customRules[:i], e.customRules[i+1:]...)
+				break
+			}
+		}
+		e.customRules = append(e.customRules, rule)
+	}
+	sortRules(e.customRules)
+	e.rebuildCIDRTrieLocked()
+
+	log.Info().Int("count", len(rules)).Str("path", path).Msg("loaded dynamic bypass rules")
+	return nil
+}
+
+// persistRules saves to the configured rules file, if any. Called after
+// runtime rule mutations; failures are logged rather than bubbled up so
+// a full disk doesn't break rule management.
+func (e *Engine) persistRules() {
+	if e.rulesPath == "" {
+		return
+	}
+	if err := e.SaveRules(e.rulesPath); err != nil {
+		log := logger.WithComponent("bypass")
+		log.Warn().Err(err).Str("path", e.rulesPath).
+			Msg("failed to persist dynamic rules")
+	}
+}
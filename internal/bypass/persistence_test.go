@@ -0,0 +1,185 @@
+package bypass
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jery0843/torforge/pkg/config"
+)
+
+func TestRulesRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+
+	eng, err := NewEngine(&config.BypassConfig{
+		Enabled: true,
+		CustomRules: []config.BypassRule{
+			{Name: "from-config", Type: "domain", Pattern: "*.corp.example", Action: "bypass"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	if err := eng.AddRule(Rule{
+		Name:        "lan",
+		Type:        RuleTypeCIDR,
+		Pattern:     "10.8.0.0/24",
+		Action:      ActionBypass,
+		Priority:    5,
+		Description: "VPN subnet",
+	}); err != nil {
+		t.Fatalf("AddRule() error = %v", err)
+	}
+	if err := eng.AddRule(Rule{
+		Name:    "torrents",
+		Type:    RuleTypePort,
+		Pattern: "6881-6999",
+		Action:  ActionBlock,
+	}); err != nil {
+		t.Fatalf("AddRule() error = %v", err)
+	}
+
+	if err := eng.SaveRules(path); err != nil {
+		t.Fatalf("SaveRules() error = %v", err)
+	}
+
+	fresh, err := NewEngine(&config.BypassConfig{Enabled: true})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	if err := fresh.LoadRules(path); err != nil {
+		t.Fatalf("LoadRules() error = %v", err)
+	}
+
+	rules := fresh.GetRules()
+	if len(rules) != 2 {
+		t.Fatalf("loaded %d rules, want 2 (config rules must not be persisted)", len(rules))
+	}
+	// Priority 5 sorts the CIDR rule after the port rule (priority 0)
+	if rules[0].Name != "torrents" || rules[0].Action != ActionBlock {
+		t.Errorf("rules[0] = %q action %q, want torrents/block", rules[0].Name, rules[0].Action)
+	}
+	if rules[1].Name != "lan" || rules[1].Pattern != "10.8.0.0/24" ||
+		rules[1].Priority != 5 || rules[1].Description != "VPN subnet" {
+		t.Errorf("lan rule did not round-trip: %+v", rules[1])
+	}
+
+	// Loaded rules must be compiled and matchable
+	if result := fresh.MatchPort(6890, ""); !result.Matched || result.Action != ActionBlock {
+		t.Errorf("MatchPort(6890) = %+v, want block from loaded rule", result)
+	}
+}
+
+func TestNewEngineWithRulesFilePersistsAcrossRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	cfg := &config.BypassConfig{Enabled: true}
+
+	eng, err := NewEngineWithRulesFile(cfg, path)
+	if err != nil {
+		t.Fatalf("NewEngineWithRulesFile() error = %v", err)
+	}
+	if err := eng.AddRule(Rule{
+		Name: "dyn", Type: RuleTypeDomain, Pattern: "printer.lan", Action: ActionBypass,
+	}); err != nil {
+		t.Fatalf("AddRule() error = %v", err)
+	}
+
+	// Simulate a restart: a fresh engine picks up the saved rule
+	restarted, err := NewEngineWithRulesFile(cfg, path)
+	if err != nil {
+		t.Fatalf("NewEngineWithRulesFile() after restart error = %v", err)
+	}
+	if result := restarted.MatchDomain("printer.lan"); !result.Matched {
+		t.Error("rule added before restart did not survive")
+	}
+
+	// Removing the rule persists too
+	if !restarted.RemoveRule("dyn") {
+		t.Fatal("RemoveRule() = false, want true")
+	}
+	again, err := NewEngineWithRulesFile(cfg, path)
+	if err != nil {
+		t.Fatalf("NewEngineWithRulesFile() error = %v", err)
+	}
+	if result := again.MatchDomain("printer.lan"); result.Matched {
+		t.Error("removed rule came back after restart")
+	}
+}
+
+func TestLoadRulesPrefersDynamicOverConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+
+	data := `{"version":1,"rules":[{"name":"corp","type":"domain","pattern":"*.new.example","action":"bypass"}]}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	eng, err := NewEngineWithRulesFile(&config.BypassConfig{
+		Enabled: true,
+		CustomRules: []config.BypassRule{
+			{Name: "corp", Type: "domain", Pattern: "*.old.example", Action: "bypass"},
+		},
+	}, path)
+	if err != nil {
+		t.Fatalf("NewEngineWithRulesFile() error = %v", err)
+	}
+
+	var corp []Rule
+	for _, r := range eng.GetRules() {
+		if r.Name == "corp" {
+			corp = append(corp, r)
+		}
+	}
+	if len(corp) != 1 {
+		t.Fatalf("found %d rules named corp, want 1 (dynamic replaces config)", len(corp))
+	}
+	if corp[0].Pattern != "*.new.example" {
+		t.Errorf("corp pattern = %q, want the dynamic file's *.new.example", corp[0].Pattern)
+	}
+}
+
+func TestLoadRulesMalformedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(path, []byte("{not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.BypassConfig{
+		Enabled: true,
+		CustomRules: []config.BypassRule{
+			{Name: "cfg", Type: "domain", Pattern: "keep.example", Action: "bypass"},
+		},
+	}
+
+	eng, err := NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	if err := eng.LoadRules(path); err == nil {
+		t.Error("LoadRules() on malformed file returned nil error")
+	}
+	if len(eng.GetRules()) != 1 {
+		t.Errorf("malformed load changed the rule set: %d rules", len(eng.GetRules()))
+	}
+
+	// The auto-loading constructor still starts with config rules
+	auto, err := NewEngineWithRulesFile(cfg, path)
+	if err != nil {
+		t.Fatalf("NewEngineWithRulesFile() error = %v, want engine despite bad file", err)
+	}
+	if result := auto.MatchDomain("keep.example"); !result.Matched {
+		t.Error("config rule missing after malformed dynamic file")
+	}
+}
+
+func TestLoadRulesMissingFile(t *testing.T) {
+	eng, err := NewEngine(&config.BypassConfig{Enabled: true})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	err = eng.LoadRules(filepath.Join(t.TempDir(), "nope.json"))
+	if !os.IsNotExist(err) {
+		t.Errorf("LoadRules() on missing file error = %v, want not-exist", err)
+	}
+}
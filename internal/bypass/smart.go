@@ -3,6 +3,7 @@ package bypass
 
 import (
 	"encoding/json"
+	"fmt"
 	"net"
 	"os"
 	"path/filepath"
@@ -35,7 +36,34 @@ type TrafficPattern struct {
 	ConnectionCount int         `json:"connection_count"`
 	BypassScore     float64     `json:"bypass_score"` // 0-1, higher = should bypass
 	LastSeen        time.Time   `json:"last_seen"`
-	Confidence      float64     `json:"confidence"` // 0-1
+	Confidence      float64     `json:"confidence"`   // 0-1
+	Manual          bool        `json:"manual"`       // Set by AddManualBypass, never by learning
+	AutoApplied     bool        `json:"auto_applied"` // Promoted automatically from a suggestion
+}
+
+// ScoringConfig tunes how connection stats turn into bypass scores.
+// The zero value is not valid; start from DefaultScoringConfig.
+type ScoringConfig struct {
+	MinConnections int                     `json:"min_connections"` // Sample size needed before confidence grows
+	MinAvgBytes    int64                   `json:"min_avg_bytes"`   // Below this per connection, confidence stays low (0 disables)
+	MaxTorLatency  time.Duration           `json:"max_tor_latency"` // Latency above this strongly favors bypass
+	TypeWeights    map[PatternType]float64 `json:"type_weights"`    // Per-type multiplier on the score (0-1)
+}
+
+// DefaultScoringConfig returns the thresholds the engine has always used
+func DefaultScoringConfig() ScoringConfig {
+	return ScoringConfig{
+		MinConnections: 10,
+		MaxTorLatency:  500 * time.Millisecond,
+		TypeWeights: map[PatternType]float64{
+			PatternStreaming: 1.0,
+			PatternGaming:    1.0,
+			PatternVoIP:      1.0,
+			PatternDownload:  1.0,
+			PatternBrowsing:  1.0,
+			PatternUnknown:   1.0,
+		},
+	}
 }
 
 // SmartBypass provides intelligent, pattern-based bypass decisions
@@ -45,6 +73,11 @@ type SmartBypass struct {
 	mu           sync.RWMutex
 	dataDir      string
 	patternsFile string
+	scoring      ScoringConfig
+	scoringFile  string
+	statsFile    string
+	maxStats     int
+	saverStop    chan struct{}
 
 	// Known patterns for quick matching
 	streamingDomains *regexp.Regexp
@@ -54,17 +87,58 @@ type SmartBypass struct {
 
 	// Signature-based detection
 	appSignatures map[string]AppSignature
+
+	// User-defined domain classifiers, checked before the built-ins
+	userSignatures []DomainSignature
+	signaturesFile string
+
+	// Auto-apply: opt-in promotion of high-confidence suggestions to
+	// real bypass patterns. Denied domains are never promoted.
+	autoApply    bool
+	autoMinScore float64
+	autoMinConf  float64
+	autoDeny     map[string]bool
+
+	// Injectable clock for tests
+	now func() time.Time
 }
 
 // ConnectionStats tracks connection statistics
 type ConnectionStats struct {
-	Domain       string
-	TotalBytes   int64
-	TotalPackets int64
-	Connections  int
-	FirstSeen    time.Time
-	LastSeen     time.Time
-	AvgLatency   time.Duration
+	Domain       string        `json:"domain"`
+	TotalBytes   int64         `json:"total_bytes"`
+	TotalPackets int64         `json:"total_packets"`
+	Connections  int           `json:"connections"`
+	FirstSeen    time.Time     `json:"first_seen"`
+	LastSeen     time.Time     `json:"last_seen"`
+	AvgLatency   time.Duration `json:"avg_latency"`
+
+	// Hourly buckets covering the last 24 hours, for windowed top-N
+	// queries
+	Buckets []statsBucket `json:"buckets,omitempty"`
+}
+
+// statsBucket accumulates one hour of traffic for a domain
+type statsBucket struct {
+	Start       time.Time     `json:"start"`
+	Bytes       int64         `json:"bytes"`
+	Connections int           `json:"connections"`
+	LatencySum  time.Duration `json:"latency_sum"`
+}
+
+// defaultMaxStats caps the per-domain stats map; the least recently
+// active domains are evicted past it
+const defaultMaxStats = 5000
+
+// DomainSignature classifies domains into a PatternType by regex, so
+// services the built-in lists do not know about (regional streaming,
+// niche VoIP) still score correctly
+type DomainSignature struct {
+	Name    string      `json:"name"`
+	Pattern string      `json:"pattern"` // Regex matched against the domain
+	Type    PatternType `json:"type"`
+
+	re *regexp.Regexp
 }
 
 // AppSignature represents application traffic signatures
@@ -77,14 +151,32 @@ type AppSignature struct {
 	ShouldBypass  bool
 }
 
-// NewSmartBypass creates a new smart bypass engine
+// NewSmartBypass creates a new smart bypass engine with default scoring
 func NewSmartBypass(dataDir string) (*SmartBypass, error) {
+	return NewSmartBypassWithScoring(dataDir, DefaultScoringConfig())
+}
+
+// NewSmartBypassWithScoring creates a smart bypass engine with custom
+// scoring thresholds. A scoring config previously saved in the data dir
+// takes precedence over the one passed here.
+func NewSmartBypassWithScoring(dataDir string, scoring ScoringConfig) (*SmartBypass, error) {
+	if err := validateScoringConfig(scoring); err != nil {
+		return nil, err
+	}
+
 	sb := &SmartBypass{
-		patterns:      make(map[string]*TrafficPattern),
-		stats:         make(map[string]*ConnectionStats),
-		dataDir:       dataDir,
-		patternsFile:  filepath.Join(dataDir, "patterns.json"),
-		appSignatures: make(map[string]AppSignature),
+		patterns:       make(map[string]*TrafficPattern),
+		stats:          make(map[string]*ConnectionStats),
+		dataDir:        dataDir,
+		patternsFile:   filepath.Join(dataDir, "patterns.json"),
+		scoring:        scoring,
+		scoringFile:    filepath.Join(dataDir, "scoring.json"),
+		statsFile:      filepath.Join(dataDir, "stats.json"),
+		maxStats:       defaultMaxStats,
+		appSignatures:  make(map[string]AppSignature),
+		signaturesFile: filepath.Join(dataDir, "signatures.json"),
+		autoDeny:       make(map[string]bool),
+		now:            time.Now,
 	}
 
 	// Compile known domain patterns
@@ -96,12 +188,157 @@ func NewSmartBypass(dataDir string) (*SmartBypass, error) {
 	// Initialize known app signatures
 	sb.initSignatures()
 
-	// Load saved patterns
+	// Load saved patterns, stats, and any persisted scoring overrides
 	sb.loadPatterns()
+	sb.loadStats()
+	sb.loadScoring()
+
+	// User signatures are config, not cache: a broken file is an error
+	// the user needs to hear about, not silently ignored
+	if err := sb.loadSignatures(); err != nil {
+		return nil, fmt.Errorf("loading %s: %w", sb.signaturesFile, err)
+	}
 
 	return sb, nil
 }
 
+// SetMaxStats changes the stats map cap. Excess entries are evicted on
+// the next recorded connection.
+func (sb *SmartBypass) SetMaxStats(n int) error {
+	if n <= 0 {
+		return fmt.Errorf("stats cap must be positive, got %d", n)
+	}
+	sb.mu.Lock()
+	sb.maxStats = n
+	sb.mu.Unlock()
+	return nil
+}
+
+// StartAutoSave periodically persists patterns and stats until Stop is
+// called. A second call replaces the previous schedule.
+func (sb *SmartBypass) StartAutoSave(interval time.Duration) {
+	sb.mu.Lock()
+	if sb.saverStop != nil {
+		close(sb.saverStop)
+	}
+	stop := make(chan struct{})
+	sb.saverStop = stop
+	sb.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		log := logger.WithComponent("smart-bypass")
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := sb.SavePatterns(); err != nil {
+					log.Warn().Err(err).Msg("periodic pattern save failed")
+				}
+				if err := sb.SaveStats(); err != nil {
+					log.Warn().Err(err).Msg("periodic stats save failed")
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop halts the auto-save loop and writes a final snapshot of patterns
+// and stats. Safe to call without StartAutoSave.
+func (sb *SmartBypass) Stop() error {
+	sb.mu.Lock()
+	if sb.saverStop != nil {
+		close(sb.saverStop)
+		sb.saverStop = nil
+	}
+	sb.mu.Unlock()
+
+	if err := sb.SavePatterns(); err != nil {
+		return err
+	}
+	return sb.SaveStats()
+}
+
+// validateScoringConfig rejects thresholds the scorer cannot work with
+func validateScoringConfig(cfg ScoringConfig) error {
+	if cfg.MinConnections < 0 {
+		return fmt.Errorf("min connections must not be negative, got %d", cfg.MinConnections)
+	}
+	if cfg.MinAvgBytes < 0 {
+		return fmt.Errorf("min average bytes must not be negative, got %d", cfg.MinAvgBytes)
+	}
+	if cfg.MaxTorLatency <= 0 {
+		return fmt.Errorf("max Tor latency must be positive, got %v", cfg.MaxTorLatency)
+	}
+	for ptype, weight := range cfg.TypeWeights {
+		if weight < 0 || weight > 1 {
+			return fmt.Errorf("weight for %s must be in [0, 1], got %v", ptype, weight)
+		}
+	}
+	return nil
+}
+
+// SetScoringConfig replaces the scoring thresholds at runtime,
+// recomputes scores for every pattern with recorded stats, and persists
+// the config in the data dir
+func (sb *SmartBypass) SetScoringConfig(cfg ScoringConfig) error {
+	if err := validateScoringConfig(cfg); err != nil {
+		return err
+	}
+
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	sb.scoring = cfg
+
+	// Re-score everything we have stats for; manual bypasses keep
+	// their pinned scores
+	for domain, stats := range sb.stats {
+		if pattern, ok := sb.patterns[domain]; ok && pattern.Manual {
+			continue
+		}
+		sb.updatePattern(stats)
+	}
+
+	return sb.saveScoringLocked()
+}
+
+// GetScoringConfig returns the active scoring thresholds
+func (sb *SmartBypass) GetScoringConfig() ScoringConfig {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+	return sb.scoring
+}
+
+// saveScoringLocked persists the scoring config; callers hold the lock
+func (sb *SmartBypass) saveScoringLocked() error {
+	data, err := json.MarshalIndent(sb.scoring, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(sb.scoringFile, data, 0600)
+}
+
+// loadScoring restores a persisted scoring config, if any
+func (sb *SmartBypass) loadScoring() {
+	data, err := os.ReadFile(sb.scoringFile)
+	if err != nil {
+		return // No saved config
+	}
+
+	cfg := sb.scoring
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return
+	}
+	if validateScoringConfig(cfg) != nil {
+		return
+	}
+	sb.scoring = cfg
+}
+
 // initSignatures initializes known application signatures
 func (sb *SmartBypass) initSignatures() {
 	sb.appSignatures = map[string]AppSignature{
@@ -136,47 +373,99 @@ func (sb *SmartBypass) initSignatures() {
 	}
 }
 
+// BypassVerdict explains a bypass decision: which check matched, the
+// scores behind it, and the stats that informed them. Used by
+// `torforge bypass explain <domain>`.
+type BypassVerdict struct {
+	Bypass      bool          `json:"bypass"`
+	Reason      string        `json:"reason"`       // "local", "signature", "pattern-type", "manual", "learned", or "default"
+	Detail      string        `json:"detail"`       // Matched app or pattern type, when applicable
+	PatternType PatternType   `json:"pattern_type"` // Detected type for the domain
+	BypassScore float64       `json:"bypass_score"` // Learned score, if a pattern exists
+	Confidence  float64       `json:"confidence"`
+	Manual      bool          `json:"manual"` // True when AddManualBypass set the pattern
+	TotalBytes  int64         `json:"total_bytes"`
+	Connections int           `json:"connections"`
+	AvgLatency  time.Duration `json:"avg_latency"`
+}
+
 // ShouldBypass returns whether traffic should bypass Tor
 func (sb *SmartBypass) ShouldBypass(domain string, destIP net.IP, port int, protocol string) bool {
+	return sb.ShouldBypassDetailed(domain, destIP, port, protocol).Bypass
+}
+
+// ShouldBypassDetailed runs the same checks as ShouldBypass but reports
+// which one decided and the scores and stats behind it
+func (sb *SmartBypass) ShouldBypassDetailed(domain string, destIP net.IP, port int, protocol string) BypassVerdict {
 	sb.mu.RLock()
 	defer sb.mu.RUnlock()
 
 	log := logger.WithComponent("smart-bypass")
 
+	verdict := BypassVerdict{
+		PatternType: sb.detectPatternType(domain),
+	}
+	if pattern, ok := sb.patterns[domain]; ok {
+		verdict.BypassScore = pattern.BypassScore
+		verdict.Confidence = pattern.Confidence
+		verdict.Manual = pattern.Manual
+	}
+	if stats, ok := sb.stats[domain]; ok {
+		verdict.TotalBytes = stats.TotalBytes
+		verdict.Connections = stats.Connections
+		verdict.AvgLatency = stats.AvgLatency
+	}
+
 	// Check 1: Local domains always bypass
 	if sb.localDomains.MatchString(domain) {
 		log.Debug().Str("domain", domain).Msg("bypassing local domain")
-		return true
+		verdict.Bypass = true
+		verdict.Reason = "local"
+		return verdict
 	}
 
 	// Check 2: Check app signatures
 	for name, sig := range sb.appSignatures {
 		if sb.matchesSignature(domain, port, protocol, sig) {
 			log.Debug().Str("app", name).Str("domain", domain).Bool("bypass", sig.ShouldBypass).Msg("matched signature")
-			return sig.ShouldBypass
+			verdict.Bypass = sig.ShouldBypass
+			verdict.Reason = "signature"
+			verdict.Detail = sig.Name
+			return verdict
 		}
 	}
 
 	// Check 3: Pattern-based detection
-	patternType := sb.detectPatternType(domain)
-	switch patternType {
+	switch verdict.PatternType {
 	case PatternGaming, PatternVoIP:
-		log.Debug().Str("domain", domain).Str("type", string(patternType)).Msg("bypassing latency-sensitive")
-		return true
+		log.Debug().Str("domain", domain).Str("type", string(verdict.PatternType)).Msg("bypassing latency-sensitive")
+		verdict.Bypass = true
+		verdict.Reason = "pattern-type"
+		verdict.Detail = string(verdict.PatternType)
+		return verdict
 	case PatternStreaming:
 		// Streaming can work through Tor, don't bypass by default
-		return false
+		verdict.Reason = "pattern-type"
+		verdict.Detail = string(PatternStreaming)
+		return verdict
 	}
 
 	// Check 4: Learned patterns
 	if pattern, ok := sb.patterns[domain]; ok {
 		if pattern.BypassScore > 0.7 && pattern.Confidence > 0.5 {
 			log.Debug().Str("domain", domain).Float64("score", pattern.BypassScore).Msg("learned bypass")
-			return true
+			verdict.Bypass = true
+			if pattern.Manual {
+				verdict.Reason = "manual"
+			} else {
+				verdict.Reason = "learned"
+			}
+			return verdict
 		}
 	}
 
-	return false
+	verdict.Reason = "default"
+	return verdict
 }
 
 // matchesSignature checks if traffic matches an app signature
@@ -196,8 +485,111 @@ func (sb *SmartBypass) matchesSignature(domain string, port int, protocol string
 	return false
 }
 
+// compileSignature validates a user signature and compiles its regex
+func compileSignature(sig *DomainSignature) error {
+	if sig.Name == "" {
+		return fmt.Errorf("signature needs a name")
+	}
+	if sig.Pattern == "" {
+		return fmt.Errorf("signature %q needs a pattern", sig.Name)
+	}
+	switch sig.Type {
+	case PatternStreaming, PatternGaming, PatternVoIP, PatternDownload, PatternBrowsing:
+	default:
+		return fmt.Errorf("signature %q has unknown pattern type %q", sig.Name, sig.Type)
+	}
+
+	re, err := regexp.Compile("(?i)" + sig.Pattern)
+	if err != nil {
+		return fmt.Errorf("signature %q pattern: %w", sig.Name, err)
+	}
+	sig.re = re
+	return nil
+}
+
+// AddSignature registers a user domain signature, replacing any with
+// the same name, and persists the set
+func (sb *SmartBypass) AddSignature(sig DomainSignature) error {
+	if err := compileSignature(&sig); err != nil {
+		return err
+	}
+
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	for i, existing := range sb.userSignatures {
+		if existing.Name == sig.Name {
+			sb.userSignatures[i] = sig
+			return sb.saveSignaturesLocked()
+		}
+	}
+	sb.userSignatures = append(sb.userSignatures, sig)
+	return sb.saveSignaturesLocked()
+}
+
+// RemoveSignature drops a user signature by name and persists the set
+func (sb *SmartBypass) RemoveSignature(name string) error {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	for i, sig := range sb.userSignatures {
+		if sig.Name == name {
+			sb.userSignatures = append(sb.userSignatures[:i], sb.userSignatures[i+1:]...)
+			return sb.saveSignaturesLocked()
+		}
+	}
+	return fmt.Errorf("signature %q not found", name)
+}
+
+// GetSignatures returns the user-defined domain signatures
+func (sb *SmartBypass) GetSignatures() []DomainSignature {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+
+	out := make([]DomainSignature, len(sb.userSignatures))
+	copy(out, sb.userSignatures)
+	return out
+}
+
+// saveSignaturesLocked persists user signatures; callers hold the lock
+func (sb *SmartBypass) saveSignaturesLocked() error {
+	data, err := json.MarshalIndent(sb.userSignatures, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(sb.signaturesFile, data, 0600)
+}
+
+// loadSignatures restores user signatures, validating each entry. A
+// missing file is fine; a malformed one is an error.
+func (sb *SmartBypass) loadSignatures() error {
+	data, err := os.ReadFile(sb.signaturesFile)
+	if err != nil {
+		return nil // No user signatures
+	}
+
+	var sigs []DomainSignature
+	if err := json.Unmarshal(data, &sigs); err != nil {
+		return err
+	}
+	for i := range sigs {
+		if err := compileSignature(&sigs[i]); err != nil {
+			return err
+		}
+	}
+	sb.userSignatures = sigs
+	return nil
+}
+
 // detectPatternType detects the type of traffic based on domain
 func (sb *SmartBypass) detectPatternType(domain string) PatternType {
+	// User signatures first, so they can override the built-in lists
+	for _, sig := range sb.userSignatures {
+		if sig.re.MatchString(domain) {
+			return sig.Type
+		}
+	}
+
 	if sb.streamingDomains.MatchString(domain) {
 		return PatternStreaming
 	}
@@ -215,10 +607,13 @@ func (sb *SmartBypass) RecordConnection(domain string, bytes int64, latency time
 	sb.mu.Lock()
 	defer sb.mu.Unlock()
 
-	now := time.Now()
+	now := sb.now()
 
 	stats, ok := sb.stats[domain]
 	if !ok {
+		if len(sb.stats) >= sb.maxStats {
+			sb.evictStatsLocked()
+		}
 		stats = &ConnectionStats{
 			Domain:    domain,
 			FirstSeen: now,
@@ -238,8 +633,155 @@ func (sb *SmartBypass) RecordConnection(domain string, bytes int64, latency time
 		stats.AvgLatency = (stats.AvgLatency + latency) / 2
 	}
 
+	// Accumulate into the current hourly bucket and drop buckets that
+	// fell out of the 24h window
+	hour := now.Truncate(time.Hour)
+	if n := len(stats.Buckets); n > 0 && stats.Buckets[n-1].Start.Equal(hour) {
+		stats.Buckets[n-1].Bytes += bytes
+		stats.Buckets[n-1].Connections++
+		stats.Buckets[n-1].LatencySum += latency
+	} else {
+		stats.Buckets = append(stats.Buckets, statsBucket{
+			Start:       hour,
+			Bytes:       bytes,
+			Connections: 1,
+			LatencySum:  latency,
+		})
+	}
+	cutoff := now.Add(-24 * time.Hour)
+	for len(stats.Buckets) > 0 && stats.Buckets[0].Start.Before(cutoff) {
+		stats.Buckets = stats.Buckets[1:]
+	}
+
 	// Update pattern
 	sb.updatePattern(stats)
+
+	// Promote the pattern if auto-apply is on and the thresholds are met
+	sb.maybeAutoApplyLocked(domain, stats)
+}
+
+// EnableAutoApply opts in to promoting suggestions whose bypass score
+// and confidence both exceed the given thresholds into real bypass
+// patterns. Each promotion is recorded in the audit log.
+func (sb *SmartBypass) EnableAutoApply(minScore, minConfidence float64) error {
+	if minScore < 0 || minScore > 1 {
+		return fmt.Errorf("min score must be in [0, 1], got %v", minScore)
+	}
+	if minConfidence < 0 || minConfidence > 1 {
+		return fmt.Errorf("min confidence must be in [0, 1], got %v", minConfidence)
+	}
+
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	sb.autoApply = true
+	sb.autoMinScore = minScore
+	sb.autoMinConf = minConfidence
+
+	// Sweep patterns that already qualify
+	for domain, stats := range sb.stats {
+		sb.maybeAutoApplyLocked(domain, stats)
+	}
+	return nil
+}
+
+// DisableAutoApply is the global off switch; already promoted domains
+// stay promoted until reverted
+func (sb *SmartBypass) DisableAutoApply() {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	sb.autoApply = false
+}
+
+// DenyAutoApply puts a domain on the deny list so it is never
+// auto-bypassed, and reverts it if a promotion already happened
+func (sb *SmartBypass) DenyAutoApply(domain string) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	sb.autoDeny[domain] = true
+	if pattern, ok := sb.patterns[domain]; ok && pattern.AutoApplied {
+		sb.revertAutoAppliedLocked(domain, pattern)
+	}
+}
+
+// AllowAutoApply removes a domain from the deny list
+func (sb *SmartBypass) AllowAutoApply(domain string) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	delete(sb.autoDeny, domain)
+}
+
+// RevertAutoApplied demotes an automatically promoted domain back to
+// its learned scores
+func (sb *SmartBypass) RevertAutoApplied(domain string) error {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	pattern, ok := sb.patterns[domain]
+	if !ok || !pattern.AutoApplied {
+		return fmt.Errorf("%s was not auto-applied", domain)
+	}
+	sb.revertAutoAppliedLocked(domain, pattern)
+	return nil
+}
+
+// GetAutoApplied lists domains currently promoted by auto-apply
+func (sb *SmartBypass) GetAutoApplied() []string {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+
+	var domains []string
+	for domain, pattern := range sb.patterns {
+		if pattern.AutoApplied {
+			domains = append(domains, domain)
+		}
+	}
+	sort.Strings(domains)
+	return domains
+}
+
+// maybeAutoApplyLocked promotes a qualifying pattern; callers hold the
+// write lock
+func (sb *SmartBypass) maybeAutoApplyLocked(domain string, stats *ConnectionStats) {
+	if !sb.autoApply || sb.autoDeny[domain] {
+		return
+	}
+	pattern, ok := sb.patterns[domain]
+	if !ok || pattern.Manual || pattern.AutoApplied {
+		return
+	}
+	if pattern.BypassScore < sb.autoMinScore || pattern.Confidence < sb.autoMinConf {
+		return
+	}
+
+	logger.Audit("bypass_auto_applied").
+		Str("domain", domain).
+		Float64("bypass_score", pattern.BypassScore).
+		Float64("confidence", pattern.Confidence).
+		Int("connections", stats.Connections).
+		Int64("total_bytes", stats.TotalBytes).
+		Dur("avg_latency", stats.AvgLatency).
+		Msg("")
+
+	pattern.AutoApplied = true
+	pattern.BypassScore = 1.0
+	pattern.Confidence = 1.0
+}
+
+// revertAutoAppliedLocked restores the learned scores for a promoted
+// domain; callers hold the write lock
+func (sb *SmartBypass) revertAutoAppliedLocked(domain string, pattern *TrafficPattern) {
+	pattern.AutoApplied = false
+	pattern.BypassScore = 0
+	pattern.Confidence = 0
+	if stats, ok := sb.stats[domain]; ok {
+		sb.updatePattern(stats)
+	}
+
+	logger.Audit("bypass_auto_reverted").
+		Str("domain", domain).
+		Msg("")
 }
 
 // updatePattern updates learned patterns based on stats
@@ -256,26 +798,46 @@ func (sb *SmartBypass) updatePattern(stats *ConnectionStats) {
 	pattern.LastSeen = stats.LastSeen
 	pattern.Type = sb.detectPatternType(stats.Domain)
 
-	// Calculate bypass score based on latency sensitivity
+	// Pinned scores (manual or auto-applied) are not overwritten by
+	// ongoing traffic
+	if pattern.Manual || pattern.AutoApplied {
+		return
+	}
+
+	cfg := sb.scoring
+	weight := 1.0
+	if w, ok := cfg.TypeWeights[pattern.Type]; ok {
+		weight = w
+	}
+
+	// Calculate bypass score based on latency sensitivity; the midpoint
+	// threshold tracks the configured maximum at the historical 200/500
+	// ratio
 	if stats.AvgLatency > 0 {
 		// High latency connections should bypass
-		if stats.AvgLatency > 500*time.Millisecond {
-			pattern.BypassScore = 0.8
-		} else if stats.AvgLatency > 200*time.Millisecond {
-			pattern.BypassScore = 0.5
+		if stats.AvgLatency > cfg.MaxTorLatency {
+			pattern.BypassScore = 0.8 * weight
+		} else if stats.AvgLatency > cfg.MaxTorLatency*2/5 {
+			pattern.BypassScore = 0.5 * weight
 		} else {
-			pattern.BypassScore = 0.2
+			pattern.BypassScore = 0.2 * weight
 		}
 	}
 
 	// Update confidence based on sample size
-	if stats.Connections > 100 {
+	if stats.Connections > cfg.MinConnections*10 {
 		pattern.Confidence = 0.9
-	} else if stats.Connections > 10 {
+	} else if stats.Connections > cfg.MinConnections {
 		pattern.Confidence = 0.6
 	} else {
 		pattern.Confidence = 0.3
 	}
+
+	// Too little data per connection to judge the traffic profile
+	if cfg.MinAvgBytes > 0 && stats.Connections > 0 &&
+		stats.TotalBytes/int64(stats.Connections) < cfg.MinAvgBytes {
+		pattern.Confidence = 0.3
+	}
 }
 
 // SavePatterns saves learned patterns to disk
@@ -291,6 +853,76 @@ func (sb *SmartBypass) SavePatterns() error {
 	return os.WriteFile(sb.patternsFile, data, 0600)
 }
 
+// evictStatsLocked drops the least recently active stats entries down
+// to 90% of the cap, so eviction runs in batches rather than per insert.
+// Callers hold the write lock.
+func (sb *SmartBypass) evictStatsLocked() {
+	target := sb.maxStats * 9 / 10
+	if target < 1 {
+		target = 1
+	}
+	if len(sb.stats) <= target {
+		return
+	}
+
+	entries := make([]*ConnectionStats, 0, len(sb.stats))
+	for _, s := range sb.stats {
+		entries = append(entries, s)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastSeen.Before(entries[j].LastSeen)
+	})
+
+	for _, s := range entries[:len(entries)-target] {
+		delete(sb.stats, s.Domain)
+	}
+}
+
+// PruneStats removes stats for domains with no activity in the given
+// window and returns how many were dropped
+func (sb *SmartBypass) PruneStats(olderThan time.Duration) int {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	cutoff := time.Now().Add(-olderThan)
+	pruned := 0
+	for domain, stats := range sb.stats {
+		if stats.LastSeen.Before(cutoff) {
+			delete(sb.stats, domain)
+			pruned++
+		}
+	}
+	return pruned
+}
+
+// SaveStats saves per-domain connection stats to disk
+func (sb *SmartBypass) SaveStats() error {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+
+	data, err := json.MarshalIndent(sb.stats, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(sb.statsFile, data, 0600)
+}
+
+// loadStats loads stats from disk; a missing or corrupt file just means
+// starting fresh
+func (sb *SmartBypass) loadStats() {
+	data, err := os.ReadFile(sb.statsFile)
+	if err != nil {
+		return // No saved stats
+	}
+
+	stats := make(map[string]*ConnectionStats)
+	if err := json.Unmarshal(data, &stats); err != nil {
+		return
+	}
+	sb.stats = stats
+}
+
 // loadPatterns loads patterns from disk
 func (sb *SmartBypass) loadPatterns() {
 	data, err := os.ReadFile(sb.patternsFile)
@@ -322,6 +954,95 @@ func (sb *SmartBypass) GetTopDomains(n int) []TrafficPattern {
 	return patterns
 }
 
+// Sort keys and time windows for GetTopDomainsBy
+const (
+	SortByConnections = "connections"
+	SortByBytes       = "bytes"
+	SortByLatency     = "latency"
+
+	WindowHour = "hour"
+	WindowDay  = "24h"
+	WindowAll  = "all"
+)
+
+// DomainUsage is one entry of a windowed top-domains query
+type DomainUsage struct {
+	Domain      string        `json:"domain"`
+	Connections int           `json:"connections"`
+	Bytes       int64         `json:"bytes"`
+	AvgLatency  time.Duration `json:"avg_latency"`
+}
+
+// GetTopDomainsBy returns the top n domains ordered by the given sort
+// key over the given time window. The hourly and daily views read the
+// bucketed counters; the all-time view reads the running totals.
+func (sb *SmartBypass) GetTopDomainsBy(n int, sortKey, window string) ([]DomainUsage, error) {
+	switch sortKey {
+	case SortByConnections, SortByBytes, SortByLatency:
+	default:
+		return nil, fmt.Errorf("unknown sort key %q", sortKey)
+	}
+
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+
+	var cutoff time.Time
+	switch window {
+	case WindowHour:
+		cutoff = sb.now().Add(-time.Hour)
+	case WindowDay:
+		cutoff = sb.now().Add(-24 * time.Hour)
+	case WindowAll:
+	default:
+		return nil, fmt.Errorf("unknown time window %q", window)
+	}
+
+	usage := make([]DomainUsage, 0, len(sb.stats))
+	for domain, stats := range sb.stats {
+		entry := DomainUsage{Domain: domain}
+		if window == WindowAll {
+			entry.Connections = stats.Connections
+			entry.Bytes = stats.TotalBytes
+			entry.AvgLatency = stats.AvgLatency
+		} else {
+			var latencySum time.Duration
+			for _, bucket := range stats.Buckets {
+				// A bucket covers an hour; count it once any part of
+				// it overlaps the window
+				if bucket.Start.Add(time.Hour).Before(cutoff) {
+					continue
+				}
+				entry.Connections += bucket.Connections
+				entry.Bytes += bucket.Bytes
+				latencySum += bucket.LatencySum
+			}
+			if entry.Connections > 0 {
+				entry.AvgLatency = latencySum / time.Duration(entry.Connections)
+			}
+		}
+		if entry.Connections == 0 {
+			continue // Nothing in the window
+		}
+		usage = append(usage, entry)
+	}
+
+	sort.Slice(usage, func(i, j int) bool {
+		switch sortKey {
+		case SortByBytes:
+			return usage[i].Bytes > usage[j].Bytes
+		case SortByLatency:
+			return usage[i].AvgLatency > usage[j].AvgLatency
+		default:
+			return usage[i].Connections > usage[j].Connections
+		}
+	})
+
+	if len(usage) > n {
+		usage = usage[:n]
+	}
+	return usage, nil
+}
+
 // GetBypassSuggestions returns domains that should probably bypass
 func (sb *SmartBypass) GetBypassSuggestions() []string {
 	sb.mu.RLock()
@@ -352,6 +1073,7 @@ func (sb *SmartBypass) AddManualBypass(domain string) {
 
 	pattern.BypassScore = 1.0
 	pattern.Confidence = 1.0
+	pattern.Manual = true
 }
 
 // ClearPatterns clears all learned patterns
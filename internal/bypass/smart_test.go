@@ -1,10 +1,17 @@
 package bypass
 
 import (
+	"bytes"
+	"fmt"
 	"net"
 	"os"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/jery0843/torforge/pkg/logger"
 )
 
 // TestNewSmartBypass tests creating a SmartBypass engine from user perspective
@@ -157,6 +164,561 @@ func TestSmartBypassManualBypass(t *testing.T) {
 	}
 }
 
+// TestSmartBypassShouldBypassDetailed tests that verdicts explain which
+// check decided and carry the recorded stats
+func TestSmartBypassShouldBypassDetailed(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	sb, err := NewSmartBypass(tmpDir)
+	if err != nil {
+		t.Fatalf("NewSmartBypass() error = %v", err)
+	}
+
+	// Local domains report "local"
+	verdict := sb.ShouldBypassDetailed("printer.lan", nil, 631, "tcp")
+	if !verdict.Bypass || verdict.Reason != "local" {
+		t.Errorf("local verdict = %+v, want bypass with reason local", verdict)
+	}
+
+	// Signature match reports the app name
+	verdict = sb.ShouldBypassDetailed("cdn.discord.com", nil, 50000, "udp")
+	if !verdict.Bypass || verdict.Reason != "signature" || verdict.Detail != "Discord" {
+		t.Errorf("signature verdict = %+v, want Discord signature bypass", verdict)
+	}
+
+	// Streaming resolves through Tor but the verdict still says why
+	verdict = sb.ShouldBypassDetailed("netflix.com", nil, 8443, "tcp")
+	if verdict.Bypass || verdict.Reason != "pattern-type" || verdict.Detail != string(PatternStreaming) {
+		t.Errorf("streaming verdict = %+v, want no-bypass streaming pattern-type", verdict)
+	}
+
+	// No match at all is the default
+	verdict = sb.ShouldBypassDetailed("random-site.example", nil, 8443, "tcp")
+	if verdict.Bypass || verdict.Reason != "default" {
+		t.Errorf("default verdict = %+v, want no-bypass default", verdict)
+	}
+}
+
+// TestSmartBypassManualVsLearnedReason tests that a manual bypass is
+// distinguishable from a learned one in the verdict
+func TestSmartBypassManualVsLearnedReason(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	sb, err := NewSmartBypass(tmpDir)
+	if err != nil {
+		t.Fatalf("NewSmartBypass() error = %v", err)
+	}
+
+	sb.AddManualBypass("nas.example")
+
+	sb.RecordConnection("slowlink.example", 4096, 600*time.Millisecond)
+	sb.mu.Lock()
+	sb.patterns["slowlink.example"].Confidence = 0.9 // Learned over many sessions
+	sb.mu.Unlock()
+
+	manual := sb.ShouldBypassDetailed("nas.example", nil, 445, "tcp")
+	if !manual.Bypass || manual.Reason != "manual" || !manual.Manual {
+		t.Errorf("manual verdict = %+v, want manual bypass", manual)
+	}
+
+	learned := sb.ShouldBypassDetailed("slowlink.example", nil, 8443, "tcp")
+	if !learned.Bypass || learned.Reason != "learned" || learned.Manual {
+		t.Errorf("learned verdict = %+v, want learned bypass", learned)
+	}
+	if learned.BypassScore != 0.8 || learned.TotalBytes != 4096 {
+		t.Errorf("learned verdict = %+v, want score 0.8 and the recorded stats", learned)
+	}
+}
+
+// TestSmartBypassTopDomainsIncludeScores tests that top-domain entries
+// carry their bypass scores and the manual flag
+func TestSmartBypassTopDomainsIncludeScores(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	sb, err := NewSmartBypass(tmpDir)
+	if err != nil {
+		t.Fatalf("NewSmartBypass() error = %v", err)
+	}
+
+	sb.AddManualBypass("pinned.example")
+
+	top := sb.GetTopDomains(5)
+	if len(top) != 1 {
+		t.Fatalf("GetTopDomains() returned %d entries, want 1", len(top))
+	}
+	if top[0].BypassScore != 1.0 || top[0].Confidence != 1.0 || !top[0].Manual {
+		t.Errorf("GetTopDomains()[0] = %+v, want full scores and manual flag", top[0])
+	}
+}
+
+// TestSmartBypassScoringLatencyThreshold tests that raising the latency
+// threshold flips a borderline domain out of the bypass suggestions
+func TestSmartBypassScoringLatencyThreshold(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	sb, err := NewSmartBypass(tmpDir)
+	if err != nil {
+		t.Fatalf("NewSmartBypass() error = %v", err)
+	}
+
+	// 600ms through Tor is above the 500ms default, so the domain is
+	// suggested for bypass
+	for i := 0; i < 15; i++ {
+		sb.RecordConnection("laggy.example", 4096, 600*time.Millisecond)
+	}
+	if !containsDomain(sb.GetBypassSuggestions(), "laggy.example") {
+		t.Fatal("laggy.example should be suggested under the default latency threshold")
+	}
+
+	// Declaring 2s of Tor latency acceptable recomputes the score and
+	// drops the suggestion
+	cfg := sb.GetScoringConfig()
+	cfg.MaxTorLatency = 2 * time.Second
+	if err := sb.SetScoringConfig(cfg); err != nil {
+		t.Fatalf("SetScoringConfig() error = %v", err)
+	}
+	if containsDomain(sb.GetBypassSuggestions(), "laggy.example") {
+		t.Error("laggy.example should not be suggested once 2s latency is acceptable")
+	}
+}
+
+// TestSmartBypassScoringPersistsAndValidates tests that a changed
+// scoring config survives a restart and that bad configs are rejected
+func TestSmartBypassScoringPersistsAndValidates(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	sb, err := NewSmartBypass(tmpDir)
+	if err != nil {
+		t.Fatalf("NewSmartBypass() error = %v", err)
+	}
+
+	cfg := sb.GetScoringConfig()
+	cfg.MaxTorLatency = time.Second
+	cfg.MinConnections = 25
+	if err := sb.SetScoringConfig(cfg); err != nil {
+		t.Fatalf("SetScoringConfig() error = %v", err)
+	}
+
+	reloaded, err := NewSmartBypass(tmpDir)
+	if err != nil {
+		t.Fatalf("NewSmartBypass() reload error = %v", err)
+	}
+	got := reloaded.GetScoringConfig()
+	if got.MaxTorLatency != time.Second || got.MinConnections != 25 {
+		t.Errorf("reloaded scoring = %+v, want the saved thresholds", got)
+	}
+
+	bad := []ScoringConfig{
+		{MinConnections: -1, MaxTorLatency: time.Second},
+		{MaxTorLatency: 0},
+		{MaxTorLatency: time.Second, TypeWeights: map[PatternType]float64{PatternGaming: 1.5}},
+	}
+	for i, cfg := range bad {
+		if err := sb.SetScoringConfig(cfg); err == nil {
+			t.Errorf("SetScoringConfig(bad #%d) expected error", i)
+		}
+	}
+}
+
+// TestSmartBypassScoringTypeWeights tests that a per-type weight scales
+// the bypass score
+func TestSmartBypassScoringTypeWeights(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	scoring := DefaultScoringConfig()
+	scoring.TypeWeights[PatternUnknown] = 0.5
+
+	sb, err := NewSmartBypassWithScoring(tmpDir, scoring)
+	if err != nil {
+		t.Fatalf("NewSmartBypassWithScoring() error = %v", err)
+	}
+
+	sb.RecordConnection("weighted.example", 4096, 600*time.Millisecond)
+
+	sb.mu.RLock()
+	score := sb.patterns["weighted.example"].BypassScore
+	sb.mu.RUnlock()
+	if score != 0.4 { // 0.8 halved by the unknown-type weight
+		t.Errorf("BypassScore = %v, want 0.4", score)
+	}
+}
+
+func containsDomain(domains []string, want string) bool {
+	for _, d := range domains {
+		if d == want {
+			return true
+		}
+	}
+	return false
+}
+
+// TestSmartBypassStatsCapAndPersistence tests the LRU stats cap and
+// that hot domains survive a restart from disk
+func TestSmartBypassStatsCapAndPersistence(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	sb, err := NewSmartBypass(tmpDir)
+	if err != nil {
+		t.Fatalf("NewSmartBypass() error = %v", err)
+	}
+	if err := sb.SetMaxStats(1000); err != nil {
+		t.Fatalf("SetMaxStats() error = %v", err)
+	}
+
+	for i := 0; i < 10000; i++ {
+		sb.RecordConnection(fmt.Sprintf("domain-%d.example", i), 512, 20*time.Millisecond)
+	}
+
+	// The domains we still care about were touched last
+	hot := []string{"nas.example", "git.example", "printer.example"}
+	for _, domain := range hot {
+		sb.RecordConnection(domain, 8192, 30*time.Millisecond)
+	}
+
+	sb.mu.RLock()
+	count := len(sb.stats)
+	sb.mu.RUnlock()
+	if count > 1000 {
+		t.Fatalf("stats map holds %d entries, want at most the 1000 cap", count)
+	}
+
+	// Stop writes the final snapshot; a fresh engine reads it back
+	if err := sb.Stop(); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+
+	reloaded, err := NewSmartBypass(tmpDir)
+	if err != nil {
+		t.Fatalf("NewSmartBypass() reload error = %v", err)
+	}
+	reloaded.mu.RLock()
+	defer reloaded.mu.RUnlock()
+	for _, domain := range hot {
+		stats, ok := reloaded.stats[domain]
+		if !ok {
+			t.Errorf("stats for %s lost across restart", domain)
+			continue
+		}
+		if stats.TotalBytes != 8192 {
+			t.Errorf("stats for %s = %+v, want the recorded 8192 bytes", domain, stats)
+		}
+	}
+}
+
+// TestSmartBypassPruneStats tests dropping stats for stale domains
+func TestSmartBypassPruneStats(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	sb, err := NewSmartBypass(tmpDir)
+	if err != nil {
+		t.Fatalf("NewSmartBypass() error = %v", err)
+	}
+
+	sb.RecordConnection("fresh.example", 512, 10*time.Millisecond)
+	sb.RecordConnection("stale.example", 512, 10*time.Millisecond)
+	sb.mu.Lock()
+	sb.stats["stale.example"].LastSeen = time.Now().Add(-48 * time.Hour)
+	sb.mu.Unlock()
+
+	if pruned := sb.PruneStats(24 * time.Hour); pruned != 1 {
+		t.Errorf("PruneStats() = %d, want 1", pruned)
+	}
+
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+	if _, ok := sb.stats["stale.example"]; ok {
+		t.Error("stale.example should have been pruned")
+	}
+	if _, ok := sb.stats["fresh.example"]; !ok {
+		t.Error("fresh.example should have survived pruning")
+	}
+}
+
+// TestSmartBypassLoadToleratesCorruptStats tests that a damaged stats
+// file does not prevent startup
+func TestSmartBypassLoadToleratesCorruptStats(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(tmpDir+"/stats.json", []byte("{not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	sb, err := NewSmartBypass(tmpDir)
+	if err != nil {
+		t.Fatalf("NewSmartBypass() error = %v", err)
+	}
+
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+	if len(sb.stats) != 0 {
+		t.Errorf("stats after corrupt load = %d entries, want a fresh map", len(sb.stats))
+	}
+}
+
+// TestSmartBypassUserSignatures tests that a custom signature classifies
+// a regional service and survives a restart from disk
+func TestSmartBypassUserSignatures(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	sb, err := NewSmartBypass(tmpDir)
+	if err != nil {
+		t.Fatalf("NewSmartBypass() error = %v", err)
+	}
+
+	// Unknown to the built-in lists
+	if got := sb.detectPatternType("hotstar.com"); got != PatternUnknown {
+		t.Fatalf("detectPatternType(hotstar.com) = %s before signature, want unknown", got)
+	}
+
+	if err := sb.AddSignature(DomainSignature{
+		Name:    "hotstar",
+		Pattern: `hotstar\.com$`,
+		Type:    PatternStreaming,
+	}); err != nil {
+		t.Fatalf("AddSignature() error = %v", err)
+	}
+	if got := sb.detectPatternType("hotstar.com"); got != PatternStreaming {
+		t.Errorf("detectPatternType(hotstar.com) = %s, want streaming", got)
+	}
+
+	// The signature persisted and loads on a fresh engine
+	reloaded, err := NewSmartBypass(tmpDir)
+	if err != nil {
+		t.Fatalf("NewSmartBypass() reload error = %v", err)
+	}
+	if got := reloaded.detectPatternType("tv.hotstar.com"); got != PatternStreaming {
+		t.Errorf("reloaded detectPatternType = %s, want streaming", got)
+	}
+}
+
+// TestSmartBypassUserSignatureOverridesBuiltin tests that user
+// signatures are consulted before the built-in lists
+func TestSmartBypassUserSignatureOverridesBuiltin(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	sb, err := NewSmartBypass(tmpDir)
+	if err != nil {
+		t.Fatalf("NewSmartBypass() error = %v", err)
+	}
+
+	// "xbox" is gaming per the built-ins; reclassify one specific host
+	if err := sb.AddSignature(DomainSignature{
+		Name:    "xbox-video",
+		Pattern: `^video\.xbox\.com$`,
+		Type:    PatternStreaming,
+	}); err != nil {
+		t.Fatalf("AddSignature() error = %v", err)
+	}
+
+	if got := sb.detectPatternType("video.xbox.com"); got != PatternStreaming {
+		t.Errorf("detectPatternType(video.xbox.com) = %s, want the streaming override", got)
+	}
+	if got := sb.detectPatternType("www.xbox.com"); got != PatternGaming {
+		t.Errorf("detectPatternType(www.xbox.com) = %s, want the built-in gaming", got)
+	}
+}
+
+// TestSmartBypassSignatureValidation tests rejection of broken
+// signatures at runtime and at load
+func TestSmartBypassSignatureValidation(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	sb, err := NewSmartBypass(tmpDir)
+	if err != nil {
+		t.Fatalf("NewSmartBypass() error = %v", err)
+	}
+
+	bad := []DomainSignature{
+		{Name: "", Pattern: "x", Type: PatternStreaming},
+		{Name: "no-pattern", Type: PatternStreaming},
+		{Name: "bad-type", Pattern: "x", Type: PatternType("wat")},
+		{Name: "bad-regex", Pattern: "(", Type: PatternVoIP},
+	}
+	for _, sig := range bad {
+		if err := sb.AddSignature(sig); err == nil {
+			t.Errorf("AddSignature(%+v) expected error", sig)
+		}
+	}
+
+	if err := sb.RemoveSignature("never-added"); err == nil {
+		t.Error("RemoveSignature() for a missing name expected error")
+	}
+
+	// A corrupt signatures file fails construction loudly
+	brokenDir := t.TempDir()
+	if err := os.WriteFile(brokenDir+"/signatures.json", []byte(`[{"name":"x","pattern":"(","type":"voip"}]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewSmartBypass(brokenDir); err == nil {
+		t.Error("NewSmartBypass() with a broken signatures file expected error")
+	}
+}
+
+// TestSmartBypassTopDomainsWindowed tests that the hour view drops old
+// traffic while the all-time view keeps it
+func TestSmartBypassTopDomainsWindowed(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	sb, err := NewSmartBypass(tmpDir)
+	if err != nil {
+		t.Fatalf("NewSmartBypass() error = %v", err)
+	}
+
+	clock := time.Date(2026, 8, 28, 9, 30, 0, 0, time.UTC)
+	sb.now = func() time.Time { return clock }
+
+	// Heavy traffic three hours ago...
+	for i := 0; i < 10; i++ {
+		sb.RecordConnection("old-heavy.example", 100000, 50*time.Millisecond)
+	}
+
+	// ...then lighter traffic just now
+	clock = clock.Add(3 * time.Hour)
+	sb.RecordConnection("fresh.example", 1000, 200*time.Millisecond)
+
+	hour, err := sb.GetTopDomainsBy(10, SortByBytes, WindowHour)
+	if err != nil {
+		t.Fatalf("GetTopDomainsBy(hour) error = %v", err)
+	}
+	if len(hour) != 1 || hour[0].Domain != "fresh.example" {
+		t.Errorf("hour window = %+v, want only fresh.example", hour)
+	}
+
+	all, err := sb.GetTopDomainsBy(10, SortByBytes, WindowAll)
+	if err != nil {
+		t.Fatalf("GetTopDomainsBy(all) error = %v", err)
+	}
+	if len(all) != 2 || all[0].Domain != "old-heavy.example" {
+		t.Errorf("all-time window = %+v, want old-heavy.example first by bytes", all)
+	}
+	if all[0].Bytes != 1000000 || all[0].Connections != 10 {
+		t.Errorf("old-heavy usage = %+v, want 1000000 bytes over 10 connections", all[0])
+	}
+}
+
+// TestSmartBypassTopDomainsSortKeys tests ordering by bytes and latency
+func TestSmartBypassTopDomainsSortKeys(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	sb, err := NewSmartBypass(tmpDir)
+	if err != nil {
+		t.Fatalf("NewSmartBypass() error = %v", err)
+	}
+
+	sb.RecordConnection("big.example", 1000000, 10*time.Millisecond)
+	sb.RecordConnection("slow.example", 100, 900*time.Millisecond)
+
+	byBytes, err := sb.GetTopDomainsBy(1, SortByBytes, WindowDay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(byBytes) != 1 || byBytes[0].Domain != "big.example" {
+		t.Errorf("by bytes = %+v, want big.example", byBytes)
+	}
+
+	byLatency, err := sb.GetTopDomainsBy(1, SortByLatency, WindowDay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(byLatency) != 1 || byLatency[0].Domain != "slow.example" {
+		t.Errorf("by latency = %+v, want slow.example", byLatency)
+	}
+
+	if _, err := sb.GetTopDomainsBy(1, "popularity", WindowDay); err == nil {
+		t.Error("GetTopDomainsBy() with unknown sort key expected error")
+	}
+	if _, err := sb.GetTopDomainsBy(1, SortByBytes, "fortnight"); err == nil {
+		t.Error("GetTopDomainsBy() with unknown window expected error")
+	}
+}
+
+// TestSmartBypassAutoApply tests promotion of high-confidence
+// suggestions, the audit record, revert, and the deny list
+func TestSmartBypassAutoApply(t *testing.T) {
+	var audit bytes.Buffer
+	oldAudit := logger.AuditLog
+	logger.AuditLog = zerolog.New(&audit)
+	defer func() { logger.AuditLog = oldAudit }()
+
+	tmpDir := t.TempDir()
+	sb, err := NewSmartBypass(tmpDir)
+	if err != nil {
+		t.Fatalf("NewSmartBypass() error = %v", err)
+	}
+
+	if err := sb.EnableAutoApply(0.7, 0.5); err != nil {
+		t.Fatalf("EnableAutoApply() error = %v", err)
+	}
+
+	// Enough slow connections to earn score 0.8 at confidence 0.6
+	for i := 0; i < 15; i++ {
+		sb.RecordConnection("laggy.example", 4096, 600*time.Millisecond)
+	}
+
+	sb.mu.RLock()
+	pattern := sb.patterns["laggy.example"]
+	sb.mu.RUnlock()
+	if pattern == nil || !pattern.AutoApplied || pattern.BypassScore != 1.0 {
+		t.Fatalf("pattern = %+v, want auto-applied with pinned score", pattern)
+	}
+	if !sb.ShouldBypass("laggy.example", nil, 8443, "tcp") {
+		t.Error("promoted domain should bypass")
+	}
+	if !strings.Contains(audit.String(), `"audit_event":"bypass_auto_applied"`) ||
+		!strings.Contains(audit.String(), `"domain":"laggy.example"`) {
+		t.Errorf("audit log = %s, want a bypass_auto_applied record with the domain", audit.String())
+	}
+
+	// Revert drops back to the learned score
+	if err := sb.RevertAutoApplied("laggy.example"); err != nil {
+		t.Fatalf("RevertAutoApplied() error = %v", err)
+	}
+	sb.mu.RLock()
+	reverted := *sb.patterns["laggy.example"]
+	sb.mu.RUnlock()
+	if reverted.AutoApplied || reverted.BypassScore != 0.8 {
+		t.Errorf("reverted pattern = %+v, want learned score 0.8", reverted)
+	}
+
+	if err := sb.RevertAutoApplied("never-promoted.example"); err == nil {
+		t.Error("RevertAutoApplied() for an unpromoted domain expected error")
+	}
+}
+
+// TestSmartBypassAutoApplyDenyList tests that denied domains are never
+// promoted, even with excellent stats
+func TestSmartBypassAutoApplyDenyList(t *testing.T) {
+	tmpDir := t.TempDir()
+	sb, err := NewSmartBypass(tmpDir)
+	if err != nil {
+		t.Fatalf("NewSmartBypass() error = %v", err)
+	}
+
+	if err := sb.EnableAutoApply(0.7, 0.5); err != nil {
+		t.Fatal(err)
+	}
+	sb.DenyAutoApply("bank.example")
+
+	for i := 0; i < 200; i++ {
+		sb.RecordConnection("bank.example", 4096, 900*time.Millisecond)
+	}
+
+	sb.mu.RLock()
+	pattern := sb.patterns["bank.example"]
+	sb.mu.RUnlock()
+	if pattern.AutoApplied {
+		t.Fatal("denied domain was auto-applied")
+	}
+
+	// The off switch stops further promotions entirely
+	sb.DisableAutoApply()
+	for i := 0; i < 200; i++ {
+		sb.RecordConnection("other.example", 4096, 900*time.Millisecond)
+	}
+	if got := sb.GetAutoApplied(); len(got) != 0 {
+		t.Errorf("GetAutoApplied() = %v, want none after the off switch", got)
+	}
+}
+
 // TestSmartBypassTopDomains tests getting top domains from user perspective
 func TestSmartBypassTopDomains(t *testing.T) {
 	tmpDir := t.TempDir()
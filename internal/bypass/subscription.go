@@ -0,0 +1,270 @@
+// Package bypass - remote rule list subscriptions
+package bypass
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jery0843/torforge/pkg/logger"
+)
+
+// maxSubscriptionBody caps how much of a remote rule list we read, so a
+// misconfigured URL can't balloon memory
+const maxSubscriptionBody = 10 << 20 // 10MB
+
+// RuleSubscription describes a remote rule list the engine keeps in
+// sync: one pattern per line, '#' comments and blank lines ignored
+type RuleSubscription struct {
+	Name     string
+	URL      string
+	Format   RuleType      // RuleTypeDomain or RuleTypeCIDR
+	Action   Action        // ActionBypass or ActionBlock
+	Interval time.Duration // Refresh interval; 0 disables periodic refresh
+	SHA256   string        // Optional hex pin of the exact list body
+}
+
+// SubscriptionStatus is a point-in-time view of one subscription
+type SubscriptionStatus struct {
+	Name      string    `json:"name"`
+	URL       string    `json:"url"`
+	LastFetch time.Time `json:"last_fetch"`
+	RuleCount int       `json:"rule_count"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// subscription is the runtime state behind one RuleSubscription
+type subscription struct {
+	spec   RuleSubscription
+	client *http.Client
+	stop   chan struct{}
+
+	mu        sync.Mutex
+	lastFetch time.Time
+	ruleCount int
+	lastErr   error
+}
+
+// Subscribe registers a remote rule list and fetches it immediately.
+// The provided client is used for every fetch, so callers who care
+// about leaking the request can route it through the Tor SOCKS proxy.
+// A failed first fetch is recorded in the status rather than returned —
+// the periodic refresh will keep retrying. Only invalid specs error.
+func (e *Engine) Subscribe(spec RuleSubscription, client *http.Client) error {
+	if spec.Name == "" {
+		return fmt.Errorf("subscription name is required")
+	}
+	if spec.URL == "" {
+		return fmt.Errorf("subscription URL is required")
+	}
+	if spec.Format != RuleTypeDomain && spec.Format != RuleTypeCIDR {
+		return fmt.Errorf("unsupported subscription format %q (want domain or cidr)", spec.Format)
+	}
+	if spec.Action != ActionBypass && spec.Action != ActionBlock {
+		return fmt.Errorf("unsupported subscription action %q (want bypass or block)", spec.Action)
+	}
+	if client == nil {
+		return fmt.Errorf("subscription requires an http.Client")
+	}
+
+	sub := &subscription{
+		spec:   spec,
+		client: client,
+		stop:   make(chan struct{}),
+	}
+
+	e.mu.Lock()
+	if e.subs == nil {
+		e.subs = make(map[string]*subscription)
+	}
+	if _, exists := e.subs[spec.Name]; exists {
+		e.mu.Unlock()
+		return fmt.Errorf("subscription %q already exists", spec.Name)
+	}
+	e.subs[spec.Name] = sub
+	e.mu.Unlock()
+
+	e.refreshSubscription(sub)
+
+	if spec.Interval > 0 {
+		go e.subscriptionLoop(sub)
+	}
+	return nil
+}
+
+// Unsubscribe stops a subscription's refresh and removes its rules.
+// Returns false when no subscription with that name exists.
+func (e *Engine) Unsubscribe(name string) bool {
+	e.mu.Lock()
+	sub, ok := e.subs[name]
+	if ok {
+		delete(e.subs, name)
+	}
+	e.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	close(sub.stop)
+	e.swapSubscriptionRules(name, nil)
+	return true
+}
+
+// RefreshSubscription forces an immediate fetch outside the regular
+// interval. Returns the fetch error, if any.
+func (e *Engine) RefreshSubscription(name string) error {
+	e.mu.RLock()
+	sub, ok := e.subs[name]
+	e.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("no subscription named %q", name)
+	}
+
+	e.refreshSubscription(sub)
+
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return sub.lastErr
+}
+
+// GetSubscriptionStatus returns the current state of every subscription
+func (e *Engine) GetSubscriptionStatus() []SubscriptionStatus {
+	e.mu.RLock()
+	subs := make([]*subscription, 0, len(e.subs))
+	for _, sub := range e.subs {
+		subs = append(subs, sub)
+	}
+	e.mu.RUnlock()
+
+	statuses := make([]SubscriptionStatus, 0, len(subs))
+	for _, sub := range subs {
+		sub.mu.Lock()
+		status := SubscriptionStatus{
+			Name:      sub.spec.Name,
+			URL:       sub.spec.URL,
+			LastFetch: sub.lastFetch,
+			RuleCount: sub.ruleCount,
+		}
+		if sub.lastErr != nil {
+			status.LastError = sub.lastErr.Error()
+		}
+		sub.mu.Unlock()
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// subscriptionLoop refreshes the list on the configured interval until
+// Unsubscribe or Close stops it
+func (e *Engine) subscriptionLoop(sub *subscription) {
+	ticker := time.NewTicker(sub.spec.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			e.refreshSubscription(sub)
+		case <-sub.stop:
+			return
+		}
+	}
+}
+
+// refreshSubscription fetches and installs one list. On any failure the
+// previous rule set stays in place and the error lands in the status.
+func (e *Engine) refreshSubscription(sub *subscription) {
+	log := logger.WithComponent("bypass")
+
+	rules, err := e.fetchSubscription(sub)
+
+	sub.mu.Lock()
+	sub.lastFetch = e.now()
+	sub.lastErr = err
+	if err == nil {
+		sub.ruleCount = len(rules)
+	}
+	sub.mu.Unlock()
+
+	if err != nil {
+		log.Warn().Err(err).Str("subscription", sub.spec.Name).
+			Msg("rule subscription fetch failed, keeping last good rule set")
+		return
+	}
+
+	e.swapSubscriptionRules(sub.spec.Name, rules)
+	log.Info().Str("subscription", sub.spec.Name).Int("rules", len(rules)).
+		Msg("rule subscription refreshed")
+}
+
+// fetchSubscription downloads and compiles the list without touching
+// the engine, so a bad fetch can't disturb the installed rules
+func (e *Engine) fetchSubscription(sub *subscription) ([]Rule, error) {
+	resp, err := sub.client.Get(sub.spec.URL)
+	if err != nil {
+		return nil, fmt.Errorf("fetch failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch returned status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSubscriptionBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read list body: %w", err)
+	}
+
+	if sub.spec.SHA256 != "" {
+		sum := sha256.Sum256(body)
+		if !strings.EqualFold(hex.EncodeToString(sum[:]), sub.spec.SHA256) {
+			return nil, fmt.Errorf("list body does not match pinned SHA256")
+		}
+	}
+
+	var rules []Rule
+	scanner := bufio.NewScanner(strings.NewReader(string(body)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		if line == "" {
+			continue
+		}
+		rule := Rule{
+			Name:         fmt.Sprintf("%s:%s", sub.spec.Name, line),
+			Type:         sub.spec.Format,
+			Pattern:      line,
+			Action:       sub.spec.Action,
+			subscription: sub.spec.Name,
+		}
+		if err := compileRule(&rule); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", line, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// swapSubscriptionRules atomically replaces every rule belonging to one
+// subscription; concurrent Match calls see the old or new set, never a
+// mix
+func (e *Engine) swapSubscriptionRules(name string, rules []Rule) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	kept := make([]Rule, 0, len(e.customRules)+len(rules))
+	for _, r := range e.customRules {
+		if r.subscription != name {
+			kept = append(kept, r)
+		}
+	}
+	e.customRules = append(kept, rules...)
+	sortRules(e.customRules)
+	e.rebuildCIDRTrieLocked()
+}
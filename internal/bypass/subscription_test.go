@@ -0,0 +1,254 @@
+package bypass
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jery0843/torforge/pkg/config"
+)
+
+func newSubscriptionEngine(t *testing.T) *Engine {
+	t.Helper()
+	eng, err := NewEngine(&config.BypassConfig{Enabled: true})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	t.Cleanup(eng.Close)
+	return eng
+}
+
+func TestSubscribeDomainList(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ads.example\n*.tracker.example # telemetry\n\n# comment only\n"))
+	}))
+	defer srv.Close()
+
+	eng := newSubscriptionEngine(t)
+	err := eng.Subscribe(RuleSubscription{
+		Name:   "blocklist",
+		URL:    srv.URL,
+		Format: RuleTypeDomain,
+		Action: ActionBlock,
+	}, srv.Client())
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+
+	if result := eng.MatchDomain("ads.example"); !result.Matched || result.Action != ActionBlock {
+		t.Errorf("MatchDomain(ads.example) = %+v, want block", result)
+	}
+	if result := eng.MatchDomain("cdn.tracker.example"); !result.Matched || result.Action != ActionBlock {
+		t.Errorf("MatchDomain(cdn.tracker.example) = %+v, want block", result)
+	}
+
+	statuses := eng.GetSubscriptionStatus()
+	if len(statuses) != 1 {
+		t.Fatalf("expected 1 subscription status, got %d", len(statuses))
+	}
+	if statuses[0].RuleCount != 2 {
+		t.Errorf("RuleCount = %d, want 2", statuses[0].RuleCount)
+	}
+	if statuses[0].LastError != "" {
+		t.Errorf("unexpected LastError %q", statuses[0].LastError)
+	}
+	if statuses[0].LastFetch.IsZero() {
+		t.Error("LastFetch not recorded")
+	}
+}
+
+func TestSubscriptionSwapOnUpdate(t *testing.T) {
+	var body atomic.Value
+	body.Store("old.example\n")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body.Load().(string)))
+	}))
+	defer srv.Close()
+
+	eng := newSubscriptionEngine(t)
+	if err := eng.Subscribe(RuleSubscription{
+		Name:   "bypasslist",
+		URL:    srv.URL,
+		Format: RuleTypeDomain,
+		Action: ActionBypass,
+	}, srv.Client()); err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+
+	if result := eng.MatchDomain("old.example"); !result.Matched {
+		t.Fatal("old.example not matched after initial fetch")
+	}
+
+	body.Store("new.example\n")
+	if err := eng.RefreshSubscription("bypasslist"); err != nil {
+		t.Fatalf("RefreshSubscription() error = %v", err)
+	}
+
+	if result := eng.MatchDomain("old.example"); result.Matched {
+		t.Error("old.example still matched after list swap")
+	}
+	if result := eng.MatchDomain("new.example"); !result.Matched {
+		t.Error("new.example not matched after list swap")
+	}
+}
+
+func TestSubscriptionKeepsLastGoodOnFailure(t *testing.T) {
+	var failing atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("good.example\n"))
+	}))
+	defer srv.Close()
+
+	eng := newSubscriptionEngine(t)
+	if err := eng.Subscribe(RuleSubscription{
+		Name:   "flaky",
+		URL:    srv.URL,
+		Format: RuleTypeDomain,
+		Action: ActionBypass,
+	}, srv.Client()); err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+
+	failing.Store(true)
+	if err := eng.RefreshSubscription("flaky"); err == nil {
+		t.Error("expected error from failing refresh")
+	}
+
+	// Last good rule set survives the failure
+	if result := eng.MatchDomain("good.example"); !result.Matched {
+		t.Error("last good rules dropped after failed refresh")
+	}
+	statuses := eng.GetSubscriptionStatus()
+	if statuses[0].LastError == "" {
+		t.Error("LastError not recorded after failure")
+	}
+
+	// And the error clears on recovery
+	failing.Store(false)
+	if err := eng.RefreshSubscription("flaky"); err != nil {
+		t.Errorf("RefreshSubscription() after recovery error = %v", err)
+	}
+	if statuses := eng.GetSubscriptionStatus(); statuses[0].LastError != "" {
+		t.Errorf("LastError %q not cleared after recovery", statuses[0].LastError)
+	}
+}
+
+func TestSubscriptionSHA256Pin(t *testing.T) {
+	content := []byte("pinned.example\n")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer srv.Close()
+
+	sum := sha256.Sum256(content)
+
+	eng := newSubscriptionEngine(t)
+	if err := eng.Subscribe(RuleSubscription{
+		Name:   "pinned",
+		URL:    srv.URL,
+		Format: RuleTypeDomain,
+		Action: ActionBypass,
+		SHA256: hex.EncodeToString(sum[:]),
+	}, srv.Client()); err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	if result := eng.MatchDomain("pinned.example"); !result.Matched {
+		t.Error("pinned list not installed despite matching hash")
+	}
+
+	// A wrong pin must reject the list
+	eng2 := newSubscriptionEngine(t)
+	if err := eng2.Subscribe(RuleSubscription{
+		Name:   "badpin",
+		URL:    srv.URL,
+		Format: RuleTypeDomain,
+		Action: ActionBypass,
+		SHA256: "deadbeef",
+	}, srv.Client()); err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	if result := eng2.MatchDomain("pinned.example"); result.Matched {
+		t.Error("list installed despite hash mismatch")
+	}
+	if statuses := eng2.GetSubscriptionStatus(); statuses[0].LastError == "" {
+		t.Error("hash mismatch not recorded in status")
+	}
+}
+
+func TestSubscriptionCIDRList(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("198.51.100.0/24\n203.0.113.0/24\n"))
+	}))
+	defer srv.Close()
+
+	eng := newSubscriptionEngine(t)
+	if err := eng.Subscribe(RuleSubscription{
+		Name:   "corp-nets",
+		URL:    srv.URL,
+		Format: RuleTypeCIDR,
+		Action: ActionBypass,
+	}, srv.Client()); err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+
+	if result := eng.MatchIP(net.ParseIP("198.51.100.7")); !result.Matched || result.Action != ActionBypass {
+		t.Errorf("MatchIP(198.51.100.7) = %+v, want bypass", result)
+	}
+}
+
+func TestSubscriptionValidation(t *testing.T) {
+	eng := newSubscriptionEngine(t)
+	cases := []RuleSubscription{
+		{URL: "http://example.invalid", Format: RuleTypeDomain, Action: ActionBypass},          // No name
+		{Name: "x", Format: RuleTypeDomain, Action: ActionBypass},                              // No URL
+		{Name: "x", URL: "http://example.invalid", Format: RuleTypePort, Action: ActionBypass}, // Bad format
+		{Name: "x", URL: "http://example.invalid", Format: RuleTypeDomain, Action: ActionTor},  // Bad action
+	}
+	for _, spec := range cases {
+		if err := eng.Subscribe(spec, http.DefaultClient); err == nil {
+			t.Errorf("Subscribe(%+v) expected error", spec)
+		}
+	}
+	if err := eng.Subscribe(RuleSubscription{
+		Name: "x", URL: "http://example.invalid", Format: RuleTypeDomain, Action: ActionBypass,
+	}, nil); err == nil {
+		t.Error("Subscribe with nil client expected error")
+	}
+}
+
+func TestUnsubscribeRemovesRules(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("gone.example\n"))
+	}))
+	defer srv.Close()
+
+	eng := newSubscriptionEngine(t)
+	if err := eng.Subscribe(RuleSubscription{
+		Name:     "temp",
+		URL:      srv.URL,
+		Format:   RuleTypeDomain,
+		Action:   ActionBypass,
+		Interval: time.Hour,
+	}, srv.Client()); err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+
+	if !eng.Unsubscribe("temp") {
+		t.Fatal("Unsubscribe returned false for existing subscription")
+	}
+	if result := eng.MatchDomain("gone.example"); result.Matched {
+		t.Error("subscription rules survived Unsubscribe")
+	}
+	if eng.Unsubscribe("temp") {
+		t.Error("Unsubscribe returned true for removed subscription")
+	}
+}
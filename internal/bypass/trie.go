@@ -0,0 +1,98 @@
+// Package bypass - binary radix trie for CIDR lookups
+package bypass
+
+import "net"
+
+// trieEntry is one inserted prefix: either a built-in bypass CIDR or a
+// custom CIDR rule carrying its Rule reference
+type trieEntry struct {
+	ipNet *net.IPNet
+	rule  *Rule // nil for built-in bypass list entries
+	stats *ruleStats
+}
+
+// trieNode is one bit of the prefix trie. Entries live on the node where
+// their prefix ends; duplicates (e.g. the same CIDR as both a bypass
+// list entry and a custom rule) share the node.
+type trieNode struct {
+	children [2]*trieNode
+	entries  []*trieEntry
+}
+
+// cidrTrie indexes CIDR prefixes for O(address bits) lookups instead of
+// a scan over every range. IPv4 and IPv6 prefixes keep separate roots so
+// 10.0.0.0/8 never shadows part of the v6 space.
+type cidrTrie struct {
+	v4 trieNode
+	v6 trieNode
+}
+
+func newCIDRTrie() *cidrTrie {
+	return &cidrTrie{}
+}
+
+// insert adds a prefix to the trie
+func (t *cidrTrie) insert(ipNet *net.IPNet, entry *trieEntry) {
+	ip := ipNet.IP.To4()
+	root := &t.v4
+	if ip == nil {
+		ip = ipNet.IP.To16()
+		root = &t.v6
+	}
+	if ip == nil {
+		return
+	}
+
+	ones, bits := ipNet.Mask.Size()
+	// IPv4-mapped IPv6 CIDRs (e.g. ::ffff:10.0.0.0/104) reduce to a
+	// 4-byte key but keep a 128-bit mask; drop the ::ffff:0:0/96 prefix
+	// the v4 root already implies so the bit loop stays in bounds
+	if len(ip) == net.IPv4len && bits == 8*net.IPv6len {
+		ones -= 96
+		if ones < 0 {
+			ones = 0
+		}
+	}
+	if max := len(ip) * 8; ones > max {
+		ones = max
+	}
+
+	node := root
+	for i := 0; i < ones; i++ {
+		bit := (ip[i/8] >> (7 - i%8)) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = &trieNode{}
+		}
+		node = node.children[bit]
+	}
+	node.entries = append(node.entries, entry)
+}
+
+// lookup walks the address once and returns every prefix containing it,
+// shortest first. Returning all of them (rather than just the longest)
+// lets the caller apply the block > custom > built-in precedence exactly
+// as the linear scan did.
+func (t *cidrTrie) lookup(ip net.IP) []*trieEntry {
+	addr := ip.To4()
+	root := &t.v4
+	if addr == nil {
+		addr = ip.To16()
+		root = &t.v6
+	}
+	if addr == nil {
+		return nil
+	}
+
+	var matches []*trieEntry
+	node := root
+	matches = append(matches, node.entries...)
+	for i := 0; i < len(addr)*8; i++ {
+		bit := (addr[i/8] >> (7 - i%8)) & 1
+		node = node.children[bit]
+		if node == nil {
+			break
+		}
+		matches = append(matches, node.entries...)
+	}
+	return matches
+}
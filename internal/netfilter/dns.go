@@ -7,8 +7,10 @@ import (
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/jery0843/torforge/internal/bypass"
 	"github.com/jery0843/torforge/pkg/config"
 	"github.com/jery0843/torforge/pkg/logger"
 	"github.com/miekg/dns"
@@ -16,37 +18,135 @@ import (
 
 // DNSResolver is a DNS server that routes queries through Tor
 type DNSResolver struct {
-	cfg         *config.TorConfig
-	bypassCfg   *config.BypassConfig
-	server      *dns.Server
-	torUpstream string
-	fallback    string
-	cache       *dnsCache
-	running     bool
-	mu          sync.RWMutex
+	cfg            *config.TorConfig
+	bypassCfg      *config.BypassConfig
+	engine         *bypass.Engine
+	server         *dns.Server
+	tcpServer      *dns.Server
+	torUpstream    string
+	localUpstreams []string
+	fallback       string
+	cache          *dnsCache
+	stats          dnsStats
+	stopSweep      chan struct{}
+	running        bool
+	mu             sync.RWMutex
+}
+
+// dnsStats holds the resolver's counters. Updated with atomics so the
+// query hot path never contends on the resolver lock.
+type dnsStats struct {
+	queries      atomic.Int64
+	cacheHits    atomic.Int64
+	bypassed     atomic.Int64
+	blocked      atomic.Int64
+	torResolved  atomic.Int64
+	servfails    atomic.Int64
+	torLatencyNs atomic.Int64 // Sum over torResolved samples
+}
+
+// DNSStats is a point-in-time snapshot of the resolver's counters
+type DNSStats struct {
+	Queries         int64   `json:"queries"`
+	CacheHits       int64   `json:"cache_hits"`
+	Bypassed        int64   `json:"bypassed"`
+	Blocked         int64   `json:"blocked"`
+	TorResolved     int64   `json:"tor_resolved"`
+	Servfails       int64   `json:"servfails"`
+	AvgTorLatencyMs float64 `json:"avg_tor_latency_ms"`
+}
+
+// Stats returns a snapshot of the resolver's query counters
+func (r *DNSResolver) Stats() DNSStats {
+	stats := DNSStats{
+		Queries:     r.stats.queries.Load(),
+		CacheHits:   r.stats.cacheHits.Load(),
+		Bypassed:    r.stats.bypassed.Load(),
+		Blocked:     r.stats.blocked.Load(),
+		TorResolved: r.stats.torResolved.Load(),
+		Servfails:   r.stats.servfails.Load(),
+	}
+	if stats.TorResolved > 0 {
+		avgNs := float64(r.stats.torLatencyNs.Load()) / float64(stats.TorResolved)
+		stats.AvgTorLatencyMs = avgNs / float64(time.Millisecond)
+	}
+	return stats
 }
 
 type dnsCache struct {
-	entries map[string]*dnsCacheEntry
-	mu      sync.RWMutex
-	maxAge  time.Duration
+	entries    map[string]*dnsCacheEntry
+	mu         sync.Mutex
+	maxAge     time.Duration // Ceiling for record TTLs, and lifetime for TTL-less answers
+	minAge     time.Duration // Floor for record TTLs
+	negAge     time.Duration // Lifetime for cached NXDOMAIN answers
+	maxEntries int
 }
 
 type dnsCacheEntry struct {
 	response  *dns.Msg
+	ttl       time.Duration // Effective TTL, from the answer RRs clamped to floor/ceiling
 	timestamp time.Time
+	lastUsed  time.Time
+}
+
+// Cache defaults, used when DNSCacheOptions leaves a field zero
+const (
+	defaultDNSCacheEntries = 4096
+	defaultDNSCacheAge     = 5 * time.Minute
+	defaultDNSCacheMinAge  = 5 * time.Second
+	defaultDNSCacheNegAge  = 30 * time.Second
+	dnsCacheSweepInterval  = time.Minute
+)
+
+// DNSCacheOptions tunes the resolver's response cache. A nil options
+// pointer or zero fields fall back to the defaults.
+type DNSCacheOptions struct {
+	MaxEntries  int
+	MaxAge      time.Duration // TTL ceiling
+	MinAge      time.Duration // TTL floor
+	NegativeTTL time.Duration // Lifetime for cached NXDOMAIN answers
 }
 
-// NewDNSResolver creates a new DNS resolver
-func NewDNSResolver(torCfg *config.TorConfig, bypassCfg *config.BypassConfig) *DNSResolver {
+// NewDNSResolver creates a new DNS resolver. When an engine is given,
+// bypass decisions go through it so DNS matches the rest of the system
+// (custom rules, block actions); a nil engine falls back to plain
+// pattern matching over the bypass config.
+func NewDNSResolver(torCfg *config.TorConfig, bypassCfg *config.BypassConfig, engine *bypass.Engine, cacheOpts *DNSCacheOptions) *DNSResolver {
+	maxEntries := defaultDNSCacheEntries
+	maxAge := defaultDNSCacheAge
+	minAge := defaultDNSCacheMinAge
+	negAge := defaultDNSCacheNegAge
+	if cacheOpts != nil {
+		if cacheOpts.MaxEntries > 0 {
+			maxEntries = cacheOpts.MaxEntries
+		}
+		if cacheOpts.MaxAge > 0 {
+			maxAge = cacheOpts.MaxAge
+		}
+		if cacheOpts.MinAge > 0 {
+			minAge = cacheOpts.MinAge
+		}
+		if cacheOpts.NegativeTTL > 0 {
+			negAge = cacheOpts.NegativeTTL
+		}
+	}
+
 	return &DNSResolver{
 		cfg:         torCfg,
 		bypassCfg:   bypassCfg,
+		engine:      engine,
 		torUpstream: fmt.Sprintf("127.0.0.1:%d", torCfg.DNSPort),
-		fallback:    "9.9.9.9:53", // Quad9 as fallback (should go through Tor)
+		localUpstreams: []string{
+			"127.0.0.53:53", // systemd-resolved
+			"127.0.0.1:53",
+		},
+		fallback: "9.9.9.9:53", // Quad9 as fallback (should go through Tor)
 		cache: &dnsCache{
-			entries: make(map[string]*dnsCacheEntry),
-			maxAge:  5 * time.Minute,
+			entries:    make(map[string]*dnsCacheEntry),
+			maxAge:     maxAge,
+			minAge:     minAge,
+			negAge:     negAge,
+			maxEntries: maxEntries,
 		},
 	}
 }
@@ -79,6 +179,34 @@ func (r *DNSResolver) Start(listenPort int) error {
 		}
 	}()
 
+	// Start TCP server on the same port for clients that query over
+	// TCP, e.g. after receiving a truncated UDP answer
+	r.tcpServer = &dns.Server{
+		Addr: addr,
+		Net:  "tcp",
+	}
+
+	go func() {
+		if err := r.tcpServer.ListenAndServe(); err != nil {
+			log.Error().Err(err).Msg("DNS TCP server error")
+		}
+	}()
+
+	// Periodically drop expired cache entries
+	r.stopSweep = make(chan struct{})
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(dnsCacheSweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.cache.sweep()
+			case <-stop:
+				return
+			}
+		}
+	}(r.stopSweep)
+
 	r.running = true
 	return nil
 }
@@ -95,6 +223,13 @@ func (r *DNSResolver) Stop() error {
 	if r.server != nil {
 		r.server.Shutdown()
 	}
+	if r.tcpServer != nil {
+		r.tcpServer.Shutdown()
+	}
+	if r.stopSweep != nil {
+		close(r.stopSweep)
+		r.stopSweep = nil
+	}
 
 	r.running = false
 	return nil
@@ -109,7 +244,8 @@ func (r *DNSResolver) handleDNS(w dns.ResponseWriter, req *dns.Msg) {
 	}
 
 	question := req.Question[0]
-	domain := strings.TrimSuffix(question.Name, ".")
+	domain := bypass.NormalizeDomain(question.Name)
+	r.stats.queries.Add(1)
 
 	log.Debug().
 		Str("domain", domain).
@@ -117,7 +253,22 @@ func (r *DNSResolver) handleDNS(w dns.ResponseWriter, req *dns.Msg) {
 		Msg("DNS query")
 
 	// Check bypass rules
-	if r.shouldBypass(domain) {
+	if r.engine != nil {
+		switch result := r.engine.MatchDomain(domain); {
+		case result.Matched && result.Action == bypass.ActionBlock:
+			log.Debug().Str("domain", domain).Str("reason", result.Reason).Msg("blocking DNS query")
+			r.stats.blocked.Add(1)
+			m := new(dns.Msg)
+			m.SetRcode(req, dns.RcodeNameError)
+			w.WriteMsg(m)
+			return
+		case result.Matched && result.Action == bypass.ActionBypass:
+			log.Debug().Str("domain", domain).Str("reason", result.Reason).Msg("bypassing DNS (clearnet)")
+			r.resolveBypass(w, req, domain)
+			return
+		}
+		// ActionTor (or no match) falls through to the Tor path
+	} else if r.shouldBypass(domain) {
 		log.Debug().Str("domain", domain).Msg("bypassing DNS (clearnet)")
 		r.resolveBypass(w, req, domain)
 		return
@@ -127,6 +278,7 @@ func (r *DNSResolver) handleDNS(w dns.ResponseWriter, req *dns.Msg) {
 	if cached := r.cache.get(domain, question.Qtype); cached != nil {
 		cached.Id = req.Id
 		w.WriteMsg(cached)
+		r.stats.cacheHits.Add(1)
 		log.Debug().Str("domain", domain).Msg("DNS cache hit")
 		return
 	}
@@ -135,11 +287,14 @@ func (r *DNSResolver) handleDNS(w dns.ResponseWriter, req *dns.Msg) {
 	r.resolveTor(w, req, domain)
 }
 
+// shouldBypass is the engine-less fallback: plain pattern matching over
+// the bypass config's domain list, with no custom rules or block actions
 func (r *DNSResolver) shouldBypass(domain string) bool {
 	if r.bypassCfg == nil || !r.bypassCfg.Enabled {
 		return false
 	}
 
+	domain = bypass.NormalizeDomain(domain)
 	for _, pattern := range r.bypassCfg.Domains {
 		if matchDomain(pattern, domain) {
 			return true
@@ -149,14 +304,16 @@ func (r *DNSResolver) shouldBypass(domain string) bool {
 	return false
 }
 
+// matchDomain compares one config pattern against an already-normalized
+// query name
 func matchDomain(pattern, domain string) bool {
 	// Handle wildcard patterns
 	if strings.HasPrefix(pattern, "*.") {
-		suffix := pattern[1:] // Remove "*"
-		return strings.HasSuffix(domain, suffix) || domain == pattern[2:]
+		suffix := bypass.NormalizeDomain(pattern[2:])
+		return strings.HasSuffix(domain, "."+suffix) || domain == suffix
 	}
 
-	return pattern == domain
+	return bypass.NormalizeDomain(pattern) == domain
 }
 
 func (r *DNSResolver) resolveTor(w dns.ResponseWriter, req *dns.Msg, domain string) {
@@ -168,19 +325,45 @@ func (r *DNSResolver) resolveTor(w dns.ResponseWriter, req *dns.Msg, domain stri
 		Timeout: 10 * time.Second,
 	}
 
+	start := time.Now()
 	resp, _, err := client.Exchange(req, r.torUpstream)
 	if err != nil {
 		log.Warn().Err(err).Str("domain", domain).Msg("Tor DNS query failed")
 
 		// Return SERVFAIL
+		r.stats.servfails.Add(1)
 		m := new(dns.Msg)
 		m.SetRcode(req, dns.RcodeServerFailure)
 		w.WriteMsg(m)
 		return
 	}
 
-	// Cache the response
-	if resp.Rcode == dns.RcodeSuccess {
+	// Large answers don't fit in a UDP datagram; retry over TCP so the
+	// client gets the full response instead of the TC bit
+	if resp.Truncated {
+		log.Debug().Str("domain", domain).Msg("truncated UDP answer, retrying over TCP")
+		tcpClient := &dns.Client{
+			Net:     "tcp",
+			Timeout: 10 * time.Second,
+		}
+		if tcpResp, _, tcpErr := tcpClient.Exchange(req, r.torUpstream); tcpErr == nil {
+			resp = tcpResp
+		} else {
+			log.Warn().Err(tcpErr).Str("domain", domain).Msg("TCP retry failed, returning truncated answer")
+		}
+	}
+
+	// The latency sum covers the whole upstream exchange, including a
+	// TCP retry, since that is what the client experienced
+	r.stats.torResolved.Add(1)
+	r.stats.torLatencyNs.Add(int64(time.Since(start)))
+	if resp.Rcode == dns.RcodeServerFailure {
+		r.stats.servfails.Add(1)
+	}
+
+	// Cache the response; NXDOMAIN is cached too (negative caching) so
+	// repeated lookups of dead names don't hammer Tor
+	if resp.Rcode == dns.RcodeSuccess || resp.Rcode == dns.RcodeNameError {
 		r.cache.set(domain, req.Question[0].Qtype, resp)
 	}
 
@@ -200,27 +383,25 @@ func (r *DNSResolver) resolveBypass(w dns.ResponseWriter, req *dns.Msg, domain s
 		Timeout: 5 * time.Second,
 	}
 
-	// Try to resolve via system resolver (for local domains)
-	resp, _, err := client.Exchange(req, "127.0.0.53:53") // systemd-resolved
-	if err != nil {
-		// Fallback to localhost
-		resp, _, err = client.Exchange(req, "127.0.0.1:53")
-		if err != nil {
-			log.Debug().Err(err).Str("domain", domain).Msg("local DNS failed, returning NXDOMAIN")
-			m := new(dns.Msg)
-			m.SetRcode(req, dns.RcodeNameError)
-			w.WriteMsg(m)
+	// Try the local resolvers in order (systemd-resolved, then localhost)
+	for _, upstream := range r.localUpstreams {
+		if resp, _, err := client.Exchange(req, upstream); err == nil {
+			r.stats.bypassed.Add(1)
+			w.WriteMsg(resp)
 			return
 		}
 	}
 
-	w.WriteMsg(resp)
+	log.Debug().Str("domain", domain).Msg("local DNS failed, returning NXDOMAIN")
+	m := new(dns.Msg)
+	m.SetRcode(req, dns.RcodeNameError)
+	w.WriteMsg(m)
 }
 
 // Cache methods
 func (c *dnsCache) get(domain string, qtype uint16) *dns.Msg {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
 	key := fmt.Sprintf("%s:%d", domain, qtype)
 	entry, ok := c.entries[key]
@@ -228,12 +409,81 @@ func (c *dnsCache) get(domain string, qtype uint16) *dns.Msg {
 		return nil
 	}
 
-	if time.Since(entry.timestamp) > c.maxAge {
+	elapsed := time.Since(entry.timestamp)
+	if elapsed > entry.ttl {
+		delete(c.entries, key)
 		return nil
 	}
 
-	// Return a copy
-	return entry.response.Copy()
+	entry.lastUsed = time.Now()
+
+	// Return a copy with the remaining TTL, so downstream caches don't
+	// hold records longer than the authority allowed
+	msg := entry.response.Copy()
+	decrementTTLs(msg, elapsed)
+	return msg
+}
+
+// effectiveTTL derives an entry's lifetime from the minimum TTL across
+// the answer RRs, clamped between the configured floor and ceiling.
+// Answers without records keep the flat ceiling.
+func (c *dnsCache) effectiveTTL(msg *dns.Msg) time.Duration {
+	if msg.Rcode == dns.RcodeNameError {
+		return c.negativeTTL(msg)
+	}
+
+	minTTL := time.Duration(-1)
+	for _, rr := range msg.Answer {
+		ttl := time.Duration(rr.Header().Ttl) * time.Second
+		if minTTL < 0 || ttl < minTTL {
+			minTTL = ttl
+		}
+	}
+	if minTTL < 0 {
+		return c.maxAge
+	}
+	if minTTL < c.minAge {
+		return c.minAge
+	}
+	if minTTL > c.maxAge {
+		return c.maxAge
+	}
+	return minTTL
+}
+
+// negativeTTL returns the lifetime for a cached NXDOMAIN. Per RFC 2308
+// the SOA minimum (and the SOA record's own TTL) bound how long the
+// non-existence may be cached.
+func (c *dnsCache) negativeTTL(msg *dns.Msg) time.Duration {
+	ttl := c.negAge
+	for _, rr := range msg.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			bound := time.Duration(min(soa.Minttl, soa.Hdr.Ttl)) * time.Second
+			if bound < ttl {
+				ttl = bound
+			}
+			break
+		}
+	}
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	return ttl
+}
+
+// decrementTTLs reduces every RR's TTL by the time the answer spent in
+// the cache
+func decrementTTLs(msg *dns.Msg, elapsed time.Duration) {
+	dec := uint32(elapsed / time.Second)
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			if hdr := rr.Header(); hdr.Ttl > dec {
+				hdr.Ttl -= dec
+			} else {
+				hdr.Ttl = 0
+			}
+		}
+	}
 }
 
 func (c *dnsCache) set(domain string, qtype uint16, msg *dns.Msg) {
@@ -241,12 +491,54 @@ func (c *dnsCache) set(domain string, qtype uint16, msg *dns.Msg) {
 	defer c.mu.Unlock()
 
 	key := fmt.Sprintf("%s:%d", domain, qtype)
+	if _, exists := c.entries[key]; !exists && len(c.entries) >= c.maxEntries {
+		c.evictLRULocked()
+	}
+
+	now := time.Now()
 	c.entries[key] = &dnsCacheEntry{
 		response:  msg.Copy(),
-		timestamp: time.Now(),
+		ttl:       c.effectiveTTL(msg),
+		timestamp: now,
+		lastUsed:  now,
 	}
 }
 
+// evictLRULocked drops the least-recently-used entry. Callers must hold
+// the lock.
+func (c *dnsCache) evictLRULocked() {
+	var oldestKey string
+	var oldest time.Time
+	for key, entry := range c.entries {
+		if oldestKey == "" || entry.lastUsed.Before(oldest) {
+			oldestKey = key
+			oldest = entry.lastUsed
+		}
+	}
+	if oldestKey != "" {
+		delete(c.entries, oldestKey)
+	}
+}
+
+// sweep drops expired entries so an idle resolver reclaims memory
+// without waiting for reads to notice staleness
+func (c *dnsCache) sweep() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, entry := range c.entries {
+		if time.Since(entry.timestamp) > entry.ttl {
+			delete(c.entries, key)
+		}
+	}
+}
+
+func (c *dnsCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
 // LeakCheck performs a DNS leak check
 func LeakCheck(ctx context.Context, torDNSPort int) (*LeakCheckResult, error) {
 	log := logger.WithComponent("leak-check")
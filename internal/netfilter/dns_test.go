@@ -0,0 +1,534 @@
+package netfilter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jery0843/torforge/internal/bypass"
+	"github.com/jery0843/torforge/pkg/config"
+	"github.com/miekg/dns"
+)
+
+// captureWriter is a dns.ResponseWriter that records the written message
+type captureWriter struct {
+	msg *dns.Msg
+}
+
+func (w *captureWriter) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 53}
+}
+func (w *captureWriter) RemoteAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 54321}
+}
+func (w *captureWriter) WriteMsg(m *dns.Msg) error { w.msg = m; return nil }
+func (w *captureWriter) Write(b []byte) (int, error) {
+	m := new(dns.Msg)
+	if err := m.Unpack(b); err != nil {
+		return 0, err
+	}
+	w.msg = m
+	return len(b), nil
+}
+func (w *captureWriter) Close() error        { return nil }
+func (w *captureWriter) TsigStatus() error   { return nil }
+func (w *captureWriter) TsigTimersOnly(bool) {}
+func (w *captureWriter) Hijack()             {}
+
+// startStubUpstream runs a DNS server on both UDP and TCP that truncates
+// UDP answers and serves the full answer over TCP, mimicking a large
+// response that doesn't fit in a datagram
+func startStubUpstream(t *testing.T) string {
+	t.Helper()
+
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(req)
+		if _, isUDP := w.RemoteAddr().(*net.UDPAddr); isUDP {
+			m.Truncated = true
+		} else {
+			rr, _ := dns.NewRR(fmt.Sprintf("%s 60 IN A 93.184.216.34", req.Question[0].Name))
+			m.Answer = append(m.Answer, rr)
+		}
+		w.WriteMsg(m)
+	})
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen UDP: %v", err)
+	}
+	addr := pc.LocalAddr().String()
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		pc.Close()
+		t.Skipf("TCP port %s not available: %v", addr, err)
+	}
+
+	udpServer := &dns.Server{PacketConn: pc, Handler: handler}
+	tcpServer := &dns.Server{Listener: ln, Handler: handler}
+	go udpServer.ActivateAndServe()
+	go tcpServer.ActivateAndServe()
+	t.Cleanup(func() {
+		udpServer.Shutdown()
+		tcpServer.Shutdown()
+	})
+
+	return addr
+}
+
+// TestResolveTorRetriesTCPOnTruncation tests that a TC-flagged UDP
+// answer is retried over TCP and the full response reaches the client
+func TestResolveTorRetriesTCPOnTruncation(t *testing.T) {
+	upstream := startStubUpstream(t)
+
+	resolver := NewDNSResolver(&config.TorConfig{}, nil, nil, nil)
+	resolver.torUpstream = upstream
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeA)
+
+	w := &captureWriter{}
+	done := make(chan struct{})
+	go func() {
+		resolver.resolveTor(w, req, "example.com")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(15 * time.Second):
+		t.Fatal("resolveTor did not finish")
+	}
+
+	if w.msg == nil {
+		t.Fatal("no response written")
+	}
+	if w.msg.Truncated {
+		t.Error("client received a truncated answer; expected TCP retry")
+	}
+	if len(w.msg.Answer) != 1 {
+		t.Fatalf("answer count = %d, want 1", len(w.msg.Answer))
+	}
+	if a, ok := w.msg.Answer[0].(*dns.A); !ok || a.A.String() != "93.184.216.34" {
+		t.Errorf("unexpected answer: %v", w.msg.Answer[0])
+	}
+}
+
+// TestDNSCacheLRUEviction tests that the cache stays within its cap and
+// drops the least-recently-used entries first
+func TestDNSCacheLRUEviction(t *testing.T) {
+	resolver := NewDNSResolver(&config.TorConfig{}, nil, nil, &DNSCacheOptions{MaxEntries: 3})
+	cache := resolver.cache
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("x.", dns.TypeA)
+
+	cache.set("a.example", dns.TypeA, msg)
+	cache.set("b.example", dns.TypeA, msg)
+	cache.set("c.example", dns.TypeA, msg)
+
+	// Touch the oldest entry so it becomes the most recently used
+	if cache.get("a.example", dns.TypeA) == nil {
+		t.Fatal("expected a.example to be cached")
+	}
+
+	// Inserting a fourth entry must evict the LRU one (b.example)
+	cache.set("d.example", dns.TypeA, msg)
+
+	if cache.len() != 3 {
+		t.Errorf("cache size = %d, want 3", cache.len())
+	}
+	if cache.get("b.example", dns.TypeA) != nil {
+		t.Error("b.example should have been evicted")
+	}
+	for _, domain := range []string{"a.example", "c.example", "d.example"} {
+		if cache.get(domain, dns.TypeA) == nil {
+			t.Errorf("%s should still be cached", domain)
+		}
+	}
+}
+
+// TestDNSCacheSweep tests that the background sweep reclaims expired
+// entries
+func TestDNSCacheSweep(t *testing.T) {
+	resolver := NewDNSResolver(&config.TorConfig{}, nil, nil, &DNSCacheOptions{MaxAge: time.Millisecond})
+	cache := resolver.cache
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("x.", dns.TypeA)
+	cache.set("stale.example", dns.TypeA, msg)
+
+	time.Sleep(5 * time.Millisecond)
+	cache.sweep()
+
+	if cache.len() != 0 {
+		t.Errorf("cache size after sweep = %d, want 0", cache.len())
+	}
+}
+
+func cacheMsg(t *testing.T, name string, ttl int) *dns.Msg {
+	t.Helper()
+	msg := new(dns.Msg)
+	msg.SetQuestion(name+".", dns.TypeA)
+	rr, err := dns.NewRR(fmt.Sprintf("%s. %d IN A 192.0.2.1", name, ttl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.Answer = append(msg.Answer, rr)
+	return msg
+}
+
+// TestDNSCacheHonorsRecordTTL tests that an entry expires when its
+// record TTL elapses, not after the flat 5-minute ceiling
+func TestDNSCacheHonorsRecordTTL(t *testing.T) {
+	resolver := NewDNSResolver(&config.TorConfig{}, nil, nil, nil)
+	cache := resolver.cache
+
+	cache.set("short.example", dns.TypeA, cacheMsg(t, "short.example", 30))
+
+	cache.mu.Lock()
+	entry := cache.entries["short.example:1"]
+	if entry.ttl != 30*time.Second {
+		t.Errorf("effective TTL = %v, want 30s", entry.ttl)
+	}
+	// Rewind past the record TTL but well under the ceiling
+	entry.timestamp = time.Now().Add(-31 * time.Second)
+	cache.mu.Unlock()
+
+	if cache.get("short.example", dns.TypeA) != nil {
+		t.Error("entry should have expired at its 30s record TTL")
+	}
+}
+
+// TestDNSCacheTTLClamping tests the floor and ceiling bounds
+func TestDNSCacheTTLClamping(t *testing.T) {
+	resolver := NewDNSResolver(&config.TorConfig{}, nil, nil, &DNSCacheOptions{
+		MinAge: 5 * time.Second,
+		MaxAge: 5 * time.Minute,
+	})
+	cache := resolver.cache
+
+	cache.set("tiny.example", dns.TypeA, cacheMsg(t, "tiny.example", 1))
+	cache.set("huge.example", dns.TypeA, cacheMsg(t, "huge.example", 86400))
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if got := cache.entries["tiny.example:1"].ttl; got != 5*time.Second {
+		t.Errorf("floor clamp: ttl = %v, want 5s", got)
+	}
+	if got := cache.entries["huge.example:1"].ttl; got != 5*time.Minute {
+		t.Errorf("ceiling clamp: ttl = %v, want 5m", got)
+	}
+}
+
+// TestDNSCacheDecrementsTTL tests that returned copies carry the
+// remaining TTL rather than the original
+func TestDNSCacheDecrementsTTL(t *testing.T) {
+	resolver := NewDNSResolver(&config.TorConfig{}, nil, nil, nil)
+	cache := resolver.cache
+
+	cache.set("decr.example", dns.TypeA, cacheMsg(t, "decr.example", 30))
+
+	cache.mu.Lock()
+	cache.entries["decr.example:1"].timestamp = time.Now().Add(-10 * time.Second)
+	cache.mu.Unlock()
+
+	msg := cache.get("decr.example", dns.TypeA)
+	if msg == nil {
+		t.Fatal("expected a cache hit")
+	}
+	ttl := msg.Answer[0].Header().Ttl
+	if ttl < 19 || ttl > 20 {
+		t.Errorf("returned TTL = %d, want ~20", ttl)
+	}
+}
+
+// TestNegativeCaching tests that NXDOMAIN answers are served from cache
+// instead of re-querying upstream
+func TestNegativeCaching(t *testing.T) {
+	var queries int32
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		atomic.AddInt32(&queries, 1)
+		m := new(dns.Msg)
+		m.SetRcode(req, dns.RcodeNameError)
+		soa, _ := dns.NewRR("example. 60 IN SOA ns.example. host.example. 1 7200 900 1209600 300")
+		m.Ns = append(m.Ns, soa)
+		w.WriteMsg(m)
+	})
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{PacketConn: pc, Handler: handler}
+	go server.ActivateAndServe()
+	t.Cleanup(func() { server.Shutdown() })
+
+	resolver := NewDNSResolver(&config.TorConfig{}, nil, nil, nil)
+	resolver.torUpstream = pc.LocalAddr().String()
+
+	req := new(dns.Msg)
+	req.SetQuestion("missing.example.", dns.TypeA)
+
+	for i := 0; i < 2; i++ {
+		w := &captureWriter{}
+		resolver.handleDNS(w, req)
+		if w.msg == nil {
+			t.Fatalf("query %d: no response written", i+1)
+		}
+		if w.msg.Rcode != dns.RcodeNameError {
+			t.Errorf("query %d: rcode = %s, want NXDOMAIN", i+1, dns.RcodeToString[w.msg.Rcode])
+		}
+	}
+
+	if n := atomic.LoadInt32(&queries); n != 1 {
+		t.Errorf("upstream saw %d queries, want 1 (second should be a negative cache hit)", n)
+	}
+
+	// The SOA minimum (300s) exceeds the default negative TTL, so the
+	// entry keeps the 30s default
+	resolver.cache.mu.Lock()
+	defer resolver.cache.mu.Unlock()
+	if entry := resolver.cache.entries["missing.example:1"]; entry == nil || entry.ttl != 30*time.Second {
+		t.Errorf("negative entry TTL = %v, want 30s", entry.ttl)
+	}
+}
+
+// TestNegativeTTLBoundedBySOA tests the RFC 2308 SOA-minimum bound
+func TestNegativeTTLBoundedBySOA(t *testing.T) {
+	resolver := NewDNSResolver(&config.TorConfig{}, nil, nil, nil)
+	cache := resolver.cache
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("gone.example.", dns.TypeA)
+	msg.Rcode = dns.RcodeNameError
+	soa, _ := dns.NewRR("example. 60 IN SOA ns.example. host.example. 1 7200 900 1209600 10")
+	msg.Ns = append(msg.Ns, soa)
+
+	if got := cache.effectiveTTL(msg); got != 10*time.Second {
+		t.Errorf("negative TTL = %v, want 10s from SOA minimum", got)
+	}
+}
+
+// TestDNSResolverServesTCP tests that Start also listens on TCP
+func TestDNSResolverServesTCP(t *testing.T) {
+	upstream := startStubUpstream(t)
+
+	resolver := NewDNSResolver(&config.TorConfig{}, nil, nil, nil)
+	resolver.torUpstream = upstream
+
+	// Pick a free port for the resolver itself
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := pc.LocalAddr().(*net.UDPAddr).Port
+	pc.Close()
+
+	if err := resolver.Start(port); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer resolver.Stop()
+
+	req := new(dns.Msg)
+	req.SetQuestion("tcp-client.example.", dns.TypeA)
+
+	client := &dns.Client{Net: "tcp", Timeout: 10 * time.Second}
+
+	// The listener comes up asynchronously; retry briefly
+	var resp *dns.Msg
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, _, err = client.Exchange(req, fmt.Sprintf("127.0.0.1:%d", port))
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("TCP query failed: %v", err)
+	}
+	if len(resp.Answer) != 1 {
+		t.Errorf("answer count = %d, want 1", len(resp.Answer))
+	}
+}
+
+// startCountingStub runs a UDP DNS server that answers every query with
+// the given A record and counts the queries it sees
+func startCountingStub(t *testing.T, answerIP string) (string, *int32) {
+	t.Helper()
+
+	var queries int32
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		atomic.AddInt32(&queries, 1)
+		m := new(dns.Msg)
+		m.SetReply(req)
+		rr, _ := dns.NewRR(fmt.Sprintf("%s 60 IN A %s", req.Question[0].Name, answerIP))
+		m.Answer = append(m.Answer, rr)
+		w.WriteMsg(m)
+	})
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{PacketConn: pc, Handler: handler}
+	go server.ActivateAndServe()
+	t.Cleanup(func() { server.Shutdown() })
+
+	return pc.LocalAddr().String(), &queries
+}
+
+// TestHandleDNSEngineBypass tests that a domain matching a custom bypass
+// rule in the engine is resolved on clearnet instead of through Tor
+func TestHandleDNSEngineBypass(t *testing.T) {
+	eng, err := bypass.NewEngine(&config.BypassConfig{
+		Enabled: true,
+		CustomRules: []config.BypassRule{
+			{Name: "lan", Type: "domain", Pattern: "printer.lan", Action: "bypass"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	localAddr, localQueries := startCountingStub(t, "192.0.2.10")
+	torAddr, torQueries := startCountingStub(t, "203.0.113.1")
+
+	resolver := NewDNSResolver(&config.TorConfig{}, nil, eng, nil)
+	resolver.torUpstream = torAddr
+	resolver.localUpstreams = []string{localAddr}
+
+	req := new(dns.Msg)
+	req.SetQuestion("printer.lan.", dns.TypeA)
+
+	w := &captureWriter{}
+	resolver.handleDNS(w, req)
+
+	if w.msg == nil {
+		t.Fatal("no response written")
+	}
+	if len(w.msg.Answer) != 1 {
+		t.Fatalf("answer count = %d, want 1", len(w.msg.Answer))
+	}
+	if a, ok := w.msg.Answer[0].(*dns.A); !ok || a.A.String() != "192.0.2.10" {
+		t.Errorf("answer = %v, want the clearnet resolver's 192.0.2.10", w.msg.Answer[0])
+	}
+	if n := atomic.LoadInt32(localQueries); n != 1 {
+		t.Errorf("local resolver saw %d queries, want 1", n)
+	}
+	if n := atomic.LoadInt32(torQueries); n != 0 {
+		t.Errorf("Tor upstream saw %d queries, want 0", n)
+	}
+}
+
+// TestHandleDNSEngineBlock tests that a domain matching a block rule gets
+// NXDOMAIN without touching any upstream
+func TestHandleDNSEngineBlock(t *testing.T) {
+	eng, err := bypass.NewEngine(&config.BypassConfig{
+		Enabled: true,
+		CustomRules: []config.BypassRule{
+			{Name: "ads", Type: "domain", Pattern: "*.ads.example", Action: "block"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	localAddr, localQueries := startCountingStub(t, "192.0.2.10")
+	torAddr, torQueries := startCountingStub(t, "203.0.113.1")
+
+	resolver := NewDNSResolver(&config.TorConfig{}, nil, eng, nil)
+	resolver.torUpstream = torAddr
+	resolver.localUpstreams = []string{localAddr}
+
+	req := new(dns.Msg)
+	req.SetQuestion("tracker.ads.example.", dns.TypeA)
+
+	w := &captureWriter{}
+	resolver.handleDNS(w, req)
+
+	if w.msg == nil {
+		t.Fatal("no response written")
+	}
+	if w.msg.Rcode != dns.RcodeNameError {
+		t.Errorf("rcode = %s, want NXDOMAIN", dns.RcodeToString[w.msg.Rcode])
+	}
+	if n := atomic.LoadInt32(localQueries); n != 0 {
+		t.Errorf("local resolver saw %d queries, want 0", n)
+	}
+	if n := atomic.LoadInt32(torQueries); n != 0 {
+		t.Errorf("Tor upstream saw %d queries, want 0", n)
+	}
+}
+
+// TestDNSResolverStats tests that the resolver's counters track each
+// resolution path and that the snapshot serializes to JSON
+func TestDNSResolverStats(t *testing.T) {
+	eng, err := bypass.NewEngine(&config.BypassConfig{
+		Enabled: true,
+		CustomRules: []config.BypassRule{
+			{Name: "lan", Type: "domain", Pattern: "printer.lan", Action: "bypass"},
+			{Name: "ads", Type: "domain", Pattern: "*.ads.example", Action: "block"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	localAddr, _ := startCountingStub(t, "192.0.2.10")
+	torAddr, _ := startCountingStub(t, "203.0.113.1")
+
+	resolver := NewDNSResolver(&config.TorConfig{}, nil, eng, nil)
+	resolver.torUpstream = torAddr
+	resolver.localUpstreams = []string{localAddr}
+
+	query := func(name string) {
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeA)
+		resolver.handleDNS(&captureWriter{}, req)
+	}
+
+	query("example.com.")         // Tor resolution
+	query("example.com.")         // Cache hit
+	query("printer.lan.")         // Clearnet bypass
+	query("tracker.ads.example.") // Blocked
+
+	resolver.torUpstream = "127.0.0.1:1" // Nothing listening
+	query("fail.example.")               // SERVFAIL
+
+	stats := resolver.Stats()
+	if stats.Queries != 5 {
+		t.Errorf("Queries = %d, want 5", stats.Queries)
+	}
+	if stats.TorResolved != 1 {
+		t.Errorf("TorResolved = %d, want 1", stats.TorResolved)
+	}
+	if stats.CacheHits != 1 {
+		t.Errorf("CacheHits = %d, want 1", stats.CacheHits)
+	}
+	if stats.Bypassed != 1 {
+		t.Errorf("Bypassed = %d, want 1", stats.Bypassed)
+	}
+	if stats.Blocked != 1 {
+		t.Errorf("Blocked = %d, want 1", stats.Blocked)
+	}
+	if stats.Servfails != 1 {
+		t.Errorf("Servfails = %d, want 1", stats.Servfails)
+	}
+	if stats.AvgTorLatencyMs <= 0 {
+		t.Errorf("AvgTorLatencyMs = %v, want > 0", stats.AvgTorLatencyMs)
+	}
+
+	data, err := json.Marshal(stats)
+	if err != nil {
+		t.Fatalf("stats did not marshal: %v", err)
+	}
+	if !strings.Contains(string(data), `"cache_hits":1`) {
+		t.Errorf("unexpected JSON: %s", data)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/jery0843/torforge/internal/bypass"
 	"github.com/jery0843/torforge/pkg/logger"
 	"github.com/miekg/dns"
 )
@@ -19,17 +20,32 @@ type FakeDNSServer struct {
 	running     bool
 	listenAddr  string
 	fakeSubnet  *net.IPNet
-	mappings    map[string]net.IP // domain -> fake IP
-	reverseMaps map[string]string // fake IP -> domain
+	fakeSubnet6 *net.IPNet              // Optional; nil keeps AAAA answers empty
+	mappings    map[string]*fakeMapping // domain -> fake IPs
+	reverseMaps map[string]string       // fake IP (either family) -> domain
+	freeIPs     []net.IP                // Recycled by CleanupOldMappings, reused before nextIP
+	freeIPs6    []net.IP
 	nextIP      net.IP
+	nextIP6     net.IP
 	ttl         uint32
+	now         func() time.Time // Injectable for tests
+}
+
+// fakeMapping is one allocated domain -> fake IP pair. Each family is
+// allocated lazily on first query of that type. lastUsed refreshes on
+// every lookup so active domains survive cleanup.
+type fakeMapping struct {
+	ip       net.IP // IPv4, nil until an A query arrives
+	ip6      net.IP // IPv6, nil until an AAAA query arrives
+	lastUsed time.Time
 }
 
 // FakeDNSConfig configures the FakeDNS server
 type FakeDNSConfig struct {
-	ListenAddr string
-	FakeSubnet string // e.g., "198.18.0.0/15" (reserved for benchmarking)
-	TTL        uint32
+	ListenAddr  string
+	FakeSubnet  string // e.g., "198.18.0.0/15" (reserved for benchmarking)
+	FakeSubnet6 string // e.g., "fc00:dead::/32"; empty forces IPv4 (AAAA answered empty)
+	TTL         uint32
 }
 
 // NewFakeDNSServer creates a new FakeDNS server
@@ -44,14 +60,32 @@ func NewFakeDNSServer(cfg *FakeDNSConfig) (*FakeDNSServer, error) {
 	copy(startIP, subnet.IP)
 	incrementIP(startIP)
 
-	return &FakeDNSServer{
+	f := &FakeDNSServer{
 		listenAddr:  cfg.ListenAddr,
 		fakeSubnet:  subnet,
-		mappings:    make(map[string]net.IP),
+		mappings:    make(map[string]*fakeMapping),
 		reverseMaps: make(map[string]string),
 		nextIP:      startIP,
 		ttl:         cfg.TTL,
-	}, nil
+		now:         time.Now,
+	}
+
+	if cfg.FakeSubnet6 != "" {
+		_, subnet6, err := net.ParseCIDR(cfg.FakeSubnet6)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fake IPv6 subnet: %w", err)
+		}
+		if subnet6.IP.To4() != nil {
+			return nil, fmt.Errorf("fake IPv6 subnet %s is not an IPv6 range", cfg.FakeSubnet6)
+		}
+		startIP6 := make(net.IP, len(subnet6.IP))
+		copy(startIP6, subnet6.IP)
+		incrementIP(startIP6)
+		f.fakeSubnet6 = subnet6
+		f.nextIP6 = startIP6
+	}
+
+	return f, nil
 }
 
 // Start starts the FakeDNS server
@@ -107,9 +141,19 @@ func (f *FakeDNSServer) handleDNS(w dns.ResponseWriter, r *dns.Msg) {
 	m.Authoritative = true
 
 	for _, q := range r.Question {
+		log.Debug().
+			Str("domain", q.Name).
+			Str("type", dns.TypeToString[q.Qtype]).
+			Msg("FakeDNS query")
+
 		switch q.Qtype {
 		case dns.TypeA:
-			fakeIP := f.getFakeIP(q.Name)
+			fakeIP, err := f.getFakeIP(q.Name)
+			if err != nil {
+				log.Error().Err(err).Str("domain", q.Name).Msg("failed to allocate fake IP")
+				m.Rcode = dns.RcodeServerFailure
+				continue
+			}
 			rr := &dns.A{
 				Hdr: dns.RR_Header{
 					Name:   q.Name,
@@ -123,8 +167,58 @@ func (f *FakeDNSServer) handleDNS(w dns.ResponseWriter, r *dns.Msg) {
 			log.Debug().Str("domain", q.Name).Str("fake_ip", fakeIP.String()).Msg("FakeDNS response")
 
 		case dns.TypeAAAA:
-			// Return empty for IPv6 to force IPv4
-			// This prevents IPv6 leaks
+			// Without a configured v6 subnet, return empty to force
+			// IPv4 and prevent IPv6 leaks
+			if f.fakeSubnet6 == nil {
+				continue
+			}
+			fakeIP, err := f.getFakeIP6(q.Name)
+			if err != nil {
+				log.Error().Err(err).Str("domain", q.Name).Msg("failed to allocate fake IPv6")
+				m.Rcode = dns.RcodeServerFailure
+				continue
+			}
+			rr := &dns.AAAA{
+				Hdr: dns.RR_Header{
+					Name:   q.Name,
+					Rrtype: dns.TypeAAAA,
+					Class:  dns.ClassINET,
+					Ttl:    f.ttl,
+				},
+				AAAA: fakeIP,
+			}
+			m.Answer = append(m.Answer, rr)
+			log.Debug().Str("domain", q.Name).Str("fake_ip", fakeIP.String()).Msg("FakeDNS AAAA response")
+
+		case dns.TypeCNAME:
+			// Synthesize a self-referencing CNAME plus the A answer so
+			// clients that insist on chasing a chain still end up at
+			// the fake IP
+			fakeIP, err := f.getFakeIP(q.Name)
+			if err != nil {
+				log.Error().Err(err).Str("domain", q.Name).Msg("failed to allocate fake IP")
+				m.Rcode = dns.RcodeServerFailure
+				continue
+			}
+			m.Answer = append(m.Answer,
+				&dns.CNAME{
+					Hdr: dns.RR_Header{
+						Name:   q.Name,
+						Rrtype: dns.TypeCNAME,
+						Class:  dns.ClassINET,
+						Ttl:    f.ttl,
+					},
+					Target: q.Name,
+				},
+				&dns.A{
+					Hdr: dns.RR_Header{
+						Name:   q.Name,
+						Rrtype: dns.TypeA,
+						Class:  dns.ClassINET,
+						Ttl:    f.ttl,
+					},
+					A: fakeIP,
+				})
 
 		case dns.TypePTR:
 			// Reverse lookup
@@ -141,36 +235,114 @@ func (f *FakeDNSServer) handleDNS(w dns.ResponseWriter, r *dns.Msg) {
 				}
 				m.Answer = append(m.Answer, rr)
 			}
+
+		default:
+			// Unsupported types (HTTPS/SVCB, TXT, ...) get an empty
+			// NOERROR so clients fall back cleanly instead of timing
+			// out on a dropped question
+			log.Debug().
+				Str("domain", q.Name).
+				Str("type", dns.TypeToString[q.Qtype]).
+				Msg("unsupported FakeDNS query type, returning empty NOERROR")
 		}
 	}
 
 	w.WriteMsg(m)
 }
 
-// getFakeIP returns a fake IP for the domain, creating one if needed
-func (f *FakeDNSServer) getFakeIP(domain string) net.IP {
+// canonicalFakeName normalizes a mapping key: shared punycode/lowercase
+// folding via the bypass helper, then back to FQDN form so the PTR path
+// keeps handing out fully qualified names
+func canonicalFakeName(domain string) string {
+	return bypass.NormalizeDomain(domain) + "."
+}
+
+// getFakeIP returns a fake IP for the domain, creating one if needed.
+// Freed IPs from expired mappings are reused before new ones are carved
+// off the subnet; an exhausted subnet is an error rather than a silent
+// wrap into out-of-range addresses.
+func (f *FakeDNSServer) getFakeIP(domain string) (net.IP, error) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
 	// Normalize domain
-	domain = dns.CanonicalName(domain)
+	domain = canonicalFakeName(domain)
 
-	// Check if already mapped
-	if ip, ok := f.mappings[domain]; ok {
-		return ip
+	m, ok := f.mappings[domain]
+	if ok {
+		m.lastUsed = f.now()
+		if m.ip != nil {
+			return m.ip, nil
+		}
 	}
 
-	// Allocate new fake IP
-	fakeIP := make(net.IP, len(f.nextIP))
-	copy(fakeIP, f.nextIP)
+	fakeIP, err := f.allocLocked(&f.freeIPs, f.nextIP, f.fakeSubnet)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		m = &fakeMapping{lastUsed: f.now()}
+		f.mappings[domain] = m
+	}
+	m.ip = fakeIP
+	f.reverseMaps[fakeIP.String()] = domain
+
+	return fakeIP, nil
+}
+
+// getFakeIP6 is the AAAA counterpart of getFakeIP, allocating from the
+// optional IPv6 subnet
+func (f *FakeDNSServer) getFakeIP6(domain string) (net.IP, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.fakeSubnet6 == nil {
+		return nil, fmt.Errorf("no fake IPv6 subnet configured")
+	}
+
+	domain = canonicalFakeName(domain)
+
+	m, ok := f.mappings[domain]
+	if ok {
+		m.lastUsed = f.now()
+		if m.ip6 != nil {
+			return m.ip6, nil
+		}
+	}
+
+	fakeIP, err := f.allocLocked(&f.freeIPs6, f.nextIP6, f.fakeSubnet6)
+	if err != nil {
+		return nil, err
+	}
 
-	f.mappings[domain] = fakeIP
+	if !ok {
+		m = &fakeMapping{lastUsed: f.now()}
+		f.mappings[domain] = m
+	}
+	m.ip6 = fakeIP
 	f.reverseMaps[fakeIP.String()] = domain
 
-	// Increment for next allocation
-	incrementIP(f.nextIP)
+	return fakeIP, nil
+}
 
-	return fakeIP
+// allocLocked hands out the next address for one family: free list
+// first, then the next untouched address, erroring on exhaustion.
+// Callers must hold the lock.
+func (f *FakeDNSServer) allocLocked(free *[]net.IP, next net.IP, subnet *net.IPNet) (net.IP, error) {
+	if n := len(*free); n > 0 {
+		ip := (*free)[n-1]
+		*free = (*free)[:n-1]
+		return ip, nil
+	}
+
+	if !subnet.Contains(next) {
+		return nil, fmt.Errorf("fake subnet %s exhausted (%d active mappings)", subnet, len(f.mappings))
+	}
+	ip := make(net.IP, len(next))
+	copy(ip, next)
+	incrementIP(next)
+	return ip, nil
 }
 
 // getReverseDomain returns the domain for a fake IP
@@ -194,9 +366,12 @@ func (f *FakeDNSServer) GetDomainForIP(ip net.IP) string {
 	return f.reverseMaps[ip.String()]
 }
 
-// IsFakeIP checks if an IP is in our fake subnet
+// IsFakeIP checks if an IP is in either fake subnet
 func (f *FakeDNSServer) IsFakeIP(ip net.IP) bool {
-	return f.fakeSubnet.Contains(ip)
+	if f.fakeSubnet.Contains(ip) {
+		return true
+	}
+	return f.fakeSubnet6 != nil && f.fakeSubnet6.Contains(ip)
 }
 
 // GetMappingCount returns the number of active mappings
@@ -206,10 +381,36 @@ func (f *FakeDNSServer) GetMappingCount() int {
 	return len(f.mappings)
 }
 
-// CleanupOldMappings removes mappings older than duration
-func (f *FakeDNSServer) CleanupOldMappings(maxAge time.Duration) {
-	// In a full implementation, we'd track timestamps
-	// For now, this is a placeholder
+// CleanupOldMappings removes mappings unused for longer than maxAge and
+// puts their IPs on the free list for reuse. Returns how many were
+// removed.
+func (f *FakeDNSServer) CleanupOldMappings(maxAge time.Duration) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := f.now()
+	removed := 0
+	for domain, m := range f.mappings {
+		if now.Sub(m.lastUsed) <= maxAge {
+			continue
+		}
+		delete(f.mappings, domain)
+		if m.ip != nil {
+			delete(f.reverseMaps, m.ip.String())
+			f.freeIPs = append(f.freeIPs, m.ip)
+		}
+		if m.ip6 != nil {
+			delete(f.reverseMaps, m.ip6.String())
+			f.freeIPs6 = append(f.freeIPs6, m.ip6)
+		}
+		removed++
+	}
+
+	if removed > 0 {
+		log := logger.WithComponent("fakedns")
+		log.Debug().Int("removed", removed).Int("remaining", len(f.mappings)).Msg("cleaned up stale FakeDNS mappings")
+	}
+	return removed
 }
 
 // incrementIP increments an IP address by 1
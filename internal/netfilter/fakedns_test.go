@@ -1,8 +1,12 @@
 package netfilter
 
 import (
+	"fmt"
 	"net"
 	"testing"
+	"time"
+
+	"github.com/miekg/dns"
 )
 
 // TestNewFakeDNSServer tests creating a FakeDNS server from user perspective
@@ -59,19 +63,22 @@ func TestFakeDNSGetFakeIP(t *testing.T) {
 	}
 
 	// User queries for a domain
-	ip1 := server.getFakeIP("example.com.")
+	ip1, err := server.getFakeIP("example.com.")
+	if err != nil {
+		t.Fatalf("getFakeIP() error = %v", err)
+	}
 	if ip1 == nil {
 		t.Fatal("getFakeIP() returned nil")
 	}
 
 	// Same domain should return same IP (user expects consistency)
-	ip2 := server.getFakeIP("example.com.")
+	ip2, _ := server.getFakeIP("example.com.")
 	if !ip1.Equal(ip2) {
 		t.Errorf("same domain should return same IP: %s vs %s", ip1, ip2)
 	}
 
 	// Different domain should get different IP
-	ip3 := server.getFakeIP("google.com.")
+	ip3, _ := server.getFakeIP("google.com.")
 	if ip1.Equal(ip3) {
 		t.Error("different domains should get different IPs")
 	}
@@ -126,7 +133,10 @@ func TestFakeDNSGetDomainForIP(t *testing.T) {
 
 	// User first queries a domain to create mapping
 	domain := "test-domain.example.com."
-	fakeIP := server.getFakeIP(domain)
+	fakeIP, err := server.getFakeIP(domain)
+	if err != nil {
+		t.Fatalf("getFakeIP() error = %v", err)
+	}
 
 	// User then looks up the domain for that IP
 	foundDomain := server.GetDomainForIP(fakeIP)
@@ -254,3 +264,311 @@ func TestIncrementIP(t *testing.T) {
 		})
 	}
 }
+
+// TestFakeDNSCleanupExpiry tests that stale mappings are removed from
+// both directions of the table
+func TestFakeDNSCleanupExpiry(t *testing.T) {
+	server, err := NewFakeDNSServer(&FakeDNSConfig{
+		ListenAddr: "127.0.0.1:15353",
+		FakeSubnet: "198.18.0.0/15",
+		TTL:        60,
+	})
+	if err != nil {
+		t.Fatalf("NewFakeDNSServer() error = %v", err)
+	}
+
+	current := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	server.now = func() time.Time { return current }
+
+	staleIP, _ := server.getFakeIP("stale.example.")
+	current = current.Add(2 * time.Hour)
+	freshIP, _ := server.getFakeIP("fresh.example.")
+
+	if removed := server.CleanupOldMappings(time.Hour); removed != 1 {
+		t.Fatalf("CleanupOldMappings() = %d, want 1", removed)
+	}
+	if server.GetMappingCount() != 1 {
+		t.Errorf("mapping count = %d, want 1", server.GetMappingCount())
+	}
+	if got := server.GetDomainForIP(staleIP); got != "" {
+		t.Errorf("reverse map still has stale entry: %q", got)
+	}
+	if got := server.GetDomainForIP(freshIP); got != "fresh.example." {
+		t.Errorf("fresh mapping lost: %q", got)
+	}
+}
+
+// TestFakeDNSCleanupKeepsActiveMappings tests that a lookup refreshes
+// the mapping's age
+func TestFakeDNSCleanupKeepsActiveMappings(t *testing.T) {
+	server, err := NewFakeDNSServer(&FakeDNSConfig{
+		ListenAddr: "127.0.0.1:15353",
+		FakeSubnet: "198.18.0.0/15",
+		TTL:        60,
+	})
+	if err != nil {
+		t.Fatalf("NewFakeDNSServer() error = %v", err)
+	}
+
+	current := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	server.now = func() time.Time { return current }
+
+	server.getFakeIP("busy.example.")
+	current = current.Add(50 * time.Minute)
+	server.getFakeIP("busy.example.") // Refreshes lastUsed
+	current = current.Add(50 * time.Minute)
+
+	if removed := server.CleanupOldMappings(time.Hour); removed != 0 {
+		t.Errorf("CleanupOldMappings() = %d, want 0 for an active mapping", removed)
+	}
+}
+
+// TestFakeDNSRecyclesFreedIPs tests that cleanup returns IPs to a free
+// list and new domains reuse them
+func TestFakeDNSRecyclesFreedIPs(t *testing.T) {
+	server, err := NewFakeDNSServer(&FakeDNSConfig{
+		ListenAddr: "127.0.0.1:15353",
+		FakeSubnet: "198.18.0.0/15",
+		TTL:        60,
+	})
+	if err != nil {
+		t.Fatalf("NewFakeDNSServer() error = %v", err)
+	}
+
+	current := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	server.now = func() time.Time { return current }
+
+	oldIP, _ := server.getFakeIP("old.example.")
+	current = current.Add(2 * time.Hour)
+	server.CleanupOldMappings(time.Hour)
+
+	newIP, _ := server.getFakeIP("new.example.")
+	if !newIP.Equal(oldIP) {
+		t.Errorf("getFakeIP() = %s, want recycled %s", newIP, oldIP)
+	}
+	if got := server.GetDomainForIP(newIP); got != "new.example." {
+		t.Errorf("GetDomainForIP(%s) = %q, want new.example.", newIP, got)
+	}
+}
+
+// TestFakeDNSSubnetExhaustion tests that running out of addresses is an
+// error instead of a silent wrap out of the subnet
+func TestFakeDNSSubnetExhaustion(t *testing.T) {
+	server, err := NewFakeDNSServer(&FakeDNSConfig{
+		ListenAddr: "127.0.0.1:15353",
+		FakeSubnet: "198.18.0.0/30", // Addresses .1 through .3 after the base
+		TTL:        60,
+	})
+	if err != nil {
+		t.Fatalf("NewFakeDNSServer() error = %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := server.getFakeIP(fmt.Sprintf("host%d.example.", i)); err != nil {
+			t.Fatalf("getFakeIP() #%d error = %v", i, err)
+		}
+	}
+
+	if _, err := server.getFakeIP("one-too-many.example."); err == nil {
+		t.Fatal("getFakeIP() on exhausted subnet returned nil error")
+	}
+
+	// Cleanup frees capacity again
+	server.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	server.CleanupOldMappings(time.Hour)
+	if _, err := server.getFakeIP("late.example."); err != nil {
+		t.Errorf("getFakeIP() after cleanup error = %v", err)
+	}
+}
+
+// TestFakeDNSGetFakeIP6 tests AAAA allocation when a v6 subnet is
+// configured, mirroring the v4 behavior
+func TestFakeDNSGetFakeIP6(t *testing.T) {
+	server, err := NewFakeDNSServer(&FakeDNSConfig{
+		ListenAddr:  "127.0.0.1:15353",
+		FakeSubnet:  "198.18.0.0/15",
+		FakeSubnet6: "fc00:dead::/32",
+		TTL:         60,
+	})
+	if err != nil {
+		t.Fatalf("NewFakeDNSServer() error = %v", err)
+	}
+
+	ip1, err := server.getFakeIP6("example.com.")
+	if err != nil {
+		t.Fatalf("getFakeIP6() error = %v", err)
+	}
+	if ip1.To4() != nil {
+		t.Fatalf("getFakeIP6() = %s, want an IPv6 address", ip1)
+	}
+	if !server.IsFakeIP(ip1) {
+		t.Errorf("IsFakeIP(%s) = false for an allocated fake IPv6", ip1)
+	}
+
+	ip2, _ := server.getFakeIP6("example.com.")
+	if !ip1.Equal(ip2) {
+		t.Errorf("same domain should return same IPv6: %s vs %s", ip1, ip2)
+	}
+
+	ip3, _ := server.getFakeIP6("google.com.")
+	if ip1.Equal(ip3) {
+		t.Error("different domains should get different IPv6s")
+	}
+
+	// Both families of the same domain share one mapping entry
+	ip4, err := server.getFakeIP("example.com.")
+	if err != nil {
+		t.Fatalf("getFakeIP() error = %v", err)
+	}
+	if server.GetMappingCount() != 2 {
+		t.Errorf("mapping count = %d, want 2", server.GetMappingCount())
+	}
+	if got := server.GetDomainForIP(ip1); got != "example.com." {
+		t.Errorf("GetDomainForIP(v6) = %q, want example.com.", got)
+	}
+	if got := server.GetDomainForIP(ip4); got != "example.com." {
+		t.Errorf("GetDomainForIP(v4) = %q, want example.com.", got)
+	}
+}
+
+// TestFakeDNSGetFakeIP6Unconfigured tests that the force-IPv4 default
+// stays in place without a v6 subnet
+func TestFakeDNSGetFakeIP6Unconfigured(t *testing.T) {
+	server, err := NewFakeDNSServer(&FakeDNSConfig{
+		ListenAddr: "127.0.0.1:15353",
+		FakeSubnet: "198.18.0.0/15",
+		TTL:        60,
+	})
+	if err != nil {
+		t.Fatalf("NewFakeDNSServer() error = %v", err)
+	}
+
+	if _, err := server.getFakeIP6("example.com."); err == nil {
+		t.Error("getFakeIP6() without a v6 subnet returned nil error")
+	}
+	if server.IsFakeIP(net.ParseIP("fc00:dead::1")) {
+		t.Error("IsFakeIP() claimed a v6 address without a configured subnet")
+	}
+}
+
+// TestFakeDNSInvalidSubnet6 tests v6 subnet validation
+func TestFakeDNSInvalidSubnet6(t *testing.T) {
+	for _, subnet6 := range []string{"not-a-subnet", "10.0.0.0/8"} {
+		_, err := NewFakeDNSServer(&FakeDNSConfig{
+			ListenAddr:  "127.0.0.1:15353",
+			FakeSubnet:  "198.18.0.0/15",
+			FakeSubnet6: subnet6,
+			TTL:         60,
+		})
+		if err == nil {
+			t.Errorf("expected error for v6 subnet %q", subnet6)
+		}
+	}
+}
+
+// TestFakeDNSCleanupFreesBothFamilies tests that cleanup recycles v4 and
+// v6 addresses of one domain together
+func TestFakeDNSCleanupFreesBothFamilies(t *testing.T) {
+	server, err := NewFakeDNSServer(&FakeDNSConfig{
+		ListenAddr:  "127.0.0.1:15353",
+		FakeSubnet:  "198.18.0.0/15",
+		FakeSubnet6: "fc00:dead::/32",
+		TTL:         60,
+	})
+	if err != nil {
+		t.Fatalf("NewFakeDNSServer() error = %v", err)
+	}
+
+	current := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	server.now = func() time.Time { return current }
+
+	v4, _ := server.getFakeIP("dual.example.")
+	v6, _ := server.getFakeIP6("dual.example.")
+
+	current = current.Add(2 * time.Hour)
+	if removed := server.CleanupOldMappings(time.Hour); removed != 1 {
+		t.Fatalf("CleanupOldMappings() = %d, want 1", removed)
+	}
+	if got := server.GetDomainForIP(v4); got != "" {
+		t.Errorf("v4 reverse entry survived cleanup: %q", got)
+	}
+	if got := server.GetDomainForIP(v6); got != "" {
+		t.Errorf("v6 reverse entry survived cleanup: %q", got)
+	}
+
+	// Both addresses are recycled for the next domains
+	newV4, _ := server.getFakeIP("next.example.")
+	newV6, _ := server.getFakeIP6("next.example.")
+	if !newV4.Equal(v4) {
+		t.Errorf("v4 not recycled: got %s, want %s", newV4, v4)
+	}
+	if !newV6.Equal(v6) {
+		t.Errorf("v6 not recycled: got %s, want %s", newV6, v6)
+	}
+}
+
+// TestFakeDNSCNAMEQuery tests that CNAME queries get a synthesized chain
+// ending in the fake A record
+func TestFakeDNSCNAMEQuery(t *testing.T) {
+	server, err := NewFakeDNSServer(&FakeDNSConfig{
+		ListenAddr: "127.0.0.1:15353",
+		FakeSubnet: "198.18.0.0/15",
+		TTL:        60,
+	})
+	if err != nil {
+		t.Fatalf("NewFakeDNSServer() error = %v", err)
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion("alias.example.", dns.TypeCNAME)
+
+	w := &captureWriter{}
+	server.handleDNS(w, req)
+
+	if w.msg == nil {
+		t.Fatal("no response written")
+	}
+	if w.msg.Rcode != dns.RcodeSuccess {
+		t.Fatalf("rcode = %s, want NOERROR", dns.RcodeToString[w.msg.Rcode])
+	}
+	if len(w.msg.Answer) != 2 {
+		t.Fatalf("answer count = %d, want CNAME + A", len(w.msg.Answer))
+	}
+	cname, ok := w.msg.Answer[0].(*dns.CNAME)
+	if !ok || cname.Target != "alias.example." {
+		t.Errorf("answer[0] = %v, want CNAME to alias.example.", w.msg.Answer[0])
+	}
+	a, ok := w.msg.Answer[1].(*dns.A)
+	if !ok || !server.IsFakeIP(a.A) {
+		t.Errorf("answer[1] = %v, want an A record in the fake subnet", w.msg.Answer[1])
+	}
+}
+
+// TestFakeDNSUnsupportedQueryType tests that e.g. HTTPS (type 65)
+// queries get an empty NOERROR instead of being dropped
+func TestFakeDNSUnsupportedQueryType(t *testing.T) {
+	server, err := NewFakeDNSServer(&FakeDNSConfig{
+		ListenAddr: "127.0.0.1:15353",
+		FakeSubnet: "198.18.0.0/15",
+		TTL:        60,
+	})
+	if err != nil {
+		t.Fatalf("NewFakeDNSServer() error = %v", err)
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeHTTPS)
+
+	w := &captureWriter{}
+	server.handleDNS(w, req)
+
+	if w.msg == nil {
+		t.Fatal("no response written for unsupported query type")
+	}
+	if w.msg.Rcode != dns.RcodeSuccess {
+		t.Errorf("rcode = %s, want NOERROR", dns.RcodeToString[w.msg.Rcode])
+	}
+	if len(w.msg.Answer) != 0 {
+		t.Errorf("answer count = %d, want empty answer", len(w.msg.Answer))
+	}
+}
@@ -6,30 +6,144 @@ import (
 	"net"
 	"os/exec"
 	"strconv"
+	"strings"
 	"sync"
 
+	"golang.org/x/sys/unix"
+
 	"github.com/jery0843/torforge/pkg/config"
 	"github.com/jery0843/torforge/pkg/logger"
 )
 
+// nftTable is the dedicated nftables table holding our TProxy chains,
+// so rollback is a single table delete
+const nftTable = "torforge-tproxy"
+
+// commandRunner executes an external command. Injectable so rule
+// generation is unit-testable without CAP_NET_ADMIN.
+type commandRunner func(name string, args ...string) error
+
+func runCommand(name string, args ...string) error {
+	return exec.Command(name, args...).Run()
+}
+
+// outputRunner executes an external command and returns its combined
+// output. Injectable for the same reason as commandRunner.
+type outputRunner func(name string, args ...string) ([]byte, error)
+
+func runCommandOutput(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).CombinedOutput()
+}
+
 // TProxyManager manages TProxy rules for UDP transparent proxying
 type TProxyManager struct {
 	cfg       *config.TorConfig
+	mode      string // "iptables" or "nftables"
+	run       commandRunner
+	runOutput outputRunner
 	mu        sync.Mutex
 	active    bool
+	dryRun    bool
 	markValue int
 	tableID   int
 }
 
-// NewTProxyManager creates a new TProxy manager
+// NewTProxyManager creates a new TProxy manager using the iptables
+// backend
 func NewTProxyManager(cfg *config.TorConfig) *TProxyManager {
 	return &TProxyManager{
 		cfg:       cfg,
+		mode:      "iptables",
+		run:       runCommand,
+		runOutput: runCommandOutput,
 		markValue: 100, // Packet mark for routing
 		tableID:   100, // Custom routing table ID
 	}
 }
 
+// NewTProxyManagerWithMode creates a TProxy manager for the given proxy
+// mode, matching the config's "iptables"/"nftables" values
+func NewTProxyManagerWithMode(cfg *config.TorConfig, mode string) (*TProxyManager, error) {
+	if mode != "iptables" && mode != "nftables" {
+		return nil, fmt.Errorf("unsupported tproxy mode %q (want iptables or nftables)", mode)
+	}
+	t := NewTProxyManager(cfg)
+	t.mode = mode
+	return t, nil
+}
+
+// SetMark overrides the default packet mark, for hosts where another
+// tool already claims mark 100. Must be called before Apply.
+func (t *TProxyManager) SetMark(mark int) error {
+	if mark < 1 || mark > 0xFFFFFFFF {
+		return fmt.Errorf("tproxy mark %d out of range (1-4294967295)", mark)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.active {
+		return fmt.Errorf("cannot change mark while TProxy rules are active")
+	}
+	t.markValue = mark
+	return nil
+}
+
+// SetTableID overrides the default routing table, for hosts where
+// another tool already claims table 100. Must be called before Apply.
+// Tables 253-255 (and 0) are reserved by the kernel.
+func (t *TProxyManager) SetTableID(id int) error {
+	if id < 1 || id > 252 {
+		return fmt.Errorf("tproxy routing table %d out of range (1-252)", id)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.active {
+		return fmt.Errorf("cannot change routing table while TProxy rules are active")
+	}
+	t.tableID = id
+	return nil
+}
+
+// SetDryRun toggles dry-run mode: Apply logs the commands it would
+// execute and leaves the firewall (and active state) untouched
+func (t *TProxyManager) SetDryRun(enabled bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.dryRun = enabled
+}
+
+// DryRun returns the ordered commands Apply would execute, without
+// running any of them — handy for reviewing or pasting rules manually
+func (t *TProxyManager) DryRun() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.dryRunLocked()
+}
+
+// dryRunLocked collects the apply sequence by swapping in a recording
+// runner. Callers must hold the lock.
+func (t *TProxyManager) dryRunLocked() []string {
+	var cmds []string
+	saved := t.run
+	t.run = func(name string, args ...string) error {
+		cmds = append(cmds, name+" "+strings.Join(args, " "))
+		return nil
+	}
+	defer func() { t.run = saved }()
+
+	// The recording runner never fails, so neither do these
+	_ = t.setupRouting()
+	if t.mode == "nftables" {
+		_ = t.applyNftRules()
+	} else {
+		_ = t.applyMangleRules()
+	}
+	return cmds
+}
+
 // Apply sets up TProxy rules for UDP
 func (t *TProxyManager) Apply() error {
 	t.mu.Lock()
@@ -40,17 +154,31 @@ func (t *TProxyManager) Apply() error {
 	}
 
 	log := logger.WithComponent("tproxy")
-	log.Info().Msg("applying TProxy rules for UDP")
+
+	if t.dryRun {
+		for _, cmd := range t.dryRunLocked() {
+			log.Info().Str("cmd", cmd).Msg("dry-run: would execute")
+		}
+		return nil
+	}
+
+	log.Info().Str("mode", t.mode).Msg("applying TProxy rules for UDP")
 
 	// Step 1: Create routing policy for marked packets
 	if err := t.setupRouting(); err != nil {
 		return fmt.Errorf("failed to setup routing: %w", err)
 	}
 
-	// Step 2: Apply mangle rules for TProxy
-	if err := t.applyMangleRules(); err != nil {
-		t.Rollback()
-		return fmt.Errorf("failed to apply mangle rules: %w", err)
+	// Step 2: Apply mark/tproxy rules with the selected backend
+	var err error
+	if t.mode == "nftables" {
+		err = t.applyNftRules()
+	} else {
+		err = t.applyMangleRules()
+	}
+	if err != nil {
+		t.rollbackLocked()
+		return fmt.Errorf("failed to apply %s rules: %w", t.mode, err)
 	}
 
 	t.active = true
@@ -61,12 +189,12 @@ func (t *TProxyManager) Apply() error {
 // setupRouting configures routing for TProxy marked packets
 func (t *TProxyManager) setupRouting() error {
 	// Add routing rule: fwmark 100 lookup 100
-	if err := exec.Command("ip", "rule", "add", "fwmark", strconv.Itoa(t.markValue), "table", strconv.Itoa(t.tableID)).Run(); err != nil {
+	if err := t.run("ip", "rule", "add", "fwmark", strconv.Itoa(t.markValue), "table", strconv.Itoa(t.tableID)); err != nil {
 		return fmt.Errorf("failed to add ip rule: %w", err)
 	}
 
 	// Add route in custom table: route everything to localhost
-	if err := exec.Command("ip", "route", "add", "local", "0.0.0.0/0", "dev", "lo", "table", strconv.Itoa(t.tableID)).Run(); err != nil {
+	if err := t.run("ip", "route", "add", "local", "0.0.0.0/0", "dev", "lo", "table", strconv.Itoa(t.tableID)); err != nil {
 		return fmt.Errorf("failed to add route: %w", err)
 	}
 
@@ -85,7 +213,7 @@ func (t *TProxyManager) applyMangleRules() error {
 		"--tproxy-mark", fmt.Sprintf("%d/%d", t.markValue, t.markValue),
 	}
 
-	if err := exec.Command("iptables", markRule...).Run(); err != nil {
+	if err := t.run("iptables", markRule...); err != nil {
 		return fmt.Errorf("failed to add TPROXY rule: %w", err)
 	}
 
@@ -98,36 +226,76 @@ func (t *TProxyManager) applyMangleRules() error {
 		"-j", "MARK", "--set-mark", strconv.Itoa(t.markValue),
 	}
 
-	if err := exec.Command("iptables", outputMark...).Run(); err != nil {
+	if err := t.run("iptables", outputMark...); err != nil {
 		return fmt.Errorf("failed to add OUTPUT mark rule: %w", err)
 	}
 
 	return nil
 }
 
+// applyNftRules sets up the equivalent mark/tproxy rules with nftables,
+// in a dedicated table so rollback is one delete
+func (t *TProxyManager) applyNftRules() error {
+	mark := strconv.Itoa(t.markValue)
+	udpPort := strconv.Itoa(t.cfg.TransPort + 1) // Use TransPort+1 for UDP
+
+	cmds := [][]string{
+		{"add", "table", "ip", nftTable},
+		{"add", "chain", "ip", nftTable, "prerouting",
+			"{", "type", "filter", "hook", "prerouting", "priority", "mangle", ";", "}"},
+		{"add", "rule", "ip", nftTable, "prerouting",
+			"udp", "dport", "!=", "53",
+			"tproxy", "to", ":" + udpPort,
+			"meta", "mark", "set", mark, "accept"},
+		{"add", "chain", "ip", nftTable, "output",
+			"{", "type", "route", "hook", "output", "priority", "mangle", ";", "}"},
+		{"add", "rule", "ip", nftTable, "output",
+			"udp", "dport", "!=", "53",
+			"meta", "skuid", "!=", "0",
+			"meta", "mark", "set", mark},
+	}
+
+	for _, args := range cmds {
+		if err := t.run("nft", args...); err != nil {
+			return fmt.Errorf("nft %s failed: %w", strings.Join(args[:2], " "), err)
+		}
+	}
+	return nil
+}
+
 // Rollback removes TProxy rules
 func (t *TProxyManager) Rollback() error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
+	return t.rollbackLocked()
+}
 
+// rollbackLocked removes the rules for the active backend. Callers must
+// hold the lock.
+func (t *TProxyManager) rollbackLocked() error {
 	log := logger.WithComponent("tproxy")
-	log.Info().Msg("rolling back TProxy rules")
-
-	// Remove mangle rules
-	exec.Command("iptables", "-t", "mangle", "-D", "PREROUTING",
-		"-p", "udp", "!", "--dport", "53",
-		"-j", "TPROXY",
-		"--on-port", strconv.Itoa(t.cfg.TransPort+1),
-		"--tproxy-mark", fmt.Sprintf("%d/%d", t.markValue, t.markValue)).Run()
-
-	exec.Command("iptables", "-t", "mangle", "-D", "OUTPUT",
-		"-p", "udp", "!", "--dport", "53",
-		"-m", "owner", "!", "--uid-owner", "0",
-		"-j", "MARK", "--set-mark", strconv.Itoa(t.markValue)).Run()
+	log.Info().Str("mode", t.mode).Msg("rolling back TProxy rules")
+
+	if t.mode == "nftables" {
+		// The dedicated table takes every chain and rule with it
+		t.run("nft", "delete", "table", "ip", nftTable)
+	} else {
+		// Remove mangle rules
+		t.run("iptables", "-t", "mangle", "-D", "PREROUTING",
+			"-p", "udp", "!", "--dport", "53",
+			"-j", "TPROXY",
+			"--on-port", strconv.Itoa(t.cfg.TransPort+1),
+			"--tproxy-mark", fmt.Sprintf("%d/%d", t.markValue, t.markValue))
+
+		t.run("iptables", "-t", "mangle", "-D", "OUTPUT",
+			"-p", "udp", "!", "--dport", "53",
+			"-m", "owner", "!", "--uid-owner", "0",
+			"-j", "MARK", "--set-mark", strconv.Itoa(t.markValue))
+	}
 
 	// Remove routing
-	exec.Command("ip", "route", "del", "local", "0.0.0.0/0", "dev", "lo", "table", strconv.Itoa(t.tableID)).Run()
-	exec.Command("ip", "rule", "del", "fwmark", strconv.Itoa(t.markValue), "table", strconv.Itoa(t.tableID)).Run()
+	t.run("ip", "route", "del", "local", "0.0.0.0/0", "dev", "lo", "table", strconv.Itoa(t.tableID))
+	t.run("ip", "rule", "del", "fwmark", strconv.Itoa(t.markValue), "table", strconv.Itoa(t.tableID))
 
 	t.active = false
 	log.Info().Msg("TProxy rules rolled back")
@@ -141,6 +309,78 @@ func (t *TProxyManager) IsActive() bool {
 	return t.active
 }
 
+// TProxyStatus describes the manager's view of its own rule state
+type TProxyStatus struct {
+	Active  bool     `json:"active"`
+	Mode    string   `json:"mode"`
+	Mark    int      `json:"mark"`
+	TableID int      `json:"table_id"`
+	Rules   []string `json:"rules,omitempty"` // Commands used to install, when active
+}
+
+// Status reports whether rules are active and what the manager believes
+// it installed
+func (t *TProxyManager) Status() TProxyStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	status := TProxyStatus{
+		Active:  t.active,
+		Mode:    t.mode,
+		Mark:    t.markValue,
+		TableID: t.tableID,
+	}
+	if t.active {
+		status.Rules = t.dryRunLocked()
+	}
+	return status
+}
+
+// Verify checks that the rules Apply installed are still present, by
+// inspecting the live iptables/nftables and routing state. It catches
+// manual tampering or another tool flushing our chains. Returns nil
+// when everything expected is in place.
+func (t *TProxyManager) Verify() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.active {
+		return fmt.Errorf("TProxy rules are not active, nothing to verify")
+	}
+
+	var problems []string
+	check := func(desc, needle, name string, args ...string) {
+		out, err := t.runOutput(name, args...)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", desc, err))
+			return
+		}
+		if !strings.Contains(string(out), needle) {
+			problems = append(problems, fmt.Sprintf("%s missing (expected %q)", desc, needle))
+		}
+	}
+
+	udpPort := strconv.Itoa(t.cfg.TransPort + 1)
+	if t.mode == "nftables" {
+		check("nft tproxy rule", "tproxy to :"+udpPort,
+			"nft", "list", "table", "ip", nftTable)
+	} else {
+		check("iptables TPROXY rule", "--on-port "+udpPort,
+			"iptables", "-t", "mangle", "-S", "PREROUTING")
+		check("iptables OUTPUT mark rule", "-j MARK",
+			"iptables", "-t", "mangle", "-S", "OUTPUT")
+	}
+	check("routing policy rule", fmt.Sprintf("lookup %d", t.tableID),
+		"ip", "rule", "show")
+	check("local route", "local",
+		"ip", "route", "show", "table", strconv.Itoa(t.tableID))
+
+	if len(problems) > 0 {
+		return fmt.Errorf("TProxy rule verification failed: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
 // UDPProxyListener is a TProxy UDP listener
 type UDPProxyListener struct {
 	conn      *net.UDPConn
@@ -172,8 +412,11 @@ func NewUDPProxyListener(listenAddr, socksAddr string) (*UDPProxyListener, error
 
 	var setOptErr error
 	rawConn.Control(func(fd uintptr) {
-		// SOL_IP = 0, IP_TRANSPARENT = 19
-		setOptErr = setSocketOption(int(fd), 0, 19, 1)
+		setOptErr = setSocketOption(int(fd), unix.SOL_IP, unix.IP_TRANSPARENT, 1)
+		if setOptErr == nil && addr.IP != nil && addr.IP.To4() == nil {
+			// Dual-stack and v6 listeners also need the v6 flavor
+			setOptErr = setSocketOption(int(fd), unix.SOL_IPV6, unix.IPV6_TRANSPARENT, 1)
+		}
 	})
 
 	if setOptErr != nil {
@@ -245,10 +488,11 @@ func (u *UDPProxyListener) Stop() error {
 	return nil
 }
 
-// setSocketOption sets a socket option
+// setSocketOption sets an integer socket option directly via the
+// syscall interface
 func setSocketOption(fd, level, opt, value int) error {
-	// Use syscall to set socket option
-	return exec.Command("sh", "-c",
-		fmt.Sprintf("python3 -c 'import socket; s=socket.fromfd(%d, socket.AF_INET, socket.SOCK_DGRAM); s.setsockopt(%d, %d, %d)'",
-			fd, level, opt, value)).Run()
+	if err := unix.SetsockoptInt(fd, level, opt, value); err != nil {
+		return fmt.Errorf("setsockopt(%d, %d, %d): %w", level, opt, value, err)
+	}
+	return nil
 }
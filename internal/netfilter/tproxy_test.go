@@ -1,8 +1,11 @@
 package netfilter
 
 import (
+	"strings"
 	"testing"
 
+	"golang.org/x/sys/unix"
+
 	"github.com/jery0843/torforge/pkg/config"
 )
 
@@ -109,3 +112,342 @@ func TestSetSocketOption(t *testing.T) {
 		_ = setSocketOption(-1, 0, 19, 1)
 	})
 }
+
+// recordingRunner captures the commands a TProxy backend generates
+// instead of executing them
+type recordingRunner struct {
+	commands [][]string
+}
+
+func (r *recordingRunner) run(name string, args ...string) error {
+	r.commands = append(r.commands, append([]string{name}, args...))
+	return nil
+}
+
+func (r *recordingRunner) contains(want ...string) bool {
+	for _, cmd := range r.commands {
+		if strings.Join(cmd, " ") == strings.Join(want, " ") {
+			return true
+		}
+	}
+	return false
+}
+
+// TestTProxyManagerInvalidMode tests mode validation
+func TestTProxyManagerInvalidMode(t *testing.T) {
+	if _, err := NewTProxyManagerWithMode(&config.TorConfig{}, "ebpf"); err == nil {
+		t.Error("expected error for unsupported mode")
+	}
+}
+
+// TestTProxyNftablesApply tests the rules generated by the nftables
+// backend without requiring CAP_NET_ADMIN
+func TestTProxyNftablesApply(t *testing.T) {
+	mgr, err := NewTProxyManagerWithMode(&config.TorConfig{TransPort: 9040}, "nftables")
+	if err != nil {
+		t.Fatalf("NewTProxyManagerWithMode() error = %v", err)
+	}
+	rec := &recordingRunner{}
+	mgr.run = rec.run
+
+	if err := mgr.Apply(); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if !mgr.IsActive() {
+		t.Error("manager not active after Apply()")
+	}
+
+	// Same routing setup as the iptables backend, defaults 100/100
+	if !rec.contains("ip", "rule", "add", "fwmark", "100", "table", "100") {
+		t.Errorf("missing ip rule command, got %v", rec.commands)
+	}
+	if !rec.contains("ip", "route", "add", "local", "0.0.0.0/0", "dev", "lo", "table", "100") {
+		t.Errorf("missing ip route command, got %v", rec.commands)
+	}
+
+	// The tproxy rule targets TransPort+1 with the default mark
+	var sawTproxy bool
+	for _, cmd := range rec.commands {
+		line := strings.Join(cmd, " ")
+		if strings.HasPrefix(line, "nft add rule ip torforge-tproxy prerouting") &&
+			strings.Contains(line, "tproxy to :9041") &&
+			strings.Contains(line, "meta mark set 100") {
+			sawTproxy = true
+		}
+	}
+	if !sawTproxy {
+		t.Errorf("missing nft tproxy rule, got %v", rec.commands)
+	}
+}
+
+// TestTProxyNftablesRollback tests that rollback deletes the dedicated
+// table and the routing entries
+func TestTProxyNftablesRollback(t *testing.T) {
+	mgr, err := NewTProxyManagerWithMode(&config.TorConfig{TransPort: 9040}, "nftables")
+	if err != nil {
+		t.Fatalf("NewTProxyManagerWithMode() error = %v", err)
+	}
+	rec := &recordingRunner{}
+	mgr.run = rec.run
+
+	if err := mgr.Apply(); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	rec.commands = nil
+
+	if err := mgr.Rollback(); err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+	if mgr.IsActive() {
+		t.Error("manager still active after Rollback()")
+	}
+	if !rec.contains("nft", "delete", "table", "ip", "torforge-tproxy") {
+		t.Errorf("missing nft table delete, got %v", rec.commands)
+	}
+	if !rec.contains("ip", "rule", "del", "fwmark", "100", "table", "100") {
+		t.Errorf("missing ip rule del, got %v", rec.commands)
+	}
+}
+
+// TestTProxyIptablesApplyCommands tests the iptables backend's generated
+// commands through the same injectable runner
+func TestTProxyIptablesApplyCommands(t *testing.T) {
+	mgr := NewTProxyManager(&config.TorConfig{TransPort: 9040})
+	rec := &recordingRunner{}
+	mgr.run = rec.run
+
+	if err := mgr.Apply(); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	var sawTproxy bool
+	for _, cmd := range rec.commands {
+		line := strings.Join(cmd, " ")
+		if strings.HasPrefix(line, "iptables -t mangle -A PREROUTING") &&
+			strings.Contains(line, "--on-port 9041") &&
+			strings.Contains(line, "--tproxy-mark 100/100") {
+			sawTproxy = true
+		}
+	}
+	if !sawTproxy {
+		t.Errorf("missing iptables TPROXY rule, got %v", rec.commands)
+	}
+}
+
+// TestTProxySetMarkAndTable tests that overridden mark/table values show
+// up in the generated rules
+func TestTProxySetMarkAndTable(t *testing.T) {
+	mgr := NewTProxyManager(&config.TorConfig{TransPort: 9040})
+	if err := mgr.SetMark(200); err != nil {
+		t.Fatalf("SetMark(200) error = %v", err)
+	}
+	if err := mgr.SetTableID(201); err != nil {
+		t.Fatalf("SetTableID(201) error = %v", err)
+	}
+
+	rec := &recordingRunner{}
+	mgr.run = rec.run
+	if err := mgr.Apply(); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	if !rec.contains("ip", "rule", "add", "fwmark", "200", "table", "201") {
+		t.Errorf("ip rule missing overridden mark/table, got %v", rec.commands)
+	}
+	var sawMark bool
+	for _, cmd := range rec.commands {
+		if strings.Contains(strings.Join(cmd, " "), "--tproxy-mark 200/200") {
+			sawMark = true
+		}
+	}
+	if !sawMark {
+		t.Errorf("TPROXY rule missing overridden mark, got %v", rec.commands)
+	}
+
+	rec.commands = nil
+	if err := mgr.Rollback(); err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+	if !rec.contains("ip", "rule", "del", "fwmark", "200", "table", "201") {
+		t.Errorf("rollback missing overridden mark/table, got %v", rec.commands)
+	}
+}
+
+// TestTProxySetMarkValidation tests range and lifecycle checks
+func TestTProxySetMarkValidation(t *testing.T) {
+	mgr := NewTProxyManager(&config.TorConfig{TransPort: 9040})
+
+	if err := mgr.SetMark(0); err == nil {
+		t.Error("SetMark(0) expected error")
+	}
+	if err := mgr.SetTableID(0); err == nil {
+		t.Error("SetTableID(0) expected error")
+	}
+	if err := mgr.SetTableID(253); err == nil {
+		t.Error("SetTableID(253) expected error for reserved table")
+	}
+
+	rec := &recordingRunner{}
+	mgr.run = rec.run
+	if err := mgr.Apply(); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if err := mgr.SetMark(200); err == nil {
+		t.Error("SetMark while active expected error")
+	}
+	if err := mgr.SetTableID(201); err == nil {
+		t.Error("SetTableID while active expected error")
+	}
+}
+
+// TestTProxyDryRun tests that DryRun lists the apply commands without
+// executing anything
+func TestTProxyDryRun(t *testing.T) {
+	// TransPort 9039 puts the UDP TPROXY listener on 9040
+	mgr := NewTProxyManager(&config.TorConfig{TransPort: 9039})
+	rec := &recordingRunner{}
+	mgr.run = rec.run
+
+	cmds := mgr.DryRun()
+	if len(cmds) == 0 {
+		t.Fatal("DryRun() returned no commands")
+	}
+	var sawTproxy bool
+	for _, cmd := range cmds {
+		if strings.Contains(cmd, "-j TPROXY") && strings.Contains(cmd, "--on-port 9040") {
+			sawTproxy = true
+		}
+	}
+	if !sawTproxy {
+		t.Errorf("dry-run output missing TPROXY rule, got %v", cmds)
+	}
+	if len(rec.commands) != 0 {
+		t.Errorf("DryRun() executed %d commands", len(rec.commands))
+	}
+}
+
+// TestTProxyDryRunApply tests that Apply in dry-run mode skips execution
+// and leaves the manager inactive
+func TestTProxyDryRunApply(t *testing.T) {
+	mgr := NewTProxyManager(&config.TorConfig{TransPort: 9040})
+	rec := &recordingRunner{}
+	mgr.run = rec.run
+	mgr.SetDryRun(true)
+
+	if err := mgr.Apply(); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if mgr.IsActive() {
+		t.Error("manager active after dry-run Apply")
+	}
+	if len(rec.commands) != 0 {
+		t.Errorf("dry-run Apply executed %d commands", len(rec.commands))
+	}
+
+	// Turning dry-run off restores normal behavior
+	mgr.SetDryRun(false)
+	if err := mgr.Apply(); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if !mgr.IsActive() {
+		t.Error("manager not active after real Apply")
+	}
+}
+
+// TestSetSocketOptionReuseAddr proves the native setsockopt path works
+// by setting SO_REUSEADDR on a real socket and reading it back
+func TestSetSocketOptionReuseAddr(t *testing.T) {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM, 0)
+	if err != nil {
+		t.Fatalf("failed to create socket: %v", err)
+	}
+	defer unix.Close(fd)
+
+	if err := setSocketOption(fd, unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err != nil {
+		t.Fatalf("setSocketOption(SO_REUSEADDR) error = %v", err)
+	}
+
+	value, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEADDR)
+	if err != nil {
+		t.Fatalf("getsockopt failed: %v", err)
+	}
+	if value == 0 {
+		t.Error("SO_REUSEADDR not set after setSocketOption")
+	}
+
+	// Invalid fds error instead of panicking
+	if err := setSocketOption(-1, unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err == nil {
+		t.Error("setSocketOption(-1) expected error")
+	}
+}
+
+// TestTProxyStatus tests the status report before and after Apply
+func TestTProxyStatus(t *testing.T) {
+	mgr := NewTProxyManager(&config.TorConfig{TransPort: 9040})
+	rec := &recordingRunner{}
+	mgr.run = rec.run
+
+	status := mgr.Status()
+	if status.Active || status.Rules != nil {
+		t.Errorf("inactive status = %+v, want inactive with no rules", status)
+	}
+	if status.Mode != "iptables" || status.Mark != 100 || status.TableID != 100 {
+		t.Errorf("status = %+v, want iptables/100/100", status)
+	}
+
+	if err := mgr.Apply(); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	status = mgr.Status()
+	if !status.Active || len(status.Rules) == 0 {
+		t.Errorf("active status = %+v, want active with installed rules", status)
+	}
+}
+
+// fakeOutputRunner serves canned output per command name
+type fakeOutputRunner struct {
+	outputs map[string]string
+}
+
+func (f *fakeOutputRunner) run(name string, args ...string) ([]byte, error) {
+	return []byte(f.outputs[name]), nil
+}
+
+// TestTProxyVerify tests detection of missing rules via the injectable
+// output runner
+func TestTProxyVerify(t *testing.T) {
+	mgr := NewTProxyManager(&config.TorConfig{TransPort: 9040})
+	rec := &recordingRunner{}
+	mgr.run = rec.run
+
+	if err := mgr.Verify(); err == nil {
+		t.Error("Verify() before Apply expected error")
+	}
+
+	if err := mgr.Apply(); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	healthy := &fakeOutputRunner{outputs: map[string]string{
+		"iptables": "-A PREROUTING -p udp ! --dport 53 -j TPROXY --on-port 9041 --tproxy-mark 0x64/0x64\n-A OUTPUT -p udp -j MARK --set-xmark 0x64\n",
+		"ip":       "32765: from all fwmark 0x64 lookup 100\nlocal default dev lo scope host\n",
+	}}
+	mgr.runOutput = healthy.run
+	if err := mgr.Verify(); err != nil {
+		t.Errorf("Verify() with intact rules error = %v", err)
+	}
+
+	// Someone flushed the mangle table: the TPROXY rule is gone
+	tampered := &fakeOutputRunner{outputs: map[string]string{
+		"iptables": "-P PREROUTING ACCEPT\n",
+		"ip":       "32765: from all fwmark 0x64 lookup 100\nlocal default dev lo scope host\n",
+	}}
+	mgr.runOutput = tampered.run
+	err := mgr.Verify()
+	if err == nil {
+		t.Fatal("Verify() with missing TPROXY rule expected error")
+	}
+	if !strings.Contains(err.Error(), "TPROXY rule") {
+		t.Errorf("Verify() error = %v, want mention of the missing TPROXY rule", err)
+	}
+}
@@ -69,15 +69,16 @@ func New(cfg *config.Config) (*Proxy, error) {
 		return nil, fmt.Errorf("failed to create iptables manager: %w", err)
 	}
 
-	// Create DNS resolver
-	dnsResolver := netfilter.NewDNSResolver(&cfg.Tor, &cfg.Bypass)
-
 	// Create bypass engine
 	bypassEng, err := bypass.NewEngine(&cfg.Bypass)
 	if err != nil {
 		log.Warn().Err(err).Msg("failed to create bypass engine, continuing without")
 	}
 
+	// Create DNS resolver; it shares the bypass engine so DNS decisions
+	// match the rest of the system
+	dnsResolver := netfilter.NewDNSResolver(&cfg.Tor, &cfg.Bypass, bypassEng, nil)
+
 	// Initialize AI modules
 	aiDataDir := "/var/lib/torforge/ai"
 	circuitAI := ai.NewSmartCircuitSelector(aiDataDir)
@@ -4,15 +4,35 @@ package security
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/ecdh"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
+	"os"
 	"sync"
+	"time"
 
+	"github.com/cloudflare/circl/kem"
+	"github.com/cloudflare/circl/kem/kyber/kyber1024"
+	"github.com/cloudflare/circl/kem/kyber/kyber512"
 	"github.com/cloudflare/circl/kem/kyber/kyber768"
 	"github.com/jery0843/torforge/pkg/logger"
 	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// x25519KeySize is the length of an X25519 public key appended to the
+// Kyber material in hybrid mode
+const x25519KeySize = 32
+
+// Supported symmetric AEADs for the shared-secret channel cipher
+const (
+	aeadAESGCM   = "aes-gcm"
+	aeadChaCha20 = "chacha20poly1305"
 )
 
 // Argon2id parameters (OWASP recommended for password hashing)
@@ -29,6 +49,8 @@ type PostQuantumConfig struct {
 	Enabled   bool   `yaml:"enabled"`
 	Algorithm string `yaml:"algorithm"` // "kyber768"
 	Password  string `yaml:"password"`  // Password for persistent file encryption
+	Hybrid    bool   `yaml:"hybrid"`    // Combine X25519 ECDH with Kyber via HKDF
+	AEAD      string `yaml:"aead"`      // "aes-gcm" (default) or "chacha20poly1305"
 }
 
 // QuantumResistantLayer provides an additional encryption layer
@@ -38,9 +60,20 @@ type QuantumResistantLayer struct {
 	enabled   bool
 	algorithm string
 
-	// Kyber key pair (using real Kyber768)
-	publicKey  *kyber768.PublicKey
-	privateKey *kyber768.PrivateKey
+	// Kyber KEM scheme and key pair; the scheme decides the security
+	// level (Kyber512/768/1024 → NIST 1/3/5)
+	scheme     kem.Scheme
+	nistLevel  int
+	publicKey  kem.PublicKey
+	privateKey kem.PrivateKey
+
+	// Hybrid mode: classical X25519 key agreed alongside Kyber, with
+	// both secrets fed through HKDF. Pure post-quantum KEMs are not
+	// trusted on their own yet.
+	hybrid      bool
+	ecdhKey     *ecdh.PrivateKey
+	kyberSecret []byte // Kyber half of the hybrid secret
+	ecdhSecret  []byte // X25519 half of the hybrid secret
 
 	// Ciphertext from key encapsulation
 	ciphertext []byte
@@ -48,12 +81,17 @@ type QuantumResistantLayer struct {
 	// Shared secret for symmetric encryption
 	sharedSecret []byte
 	cipher       cipher.AEAD
+	aeadName     string // "aes-gcm" or "chacha20poly1305"
 
 	// Password-derived cipher for persistent file encryption
 	passwordSet    bool
 	passwordSalt   []byte // Random salt for Argon2id
 	passwordRaw    string // Raw password for key re-derivation on decrypt
 	passwordCipher cipher.AEAD
+
+	// Automatic key rotation
+	rotateStop chan struct{}         // Non-nil while the rotation loop runs
+	onRotate   func(newKeyID string) // Invoked after each automatic rotation
 }
 
 // NewQuantumResistantLayer creates a new post-quantum encryption layer
@@ -64,9 +102,25 @@ func NewQuantumResistantLayer(cfg *PostQuantumConfig) (*QuantumResistantLayer, e
 		return &QuantumResistantLayer{enabled: false}, nil
 	}
 
+	scheme, nistLevel, err := selectKyberScheme(cfg.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	aeadName, err := selectAEAD(cfg.AEAD)
+	if err != nil {
+		return nil, err
+	}
+
 	q := &QuantumResistantLayer{
 		enabled:   true,
-		algorithm: "CRYSTALS-Kyber768",
+		scheme:    scheme,
+		nistLevel: nistLevel,
+		algorithm: "CRYSTALS-" + scheme.Name(),
+		hybrid:    cfg.Hybrid,
+		aeadName:  aeadName,
+	}
+	if q.hybrid {
+		q.algorithm = "X25519+" + q.algorithm
 	}
 
 	// Generate Kyber key pair
@@ -76,15 +130,33 @@ func NewQuantumResistantLayer(cfg *PostQuantumConfig) (*QuantumResistantLayer, e
 
 	log.Info().
 		Str("algorithm", q.algorithm).
-		Msg("🔐 Post-quantum encryption layer initialized with REAL CRYSTALS-Kyber768")
+		Int("nist_level", q.nistLevel).
+		Bool("hybrid", q.hybrid).
+		Str("aead", q.aeadName).
+		Msg("🔐 Post-quantum encryption layer initialized with REAL CRYSTALS-Kyber")
 
 	return q, nil
 }
 
-// generateKyberKeyPair generates a real CRYSTALS-Kyber768 key pair
+// selectKyberScheme maps the configured algorithm name to a KEM scheme
+// and its NIST security level. An empty name keeps the Kyber768 default.
+func selectKyberScheme(algorithm string) (kem.Scheme, int, error) {
+	switch algorithm {
+	case "", "kyber768":
+		return kyber768.Scheme(), 3, nil
+	case "kyber512":
+		return kyber512.Scheme(), 1, nil
+	case "kyber1024":
+		return kyber1024.Scheme(), 5, nil
+	default:
+		return nil, 0, fmt.Errorf("unknown post-quantum algorithm %q (want kyber512, kyber768, or kyber1024)", algorithm)
+	}
+}
+
+// generateKyberKeyPair generates a real CRYSTALS-Kyber key pair at the
+// configured security level
 func (q *QuantumResistantLayer) generateKyberKeyPair() error {
-	// Generate Kyber768 key pair (NIST Level 3 security)
-	pub, priv, err := kyber768.GenerateKeyPair(rand.Reader)
+	pub, priv, err := q.scheme.GenerateKeyPair()
 	if err != nil {
 		return err
 	}
@@ -93,40 +165,259 @@ func (q *QuantumResistantLayer) generateKyberKeyPair() error {
 	q.privateKey = priv
 
 	// Perform key encapsulation to derive shared secret
-	// EncapsulateTo fills ciphertext and shared secret
-	ct := make([]byte, kyber768.CiphertextSize)
-	ss := make([]byte, kyber768.SharedKeySize)
-
-	pub.EncapsulateTo(ct, ss, nil)
+	ct, ss, err := q.scheme.Encapsulate(pub)
+	if err != nil {
+		return err
+	}
 
 	// Store ciphertext
 	q.ciphertext = ct
 
 	// Verify we can decapsulate
-	ssCheck := make([]byte, kyber768.SharedKeySize)
-	priv.DecapsulateTo(ssCheck, ct)
+	ssCheck, err := q.scheme.Decapsulate(priv, ct)
+	if err != nil {
+		return err
+	}
 
 	// Compare shared secrets
 	if !compareBytes(ss, ssCheck) {
 		return fmt.Errorf("kyber key exchange verification failed")
 	}
 
-	q.sharedSecret = ss
+	q.kyberSecret = ss
+
+	if !q.hybrid {
+		return q.setSharedSecretLocked(ss)
+	}
 
-	// Create AES-256-GCM cipher using the Kyber-derived shared secret
-	block, err := aes.NewCipher(q.sharedSecret)
+	// Hybrid: agree an X25519 secret locally the same way the Kyber
+	// half is self-encapsulated, then combine both halves
+	ecdhKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	ecdhSS, err := ecdhKey.ECDH(ephemeral.PublicKey())
 	if err != nil {
 		return err
 	}
 
-	q.cipher, err = cipher.NewGCM(block)
+	q.ecdhKey = ecdhKey
+	q.ecdhSecret = ecdhSS
+
+	combined, err := deriveHybridKey(q.kyberSecret, q.ecdhSecret)
 	if err != nil {
 		return err
 	}
+	return q.setSharedSecretLocked(combined)
+}
+
+// deriveHybridKey combines the Kyber and X25519 shared secrets into one
+// AES-256 key via HKDF-SHA256, so the result is safe as long as either
+// half holds up
+func deriveHybridKey(kyberSS, ecdhSS []byte) ([]byte, error) {
+	ikm := make([]byte, 0, len(kyberSS)+len(ecdhSS))
+	ikm = append(ikm, kyberSS...)
+	ikm = append(ikm, ecdhSS...)
+
+	key := make([]byte, 32)
+	reader := hkdf.New(sha256.New, ikm, nil, []byte("torforge hybrid x25519+kyber768 v1"))
+	if _, err := io.ReadFull(reader, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// selectAEAD validates the configured AEAD name. An empty name keeps
+// the AES-256-GCM default; ChaCha20-Poly1305 suits hosts without AES
+// hardware acceleration.
+func selectAEAD(name string) (string, error) {
+	switch name {
+	case "", aeadAESGCM:
+		return aeadAESGCM, nil
+	case aeadChaCha20:
+		return aeadChaCha20, nil
+	default:
+		return "", fmt.Errorf("unknown AEAD %q (want %s or %s)", name, aeadAESGCM, aeadChaCha20)
+	}
+}
+
+// setSharedSecretLocked installs a shared secret and derives the
+// configured AEAD from it. Both ciphers take the 32-byte Kyber-derived
+// key directly. Callers hold the write lock.
+func (q *QuantumResistantLayer) setSharedSecretLocked(ss []byte) error {
+	var (
+		aead cipher.AEAD
+		err  error
+	)
+	switch q.aeadName {
+	case aeadChaCha20:
+		aead, err = chacha20poly1305.New(ss)
+	default:
+		var block cipher.Block
+		if block, err = aes.NewCipher(ss); err == nil {
+			aead, err = cipher.NewGCM(block)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	q.sharedSecret = ss
+	q.cipher = aead
+	return nil
+}
+
+// PublicKeyBytes returns the packed Kyber public key to hand to a peer,
+// or nil when the layer is disabled
+func (q *QuantumResistantLayer) PublicKeyBytes() []byte {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if !q.enabled || q.publicKey == nil {
+		return nil
+	}
+
+	buf, err := q.publicKey.MarshalBinary()
+	if err != nil {
+		return nil
+	}
+	if q.hybrid && q.ecdhKey != nil {
+		buf = append(buf, q.ecdhKey.PublicKey().Bytes()...)
+	}
+	return buf
+}
+
+// Encapsulate runs the initiator side of the key exchange: it
+// encapsulates to the peer's public key, derives the AEAD from the
+// resulting shared secret, and returns the ciphertext to send to the
+// peer
+func (q *QuantumResistantLayer) Encapsulate(peerPub []byte) ([]byte, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.enabled {
+		return nil, fmt.Errorf("quantum layer not enabled")
+	}
+
+	kyberPubSize := q.scheme.PublicKeySize()
+	wantLen := kyberPubSize
+	if q.hybrid {
+		wantLen += x25519KeySize
+	}
+	if len(peerPub) != wantLen {
+		return nil, fmt.Errorf("peer public key must be %d bytes, got %d", wantLen, len(peerPub))
+	}
+
+	pub, err := q.scheme.UnmarshalBinaryPublicKey(peerPub[:kyberPubSize])
+	if err != nil {
+		return nil, fmt.Errorf("peer Kyber key: %w", err)
+	}
+
+	ct, ss, err := q.scheme.Encapsulate(pub)
+	if err != nil {
+		return nil, err
+	}
+	q.kyberSecret = ss
+
+	key := ss
+	if q.hybrid {
+		// Agree the classical half against the peer's X25519 key and
+		// ship our own public alongside the Kyber ciphertext
+		peerECDH, err := ecdh.X25519().NewPublicKey(peerPub[kyberPubSize:])
+		if err != nil {
+			return nil, fmt.Errorf("peer X25519 key: %w", err)
+		}
+		q.ecdhSecret, err = q.ecdhKey.ECDH(peerECDH)
+		if err != nil {
+			return nil, err
+		}
+		key, err = deriveHybridKey(q.kyberSecret, q.ecdhSecret)
+		if err != nil {
+			return nil, err
+		}
+		ct = append(ct, q.ecdhKey.PublicKey().Bytes()...)
+	}
+
+	if err := q.setSharedSecretLocked(key); err != nil {
+		return nil, err
+	}
+
+	q.ciphertext = ct
+	return ct, nil
+}
+
+// Decapsulate runs the responder side: it recovers the shared secret
+// from a ciphertext the initiator produced against our public key and
+// derives the same AEAD
+func (q *QuantumResistantLayer) Decapsulate(ciphertext []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.enabled {
+		return fmt.Errorf("quantum layer not enabled")
+	}
+	if q.privateKey == nil {
+		return fmt.Errorf("no private key available")
+	}
+
+	kyberCTSize := q.scheme.CiphertextSize()
+	wantLen := kyberCTSize
+	if q.hybrid {
+		wantLen += x25519KeySize
+	}
+	if len(ciphertext) != wantLen {
+		return fmt.Errorf("ciphertext must be %d bytes, got %d", wantLen, len(ciphertext))
+	}
+
+	ss, err := q.scheme.Decapsulate(q.privateKey, ciphertext[:kyberCTSize])
+	if err != nil {
+		return err
+	}
+	q.kyberSecret = ss
+
+	key := ss
+	if q.hybrid {
+		peerECDH, err := ecdh.X25519().NewPublicKey(ciphertext[kyberCTSize:])
+		if err != nil {
+			return fmt.Errorf("initiator X25519 key: %w", err)
+		}
+		q.ecdhSecret, err = q.ecdhKey.ECDH(peerECDH)
+		if err != nil {
+			return err
+		}
+		key, err = deriveHybridKey(q.kyberSecret, q.ecdhSecret)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := q.setSharedSecretLocked(key); err != nil {
+		return err
+	}
 
+	q.ciphertext = append([]byte(nil), ciphertext...)
 	return nil
 }
 
+// HandshakeTranscript returns the public handshake material — our
+// packed public keys followed by the last encapsulation ciphertext —
+// for binding into channel authentication
+func (q *QuantumResistantLayer) HandshakeTranscript() []byte {
+	pub := q.PublicKeyBytes()
+
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	transcript := make([]byte, 0, len(pub)+len(q.ciphertext))
+	transcript = append(transcript, pub...)
+	transcript = append(transcript, q.ciphertext...)
+	return transcript
+}
+
 // SetPassword sets a password for persistent file encryption
 // This allows encrypted files to be decrypted later with the same password
 // Uses Argon2id (OWASP recommended) for key derivation
@@ -277,6 +568,13 @@ func compareBytes(a, b []byte) bool {
 
 // Encrypt encrypts data with the quantum-resistant layer
 func (q *QuantumResistantLayer) Encrypt(plaintext []byte) ([]byte, error) {
+	return q.EncryptWithAAD(plaintext, nil)
+}
+
+// EncryptWithAAD encrypts data and binds it to the given associated
+// data (e.g. a circuit ID); decryption then fails unless the same AAD
+// is presented
+func (q *QuantumResistantLayer) EncryptWithAAD(plaintext, aad []byte) ([]byte, error) {
 	q.mu.RLock()
 	defer q.mu.RUnlock()
 
@@ -293,12 +591,18 @@ func (q *QuantumResistantLayer) Encrypt(plaintext []byte) ([]byte, error) {
 		return nil, err
 	}
 
-	ciphertext := q.cipher.Seal(nonce, nonce, plaintext, nil)
+	ciphertext := q.cipher.Seal(nonce, nonce, plaintext, aad)
 	return ciphertext, nil
 }
 
 // Decrypt decrypts data with the quantum-resistant layer
 func (q *QuantumResistantLayer) Decrypt(ciphertext []byte) ([]byte, error) {
+	return q.DecryptWithAAD(ciphertext, nil)
+}
+
+// DecryptWithAAD decrypts data encrypted with EncryptWithAAD,
+// authenticating the associated data along the way
+func (q *QuantumResistantLayer) DecryptWithAAD(ciphertext, aad []byte) ([]byte, error) {
 	q.mu.RLock()
 	defer q.mu.RUnlock()
 
@@ -317,7 +621,7 @@ func (q *QuantumResistantLayer) Decrypt(ciphertext []byte) ([]byte, error) {
 	nonce := ciphertext[:q.cipher.NonceSize()]
 	encrypted := ciphertext[q.cipher.NonceSize():]
 
-	plaintext, err := q.cipher.Open(nil, nonce, encrypted, nil)
+	plaintext, err := q.cipher.Open(nil, nonce, encrypted, aad)
 	if err != nil {
 		return nil, err
 	}
@@ -325,6 +629,182 @@ func (q *QuantumResistantLayer) Decrypt(ciphertext []byte) ([]byte, error) {
 	return plaintext, nil
 }
 
+// keyFile is the serialized form of the layer's key material. Secrets
+// are included so the AEAD survives a restart without a new handshake.
+type keyFile struct {
+	Algorithm    string `json:"algorithm"` // Config name: kyber512/768/1024
+	Hybrid       bool   `json:"hybrid"`
+	AEAD         string `json:"aead,omitempty"`
+	PublicKey    []byte `json:"public_key"`
+	PrivateKey   []byte `json:"private_key"`
+	ECDHPrivate  []byte `json:"ecdh_private,omitempty"`
+	KyberSecret  []byte `json:"kyber_secret,omitempty"`
+	ECDHSecret   []byte `json:"ecdh_secret,omitempty"`
+	SharedSecret []byte `json:"shared_secret,omitempty"`
+	Ciphertext   []byte `json:"ciphertext,omitempty"`
+}
+
+// keyFileEnvelope wraps the key material on disk so LoadKeys can tell
+// whether the payload is passphrase-encrypted
+type keyFileEnvelope struct {
+	Version   int    `json:"version"`
+	Encrypted bool   `json:"encrypted"`
+	Data      []byte `json:"data"`
+}
+
+// configAlgorithmName maps a NIST level back to the config-file
+// algorithm name so a loaded key selects the same scheme
+func configAlgorithmName(nistLevel int) string {
+	return map[int]string{1: "kyber512", 3: "kyber768", 5: "kyber1024"}[nistLevel]
+}
+
+// SaveKeys writes the key material to path with 0600 permissions. When
+// a password is set via SetPassword the payload is Argon2id-encrypted;
+// otherwise it is stored in the clear, so the file must be protected by
+// filesystem permissions alone.
+func (q *QuantumResistantLayer) SaveKeys(path string) error {
+	q.mu.RLock()
+	if !q.enabled || q.privateKey == nil {
+		q.mu.RUnlock()
+		return fmt.Errorf("quantum layer not enabled")
+	}
+
+	kf := keyFile{
+		Algorithm:    configAlgorithmName(q.nistLevel),
+		Hybrid:       q.hybrid,
+		AEAD:         q.aeadName,
+		KyberSecret:  q.kyberSecret,
+		ECDHSecret:   q.ecdhSecret,
+		SharedSecret: q.sharedSecret,
+		Ciphertext:   q.ciphertext,
+	}
+	var err error
+	if kf.PublicKey, err = q.publicKey.MarshalBinary(); err != nil {
+		q.mu.RUnlock()
+		return fmt.Errorf("marshaling public key: %w", err)
+	}
+	if kf.PrivateKey, err = q.privateKey.MarshalBinary(); err != nil {
+		q.mu.RUnlock()
+		return fmt.Errorf("marshaling private key: %w", err)
+	}
+	if q.hybrid && q.ecdhKey != nil {
+		kf.ECDHPrivate = q.ecdhKey.Bytes()
+	}
+	encrypted := q.passwordSet
+	q.mu.RUnlock()
+
+	payload, err := json.Marshal(kf)
+	if err != nil {
+		return err
+	}
+	if encrypted {
+		if payload, err = q.EncryptWithPassword(payload); err != nil {
+			return fmt.Errorf("encrypting key file: %w", err)
+		}
+	}
+
+	envelope, err := json.MarshalIndent(keyFileEnvelope{Version: 1, Encrypted: encrypted, Data: payload}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, envelope, 0600); err != nil {
+		return fmt.Errorf("writing key file: %w", err)
+	}
+
+	log := logger.WithComponent("quantum")
+	log.Info().
+		Str("path", path).
+		Bool("encrypted", encrypted).
+		Msg("quantum key material saved")
+	return nil
+}
+
+// LoadKeys restores key material saved by SaveKeys, replacing the
+// layer's current keys and re-deriving the AEAD so ciphertext produced
+// before the restart still decrypts. Passphrase-encrypted files require
+// the same password set via SetPassword first.
+func (q *QuantumResistantLayer) LoadKeys(path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading key file: %w", err)
+	}
+
+	var envelope keyFileEnvelope
+	if err := json.Unmarshal(raw, &envelope); err != nil {
+		return fmt.Errorf("parsing key file %s: %w", path, err)
+	}
+
+	payload := envelope.Data
+	if envelope.Encrypted {
+		if !q.HasPassword() {
+			return fmt.Errorf("key file %s is passphrase-encrypted; call SetPassword first", path)
+		}
+		if payload, err = q.DecryptWithPassword(payload); err != nil {
+			return fmt.Errorf("decrypting key file (wrong password?): %w", err)
+		}
+	}
+
+	var kf keyFile
+	if err := json.Unmarshal(payload, &kf); err != nil {
+		return fmt.Errorf("parsing key material: %w", err)
+	}
+
+	scheme, nistLevel, err := selectKyberScheme(kf.Algorithm)
+	if err != nil {
+		return err
+	}
+	aeadName, err := selectAEAD(kf.AEAD)
+	if err != nil {
+		return err
+	}
+	pub, err := scheme.UnmarshalBinaryPublicKey(kf.PublicKey)
+	if err != nil {
+		return fmt.Errorf("unmarshaling public key: %w", err)
+	}
+	priv, err := scheme.UnmarshalBinaryPrivateKey(kf.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("unmarshaling private key: %w", err)
+	}
+	var ecdhKey *ecdh.PrivateKey
+	if kf.Hybrid {
+		if ecdhKey, err = ecdh.X25519().NewPrivateKey(kf.ECDHPrivate); err != nil {
+			return fmt.Errorf("unmarshaling X25519 key: %w", err)
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.enabled = true
+	q.scheme = scheme
+	q.nistLevel = nistLevel
+	q.aeadName = aeadName
+	q.algorithm = "CRYSTALS-" + scheme.Name()
+	q.hybrid = kf.Hybrid
+	if q.hybrid {
+		q.algorithm = "X25519+" + q.algorithm
+	}
+	q.publicKey = pub
+	q.privateKey = priv
+	q.ecdhKey = ecdhKey
+	q.kyberSecret = kf.KyberSecret
+	q.ecdhSecret = kf.ECDHSecret
+	q.ciphertext = kf.Ciphertext
+
+	if len(kf.SharedSecret) > 0 {
+		if err := q.setSharedSecretLocked(kf.SharedSecret); err != nil {
+			return fmt.Errorf("restoring AEAD: %w", err)
+		}
+	}
+
+	log := logger.WithComponent("quantum")
+	log.Info().
+		Str("path", path).
+		Str("algorithm", q.algorithm).
+		Msg("quantum key material loaded")
+	return nil
+}
+
 // RotateKeys rotates the Kyber keys
 func (q *QuantumResistantLayer) RotateKeys() error {
 	q.mu.Lock()
@@ -332,23 +812,98 @@ func (q *QuantumResistantLayer) RotateKeys() error {
 	return q.generateKyberKeyPair()
 }
 
+// SetOnRotate registers a callback invoked after each automatic key
+// rotation with the new key ID, so peers can be re-keyed. Pass nil to
+// clear it.
+func (q *QuantumResistantLayer) SetOnRotate(fn func(newKeyID string)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.onRotate = fn
+}
+
+// StartAutoRotation rotates the keys on a background ticker. Rotation
+// takes the write lock, so in-flight Encrypt/Decrypt calls complete
+// against the old key before the swap. Calling it again while running
+// is a no-op; use StopAutoRotation first to change the interval.
+func (q *QuantumResistantLayer) StartAutoRotation(interval time.Duration) {
+	log := logger.WithComponent("quantum")
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.enabled || interval <= 0 || q.rotateStop != nil {
+		return
+	}
+
+	stop := make(chan struct{})
+	q.rotateStop = stop
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := q.RotateKeys(); err != nil {
+					log.Error().Err(err).Msg("automatic key rotation failed")
+					continue
+				}
+
+				q.mu.RLock()
+				keyID := q.keyIDLocked()
+				fn := q.onRotate
+				q.mu.RUnlock()
+
+				log.Info().Str("key_id", keyID).Msg("🔄 Quantum keys rotated automatically")
+				if fn != nil {
+					fn(keyID)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	log.Info().Dur("interval", interval).Msg("automatic quantum key rotation started")
+}
+
+// StopAutoRotation stops the background rotation loop, if running
+func (q *QuantumResistantLayer) StopAutoRotation() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.rotateStop != nil {
+		close(q.rotateStop)
+		q.rotateStop = nil
+	}
+}
+
 // GetStatus returns the current status
 func (q *QuantumResistantLayer) GetStatus() map[string]interface{} {
 	q.mu.RLock()
 	defer q.mu.RUnlock()
 
-	keyID := "none"
-	if len(q.sharedSecret) >= 8 {
-		keyID = hex.EncodeToString(q.sharedSecret[:8])
-	}
+	keyID := q.keyIDLocked()
+
+	securityBits := map[int]int{1: 128, 3: 192, 5: 256}[q.nistLevel]
 
 	return map[string]interface{}{
 		"enabled":    q.enabled,
 		"algorithm":  q.algorithm,
+		"hybrid":     q.hybrid,
 		"key_id":     keyID,
-		"nist_level": 3,
-		"security":   "192-bit quantum resistant",
+		"aead":       q.aeadName,
+		"nist_level": q.nistLevel,
+		"security":   fmt.Sprintf("%d-bit quantum resistant", securityBits),
+	}
+}
+
+// keyIDLocked derives the short identifier of the current shared
+// secret. Callers hold at least the read lock.
+func (q *QuantumResistantLayer) keyIDLocked() string {
+	if len(q.sharedSecret) >= 8 {
+		return hex.EncodeToString(q.sharedSecret[:8])
 	}
+	return "none"
 }
 
 // IsEnabled returns whether the quantum layer is enabled
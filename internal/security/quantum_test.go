@@ -1,7 +1,10 @@
 package security
 
 import (
+	"bytes"
+	"os"
 	"testing"
+	"time"
 )
 
 func TestQuantumEncryption(t *testing.T) {
@@ -39,6 +42,323 @@ func TestQuantumEncryption(t *testing.T) {
 	t.Logf("   NIST Level: %v", status["nist_level"])
 }
 
+func TestQuantumTwoPartyKeyExchange(t *testing.T) {
+	cfg := &PostQuantumConfig{Enabled: true}
+
+	alice, err := NewQuantumResistantLayer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create initiator layer: %v", err)
+	}
+	bob, err := NewQuantumResistantLayer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create responder layer: %v", err)
+	}
+
+	// Initiator encapsulates to the responder's public key...
+	bobPub := bob.PublicKeyBytes()
+	if bobPub == nil {
+		t.Fatal("PublicKeyBytes() returned nil for an enabled layer")
+	}
+	ciphertext, err := alice.Encapsulate(bobPub)
+	if err != nil {
+		t.Fatalf("Encapsulate() error = %v", err)
+	}
+
+	// ...and the responder recovers the same secret
+	if err := bob.Decapsulate(ciphertext); err != nil {
+		t.Fatalf("Decapsulate() error = %v", err)
+	}
+
+	if !compareBytes(alice.sharedSecret, bob.sharedSecret) {
+		t.Fatal("shared secrets differ between the two parties")
+	}
+
+	// Both sides now run the same AEAD: a message sealed by one side
+	// opens on the other
+	msg := []byte("over the quantum-safe channel")
+	sealed, err := alice.Encrypt(msg)
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	opened, err := bob.Decrypt(sealed)
+	if err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+	if !compareBytes(msg, opened) {
+		t.Fatal("round trip across parties did not match")
+	}
+}
+
+func TestQuantumEncapsulateValidation(t *testing.T) {
+	cfg := &PostQuantumConfig{Enabled: true}
+	q, err := NewQuantumResistantLayer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create quantum layer: %v", err)
+	}
+
+	if _, err := q.Encapsulate([]byte("short")); err == nil {
+		t.Error("Encapsulate() with a truncated public key expected error")
+	}
+	if err := q.Decapsulate([]byte("short")); err == nil {
+		t.Error("Decapsulate() with a truncated ciphertext expected error")
+	}
+
+	disabled, _ := NewQuantumResistantLayer(nil)
+	if disabled.PublicKeyBytes() != nil {
+		t.Error("PublicKeyBytes() on a disabled layer should be nil")
+	}
+	if _, err := disabled.Encapsulate(q.PublicKeyBytes()); err == nil {
+		t.Error("Encapsulate() on a disabled layer expected error")
+	}
+}
+
+func TestQuantumSecurityLevels(t *testing.T) {
+	levels := []struct {
+		algorithm string
+		nistLevel int
+	}{
+		{"kyber512", 1},
+		{"kyber768", 3},
+		{"kyber1024", 5},
+	}
+
+	for _, tt := range levels {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			cfg := &PostQuantumConfig{Enabled: true, Algorithm: tt.algorithm}
+
+			alice, err := NewQuantumResistantLayer(cfg)
+			if err != nil {
+				t.Fatalf("NewQuantumResistantLayer(%s) error = %v", tt.algorithm, err)
+			}
+			bob, err := NewQuantumResistantLayer(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if status := alice.GetStatus(); status["nist_level"] != tt.nistLevel {
+				t.Errorf("GetStatus()[nist_level] = %v, want %d", status["nist_level"], tt.nistLevel)
+			}
+
+			// Two-party exchange and round trip at this level
+			ciphertext, err := alice.Encapsulate(bob.PublicKeyBytes())
+			if err != nil {
+				t.Fatalf("Encapsulate() error = %v", err)
+			}
+			if err := bob.Decapsulate(ciphertext); err != nil {
+				t.Fatalf("Decapsulate() error = %v", err)
+			}
+
+			msg := []byte("level check")
+			sealed, err := alice.Encrypt(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			opened, err := bob.Decrypt(sealed)
+			if err != nil {
+				t.Fatalf("Decrypt() error = %v", err)
+			}
+			if !compareBytes(msg, opened) {
+				t.Fatal("round trip did not match")
+			}
+		})
+	}
+}
+
+func TestQuantumUnknownAlgorithm(t *testing.T) {
+	cfg := &PostQuantumConfig{Enabled: true, Algorithm: "rot13"}
+	if _, err := NewQuantumResistantLayer(cfg); err == nil {
+		t.Fatal("NewQuantumResistantLayer() with an unknown algorithm expected error")
+	}
+}
+
+func TestQuantumHybridKeyExchange(t *testing.T) {
+	cfg := &PostQuantumConfig{Enabled: true, Hybrid: true}
+
+	alice, err := NewQuantumResistantLayer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create initiator layer: %v", err)
+	}
+	bob, err := NewQuantumResistantLayer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create responder layer: %v", err)
+	}
+
+	ciphertext, err := alice.Encapsulate(bob.PublicKeyBytes())
+	if err != nil {
+		t.Fatalf("Encapsulate() error = %v", err)
+	}
+	if err := bob.Decapsulate(ciphertext); err != nil {
+		t.Fatalf("Decapsulate() error = %v", err)
+	}
+
+	if !compareBytes(alice.sharedSecret, bob.sharedSecret) {
+		t.Fatal("hybrid shared secrets differ between the two parties")
+	}
+
+	// The HKDF output must differ from the bare Kyber half, otherwise
+	// the classical contribution was dropped
+	if compareBytes(alice.sharedSecret, alice.kyberSecret) {
+		t.Fatal("hybrid key equals the Kyber secret; X25519 half was not mixed in")
+	}
+
+	msg := []byte("hybrid channel message")
+	sealed, err := alice.Encrypt(msg)
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	opened, err := bob.Decrypt(sealed)
+	if err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+	if !compareBytes(msg, opened) {
+		t.Fatal("hybrid round trip did not match")
+	}
+
+	if status := alice.GetStatus(); status["hybrid"] != true {
+		t.Errorf("GetStatus()[hybrid] = %v, want true", status["hybrid"])
+	}
+}
+
+func TestQuantumHybridKeyDerivation(t *testing.T) {
+	kyberSS := make([]byte, 32)
+	ecdhSS := make([]byte, 32)
+	for i := range kyberSS {
+		kyberSS[i] = byte(i)
+		ecdhSS[i] = byte(0xFF - i)
+	}
+
+	base, err := deriveHybridKey(kyberSS, ecdhSS)
+	if err != nil {
+		t.Fatalf("deriveHybridKey() error = %v", err)
+	}
+
+	// Flipping a bit in either half must change the derived key
+	kyberSS[0] ^= 1
+	flippedKyber, err := deriveHybridKey(kyberSS, ecdhSS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kyberSS[0] ^= 1
+	ecdhSS[0] ^= 1
+	flippedECDH, err := deriveHybridKey(kyberSS, ecdhSS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if compareBytes(base, flippedKyber) {
+		t.Error("derived key unchanged after altering the Kyber half")
+	}
+	if compareBytes(base, flippedECDH) {
+		t.Error("derived key unchanged after altering the X25519 half")
+	}
+}
+
+func TestQuantumSaveLoadKeys(t *testing.T) {
+	cfg := &PostQuantumConfig{Enabled: true, Algorithm: "kyber768"}
+	first, err := NewQuantumResistantLayer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create quantum layer: %v", err)
+	}
+
+	msg := []byte("sealed before the restart")
+	sealed, err := first.Encrypt(msg)
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	path := t.TempDir() + "/quantum_keys.json"
+	if err := first.SaveKeys(path); err != nil {
+		t.Fatalf("SaveKeys() error = %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("key file permissions = %o, want 0600", perm)
+	}
+
+	// A freshly constructed layer has different keys until it loads ours
+	second, err := NewQuantumResistantLayer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := second.Decrypt(sealed); err == nil {
+		t.Fatal("Decrypt() before LoadKeys() should fail with fresh keys")
+	}
+	if err := second.LoadKeys(path); err != nil {
+		t.Fatalf("LoadKeys() error = %v", err)
+	}
+
+	opened, err := second.Decrypt(sealed)
+	if err != nil {
+		t.Fatalf("Decrypt() after LoadKeys() error = %v", err)
+	}
+	if !compareBytes(msg, opened) {
+		t.Fatal("round trip through the key file did not match")
+	}
+
+	// The persisted key identity carries over
+	if first.GetStatus()["key_id"] != second.GetStatus()["key_id"] {
+		t.Error("key_id changed across save/load")
+	}
+	if !compareBytes(first.PublicKeyBytes(), second.PublicKeyBytes()) {
+		t.Error("public key changed across save/load")
+	}
+}
+
+func TestQuantumSaveLoadKeysPassphrase(t *testing.T) {
+	cfg := &PostQuantumConfig{Enabled: true, Hybrid: true}
+	first, err := NewQuantumResistantLayer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create quantum layer: %v", err)
+	}
+	if err := first.SetPassword("correct horse"); err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := first.Encrypt([]byte("hybrid keys at rest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := t.TempDir() + "/quantum_keys.json"
+	if err := first.SaveKeys(path); err != nil {
+		t.Fatalf("SaveKeys() error = %v", err)
+	}
+
+	// The key material must not appear in the clear on disk
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte("private_key")) {
+		t.Fatal("encrypted key file leaks plaintext fields")
+	}
+
+	second, err := NewQuantumResistantLayer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := second.LoadKeys(path); err == nil {
+		t.Fatal("LoadKeys() without the passphrase expected error")
+	}
+	if err := second.SetPassword("correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.LoadKeys(path); err != nil {
+		t.Fatalf("LoadKeys() with the passphrase error = %v", err)
+	}
+
+	opened, err := second.Decrypt(sealed)
+	if err != nil {
+		t.Fatalf("Decrypt() after passphrase load error = %v", err)
+	}
+	if string(opened) != "hybrid keys at rest" {
+		t.Fatal("round trip through the encrypted key file did not match")
+	}
+}
+
 func TestQuantumKeyRotation(t *testing.T) {
 	cfg := &PostQuantumConfig{Enabled: true}
 	q, _ := NewQuantumResistantLayer(cfg)
@@ -57,6 +377,191 @@ func TestQuantumKeyRotation(t *testing.T) {
 	t.Log("✅ Key rotation test PASSED!")
 }
 
+func TestQuantumEncryptWithAAD(t *testing.T) {
+	cfg := &PostQuantumConfig{Enabled: true}
+	q, err := NewQuantumResistantLayer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create quantum layer: %v", err)
+	}
+
+	msg := []byte("bound to a circuit")
+	sealed, err := q.EncryptWithAAD(msg, []byte("circuit-7"))
+	if err != nil {
+		t.Fatalf("EncryptWithAAD() error = %v", err)
+	}
+
+	// The right AAD opens it
+	opened, err := q.DecryptWithAAD(sealed, []byte("circuit-7"))
+	if err != nil {
+		t.Fatalf("DecryptWithAAD() with matching AAD error = %v", err)
+	}
+	if !compareBytes(msg, opened) {
+		t.Fatal("round trip with AAD did not match")
+	}
+
+	// The wrong or missing AAD must fail authentication
+	if _, err := q.DecryptWithAAD(sealed, []byte("circuit-8")); err == nil {
+		t.Error("DecryptWithAAD() with wrong AAD expected error")
+	}
+	if _, err := q.Decrypt(sealed); err == nil {
+		t.Error("Decrypt() without AAD expected error for AAD-bound ciphertext")
+	}
+
+	// And nil-AAD output stays compatible with the plain methods
+	plain, err := q.Encrypt(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.DecryptWithAAD(plain, nil); err != nil {
+		t.Errorf("DecryptWithAAD(nil) on Encrypt() output error = %v", err)
+	}
+
+	// Disabled layers pass through regardless of AAD
+	disabled, _ := NewQuantumResistantLayer(nil)
+	out, err := disabled.EncryptWithAAD(msg, []byte("whatever"))
+	if err != nil || !compareBytes(out, msg) {
+		t.Error("disabled layer should pass data through unchanged")
+	}
+}
+
+func TestQuantumAEADSelection(t *testing.T) {
+	for _, aead := range []string{"aes-gcm", "chacha20poly1305"} {
+		t.Run(aead, func(t *testing.T) {
+			cfg := &PostQuantumConfig{Enabled: true, AEAD: aead}
+
+			alice, err := NewQuantumResistantLayer(cfg)
+			if err != nil {
+				t.Fatalf("NewQuantumResistantLayer(AEAD=%s) error = %v", aead, err)
+			}
+			bob, err := NewQuantumResistantLayer(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if status := alice.GetStatus(); status["aead"] != aead {
+				t.Errorf("GetStatus()[aead] = %v, want %s", status["aead"], aead)
+			}
+
+			ciphertext, err := alice.Encapsulate(bob.PublicKeyBytes())
+			if err != nil {
+				t.Fatalf("Encapsulate() error = %v", err)
+			}
+			if err := bob.Decapsulate(ciphertext); err != nil {
+				t.Fatalf("Decapsulate() error = %v", err)
+			}
+
+			msg := []byte("cipher choice round trip")
+			sealed, err := alice.Encrypt(msg)
+			if err != nil {
+				t.Fatalf("Encrypt() error = %v", err)
+			}
+			opened, err := bob.Decrypt(sealed)
+			if err != nil {
+				t.Fatalf("Decrypt() error = %v", err)
+			}
+			if !compareBytes(msg, opened) {
+				t.Fatal("round trip did not match")
+			}
+		})
+	}
+}
+
+func TestQuantumAEADMismatch(t *testing.T) {
+	gcm, err := NewQuantumResistantLayer(&PostQuantumConfig{Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chacha, err := NewQuantumResistantLayer(&PostQuantumConfig{Enabled: true, AEAD: "chacha20poly1305"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Same shared secret, different AEAD: output from one must not open
+	// under the other
+	ciphertext, err := gcm.Encapsulate(chacha.PublicKeyBytes())
+	if err != nil {
+		t.Fatalf("Encapsulate() error = %v", err)
+	}
+	if err := chacha.Decapsulate(ciphertext); err != nil {
+		t.Fatalf("Decapsulate() error = %v", err)
+	}
+	if !compareBytes(gcm.sharedSecret, chacha.sharedSecret) {
+		t.Fatal("shared secrets should agree; only the AEAD differs")
+	}
+
+	sealed, err := gcm.Encrypt([]byte("wrong cipher"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chacha.Decrypt(sealed); err == nil {
+		t.Error("ChaCha20 layer decrypted AES-GCM output")
+	}
+
+	if _, err := NewQuantumResistantLayer(&PostQuantumConfig{Enabled: true, AEAD: "rc4"}); err == nil {
+		t.Error("NewQuantumResistantLayer() with an unknown AEAD expected error")
+	}
+}
+
+func TestQuantumAutoRotation(t *testing.T) {
+	cfg := &PostQuantumConfig{Enabled: true}
+	q, err := NewQuantumResistantLayer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create quantum layer: %v", err)
+	}
+
+	initialKeyID := q.GetStatus()["key_id"].(string)
+
+	rotated := make(chan string, 8)
+	q.SetOnRotate(func(newKeyID string) { rotated <- newKeyID })
+
+	q.StartAutoRotation(20 * time.Millisecond)
+	defer q.StopAutoRotation()
+
+	// Encrypt concurrently to exercise the read/write lock handoff
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			if _, err := q.Encrypt([]byte("in flight")); err != nil {
+				t.Errorf("Encrypt() during rotation error = %v", err)
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	select {
+	case newKeyID := <-rotated:
+		if newKeyID == initialKeyID {
+			t.Error("OnRotate fired but key_id did not change")
+		}
+		if got := q.GetStatus()["key_id"].(string); got == initialKeyID {
+			t.Error("GetStatus()[key_id] unchanged after rotation")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnRotate callback never fired")
+	}
+	<-done
+
+	// After stopping, no further rotations arrive. Give an in-flight
+	// tick time to finish before draining.
+	q.StopAutoRotation()
+	time.Sleep(50 * time.Millisecond)
+	for len(rotated) > 0 {
+		<-rotated
+	}
+	time.Sleep(60 * time.Millisecond)
+	if len(rotated) != 0 {
+		t.Error("rotation callback fired after StopAutoRotation()")
+	}
+
+	// Stopping twice and starting on a disabled layer are no-ops
+	q.StopAutoRotation()
+	disabled, _ := NewQuantumResistantLayer(nil)
+	disabled.StartAutoRotation(time.Millisecond)
+	disabled.StopAutoRotation()
+}
+
 func TestQuantumDisabled(t *testing.T) {
 	cfg := &PostQuantumConfig{Enabled: false}
 	q, _ := NewQuantumResistantLayer(cfg)
@@ -5,16 +5,21 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"fmt"
+	mrand "math/rand"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/jery0843/torforge/pkg/logger"
 )
 
 // StegoConfig configures the steganography mode
 type StegoConfig struct {
-	Enabled     bool   `yaml:"enabled"`
-	Mode        string `yaml:"mode"`         // "https", "dns", "icmp"
-	CoverDomain string `yaml:"cover_domain"` // Domain to mimic (e.g., "youtube.com")
+	Enabled        bool             `yaml:"enabled"`
+	Mode           string           `yaml:"mode"`            // "https", "dns", "icmp"
+	CoverDomain    string           `yaml:"cover_domain"`    // Domain to mimic (e.g., "youtube.com")
+	CustomPatterns []TrafficPattern `yaml:"custom_patterns"` // User-defined cover profiles, selectable by name
+	Jitter         float64          `yaml:"jitter"`          // Fractional timing jitter (0-1); 0.2 = ±20%
 }
 
 // StegoMode provides traffic obfuscation using steganography principles
@@ -27,14 +32,27 @@ type StegoMode struct {
 
 	// Traffic pattern templates
 	patterns []TrafficPattern
+
+	// Pacing state: position in the pattern's timing sequence and the
+	// fractional jitter applied to each delay
+	timingPos int
+	jitter    float64
+
+	// Cover rotation: every interval the active pattern advances
+	// through the rotation list. now is injectable for tests.
+	available     map[string]TrafficPattern
+	rotation      []TrafficPattern
+	rotationStart time.Time
+	rotationEvery time.Duration
+	now           func() time.Time
 }
 
 // TrafficPattern represents a traffic pattern to mimic
 type TrafficPattern struct {
-	Name       string
-	PacketSize []int    // Typical packet sizes
-	Timing     []int    // Typical timing in ms
-	Headers    []string // HTTP headers to add
+	Name       string   `yaml:"name"`
+	PacketSize []int    `yaml:"packet_size"` // Typical packet sizes
+	Timing     []int    `yaml:"timing"`      // Typical timing in ms
+	Headers    []string `yaml:"headers"`     // HTTP headers to add
 }
 
 // Common service patterns to mimic
@@ -89,7 +107,17 @@ var servicePatterns = map[string]TrafficPattern{
 // NewStegoMode creates a new steganography mode
 func NewStegoMode(cfg *StegoConfig) *StegoMode {
 	if cfg == nil || !cfg.Enabled {
-		return &StegoMode{enabled: false}
+		return &StegoMode{enabled: false, now: time.Now}
+	}
+
+	log := logger.WithComponent("stego")
+
+	jitter := cfg.Jitter
+	if jitter < 0 {
+		jitter = 0
+	}
+	if jitter > 1 {
+		jitter = 1
 	}
 
 	s := &StegoMode{
@@ -97,17 +125,35 @@ func NewStegoMode(cfg *StegoConfig) *StegoMode {
 		mode:        cfg.Mode,
 		coverDomain: cfg.CoverDomain,
 		patterns:    []TrafficPattern{},
+		jitter:      jitter,
+		now:         time.Now,
 	}
 
+	// Merge custom patterns over the built-ins, keyed by lowercased
+	// name so cover_domain selects them the same way
+	patterns := make(map[string]TrafficPattern, len(servicePatterns)+len(cfg.CustomPatterns))
+	for name, pattern := range servicePatterns {
+		patterns[name] = pattern
+	}
+	for _, pattern := range cfg.CustomPatterns {
+		if pattern.Name == "" || len(pattern.PacketSize) == 0 || len(pattern.Timing) == 0 {
+			log.Warn().Str("pattern", pattern.Name).
+				Msg("ignoring custom pattern without name, packet sizes, or timing")
+			continue
+		}
+		patterns[strings.ToLower(pattern.Name)] = pattern
+	}
+
+	// Keep the full merged set so SetCoverRotation can cycle through it
+	s.available = patterns
+
 	// Load pattern for cover domain
-	if pattern, exists := servicePatterns[cfg.CoverDomain]; exists {
+	if pattern, exists := patterns[strings.ToLower(cfg.CoverDomain)]; exists {
 		s.patterns = append(s.patterns, pattern)
 	} else {
 		// Default to YouTube pattern
-		s.patterns = append(s.patterns, servicePatterns["youtube"])
+		s.patterns = append(s.patterns, patterns["youtube"])
 	}
-
-	log := logger.WithComponent("stego")
 	log.Info().
 		Str("mode", cfg.Mode).
 		Str("cover", cfg.CoverDomain).
@@ -116,6 +162,59 @@ func NewStegoMode(cfg *StegoConfig) *StegoMode {
 	return s
 }
 
+// SetCoverRotation cycles the active cover pattern through the named
+// service patterns, advancing every interval. One cover domain for a
+// whole session is itself a fingerprint; rotating spreads the traffic
+// shape across several services. Unknown names are skipped with a
+// warning; an empty list or non-positive interval disables rotation.
+func (s *StegoMode) SetCoverRotation(domains []string, interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	log := logger.WithComponent("stego")
+
+	if len(domains) == 0 || interval <= 0 {
+		s.rotation = nil
+		s.rotationEvery = 0
+		return
+	}
+
+	rotation := make([]TrafficPattern, 0, len(domains))
+	for _, domain := range domains {
+		pattern, exists := s.available[strings.ToLower(domain)]
+		if !exists {
+			log.Warn().Str("domain", domain).Msg("no cover pattern for domain, skipping in rotation")
+			continue
+		}
+		rotation = append(rotation, pattern)
+	}
+	if len(rotation) == 0 {
+		log.Warn().Msg("cover rotation list resolved to no patterns, keeping current cover")
+		return
+	}
+
+	s.rotation = rotation
+	s.rotationEvery = interval
+	s.rotationStart = s.now()
+	s.timingPos = 0
+	log.Info().Int("patterns", len(rotation)).Dur("interval", interval).
+		Msg("🎭 Cover rotation enabled")
+}
+
+// activePatternLocked returns the pattern currently in effect, deriving
+// the rotation position from the clock. Callers hold at least the read
+// lock.
+func (s *StegoMode) activePatternLocked() TrafficPattern {
+	if len(s.rotation) > 0 {
+		idx := int(s.now().Sub(s.rotationStart)/s.rotationEvery) % len(s.rotation)
+		if idx < 0 {
+			idx = 0
+		}
+		return s.rotation[idx]
+	}
+	return s.patterns[0]
+}
+
 // WrapTraffic wraps data to look like the cover service traffic
 func (s *StegoMode) WrapTraffic(data []byte) []byte {
 	s.mu.RLock()
@@ -125,7 +224,7 @@ func (s *StegoMode) WrapTraffic(data []byte) []byte {
 		return data
 	}
 
-	pattern := s.patterns[0]
+	pattern := s.activePatternLocked()
 
 	// Add padding to match expected packet sizes
 	targetSize := pattern.PacketSize[0]
@@ -139,28 +238,74 @@ func (s *StegoMode) WrapTraffic(data []byte) []byte {
 	return s.addCoverMarkers(data)
 }
 
-// UnwrapTraffic removes steganographic wrapping
-func (s *StegoMode) UnwrapTraffic(data []byte) []byte {
+// NextDelay returns the next inter-packet delay drawn from the cover
+// pattern's timing sequence, with the configured jitter applied. The
+// caller sleeps this long between sends so the temporal fingerprint
+// matches the cover service, not Tor. The position wraps around the
+// sequence.
+func (s *StegoMode) NextDelay() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.enabled || len(s.patterns) == 0 {
+		return 0
+	}
+
+	timing := s.activePatternLocked().Timing
+	if len(timing) == 0 {
+		return 0
+	}
+	if s.timingPos >= len(timing) {
+		s.timingPos = 0 // Rotation may have switched to a shorter sequence
+	}
+	base := time.Duration(timing[s.timingPos]) * time.Millisecond
+	s.timingPos = (s.timingPos + 1) % len(timing)
+
+	if s.jitter <= 0 {
+		return base
+	}
+
+	// Uniform jitter in ±jitter of the base delay, clamped at zero
+	offset := (mrand.Float64()*2 - 1) * s.jitter * float64(base)
+	delay := time.Duration(float64(base) + offset)
+	if delay < 0 {
+		delay = 0
+	}
+	return delay
+}
+
+// UnwrapTraffic removes steganographic wrapping. It returns an error
+// when the markers or the embedded length do not match, so corrupted or
+// foreign data is never silently passed through.
+func (s *StegoMode) UnwrapTraffic(data []byte) ([]byte, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	if !s.enabled {
-		return data
+		return data, nil
 	}
 
 	return s.removeCoverMarkers(data)
 }
 
+// Marker framing: 5-byte header (NAL start + SPS), 4-byte big-endian
+// original length, base64 body, 5-byte trailer (NAL start + PPS)
+var (
+	stegoHeader  = []byte{0x00, 0x00, 0x00, 0x01, 0x67}
+	stegoTrailer = []byte{0x00, 0x00, 0x00, 0x01, 0x68}
+)
+
+const stegoOverhead = 5 + 4 + 5 // header + length field + trailer
+
 // addCoverMarkers adds markers to make traffic look like cover service
 func (s *StegoMode) addCoverMarkers(data []byte) []byte {
 	// Create a wrapper that looks like video streaming data
-	wrapper := make([]byte, 0, len(data)+64)
+	wrapper := make([]byte, 0, len(data)+stegoOverhead+base64.StdEncoding.EncodedLen(len(data)))
 
 	// Add "video" magic bytes (fake MPEG signature)
-	wrapper = append(wrapper, 0x00, 0x00, 0x00, 0x01) // Video NAL unit start
-	wrapper = append(wrapper, 0x67)                   // SPS marker
+	wrapper = append(wrapper, stegoHeader...)
 
-	// Add length
+	// Add the original length so the unwrapper can verify the decode
 	length := len(data)
 	wrapper = append(wrapper, byte(length>>24), byte(length>>16), byte(length>>8), byte(length))
 
@@ -169,31 +314,40 @@ func (s *StegoMode) addCoverMarkers(data []byte) []byte {
 	wrapper = append(wrapper, []byte(encoded)...)
 
 	// Add end marker
-	wrapper = append(wrapper, 0x00, 0x00, 0x00, 0x01, 0x68) // PPS marker
+	wrapper = append(wrapper, stegoTrailer...)
 
 	return wrapper
 }
 
-// removeCoverMarkers removes steganographic markers
-func (s *StegoMode) removeCoverMarkers(data []byte) []byte {
-	// Skip header (9 bytes)
-	if len(data) < 14 {
-		return data
+// removeCoverMarkers removes steganographic markers, verifying the
+// framing and the embedded length before trusting the payload
+func (s *StegoMode) removeCoverMarkers(data []byte) ([]byte, error) {
+	if len(data) < stegoOverhead {
+		return nil, fmt.Errorf("stego frame too short: %d bytes", len(data))
+	}
+	if !strings.HasPrefix(string(data), string(stegoHeader)) {
+		return nil, fmt.Errorf("stego header marker missing")
+	}
+	if !strings.HasSuffix(string(data), string(stegoTrailer)) {
+		return nil, fmt.Errorf("stego trailer marker missing")
 	}
 
-	// Extract length
+	// Extract the original length
 	length := int(data[5])<<24 | int(data[6])<<16 | int(data[7])<<8 | int(data[8])
+	if length < 0 {
+		return nil, fmt.Errorf("stego length field invalid: %d", length)
+	}
 
-	// Extract and decode data
-	if length > 0 && len(data) > 14 {
-		encoded := data[9 : len(data)-5]
-		decoded, err := base64.StdEncoding.DecodeString(string(encoded))
-		if err == nil {
-			return decoded
-		}
+	encoded := data[len(stegoHeader)+4 : len(data)-len(stegoTrailer)]
+	decoded, err := base64.StdEncoding.DecodeString(string(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("stego payload decode failed: %w", err)
+	}
+	if len(decoded) != length {
+		return nil, fmt.Errorf("stego length mismatch: frame says %d, decoded %d", length, len(decoded))
 	}
 
-	return data
+	return decoded, nil
 }
 
 // GetHTTPHeaders returns headers to mimic the cover service
@@ -208,7 +362,7 @@ func (s *StegoMode) GetHTTPHeaders() map[string]string {
 	}
 
 	// Add pattern-specific headers
-	pattern := s.patterns[0]
+	pattern := s.activePatternLocked()
 	for i, h := range pattern.Headers {
 		key := fmt.Sprintf("X-Custom-%d", i)
 		headers[key] = h
@@ -249,7 +403,7 @@ func (s *StegoMode) GetStatus() map[string]interface{} {
 
 	patternName := "none"
 	if len(s.patterns) > 0 {
-		patternName = s.patterns[0].Name
+		patternName = s.activePatternLocked().Name
 	}
 
 	return map[string]interface{}{
@@ -276,7 +430,7 @@ func (s *StegoMode) PrintStegoInfo() {
 	s.mu.RLock()
 	patternName := "YouTube"
 	if len(s.patterns) > 0 {
-		patternName = s.patterns[0].Name
+		patternName = s.activePatternLocked().Name
 	}
 	s.mu.RUnlock()
 
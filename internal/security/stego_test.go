@@ -0,0 +1,278 @@
+package security
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// TestStegoCustomPattern tests that a custom cover profile from config
+// is selectable by cover_domain
+func TestStegoCustomPattern(t *testing.T) {
+	stego := NewStegoMode(&StegoConfig{
+		Enabled:     true,
+		Mode:        "https",
+		CoverDomain: "mygame",
+		CustomPatterns: []TrafficPattern{
+			{
+				Name:       "MyGame",
+				PacketSize: []int{512, 256, 512},
+				Timing:     []int{10, 10, 15},
+				Headers:    []string{"Accept: application/octet-stream"},
+			},
+		},
+	})
+
+	status := stego.GetStatus()
+	if status["pattern"] != "MyGame" {
+		t.Errorf("GetStatus()[pattern] = %v, want MyGame", status["pattern"])
+	}
+}
+
+// TestStegoCustomPatternValidation tests that incomplete custom
+// patterns are ignored and the default kicks in
+func TestStegoCustomPatternValidation(t *testing.T) {
+	stego := NewStegoMode(&StegoConfig{
+		Enabled:     true,
+		Mode:        "https",
+		CoverDomain: "broken",
+		CustomPatterns: []TrafficPattern{
+			{Name: "broken", PacketSize: nil, Timing: []int{10}}, // No packet sizes
+			{Name: "", PacketSize: []int{100}, Timing: []int{10}},
+		},
+	})
+
+	// The broken pattern was dropped, so the YouTube default applies
+	status := stego.GetStatus()
+	if status["pattern"] != "YouTube Video Streaming" {
+		t.Errorf("GetStatus()[pattern] = %v, want the YouTube default", status["pattern"])
+	}
+}
+
+// TestStegoRoundTrip tests that wrapped traffic unwraps back to the
+// original payload across a spread of sizes, including empty and larger
+// than one MTU-sized packet
+func TestStegoRoundTrip(t *testing.T) {
+	stego := NewStegoMode(&StegoConfig{
+		Enabled:     true,
+		Mode:        "https",
+		CoverDomain: "youtube",
+	})
+
+	sizes := []int{0, 1, 13, 100, 1459, 1460, 1461, 4096, 65536}
+	for i := 0; i < 50; i++ {
+		sizes = append(sizes, rand.Intn(8192))
+	}
+
+	for _, size := range sizes {
+		payload := make([]byte, size)
+		rand.Read(payload)
+
+		wrapped := stego.WrapTraffic(append([]byte(nil), payload...))
+		unwrapped, err := stego.UnwrapTraffic(wrapped)
+		if err != nil {
+			t.Fatalf("UnwrapTraffic(size=%d) error = %v", size, err)
+		}
+
+		// WrapTraffic pads short payloads up to the pattern packet
+		// size, so the original must come back as a prefix; anything
+		// at or above the packet size must round-trip exactly
+		if size >= 1460 {
+			if !bytes.Equal(unwrapped, payload) {
+				t.Fatalf("round trip at size %d did not match", size)
+			}
+		} else if len(unwrapped) != 1460 || !bytes.Equal(unwrapped[:size], payload) {
+			t.Fatalf("round trip at size %d: got %d bytes, prefix match %v",
+				size, len(unwrapped), bytes.Equal(unwrapped[:size], payload))
+		}
+	}
+}
+
+// TestStegoUnwrapRejectsCorruptFrames tests that damaged or foreign data
+// is surfaced as an error instead of being passed through
+func TestStegoUnwrapRejectsCorruptFrames(t *testing.T) {
+	stego := NewStegoMode(&StegoConfig{
+		Enabled:     true,
+		Mode:        "https",
+		CoverDomain: "youtube",
+	})
+
+	wrapped := stego.WrapTraffic(make([]byte, 1460))
+
+	cases := map[string][]byte{
+		"too short":       wrapped[:8],
+		"no header":       append([]byte("junk!"), wrapped[5:]...),
+		"no trailer":      wrapped[:len(wrapped)-5],
+		"bad base64":      append(append([]byte(nil), wrapped[:9]...), append([]byte("@@@@"), wrapped[len(wrapped)-5:]...)...),
+		"length mismatch": func() []byte { f := append([]byte(nil), wrapped...); f[8] ^= 0xFF; return f }(),
+	}
+	for name, frame := range cases {
+		if _, err := stego.UnwrapTraffic(frame); err == nil {
+			t.Errorf("UnwrapTraffic(%s) expected error", name)
+		}
+	}
+
+	// Disabled mode is a pass-through and never errors
+	disabled := NewStegoMode(nil)
+	raw := []byte("plain")
+	if out, err := disabled.UnwrapTraffic(raw); err != nil || !bytes.Equal(out, raw) {
+		t.Errorf("disabled UnwrapTraffic = %v, %v; want pass-through", out, err)
+	}
+}
+
+// TestStegoCoverRotation tests that the active pattern advances through
+// the rotation list as the clock moves
+func TestStegoCoverRotation(t *testing.T) {
+	stego := NewStegoMode(&StegoConfig{
+		Enabled:     true,
+		Mode:        "https",
+		CoverDomain: "youtube",
+	})
+
+	clock := time.Date(2026, 8, 28, 12, 0, 0, 0, time.UTC)
+	stego.now = func() time.Time { return clock }
+
+	stego.SetCoverRotation([]string{"youtube", "netflix", "spotify"}, 10*time.Minute)
+
+	want := []string{
+		"YouTube Video Streaming",
+		"Netflix Streaming",
+		"Spotify Music Streaming",
+		"YouTube Video Streaming", // Wraps around
+	}
+	for i, name := range want {
+		if got := stego.GetStatus()["pattern"]; got != name {
+			t.Errorf("pattern after %d intervals = %v, want %s", i, got, name)
+		}
+		clock = clock.Add(10 * time.Minute)
+	}
+}
+
+// TestStegoCoverRotationHeaders tests that headers and wrapping follow
+// the rotated pattern
+func TestStegoCoverRotationHeaders(t *testing.T) {
+	stego := NewStegoMode(&StegoConfig{
+		Enabled:     true,
+		Mode:        "https",
+		CoverDomain: "youtube",
+	})
+
+	clock := time.Date(2026, 8, 28, 12, 0, 0, 0, time.UTC)
+	stego.now = func() time.Time { return clock }
+
+	stego.SetCoverRotation([]string{"youtube", "spotify"}, time.Minute)
+
+	if headers := stego.GetHTTPHeaders(); headers["X-Custom-1"] != "Range: bytes=0-" {
+		t.Errorf("headers before rotation = %v, want the YouTube set", headers)
+	}
+
+	clock = clock.Add(time.Minute)
+	if headers := stego.GetHTTPHeaders(); headers["X-Custom-0"] != "Accept: audio/mpeg,audio/*;q=0.9,*/*;q=0.8" {
+		t.Errorf("headers after rotation = %v, want the Spotify set", headers)
+	}
+
+	// Spotify pads to 1024 instead of YouTube's 1460
+	wrapped := stego.WrapTraffic(make([]byte, 10))
+	unwrapped, err := stego.UnwrapTraffic(wrapped)
+	if err != nil {
+		t.Fatalf("UnwrapTraffic() error = %v", err)
+	}
+	if len(unwrapped) != 1024 {
+		t.Errorf("padded payload = %d bytes, want the Spotify 1024", len(unwrapped))
+	}
+}
+
+// TestStegoCoverRotationUnknownDomains tests that a rotation list with
+// no resolvable patterns leaves the current cover alone
+func TestStegoCoverRotationUnknownDomains(t *testing.T) {
+	stego := NewStegoMode(&StegoConfig{
+		Enabled:     true,
+		Mode:        "https",
+		CoverDomain: "netflix",
+	})
+
+	stego.SetCoverRotation([]string{"nosuch", "alsonothing"}, time.Minute)
+
+	if got := stego.GetStatus()["pattern"]; got != "Netflix Streaming" {
+		t.Errorf("pattern = %v, want the unchanged Netflix cover", got)
+	}
+}
+
+// TestStegoNextDelayCyclesTiming tests that with no jitter delays come
+// from the pattern's timing sequence in order, wrapping around
+func TestStegoNextDelayCyclesTiming(t *testing.T) {
+	stego := NewStegoMode(&StegoConfig{
+		Enabled:     true,
+		Mode:        "https",
+		CoverDomain: "paced",
+		CustomPatterns: []TrafficPattern{
+			{Name: "paced", PacketSize: []int{512}, Timing: []int{10, 20, 30}},
+		},
+	})
+
+	want := []time.Duration{
+		10 * time.Millisecond, 20 * time.Millisecond, 30 * time.Millisecond,
+		10 * time.Millisecond, // Wraps around
+	}
+	for i, expected := range want {
+		if got := stego.NextDelay(); got != expected {
+			t.Errorf("NextDelay() #%d = %v, want %v", i, got, expected)
+		}
+	}
+}
+
+// TestStegoNextDelayJitterBounds tests that jittered delays stay within
+// the configured fraction of the base delay
+func TestStegoNextDelayJitterBounds(t *testing.T) {
+	stego := NewStegoMode(&StegoConfig{
+		Enabled:     true,
+		Mode:        "https",
+		CoverDomain: "paced",
+		Jitter:      0.5,
+		CustomPatterns: []TrafficPattern{
+			{Name: "paced", PacketSize: []int{512}, Timing: []int{10}},
+		},
+	})
+
+	min, max := 5*time.Millisecond, 15*time.Millisecond
+	for i := 0; i < 100; i++ {
+		if got := stego.NextDelay(); got < min || got > max {
+			t.Fatalf("NextDelay() #%d = %v, want within [%v, %v]", i, got, min, max)
+		}
+	}
+}
+
+// TestStegoNextDelayDisabled tests that a disabled stego mode never
+// delays traffic
+func TestStegoNextDelayDisabled(t *testing.T) {
+	stego := NewStegoMode(nil)
+	if got := stego.NextDelay(); got != 0 {
+		t.Errorf("NextDelay() = %v, want 0 when disabled", got)
+	}
+}
+
+// TestStegoBuiltinPatternSelection tests that built-ins still resolve
+// and custom patterns can shadow them
+func TestStegoBuiltinPatternSelection(t *testing.T) {
+	stego := NewStegoMode(&StegoConfig{
+		Enabled:     true,
+		Mode:        "https",
+		CoverDomain: "netflix",
+	})
+	if status := stego.GetStatus(); status["pattern"] != "Netflix Streaming" {
+		t.Errorf("GetStatus()[pattern] = %v, want Netflix Streaming", status["pattern"])
+	}
+
+	shadowed := NewStegoMode(&StegoConfig{
+		Enabled:     true,
+		Mode:        "https",
+		CoverDomain: "netflix",
+		CustomPatterns: []TrafficPattern{
+			{Name: "netflix", PacketSize: []int{900}, Timing: []int{40}},
+		},
+	})
+	if status := shadowed.GetStatus(); status["pattern"] != "netflix" {
+		t.Errorf("GetStatus()[pattern] = %v, want the custom netflix override", status["pattern"])
+	}
+}
@@ -2,37 +2,159 @@
 package tor
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/cretz/bine/control"
 	"github.com/cretz/bine/tor"
 	"github.com/jery0843/torforge/pkg/logger"
 )
 
+// confSetter abstracts the SETCONF side of the Tor control connection so
+// configuration changes can be tested without a running Tor
+type confSetter interface {
+	SetConf(entries ...*control.KeyVal) error
+}
+
+// circuitControl abstracts the control-port commands and event plumbing
+// used for real circuit construction so CreateCircuit can be exercised
+// against a mock connection
+type circuitControl interface {
+	SendRequest(format string, args ...interface{}) (*control.Response, error)
+	AddEventListener(ch chan<- control.Event, events ...control.EventCode) error
+	RemoveEventListener(ch chan<- control.Event, events ...control.EventCode) error
+}
+
+// circuitBuildTimeout bounds how long CreateCircuit waits for Tor to
+// report BUILT before giving up
+const circuitBuildTimeout = 60 * time.Second
+
 // CircuitManager manages Tor circuits
 type CircuitManager struct {
 	tor            *tor.Tor
+	conf           confSetter
+	ctrl           circuitControl
 	circuits       map[string]*Circuit
 	mu             sync.RWMutex
 	stopCh         chan struct{}
 	rotating       bool
 	rotateInterval time.Duration
 	rotateBytes    int64
+	exitCountry    string
+	exitAllowed    []string // SetExitPolicy allow list (upper-case codes)
+	exitExcluded   []string // SetExitPolicy exclude list
+	pinnedGuard    string
+
+	// Cached relay consensus and GeoIP resolver for per-circuit exit
+	// selection (see consensus.go)
+	geoip        exitResolver
+	consensus    []relayInfo
+	consensusAt  time.Time
+	eventsActive bool            // CIRC/STREAM listener established
+	rotatingIDs  map[string]bool // Circuits with a rotation in flight
+	now          func() time.Time
+
+	// Domain circuits idle longer than this are closed (0 = never)
+	domainIdleTimeout time.Duration
+
+	// Warm pool state (see warmpool.go)
+	warmTarget      int
+	warmLoopStarted bool
+	warmKick        chan struct{}
+
+	// Lifecycle event subscribers
+	subscribers   map[int]chan CircuitEvent
+	nextSubID     int
+	droppedEvents int64
+
+	// Monotonic lifetime counters; these survive rotation
+	totalCreated int64
+	totalClosed  int64
+}
+
+// CircuitEvent notifies subscribers of circuit lifecycle changes
+type CircuitEvent struct {
+	CircuitID string
+	Type      string // "created", "closed", "rotated", "status"
+	OldStatus string
+	NewStatus string
+	Time      time.Time
 }
 
 // Circuit represents a Tor circuit
 type Circuit struct {
-	ID        string
-	Status    string
-	CreatedAt time.Time
-	BytesSent int64
-	BytesRecv int64
-	Path      []string // Relay fingerprints
-	Purpose   string
-	Domain    string // For per-domain isolation
-	ExitNode  string
-	Latency   time.Duration
-	LastUsed  time.Time
+	ID          string
+	Status      string
+	CreatedAt   time.Time
+	BytesSent   int64
+	BytesRecv   int64
+	Path        []string // Relay fingerprints
+	Purpose     string
+	BuildFlags  []string // e.g. NEED_CAPACITY, IS_INTERNAL
+	Domain      string   // For per-domain isolation
+	ExitNode    string
+	ExitCountry string // Requested exit country, if any
+	Latency     time.Duration
+	LastUsed    time.Time
+
+	// SOCKS isolation identity for domain-bound circuits. Tor keeps
+	// streams with different auth pairs on different circuits
+	// (IsolateSOCKSAuth, on by default), so these are what actually
+	// separate per-domain traffic.
+	SocksUser string
+	SocksPass string
+
+	bwSamples []bwSample // Sliding window for throughput, guarded by cm.mu
+	warm      bool       // Pre-built and awaiting consumption, guarded by cm.mu
+}
+
+// bwSample is one traffic reading in a circuit's throughput window
+type bwSample struct {
+	t     time.Time
+	bytes int64
+}
+
+// throughputWindow is how far back throughput calculations look
+const throughputWindow = 10 * time.Second
+
+// addBWSample appends a traffic reading and drops samples that fell out
+// of the window. The caller holds cm.mu.
+func (c *Circuit) addBWSample(now time.Time, bytes int64) {
+	c.bwSamples = append(c.bwSamples, bwSample{t: now, bytes: bytes})
+	cutoff := now.Add(-throughputWindow)
+	for len(c.bwSamples) > 0 && c.bwSamples[0].t.Before(cutoff) {
+		c.bwSamples = c.bwSamples[1:]
+	}
+}
+
+// throughput returns the circuit's bytes/sec over the sliding window.
+// The caller holds cm.mu.
+func (c *Circuit) throughput(now time.Time) float64 {
+	var total int64
+	var oldest time.Time
+	for _, s := range c.bwSamples {
+		if now.Sub(s.t) > throughputWindow {
+			continue
+		}
+		if oldest.IsZero() || s.t.Before(oldest) {
+			oldest = s.t
+		}
+		total += s.bytes
+	}
+	if total == 0 {
+		return 0
+	}
+	elapsed := now.Sub(oldest)
+	if elapsed < time.Second {
+		elapsed = time.Second
+	}
+	return float64(total) / elapsed.Seconds()
 }
 
 // NewCircuitManager creates a new circuit manager
@@ -43,12 +165,67 @@ func NewCircuitManager(t *tor.Tor) *CircuitManager {
 		stopCh:         make(chan struct{}),
 		rotateInterval: 10 * time.Minute,
 		rotateBytes:    100 * 1024 * 1024, // 100MB
+		subscribers:    make(map[int]chan CircuitEvent),
+		rotatingIDs:    make(map[string]bool),
+		warmKick:       make(chan struct{}, 1),
+		now:            time.Now,
+
+		domainIdleTimeout: 30 * time.Minute,
+	}
+	if t != nil && t.Control != nil {
+		cm.conf = t.Control
+		cm.ctrl = t.Control
+		cm.startEventListener()
 	}
 
 	go cm.monitorLoop()
 	return cm
 }
 
+// Subscribe returns a channel of circuit lifecycle events and an
+// unsubscribe function. Events are dropped (and counted) when the
+// subscriber is slow rather than stalling the monitor loop.
+func (cm *CircuitManager) Subscribe() (<-chan CircuitEvent, func()) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	id := cm.nextSubID
+	cm.nextSubID++
+	ch := make(chan CircuitEvent, 32)
+	cm.subscribers[id] = ch
+
+	unsubscribe := func() {
+		cm.mu.Lock()
+		defer cm.mu.Unlock()
+		if sub, ok := cm.subscribers[id]; ok {
+			delete(cm.subscribers, id)
+			close(sub)
+		}
+	}
+	return ch, unsubscribe
+}
+
+// DroppedEvents returns the number of circuit events dropped because
+// subscribers were slow
+func (cm *CircuitManager) DroppedEvents() int64 {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return cm.droppedEvents
+}
+
+// publishEvent emits an event to all subscribers without blocking.
+// The caller holds the write lock.
+func (cm *CircuitManager) publishEvent(event CircuitEvent) {
+	event.Time = time.Now()
+	for _, ch := range cm.subscribers {
+		select {
+		case ch <- event:
+		default:
+			cm.droppedEvents++
+		}
+	}
+}
+
 // SetRotationPolicy sets circuit rotation policy
 func (cm *CircuitManager) SetRotationPolicy(interval time.Duration, bytes int64) {
 	cm.mu.Lock()
@@ -76,8 +253,32 @@ func (cm *CircuitManager) GetCircuits() []*Circuit {
 	return circuits
 }
 
-// CreateCircuit creates a new Tor circuit
+// CreateCircuit creates a new Tor circuit, blocking until Tor reports
+// it BUILT or the default build timeout expires. Without a control
+// connection it falls back to a simulated local circuit.
 func (cm *CircuitManager) CreateCircuit(purpose string) (*Circuit, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), circuitBuildTimeout)
+	defer cancel()
+	return cm.CreateCircuitContext(ctx, purpose)
+}
+
+// CreateCircuitContext is CreateCircuit with caller-controlled
+// cancellation of the wait for BUILT
+func (cm *CircuitManager) CreateCircuitContext(ctx context.Context, purpose string) (*Circuit, error) {
+	cm.mu.RLock()
+	ctrl := cm.ctrl
+	cm.mu.RUnlock()
+
+	if ctrl == nil {
+		return cm.createSimulatedCircuit(purpose), nil
+	}
+	return cm.buildCircuit(ctx, ctrl, purpose, nil)
+}
+
+// createSimulatedCircuit fabricates a local circuit struct for the
+// nil-Tor case so the rest of the daemon (and unit tests) can run
+// without a live control connection
+func (cm *CircuitManager) createSimulatedCircuit(purpose string) *Circuit {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
 
@@ -86,32 +287,146 @@ func (cm *CircuitManager) CreateCircuit(purpose string) (*Circuit, error) {
 	// Generate a local circuit ID
 	circuitID := generateCircuitID()
 
+	circuit := &Circuit{
+		ID:          circuitID,
+		Status:      "BUILDING",
+		CreatedAt:   time.Now(),
+		Purpose:     purpose,
+		ExitCountry: cm.exitCountry,
+		LastUsed:    time.Now(),
+	}
+
+	cm.circuits[circuitID] = circuit
+	cm.totalCreated++
+	log.Info().Str("circuit_id", circuitID).Str("purpose", purpose).Msg("created new circuit")
+
+	logger.CircuitEvent(circuitID, "created")
+	cm.publishEvent(CircuitEvent{CircuitID: circuitID, Type: "created", NewStatus: circuit.Status})
+
+	return circuit
+}
+
+// buildCircuit asks Tor for a fresh circuit via EXTENDCIRCUIT 0 (with an
+// optional explicit relay path) and waits for the CIRC event stream to
+// report BUILT or FAILED
+func (cm *CircuitManager) buildCircuit(ctx context.Context, ctrl circuitControl, purpose string, path []string) (*Circuit, error) {
+	log := logger.WithComponent("circuit")
+
+	// Subscribe before launching so the BUILT event cannot be missed
+	events := make(chan control.Event, 16)
+	if err := ctrl.AddEventListener(events, control.EventCodeCircuit); err != nil {
+		return nil, fmt.Errorf("failed to listen for circuit events: %w", err)
+	}
+	defer ctrl.RemoveEventListener(events, control.EventCodeCircuit)
+
+	cmd := "EXTENDCIRCUIT 0"
+	if len(path) > 0 {
+		cmd += " " + strings.Join(path, ",")
+	}
+	resp, err := ctrl.SendRequest(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("EXTENDCIRCUIT failed: %w", err)
+	}
+	circuitID := parseCircuitID(resp.Reply)
+	if circuitID == "" {
+		return nil, fmt.Errorf("could not parse circuit ID from reply %q", resp.Reply)
+	}
+
 	circuit := &Circuit{
 		ID:        circuitID,
-		Status:    "BUILDING",
+		Status:    "LAUNCHED",
 		CreatedAt: time.Now(),
 		Purpose:   purpose,
 		LastUsed:  time.Now(),
 	}
 
+	cm.mu.Lock()
+	circuit.ExitCountry = cm.exitCountry
 	cm.circuits[circuitID] = circuit
-	log.Info().Str("circuit_id", circuitID).Str("purpose", purpose).Msg("created new circuit")
+	cm.totalCreated++
+	cm.publishEvent(CircuitEvent{CircuitID: circuitID, Type: "created", NewStatus: circuit.Status})
+	cm.mu.Unlock()
 
+	log.Info().Str("circuit_id", circuitID).Str("purpose", purpose).Msg("launched circuit")
 	logger.CircuitEvent(circuitID, "created")
 
-	return circuit, nil
+	for {
+		select {
+		case <-ctx.Done():
+			// Tor may still finish the build; the status poller will
+			// reconcile either way
+			return nil, fmt.Errorf("circuit %s not built: %w", circuitID, ctx.Err())
+		case ev := <-events:
+			circEv, ok := ev.(*control.CircuitEvent)
+			if !ok || circEv.CircuitID != circuitID {
+				continue
+			}
+			cm.applyCircuitEvent(circuit, circEv)
+
+			switch circEv.Status {
+			case "BUILT":
+				return circuit, nil
+			case "FAILED", "CLOSED":
+				cm.mu.Lock()
+				delete(cm.circuits, circuitID)
+				cm.totalClosed++
+				cm.publishEvent(CircuitEvent{CircuitID: circuitID, Type: "closed", OldStatus: circEv.Status})
+				cm.mu.Unlock()
+				if circEv.Reason != "" {
+					return nil, fmt.Errorf("circuit %s %s: %s", circuitID, strings.ToLower(circEv.Status), circEv.Reason)
+				}
+				return nil, fmt.Errorf("circuit %s %s", circuitID, strings.ToLower(circEv.Status))
+			}
+		}
+	}
+}
+
+// applyCircuitEvent folds a CIRC event into the tracked circuit,
+// notifying subscribers of the status transition
+func (cm *CircuitManager) applyCircuitEvent(circuit *Circuit, ev *control.CircuitEvent) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	old := circuit.Status
+	circuit.Status = ev.Status
+	if ev.Purpose != "" {
+		circuit.Purpose = ev.Purpose
+	}
+	if path := circuitEventPath(ev); len(path) > 0 {
+		circuit.Path = path
+		circuit.ExitNode = path[len(path)-1]
+		if country := cm.resolveExitCountryLocked(circuit.ExitNode); country != "" {
+			circuit.ExitCountry = country
+		}
+	}
+	if old != ev.Status {
+		cm.publishEvent(CircuitEvent{CircuitID: circuit.ID, Type: "status", OldStatus: old, NewStatus: ev.Status})
+	}
 }
 
-// CloseCircuit closes a specific circuit
+// CloseCircuit closes a specific circuit, asking Tor to tear it down
+// when it is a real (control-port) circuit
 func (cm *CircuitManager) CloseCircuit(id string) error {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
 
 	log := logger.WithComponent("circuit")
 
-	delete(cm.circuits, id)
+	// Simulated circuits carry the "local-" prefix and have nothing to
+	// close on the Tor side
+	if cm.ctrl != nil && !strings.HasPrefix(id, "local-") {
+		if _, err := cm.ctrl.SendRequest("CLOSECIRCUIT %s", id); err != nil {
+			log.Warn().Err(err).Str("circuit_id", id).Msg("CLOSECIRCUIT failed")
+		}
+	}
+
+	if _, ok := cm.circuits[id]; ok {
+		delete(cm.circuits, id)
+		cm.totalClosed++
+	}
 	log.Info().Str("circuit_id", id).Msg("closed circuit")
 	logger.CircuitEvent(id, "closed")
+	cm.publishEvent(CircuitEvent{CircuitID: id, Type: "closed"})
 
 	return nil
 }
@@ -158,9 +473,288 @@ func (cm *CircuitManager) RotateCircuits() error {
 	}
 
 	log.Info().Msg("circuits rotated, new identity requested")
+
+	cm.mu.Lock()
+	cm.publishEvent(CircuitEvent{Type: "rotated"})
+	cm.mu.Unlock()
+
+	return nil
+}
+
+// SetExitCountry restricts exits to a two-letter country code via
+// SETCONF ExitNodes. Note that ExitNodes is a global Tor option: it
+// applies to every circuit this instance builds, including per-domain
+// isolated circuits from GetCircuitForDomain, until ClearExitCountry
+// is called.
+func (cm *CircuitManager) SetExitCountry(code string) error {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if !isCountryCode(code) {
+		return fmt.Errorf("invalid country code %q: want two letters, e.g. \"DE\"", code)
+	}
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if cm.conf == nil {
+		return fmt.Errorf("not connected to Tor")
+	}
+
+	err := cm.conf.SetConf(
+		&control.KeyVal{Key: "ExitNodes", Val: "{" + strings.ToLower(code) + "}"},
+		&control.KeyVal{Key: "StrictNodes", Val: "1"},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set exit country: %w", err)
+	}
+
+	cm.exitCountry = code
+
+	log := logger.WithComponent("circuit")
+	log.Info().Str("country", code).Msg("exit country set")
+	return nil
+}
+
+// SetExitPolicy restricts exits to the allowed countries and bans the
+// excluded ones via SETCONF ExitNodes/ExcludeExitNodes. Like
+// SetExitCountry this is a global Tor option affecting every circuit
+// this instance builds; use CreateCircuitInCountry for a per-circuit
+// choice. An empty allow list leaves exit selection open apart from the
+// exclusions.
+func (cm *CircuitManager) SetExitPolicy(allowed, excluded []string) error {
+	allowed, err := normalizeCountryCodes(allowed)
+	if err != nil {
+		return err
+	}
+	excluded, err = normalizeCountryCodes(excluded)
+	if err != nil {
+		return err
+	}
+	for _, code := range allowed {
+		for _, ex := range excluded {
+			if code == ex {
+				return fmt.Errorf("country %s is both allowed and excluded", code)
+			}
+		}
+	}
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if cm.conf == nil {
+		return fmt.Errorf("not connected to Tor")
+	}
+
+	strict := "0"
+	if len(allowed) > 0 {
+		strict = "1"
+	}
+	err = cm.conf.SetConf(
+		&control.KeyVal{Key: "ExitNodes", Val: countryCodeList(allowed)},
+		&control.KeyVal{Key: "ExcludeExitNodes", Val: countryCodeList(excluded)},
+		&control.KeyVal{Key: "StrictNodes", Val: strict},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set exit policy: %w", err)
+	}
+
+	cm.exitAllowed = allowed
+	cm.exitExcluded = excluded
+	cm.exitCountry = strings.Join(allowed, ",")
+
+	log := logger.WithComponent("circuit")
+	log.Info().
+		Strs("allowed", allowed).
+		Strs("excluded", excluded).
+		Msg("exit policy set")
+	return nil
+}
+
+// ClearExitPolicy reverts to Tor's default exit selection, removing
+// both the allow and exclude lists
+func (cm *CircuitManager) ClearExitPolicy() error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if cm.conf == nil {
+		return fmt.Errorf("not connected to Tor")
+	}
+
+	err := cm.conf.SetConf(
+		&control.KeyVal{Key: "ExitNodes", Val: ""},
+		&control.KeyVal{Key: "ExcludeExitNodes", Val: ""},
+		&control.KeyVal{Key: "StrictNodes", Val: "0"},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to clear exit policy: %w", err)
+	}
+
+	cm.exitAllowed = nil
+	cm.exitExcluded = nil
+	cm.exitCountry = ""
+
+	log := logger.WithComponent("circuit")
+	log.Info().Msg("exit policy cleared")
 	return nil
 }
 
+// normalizeCountryCodes uppercases, validates and de-duplicates a list
+// of two-letter country codes
+func normalizeCountryCodes(codes []string) ([]string, error) {
+	out := make([]string, 0, len(codes))
+	seen := make(map[string]bool, len(codes))
+	for _, code := range codes {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if !isCountryCode(code) {
+			return nil, fmt.Errorf("invalid country code %q: want two letters, e.g. \"DE\"", code)
+		}
+		if seen[code] {
+			continue
+		}
+		seen[code] = true
+		out = append(out, code)
+	}
+	return out, nil
+}
+
+// countryCodeList renders codes in Tor's {cc},{cc} ExitNodes syntax
+func countryCodeList(codes []string) string {
+	parts := make([]string, len(codes))
+	for i, code := range codes {
+		parts[i] = "{" + strings.ToLower(code) + "}"
+	}
+	return strings.Join(parts, ",")
+}
+
+// ClearExitCountry reverts to Tor's default exit selection
+func (cm *CircuitManager) ClearExitCountry() error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if cm.conf == nil {
+		return fmt.Errorf("not connected to Tor")
+	}
+
+	err := cm.conf.SetConf(
+		&control.KeyVal{Key: "ExitNodes", Val: ""},
+		&control.KeyVal{Key: "StrictNodes", Val: "0"},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to clear exit country: %w", err)
+	}
+
+	cm.exitCountry = ""
+
+	log := logger.WithComponent("circuit")
+	log.Info().Msg("exit country cleared")
+	return nil
+}
+
+// PinGuard pins the entry guard to a specific relay via SETCONF
+// EntryNodes so the first hop stays stable across rotations. NEWNYM (as
+// sent by RotateCircuits) rebuilds circuits but respects EntryNodes, so
+// only the middle and exit hops change while pinned.
+func (cm *CircuitManager) PinGuard(fingerprint string) error {
+	fingerprint = strings.ToUpper(strings.TrimPrefix(strings.TrimSpace(fingerprint), "$"))
+	if !isFingerprint(fingerprint) {
+		return fmt.Errorf("invalid relay fingerprint %q: want 40 hex characters", fingerprint)
+	}
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if cm.conf == nil {
+		return fmt.Errorf("not connected to Tor")
+	}
+
+	err := cm.conf.SetConf(
+		&control.KeyVal{Key: "EntryNodes", Val: "$" + fingerprint},
+		&control.KeyVal{Key: "UseEntryGuards", Val: "1"},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to pin guard: %w", err)
+	}
+
+	cm.pinnedGuard = fingerprint
+
+	log := logger.WithComponent("circuit")
+	log.Info().Str("guard", fingerprint).Msg("entry guard pinned")
+	return nil
+}
+
+// UnpinGuard reverts to Tor's normal guard selection
+func (cm *CircuitManager) UnpinGuard() error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if cm.conf == nil {
+		return fmt.Errorf("not connected to Tor")
+	}
+
+	err := cm.conf.SetConf(
+		&control.KeyVal{Key: "EntryNodes", Val: ""},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to unpin guard: %w", err)
+	}
+
+	cm.pinnedGuard = ""
+
+	log := logger.WithComponent("circuit")
+	log.Info().Msg("entry guard unpinned")
+	return nil
+}
+
+// GetPinnedGuard returns the currently pinned guard fingerprint, if any
+func (cm *CircuitManager) GetPinnedGuard() string {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return cm.pinnedGuard
+}
+
+// isFingerprint reports whether s is a 40-character hex relay fingerprint
+func isFingerprint(s string) bool {
+	if len(s) != 40 {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'A' || c > 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// isCountryCode reports whether s is a two-letter ISO country code
+func isCountryCode(s string) bool {
+	if len(s) != 2 {
+		return false
+	}
+	for _, c := range s {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// RecordBytes accumulates traffic counts on a circuit. The proxy layer
+// calls this per transfer; traffic-based rotation in checkRotation relies
+// on these counters.
+func (cm *CircuitManager) RecordBytes(circuitID string, sent, recv int64) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	circuit, ok := cm.circuits[circuitID]
+	if !ok {
+		return
+	}
+	now := cm.now()
+	circuit.BytesSent += sent
+	circuit.BytesRecv += recv
+	circuit.LastUsed = now
+	circuit.addBWSample(now, sent+recv)
+}
+
 // GetCircuitForDomain returns or creates a circuit for a specific domain
 func (cm *CircuitManager) GetCircuitForDomain(domain string) (*Circuit, error) {
 	cm.mu.RLock()
@@ -173,16 +767,85 @@ func (cm *CircuitManager) GetCircuitForDomain(domain string) (*Circuit, error) {
 	}
 	cm.mu.RUnlock()
 
-	// Create new circuit for this domain
-	circuit, err := cm.CreateCircuit("domain:" + domain)
-	if err != nil {
-		return nil, err
+	// A warm circuit skips the build wait; otherwise build on demand
+	circuit := cm.takeWarmCircuit("domain:" + domain)
+	if circuit == nil {
+		var err error
+		circuit, err = cm.CreateCircuit("domain:" + domain)
+		if err != nil {
+			return nil, err
+		}
 	}
+
+	cm.mu.Lock()
 	circuit.Domain = domain
+	circuit.SocksUser, circuit.SocksPass = newSOCKSCredentials(domain)
+	cm.mu.Unlock()
 
 	return circuit, nil
 }
 
+// GetSOCKSCredentialsForDomain returns the SOCKS auth pair that keeps
+// this domain's streams on their own circuit, creating the circuit if
+// needed. The pair changes whenever the domain's circuit rotates.
+func (cm *CircuitManager) GetSOCKSCredentialsForDomain(domain string) (user, pass string, err error) {
+	circuit, err := cm.GetCircuitForDomain(domain)
+	if err != nil {
+		return "", "", err
+	}
+
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return circuit.SocksUser, circuit.SocksPass, nil
+}
+
+// SetDomainIdleTimeout configures how long a domain circuit may sit
+// unused before eviction; 0 disables eviction
+func (cm *CircuitManager) SetDomainIdleTimeout(d time.Duration) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.domainIdleTimeout = d
+}
+
+// evictIdleDomains closes domain-bound circuits that have been idle past
+// the configured timeout, along with their isolation credentials
+func (cm *CircuitManager) evictIdleDomains() {
+	now := cm.now()
+
+	cm.mu.RLock()
+	timeout := cm.domainIdleTimeout
+	var idle []string
+	if timeout > 0 {
+		for id, c := range cm.circuits {
+			if c.Domain != "" && now.Sub(c.LastUsed) > timeout {
+				idle = append(idle, id)
+			}
+		}
+	}
+	cm.mu.RUnlock()
+
+	log := logger.WithComponent("circuit")
+	for _, id := range idle {
+		log.Info().Str("circuit_id", id).Msg("evicting idle domain circuit")
+		if err := cm.CloseCircuit(id); err != nil {
+			log.Warn().Err(err).Str("circuit_id", id).Msg("failed to evict circuit")
+		}
+	}
+}
+
+// newSOCKSCredentials returns a fresh SOCKS isolation pair for a domain.
+// The username names the domain for debuggability; the random password
+// makes each circuit's pair unique.
+func newSOCKSCredentials(domain string) (string, string) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		// Extremely unlikely; fall back to something still unique enough
+		// to keep domains apart
+		return "torforge-" + domain, fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return "torforge-" + domain, hex.EncodeToString(buf)
+}
+
 // Stop stops the circuit manager
 func (cm *CircuitManager) Stop() {
 	close(cm.stopCh)
@@ -190,7 +853,17 @@ func (cm *CircuitManager) Stop() {
 
 func (cm *CircuitManager) monitorLoop() {
 	log := logger.WithComponent("circuit")
-	ticker := time.NewTicker(30 * time.Second)
+
+	// With the event listener active the poller is only a reconciliation
+	// backstop, so it can run far less often
+	interval := 30 * time.Second
+	cm.mu.RLock()
+	if cm.eventsActive {
+		interval = reconcileInterval
+	}
+	cm.mu.RUnlock()
+
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
@@ -201,6 +874,7 @@ func (cm *CircuitManager) monitorLoop() {
 		case <-ticker.C:
 			cm.updateCircuitStatus()
 			cm.checkRotation()
+			cm.evictIdleDomains()
 		}
 	}
 }
@@ -219,40 +893,135 @@ func (cm *CircuitManager) updateCircuitStatus() {
 		return
 	}
 
-	// Parse circuit status
+	// Parse circuit status, noting transitions for subscribers
+	oldStatus := make(map[string]string, len(cm.circuits))
+	for id, c := range cm.circuits {
+		oldStatus[id] = c.Status
+	}
+
+	// circuit-status replies carry one circuit per line
+	reported := make(map[string]bool, len(info))
 	for _, entry := range info {
-		parseCircuitStatus(entry.Val, cm.circuits)
+		for _, line := range strings.Split(entry.Val, "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+			parseCircuitStatus(line, cm.circuits)
+			if fields := splitFields(line); len(fields) > 0 {
+				reported[fields[0]] = true
+			}
+		}
+	}
+
+	for id, c := range cm.circuits {
+		if prev, ok := oldStatus[id]; ok && prev != c.Status {
+			cm.publishEvent(CircuitEvent{CircuitID: id, Type: "status", OldStatus: prev, NewStatus: c.Status})
+		}
+	}
+
+	// Reconcile: real circuits Tor no longer reports are gone, whether
+	// or not we saw the CLOSED event. Simulated local- circuits are not
+	// Tor's to report.
+	for id, c := range cm.circuits {
+		if strings.HasPrefix(id, "local-") || reported[id] {
+			continue
+		}
+		delete(cm.circuits, id)
+		cm.totalClosed++
+		cm.publishEvent(CircuitEvent{CircuitID: id, Type: "closed", OldStatus: c.Status})
 	}
 }
 
+// checkRotation cycles individual circuits that crossed the age or
+// traffic threshold. Fresh circuits are left alone; the full
+// RotateCircuits/NEWNYM sweep stays an explicit new-identity operation.
 func (cm *CircuitManager) checkRotation() {
-	cm.mu.RLock()
-	defer cm.mu.RUnlock()
+	now := cm.now()
 
-	now := time.Now()
-	for _, c := range cm.circuits {
-		// Time-based rotation
-		if now.Sub(c.CreatedAt) > cm.rotateInterval {
-			go cm.RotateCircuits()
-			return
+	cm.mu.Lock()
+	var due []string
+	for id, c := range cm.circuits {
+		if cm.rotatingIDs[id] {
+			continue
+		}
+		if now.Sub(c.CreatedAt) > cm.rotateInterval || c.BytesSent+c.BytesRecv > cm.rotateBytes {
+			// Marked before the goroutine starts so a second tick in
+			// the same window cannot double-rotate
+			cm.rotatingIDs[id] = true
+			due = append(due, id)
 		}
+	}
+	cm.mu.Unlock()
 
-		// Traffic-based rotation
-		if c.BytesSent+c.BytesRecv > cm.rotateBytes {
-			go cm.RotateCircuits()
-			return
+	log := logger.WithComponent("circuit")
+	for _, id := range due {
+		go func(id string) {
+			defer func() {
+				cm.mu.Lock()
+				delete(cm.rotatingIDs, id)
+				cm.mu.Unlock()
+			}()
+			if err := cm.RotateCircuit(id); err != nil {
+				log.Warn().Err(err).Str("circuit_id", id).Msg("failed to rotate circuit")
+			}
+		}(id)
+	}
+}
+
+// RotateCircuit closes one circuit and builds a replacement with the
+// same purpose and domain binding, leaving every other circuit intact
+func (cm *CircuitManager) RotateCircuit(id string) error {
+	cm.mu.RLock()
+	circuit, ok := cm.circuits[id]
+	var purpose, domain string
+	if ok {
+		purpose = circuit.Purpose
+		domain = circuit.Domain
+	}
+	cm.mu.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("unknown circuit %s", id)
+	}
+
+	if err := cm.CloseCircuit(id); err != nil {
+		return err
+	}
+
+	replacement := cm.takeWarmCircuit(purpose)
+	if replacement == nil {
+		var err error
+		replacement, err = cm.CreateCircuit(purpose)
+		if err != nil {
+			return err
 		}
 	}
+
+	cm.mu.Lock()
+	replacement.Domain = domain
+	if domain != "" {
+		// A fresh isolation identity so old streams cannot follow the
+		// domain onto the new circuit
+		replacement.SocksUser, replacement.SocksPass = newSOCKSCredentials(domain)
+	}
+	cm.publishEvent(CircuitEvent{CircuitID: id, Type: "rotated", NewStatus: replacement.Status})
+	cm.mu.Unlock()
+
+	log := logger.WithComponent("circuit")
+	log.Info().Str("old_circuit", id).Str("new_circuit", replacement.ID).Msg("circuit rotated")
+	return nil
 }
 
 var circuitCounter int64
-var counterMu sync.Mutex
 
+// generateCircuitID returns a unique local circuit ID. The full monotonic
+// counter guarantees uniqueness even when circuits are created faster
+// than once per second; the "local-" prefix is load-bearing for
+// downstream string checks.
 func generateCircuitID() string {
-	counterMu.Lock()
-	defer counterMu.Unlock()
-	circuitCounter++
-	return "local-" + time.Now().Format("150405") + "-" + string('0'+byte(circuitCounter%10))
+	n := atomic.AddInt64(&circuitCounter, 1)
+	return fmt.Sprintf("local-%s-%d", time.Now().Format("150405"), n)
 }
 
 // parseCircuitID extracts circuit ID from EXTENDCIRCUIT response
@@ -292,25 +1061,90 @@ func parseCircuitID(reply string) string {
 // parseCircuitStatus parses circuit status from Tor
 func parseCircuitStatus(statusLine string, circuits map[string]*Circuit) {
 	// Format: <circuitID> <status> <path> <buildFlags> <purpose>...
-	// Example: 16 BUILT $FINGERPRINT1,$FINGERPRINT2 BUILD_FLAGS=... PURPOSE=GENERAL
+	// Example: 16 BUILT $FINGERPRINT1~nick1,$FINGERPRINT2~nick2 BUILD_FLAGS=... PURPOSE=GENERAL
 
-	// Simple parsing
-	var id, status string
 	parts := splitFields(statusLine)
-	if len(parts) >= 2 {
-		id = parts[0]
-		status = parts[1]
+	if len(parts) < 2 {
+		return
 	}
+	id := parts[0]
+	status := parts[1]
 
 	if id == "" {
 		return
 	}
 
-	if circuit, ok := circuits[id]; ok {
-		circuit.Status = status
+	// Measurement circuits are internal to Tor and not ours to track
+	var path []string
+	var buildFlags []string
+	purpose := ""
+	var created time.Time
+	for _, field := range parts[2:] {
+		switch {
+		case strings.HasPrefix(field, "$"):
+			path = parseCircuitPath(field)
+		case strings.HasPrefix(field, "PURPOSE="):
+			purpose = strings.TrimPrefix(field, "PURPOSE=")
+		case strings.HasPrefix(field, "BUILD_FLAGS="):
+			buildFlags = strings.Split(strings.TrimPrefix(field, "BUILD_FLAGS="), ",")
+		case strings.HasPrefix(field, "TIME_CREATED="):
+			created = parseCircuitTime(strings.TrimPrefix(field, "TIME_CREATED="))
+		}
+	}
+	if purpose == "MEASURE_TIMEOUT" {
+		return
+	}
+
+	circuit, ok := circuits[id]
+	if !ok {
+		return
+	}
+
+	circuit.Status = status
+	if purpose != "" {
+		circuit.Purpose = purpose
+	}
+	if len(buildFlags) > 0 {
+		circuit.BuildFlags = buildFlags
+	}
+	if !created.IsZero() {
+		// Tor's clock is authoritative for circuits it built
+		circuit.CreatedAt = created
+	}
+	if len(path) > 0 {
+		circuit.Path = path
+		circuit.ExitNode = path[len(path)-1]
 	}
 }
 
+// parseCircuitTime parses a TIME_CREATED value, which Tor reports in
+// local time with optional fractional seconds
+func parseCircuitTime(val string) time.Time {
+	for _, layout := range []string{"2006-01-02T15:04:05.999999", "2006-01-02T15:04:05"} {
+		if t, err := time.ParseInLocation(layout, val, time.Local); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// parseCircuitPath parses a comma-separated relay list ($FP~nick,$FP~nick,...)
+// into bare fingerprints.
+func parseCircuitPath(field string) []string {
+	var path []string
+	for _, hop := range strings.Split(field, ",") {
+		hop = strings.TrimPrefix(hop, "$")
+		// Strip the ~nickname or =nickname suffix if present
+		if idx := strings.IndexAny(hop, "~="); idx >= 0 {
+			hop = hop[:idx]
+		}
+		if hop != "" {
+			path = append(path, hop)
+		}
+	}
+	return path
+}
+
 func splitFields(s string) []string {
 	var fields []string
 	var current string
@@ -339,6 +1173,42 @@ type CircuitStats struct {
 	AverageLatency time.Duration
 	TotalBytesSent int64
 	TotalBytesRecv int64
+	ThroughputBps  float64 // Aggregate bytes/sec over the sliding window
+	WarmCircuits   int     // Pre-built circuits awaiting consumption
+	WarmTarget     int     // Configured pool size (0 = pool disabled)
+	PoolReady      bool    // At least one warm circuit available
+}
+
+// CircuitStat describes one circuit's traffic counters
+type CircuitStat struct {
+	ID            string
+	BytesSent     int64
+	BytesRecv     int64
+	ThroughputBps float64 // Bytes/sec over the sliding window
+	Age           time.Duration
+	LastUsed      time.Time
+}
+
+// GetCircuitStats returns traffic counters and current throughput for
+// one circuit
+func (cm *CircuitManager) GetCircuitStats(id string) (CircuitStat, error) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	circuit, ok := cm.circuits[id]
+	if !ok {
+		return CircuitStat{}, fmt.Errorf("unknown circuit %s", id)
+	}
+
+	now := cm.now()
+	return CircuitStat{
+		ID:            circuit.ID,
+		BytesSent:     circuit.BytesSent,
+		BytesRecv:     circuit.BytesRecv,
+		ThroughputBps: circuit.throughput(now),
+		Age:           now.Sub(circuit.CreatedAt),
+		LastUsed:      circuit.LastUsed,
+	}, nil
 }
 
 // GetStats returns circuit statistics
@@ -347,12 +1217,30 @@ func (cm *CircuitManager) GetStats() CircuitStats {
 	defer cm.mu.RUnlock()
 
 	stats := CircuitStats{
-		ActiveCount: len(cm.circuits),
+		TotalCreated: cm.totalCreated,
+		TotalClosed:  cm.totalClosed,
+		ActiveCount:  len(cm.circuits),
 	}
 
+	now := cm.now()
+	var latencySum time.Duration
+	var latencyCount int
 	for _, c := range cm.circuits {
 		stats.TotalBytesSent += c.BytesSent
 		stats.TotalBytesRecv += c.BytesRecv
+		stats.ThroughputBps += c.throughput(now)
+		if c.warm {
+			stats.WarmCircuits++
+		}
+		if c.Latency > 0 {
+			latencySum += c.Latency
+			latencyCount++
+		}
+	}
+	stats.WarmTarget = cm.warmTarget
+	stats.PoolReady = stats.WarmCircuits > 0
+	if latencyCount > 0 {
+		stats.AverageLatency = latencySum / time.Duration(latencyCount)
 	}
 
 	return stats
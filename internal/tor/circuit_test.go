@@ -0,0 +1,961 @@
+package tor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cretz/bine/control"
+)
+
+// fakeConfSetter records SETCONF calls for assertions
+type fakeConfSetter struct {
+	entries []*control.KeyVal
+}
+
+func (f *fakeConfSetter) SetConf(entries ...*control.KeyVal) error {
+	f.entries = append(f.entries, entries...)
+	return nil
+}
+
+func (f *fakeConfSetter) get(key string) (string, bool) {
+	for i := len(f.entries) - 1; i >= 0; i-- {
+		if f.entries[i].Key == key {
+			return f.entries[i].Val, true
+		}
+	}
+	return "", false
+}
+
+func TestSetExitCountry(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+
+	fake := &fakeConfSetter{}
+	cm.conf = fake
+
+	if err := cm.SetExitCountry("de"); err != nil {
+		t.Fatalf("SetExitCountry() error = %v", err)
+	}
+
+	if val, ok := fake.get("ExitNodes"); !ok || val != "{de}" {
+		t.Errorf("ExitNodes = %q, want {de}", val)
+	}
+	if val, ok := fake.get("StrictNodes"); !ok || val != "1" {
+		t.Errorf("StrictNodes = %q, want 1", val)
+	}
+
+	// New circuits record the requested country
+	circuit, err := cm.CreateCircuit("test")
+	if err != nil {
+		t.Fatalf("CreateCircuit() error = %v", err)
+	}
+	if circuit.ExitCountry != "DE" {
+		t.Errorf("circuit exit country = %q, want DE", circuit.ExitCountry)
+	}
+
+	// Clearing reverts both options
+	if err := cm.ClearExitCountry(); err != nil {
+		t.Fatalf("ClearExitCountry() error = %v", err)
+	}
+	if val, _ := fake.get("ExitNodes"); val != "" {
+		t.Errorf("ExitNodes after clear = %q, want empty", val)
+	}
+	if val, _ := fake.get("StrictNodes"); val != "0" {
+		t.Errorf("StrictNodes after clear = %q, want 0", val)
+	}
+}
+
+func TestPinGuard(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+
+	fake := &fakeConfSetter{}
+	cm.conf = fake
+
+	fp := "AAAA1111AAAA1111AAAA1111AAAA1111AAAA1111"
+	if err := cm.PinGuard("$" + fp); err != nil {
+		t.Fatalf("PinGuard() error = %v", err)
+	}
+
+	if val, ok := fake.get("EntryNodes"); !ok || val != "$"+fp {
+		t.Errorf("EntryNodes = %q, want $%s", val, fp)
+	}
+	if val, ok := fake.get("UseEntryGuards"); !ok || val != "1" {
+		t.Errorf("UseEntryGuards = %q, want 1", val)
+	}
+	if cm.GetPinnedGuard() != fp {
+		t.Errorf("pinned guard = %q, want %s", cm.GetPinnedGuard(), fp)
+	}
+
+	if err := cm.UnpinGuard(); err != nil {
+		t.Fatalf("UnpinGuard() error = %v", err)
+	}
+	if val, _ := fake.get("EntryNodes"); val != "" {
+		t.Errorf("EntryNodes after unpin = %q, want empty", val)
+	}
+	if cm.GetPinnedGuard() != "" {
+		t.Error("pinned guard should be cleared")
+	}
+}
+
+func TestPinGuardValidation(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+	cm.conf = &fakeConfSetter{}
+
+	for _, bad := range []string{"", "nope", "ZZZZ1111AAAA1111AAAA1111AAAA1111AAAA1111",
+		"AAAA1111AAAA1111AAAA1111AAAA1111AAAA11"} {
+		if err := cm.PinGuard(bad); err == nil {
+			t.Errorf("PinGuard(%q) should error", bad)
+		}
+	}
+}
+
+func TestSetExitCountryValidation(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+	cm.conf = &fakeConfSetter{}
+
+	for _, bad := range []string{"", "D", "DEU", "12", "d!"} {
+		if err := cm.SetExitCountry(bad); err == nil {
+			t.Errorf("SetExitCountry(%q) should error", bad)
+		}
+	}
+}
+
+func TestSetExitCountryNotConnected(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+
+	if err := cm.SetExitCountry("DE"); err == nil {
+		t.Error("SetExitCountry without control connection should error")
+	}
+}
+
+func TestParseCircuitStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		line       string
+		wantStatus string
+		wantPath   int
+		wantExit   string
+	}{
+		{
+			name:       "built with path and purpose",
+			line:       "16 BUILT $AAAA1111AAAA1111AAAA1111AAAA1111AAAA1111~guard,$BBBB2222BBBB2222BBBB2222BBBB2222BBBB2222~middle,$CCCC3333CCCC3333CCCC3333CCCC3333CCCC3333~exit BUILD_FLAGS=NEED_CAPACITY PURPOSE=GENERAL",
+			wantStatus: "BUILT",
+			wantPath:   3,
+			wantExit:   "CCCC3333CCCC3333CCCC3333CCCC3333CCCC3333",
+		},
+		{
+			name:       "extended with equals nickname separator",
+			line:       "16 EXTENDED $AAAA1111AAAA1111AAAA1111AAAA1111AAAA1111=guard PURPOSE=GENERAL",
+			wantStatus: "EXTENDED",
+			wantPath:   1,
+			wantExit:   "AAAA1111AAAA1111AAAA1111AAAA1111AAAA1111",
+		},
+		{
+			name:       "launched without path",
+			line:       "16 LAUNCHED PURPOSE=GENERAL",
+			wantStatus: "LAUNCHED",
+			wantPath:   0,
+			wantExit:   "",
+		},
+		{
+			name:       "bare fingerprints without nicknames",
+			line:       "16 BUILT $AAAA1111AAAA1111AAAA1111AAAA1111AAAA1111,$BBBB2222BBBB2222BBBB2222BBBB2222BBBB2222",
+			wantStatus: "BUILT",
+			wantPath:   2,
+			wantExit:   "BBBB2222BBBB2222BBBB2222BBBB2222BBBB2222",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			circuits := map[string]*Circuit{
+				"16": {ID: "16", Status: "BUILDING"},
+			}
+
+			parseCircuitStatus(tt.line, circuits)
+
+			circuit := circuits["16"]
+			if circuit.Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", circuit.Status, tt.wantStatus)
+			}
+			if len(circuit.Path) != tt.wantPath {
+				t.Errorf("path length = %d, want %d", len(circuit.Path), tt.wantPath)
+			}
+			if circuit.ExitNode != tt.wantExit {
+				t.Errorf("exit node = %s, want %s", circuit.ExitNode, tt.wantExit)
+			}
+		})
+	}
+}
+
+func TestParseCircuitStatusSkipsMeasurement(t *testing.T) {
+	circuits := map[string]*Circuit{
+		"7": {ID: "7", Status: "BUILDING"},
+	}
+
+	// Tor's internal measurement circuits should never update ours
+	parseCircuitStatus("7 BUILT $AAAA1111AAAA1111AAAA1111AAAA1111AAAA1111~relay PURPOSE=MEASURE_TIMEOUT", circuits)
+
+	if circuits["7"].Status != "BUILDING" {
+		t.Errorf("measurement circuit should be skipped, status = %s", circuits["7"].Status)
+	}
+	if len(circuits["7"].Path) != 0 {
+		t.Error("measurement circuit should not populate path")
+	}
+}
+
+func TestGenerateCircuitIDUnique(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+
+	for i := 0; i < 1000; i++ {
+		if _, err := cm.CreateCircuit("test"); err != nil {
+			t.Fatalf("CreateCircuit() error = %v", err)
+		}
+	}
+
+	if got := cm.GetCount(); got != 1000 {
+		t.Errorf("GetCount() = %d, want 1000 (duplicate IDs clobbered circuits)", got)
+	}
+
+	// The local- prefix is relied on by downstream string checks
+	for _, c := range cm.GetCircuits() {
+		if !strings.HasPrefix(c.ID, "local-") {
+			t.Fatalf("circuit ID %q missing local- prefix", c.ID)
+		}
+	}
+}
+
+func TestGetStatsCounters(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+
+	var first *Circuit
+	for i := 0; i < 3; i++ {
+		c, err := cm.CreateCircuit("test")
+		if err != nil {
+			t.Fatalf("CreateCircuit() error = %v", err)
+		}
+		if first == nil {
+			first = c
+		}
+	}
+
+	if err := cm.CloseCircuit(first.ID); err != nil {
+		t.Fatalf("CloseCircuit() error = %v", err)
+	}
+
+	stats := cm.GetStats()
+	if stats.TotalCreated != 3 {
+		t.Errorf("TotalCreated = %d, want 3", stats.TotalCreated)
+	}
+	if stats.TotalClosed != 1 {
+		t.Errorf("TotalClosed = %d, want 1", stats.TotalClosed)
+	}
+	if stats.ActiveCount != 2 {
+		t.Errorf("ActiveCount = %d, want 2", stats.ActiveCount)
+	}
+
+	// Closing an unknown circuit is not counted
+	if err := cm.CloseCircuit("no-such-circuit"); err != nil {
+		t.Fatalf("CloseCircuit() error = %v", err)
+	}
+	if got := cm.GetStats().TotalClosed; got != 1 {
+		t.Errorf("TotalClosed after bogus close = %d, want 1", got)
+	}
+}
+
+func TestGetStatsAverageLatency(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+
+	a, _ := cm.CreateCircuit("test")
+	b, _ := cm.CreateCircuit("test")
+	c, _ := cm.CreateCircuit("test")
+
+	// One circuit without a measured latency is skipped from the average
+	a.Latency = 100 * time.Millisecond
+	b.Latency = 300 * time.Millisecond
+	c.Latency = 0
+
+	if got := cm.GetStats().AverageLatency; got != 200*time.Millisecond {
+		t.Errorf("AverageLatency = %v, want 200ms", got)
+	}
+}
+
+func TestCircuitEventSubscription(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+
+	events, unsubscribe := cm.Subscribe()
+	defer unsubscribe()
+
+	circuit, err := cm.CreateCircuit("test")
+	if err != nil {
+		t.Fatalf("CreateCircuit() error = %v", err)
+	}
+	if err := cm.CloseCircuit(circuit.ID); err != nil {
+		t.Fatalf("CloseCircuit() error = %v", err)
+	}
+
+	var got []CircuitEvent
+drain:
+	for {
+		select {
+		case ev := <-events:
+			got = append(got, ev)
+		default:
+			break drain
+		}
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("expected 2 events, got %d: %+v", len(got), got)
+	}
+	if got[0].Type != "created" || got[0].CircuitID != circuit.ID {
+		t.Errorf("first event = %+v, want created for %s", got[0], circuit.ID)
+	}
+	if got[1].Type != "closed" || got[1].CircuitID != circuit.ID {
+		t.Errorf("second event = %+v, want closed for %s", got[1], circuit.ID)
+	}
+
+	// After unsubscribing, events are no longer delivered
+	unsubscribe()
+	if _, err := cm.CreateCircuit("test"); err != nil {
+		t.Fatalf("CreateCircuit() error = %v", err)
+	}
+	if _, ok := <-events; ok {
+		t.Error("channel should be closed after unsubscribe")
+	}
+}
+
+func TestCircuitEventsDropWhenSlow(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+
+	// Never drained: the buffer fills and further events are dropped
+	_, unsubscribe := cm.Subscribe()
+	defer unsubscribe()
+
+	for i := 0; i < 100; i++ {
+		if _, err := cm.CreateCircuit("test"); err != nil {
+			t.Fatalf("CreateCircuit() error = %v", err)
+		}
+	}
+
+	if cm.DroppedEvents() == 0 {
+		t.Error("expected dropped events with a slow subscriber")
+	}
+}
+
+func TestRecordBytesTriggersRotation(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+
+	// Long interval so only the traffic threshold can fire
+	cm.SetRotationPolicy(time.Hour, 1024)
+
+	circuit, err := cm.CreateCircuit("test")
+	if err != nil {
+		t.Fatalf("CreateCircuit() error = %v", err)
+	}
+
+	cm.RecordBytes(circuit.ID, 600, 600)
+	if circuit.BytesSent != 600 || circuit.BytesRecv != 600 {
+		t.Errorf("bytes = %d/%d, want 600/600", circuit.BytesSent, circuit.BytesRecv)
+	}
+
+	// Crossing the threshold makes checkRotation cycle this circuit:
+	// closed and replaced by a fresh one, not nuked
+	cm.checkRotation()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, ok := cm.getCircuit(circuit.ID); !ok && cm.GetCount() == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("circuit was not cycled after crossing byte threshold, count = %d", cm.GetCount())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The replacement starts with clean counters
+	replacement := cm.GetCircuits()[0]
+	if replacement.BytesSent != 0 || replacement.BytesRecv != 0 {
+		t.Errorf("replacement bytes = %d/%d, want 0/0", replacement.BytesSent, replacement.BytesRecv)
+	}
+}
+
+// getCircuit is a test helper to look up a circuit by ID
+func (cm *CircuitManager) getCircuit(id string) (*Circuit, bool) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	c, ok := cm.circuits[id]
+	return c, ok
+}
+
+func TestCheckRotationCyclesOnlyOffender(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+
+	base := time.Now()
+	cm.now = func() time.Time { return base }
+	cm.SetRotationPolicy(10*time.Minute, 1<<40)
+
+	stale, err := cm.CreateCircuit("stale")
+	if err != nil {
+		t.Fatalf("CreateCircuit() error = %v", err)
+	}
+	fresh, err := cm.CreateCircuit("fresh")
+	if err != nil {
+		t.Fatalf("CreateCircuit() error = %v", err)
+	}
+
+	// Age only the first circuit past the interval
+	cm.mu.Lock()
+	cm.circuits[stale.ID].CreatedAt = base.Add(-time.Hour)
+	cm.mu.Unlock()
+
+	cm.checkRotation()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, ok := cm.getCircuit(stale.ID); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("stale circuit was not rotated")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The fresh circuit survived untouched
+	if _, ok := cm.getCircuit(fresh.ID); !ok {
+		t.Error("fresh circuit was rotated along with the stale one")
+	}
+	if got := cm.GetCount(); got != 2 {
+		t.Errorf("GetCount() = %d, want 2 (replacement + fresh)", got)
+	}
+}
+
+func TestCheckRotationSkipsInFlightRotation(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+
+	cm.SetRotationPolicy(time.Hour, 1024)
+
+	circuit, err := cm.CreateCircuit("test")
+	if err != nil {
+		t.Fatalf("CreateCircuit() error = %v", err)
+	}
+	cm.RecordBytes(circuit.ID, 2048, 0)
+
+	// Pretend a rotation for this circuit is already running
+	cm.mu.Lock()
+	cm.rotatingIDs[circuit.ID] = true
+	cm.mu.Unlock()
+
+	cm.checkRotation()
+	time.Sleep(50 * time.Millisecond)
+
+	if _, ok := cm.getCircuit(circuit.ID); !ok {
+		t.Error("circuit was rotated twice: checkRotation ignored the in-flight marker")
+	}
+}
+
+func TestRotateCircuitKeepsDomainBinding(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+
+	circuit, err := cm.GetCircuitForDomain("example.com")
+	if err != nil {
+		t.Fatalf("GetCircuitForDomain() error = %v", err)
+	}
+
+	if err := cm.RotateCircuit(circuit.ID); err != nil {
+		t.Fatalf("RotateCircuit() error = %v", err)
+	}
+
+	circuits := cm.GetCircuits()
+	if len(circuits) != 1 {
+		t.Fatalf("GetCount() = %d, want 1", len(circuits))
+	}
+	if circuits[0].ID == circuit.ID {
+		t.Error("circuit was not replaced")
+	}
+	if circuits[0].Domain != "example.com" {
+		t.Errorf("replacement domain = %q, want example.com", circuits[0].Domain)
+	}
+}
+
+func TestRotateCircuitUnknown(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+
+	if err := cm.RotateCircuit("no-such-circuit"); err == nil {
+		t.Error("RotateCircuit() with unknown ID should error")
+	}
+}
+
+func TestRecordBytesUnknownCircuit(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+
+	// Must not panic or create a phantom circuit
+	cm.RecordBytes("no-such-circuit", 100, 100)
+	if cm.GetCount() != 0 {
+		t.Error("RecordBytes should not create circuits")
+	}
+}
+
+func TestParseCircuitStatusUnknownCircuit(t *testing.T) {
+	circuits := map[string]*Circuit{}
+
+	// Status lines for circuits we don't track must not panic or insert
+	parseCircuitStatus("42 BUILT $AAAA1111AAAA1111AAAA1111AAAA1111AAAA1111~relay PURPOSE=GENERAL", circuits)
+
+	if len(circuits) != 0 {
+		t.Errorf("unknown circuit should not be inserted, got %d circuits", len(circuits))
+	}
+}
+
+// fakeCircuitControl mocks the control connection for circuit builds,
+// scripting the CIRC events Tor would emit after EXTENDCIRCUIT
+type fakeCircuitControl struct {
+	mu        sync.Mutex
+	requests  []string
+	listeners []chan<- control.Event
+	circuitID string
+	events    []*control.CircuitEvent // Emitted after EXTENDCIRCUIT
+	extendErr error
+	infoData  []string // Data lines returned for GETINFO
+}
+
+func (f *fakeCircuitControl) SendRequest(format string, args ...interface{}) (*control.Response, error) {
+	cmd := fmt.Sprintf(format, args...)
+
+	f.mu.Lock()
+	f.requests = append(f.requests, cmd)
+	listeners := append([]chan<- control.Event(nil), f.listeners...)
+	events := f.events
+	f.mu.Unlock()
+
+	if strings.HasPrefix(cmd, "EXTENDCIRCUIT") {
+		if f.extendErr != nil {
+			return nil, f.extendErr
+		}
+		go func() {
+			for _, ev := range events {
+				for _, ch := range listeners {
+					ch <- ev
+				}
+			}
+		}()
+		return &control.Response{Reply: "EXTENDED " + f.circuitID}, nil
+	}
+	if strings.HasPrefix(cmd, "GETINFO") {
+		return &control.Response{Reply: "OK", Data: f.infoData}, nil
+	}
+	return &control.Response{Reply: "OK"}, nil
+}
+
+func (f *fakeCircuitControl) AddEventListener(ch chan<- control.Event, _ ...control.EventCode) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.listeners = append(f.listeners, ch)
+	return nil
+}
+
+func (f *fakeCircuitControl) RemoveEventListener(ch chan<- control.Event, _ ...control.EventCode) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, l := range f.listeners {
+		if l == ch {
+			f.listeners = append(f.listeners[:i], f.listeners[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (f *fakeCircuitControl) sawRequest(prefix string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, req := range f.requests {
+		if strings.HasPrefix(req, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCreateCircuitViaControl(t *testing.T) {
+	guard := strings.Repeat("A", 40)
+	middle := strings.Repeat("B", 40)
+	exit := strings.Repeat("C", 40)
+
+	fake := &fakeCircuitControl{
+		circuitID: "7",
+		events: []*control.CircuitEvent{
+			{CircuitID: "7", Status: "LAUNCHED"},
+			{CircuitID: "9", Status: "BUILT"}, // Someone else's circuit
+			{CircuitID: "7", Status: "EXTENDED", Path: []string{"$" + guard + "~guard"}},
+			{CircuitID: "7", Status: "BUILT", Path: []string{"$" + guard + "~guard", "$" + middle + "~mid", "$" + exit + "~exit"}, Purpose: "GENERAL"},
+		},
+	}
+
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+	cm.ctrl = fake
+
+	circuit, err := cm.CreateCircuit("test")
+	if err != nil {
+		t.Fatalf("CreateCircuit() error = %v", err)
+	}
+
+	if circuit.ID != "7" {
+		t.Errorf("circuit ID = %q, want 7", circuit.ID)
+	}
+	if circuit.Status != "BUILT" {
+		t.Errorf("circuit status = %q, want BUILT", circuit.Status)
+	}
+	if len(circuit.Path) != 3 {
+		t.Fatalf("circuit path = %v, want 3 hops", circuit.Path)
+	}
+	if circuit.ExitNode != exit {
+		t.Errorf("exit node = %q, want %q", circuit.ExitNode, exit)
+	}
+
+	if !fake.sawRequest("EXTENDCIRCUIT 0") {
+		t.Errorf("EXTENDCIRCUIT 0 was not sent, requests = %v", fake.requests)
+	}
+	if got := cm.GetCount(); got != 1 {
+		t.Errorf("GetCount() = %d, want 1", got)
+	}
+	if stats := cm.GetStats(); stats.TotalCreated != 1 {
+		t.Errorf("TotalCreated = %d, want 1", stats.TotalCreated)
+	}
+}
+
+func TestCreateCircuitBuildFailure(t *testing.T) {
+	fake := &fakeCircuitControl{
+		circuitID: "12",
+		events: []*control.CircuitEvent{
+			{CircuitID: "12", Status: "LAUNCHED"},
+			{CircuitID: "12", Status: "FAILED", Reason: "TIMEOUT"},
+		},
+	}
+
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+	cm.ctrl = fake
+
+	_, err := cm.CreateCircuit("test")
+	if err == nil {
+		t.Fatal("CreateCircuit() succeeded, want build failure")
+	}
+	if !strings.Contains(err.Error(), "TIMEOUT") {
+		t.Errorf("error %q does not carry the failure reason", err)
+	}
+
+	// The failed circuit is not left behind
+	if got := cm.GetCount(); got != 0 {
+		t.Errorf("GetCount() = %d, want 0", got)
+	}
+	if stats := cm.GetStats(); stats.TotalClosed != 1 {
+		t.Errorf("TotalClosed = %d, want 1", stats.TotalClosed)
+	}
+}
+
+func TestCreateCircuitContextTimeout(t *testing.T) {
+	// Tor never reports BUILT
+	fake := &fakeCircuitControl{
+		circuitID: "3",
+		events: []*control.CircuitEvent{
+			{CircuitID: "3", Status: "LAUNCHED"},
+		},
+	}
+
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+	cm.ctrl = fake
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := cm.CreateCircuitContext(ctx, "test")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("CreateCircuitContext() error = %v, want deadline exceeded", err)
+	}
+}
+
+func TestCreateCircuitExtendError(t *testing.T) {
+	fake := &fakeCircuitControl{extendErr: errors.New("551 Couldn't start circuit")}
+
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+	cm.ctrl = fake
+
+	if _, err := cm.CreateCircuit("test"); err == nil {
+		t.Fatal("CreateCircuit() succeeded, want EXTENDCIRCUIT error")
+	}
+	if got := cm.GetCount(); got != 0 {
+		t.Errorf("GetCount() = %d, want 0", got)
+	}
+}
+
+func TestCloseCircuitViaControl(t *testing.T) {
+	fake := &fakeCircuitControl{
+		circuitID: "7",
+		events: []*control.CircuitEvent{
+			{CircuitID: "7", Status: "BUILT", Path: []string{"$" + strings.Repeat("A", 40) + "~relay"}},
+		},
+	}
+
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+	cm.ctrl = fake
+
+	circuit, err := cm.CreateCircuit("test")
+	if err != nil {
+		t.Fatalf("CreateCircuit() error = %v", err)
+	}
+
+	if err := cm.CloseCircuit(circuit.ID); err != nil {
+		t.Fatalf("CloseCircuit() error = %v", err)
+	}
+	if !fake.sawRequest("CLOSECIRCUIT 7") {
+		t.Errorf("CLOSECIRCUIT 7 was not sent, requests = %v", fake.requests)
+	}
+	if got := cm.GetCount(); got != 0 {
+		t.Errorf("GetCount() = %d, want 0", got)
+	}
+}
+
+func TestCloseSimulatedCircuitSkipsControl(t *testing.T) {
+	fake := &fakeCircuitControl{}
+
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+
+	// Simulated circuit created before the control connection appeared
+	circuit := cm.createSimulatedCircuit("test")
+	cm.ctrl = fake
+
+	if err := cm.CloseCircuit(circuit.ID); err != nil {
+		t.Fatalf("CloseCircuit() error = %v", err)
+	}
+	if fake.sawRequest("CLOSECIRCUIT") {
+		t.Errorf("CLOSECIRCUIT sent for simulated circuit, requests = %v", fake.requests)
+	}
+}
+
+func TestParseCircuitStatusExtendedFields(t *testing.T) {
+	guard := "$AAAA1111AAAA1111AAAA1111AAAA1111AAAA1111~guard"
+	exit := "CCCC3333CCCC3333CCCC3333CCCC3333CCCC3333"
+
+	tests := []struct {
+		name        string
+		line        string
+		wantPurpose string
+		wantFlags   []string
+		wantExit    string
+		wantYear    int // 0 means CreatedAt untouched
+	}{
+		{
+			name:        "full general circuit",
+			line:        "16 BUILT " + guard + ",$BBBB2222BBBB2222BBBB2222BBBB2222BBBB2222~middle,$" + exit + "~exit BUILD_FLAGS=NEED_CAPACITY PURPOSE=GENERAL TIME_CREATED=2026-08-28T09:15:30.123456",
+			wantPurpose: "GENERAL",
+			wantFlags:   []string{"NEED_CAPACITY"},
+			wantExit:    exit,
+			wantYear:    2026,
+		},
+		{
+			name:        "one-hop directory circuit",
+			line:        "16 BUILT " + guard + " BUILD_FLAGS=IS_INTERNAL,ONEHOP_TUNNEL PURPOSE=GENERAL TIME_CREATED=2026-08-28T09:15:30",
+			wantPurpose: "GENERAL",
+			wantFlags:   []string{"IS_INTERNAL", "ONEHOP_TUNNEL"},
+			wantExit:    "AAAA1111AAAA1111AAAA1111AAAA1111AAAA1111",
+			wantYear:    2026,
+		},
+		{
+			name:        "onion service rendezvous circuit",
+			line:        "16 BUILT " + guard + ",$BBBB2222BBBB2222BBBB2222BBBB2222BBBB2222~middle,$" + exit + "~rend BUILD_FLAGS=IS_INTERNAL,NEED_CAPACITY,NEED_UPTIME PURPOSE=HS_CLIENT_REND REND_QUERY=vww6ybal4bd7szmgncyruucpgfkqahzddi37ktceo3ah7ngmcopnpyyd",
+			wantPurpose: "HS_CLIENT_REND",
+			wantFlags:   []string{"IS_INTERNAL", "NEED_CAPACITY", "NEED_UPTIME"},
+			wantExit:    exit,
+		},
+		{
+			name:     "minimal line without optional fields",
+			line:     "16 LAUNCHED",
+			wantExit: "",
+		},
+		{
+			name:        "unknown field with IPv6 address",
+			line:        "16 BUILT " + guard + " PURPOSE=GENERAL SOCKS_USERNAME=\"[2001:db8::1]:443\"",
+			wantPurpose: "GENERAL",
+			wantExit:    "AAAA1111AAAA1111AAAA1111AAAA1111AAAA1111",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			circuits := map[string]*Circuit{
+				"16": {ID: "16", Status: "BUILDING"},
+			}
+
+			parseCircuitStatus(tt.line, circuits)
+
+			circuit := circuits["16"]
+			if circuit.Purpose != tt.wantPurpose {
+				t.Errorf("purpose = %q, want %q", circuit.Purpose, tt.wantPurpose)
+			}
+			if len(circuit.BuildFlags) != len(tt.wantFlags) {
+				t.Errorf("build flags = %v, want %v", circuit.BuildFlags, tt.wantFlags)
+			} else {
+				for i, flag := range tt.wantFlags {
+					if circuit.BuildFlags[i] != flag {
+						t.Errorf("build flag %d = %q, want %q", i, circuit.BuildFlags[i], flag)
+					}
+				}
+			}
+			if circuit.ExitNode != tt.wantExit {
+				t.Errorf("exit node = %q, want %q", circuit.ExitNode, tt.wantExit)
+			}
+			if tt.wantYear != 0 && circuit.CreatedAt.Year() != tt.wantYear {
+				t.Errorf("CreatedAt = %v, want year %d", circuit.CreatedAt, tt.wantYear)
+			}
+			if tt.wantYear == 0 && !circuit.CreatedAt.IsZero() {
+				t.Errorf("CreatedAt = %v, want untouched zero value", circuit.CreatedAt)
+			}
+		})
+	}
+}
+
+func TestParseCircuitTime(t *testing.T) {
+	if got := parseCircuitTime("2026-08-28T09:15:30.123456"); got.IsZero() {
+		t.Error("fractional timestamp did not parse")
+	}
+	if got := parseCircuitTime("2026-08-28T09:15:30"); got.IsZero() {
+		t.Error("whole-second timestamp did not parse")
+	}
+	if got := parseCircuitTime("garbage"); !got.IsZero() {
+		t.Errorf("garbage parsed to %v, want zero", got)
+	}
+}
+
+func TestSOCKSCredentialsPerDomain(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+
+	userA, passA, err := cm.GetSOCKSCredentialsForDomain("a.example")
+	if err != nil {
+		t.Fatalf("GetSOCKSCredentialsForDomain() error = %v", err)
+	}
+	userB, passB, err := cm.GetSOCKSCredentialsForDomain("b.example")
+	if err != nil {
+		t.Fatalf("GetSOCKSCredentialsForDomain() error = %v", err)
+	}
+
+	if userA == userB {
+		t.Errorf("both domains share SOCKS user %q", userA)
+	}
+	if passA == passB {
+		t.Error("both domains share the same SOCKS password")
+	}
+	if passA == "" || passB == "" {
+		t.Error("empty SOCKS password")
+	}
+}
+
+func TestSOCKSCredentialsStableWhileCircuitLives(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+
+	circuit, err := cm.GetCircuitForDomain("stable.example")
+	if err != nil {
+		t.Fatalf("GetCircuitForDomain() error = %v", err)
+	}
+
+	// Mark BUILT so the next lookup reuses this circuit
+	cm.mu.Lock()
+	cm.circuits[circuit.ID].Status = "BUILT"
+	cm.mu.Unlock()
+
+	user1, pass1, _ := cm.GetSOCKSCredentialsForDomain("stable.example")
+	user2, pass2, _ := cm.GetSOCKSCredentialsForDomain("stable.example")
+	if user1 != user2 || pass1 != pass2 {
+		t.Error("credentials changed without the circuit rotating")
+	}
+}
+
+func TestRotationChangesSOCKSCredentials(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+
+	circuit, err := cm.GetCircuitForDomain("rotate.example")
+	if err != nil {
+		t.Fatalf("GetCircuitForDomain() error = %v", err)
+	}
+	oldPass := circuit.SocksPass
+
+	if err := cm.RotateCircuit(circuit.ID); err != nil {
+		t.Fatalf("RotateCircuit() error = %v", err)
+	}
+
+	replacement := cm.GetCircuits()[0]
+	if replacement.Domain != "rotate.example" {
+		t.Fatalf("replacement domain = %q, want rotate.example", replacement.Domain)
+	}
+	if replacement.SocksPass == oldPass {
+		t.Error("rotation did not change the SOCKS isolation password")
+	}
+	if replacement.SocksUser != "torforge-rotate.example" {
+		t.Errorf("SOCKS user = %q, want torforge-rotate.example", replacement.SocksUser)
+	}
+}
+
+func TestEvictIdleDomainCircuits(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+
+	base := time.Now()
+	cm.now = func() time.Time { return base }
+	cm.SetDomainIdleTimeout(10 * time.Minute)
+	// Keep rotation out of the picture
+	cm.SetRotationPolicy(24*time.Hour, 1<<40)
+
+	domainCircuit, err := cm.GetCircuitForDomain("idle.example")
+	if err != nil {
+		t.Fatalf("GetCircuitForDomain() error = %v", err)
+	}
+	general, err := cm.CreateCircuit("general")
+	if err != nil {
+		t.Fatalf("CreateCircuit() error = %v", err)
+	}
+
+	// Only the domain circuit is subject to idle eviction
+	cm.mu.Lock()
+	cm.circuits[domainCircuit.ID].LastUsed = base.Add(-time.Hour)
+	cm.circuits[general.ID].LastUsed = base.Add(-time.Hour)
+	cm.mu.Unlock()
+
+	cm.evictIdleDomains()
+
+	if _, ok := cm.getCircuit(domainCircuit.ID); ok {
+		t.Error("idle domain circuit was not evicted")
+	}
+	if _, ok := cm.getCircuit(general.ID); !ok {
+		t.Error("general-purpose circuit was evicted by the domain policy")
+	}
+}
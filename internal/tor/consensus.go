@@ -0,0 +1,227 @@
+// Cached relay consensus for per-circuit exit selection
+package tor
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+)
+
+// exitResolver maps a relay address to its country so exit candidates
+// can be filtered geographically. *bypass.GeoIPMatcher satisfies it.
+type exitResolver interface {
+	GetCountry(ip net.IP) string
+}
+
+// consensusTTL is how long the parsed relay consensus is reused before
+// GETINFO ns/all is issued again
+const consensusTTL = 30 * time.Minute
+
+// relayInfo is one router status entry from the cached consensus
+type relayInfo struct {
+	Fingerprint string // 40 hex characters, no $ prefix
+	Nickname    string
+	Addr        net.IP
+	Exit        bool
+	Guard       bool
+	Fast        bool
+	Stable      bool
+}
+
+// SetGeoIP supplies the resolver used to map relay addresses to
+// countries for per-circuit exit selection and for annotating circuits
+// with their exit country
+func (cm *CircuitManager) SetGeoIP(r exitResolver) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.geoip = r
+}
+
+// cachedConsensus returns the relay consensus, refreshing it over the
+// control connection when the cache has expired
+func (cm *CircuitManager) cachedConsensus() ([]relayInfo, error) {
+	cm.mu.RLock()
+	ctrl := cm.ctrl
+	if len(cm.consensus) > 0 && cm.now().Sub(cm.consensusAt) < consensusTTL {
+		relays := cm.consensus
+		cm.mu.RUnlock()
+		return relays, nil
+	}
+	cm.mu.RUnlock()
+
+	if ctrl == nil {
+		return nil, fmt.Errorf("not connected to Tor")
+	}
+
+	resp, err := ctrl.SendRequest("GETINFO ns/all")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch consensus: %w", err)
+	}
+	relays := parseConsensus(resp.Data)
+	if len(relays) == 0 {
+		return nil, fmt.Errorf("consensus contained no relays")
+	}
+
+	cm.mu.Lock()
+	cm.consensus = relays
+	cm.consensusAt = cm.now()
+	cm.mu.Unlock()
+
+	return relays, nil
+}
+
+// parseConsensus extracts relay entries from GETINFO ns/all reply data.
+// Each "r" line carries nickname, base64 identity and address; the "s"
+// line that follows carries the relay's flags.
+func parseConsensus(data []string) []relayInfo {
+	var relays []relayInfo
+	for _, block := range data {
+		block = strings.TrimPrefix(block, "ns/all=")
+		for _, line := range strings.Split(block, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				continue
+			}
+			switch fields[0] {
+			case "r":
+				// r <nickname> <identity> <digest> <date> <time> <IP> <ORPort> <DirPort>
+				if len(fields) < 7 {
+					continue
+				}
+				fp := decodeRelayIdentity(fields[2])
+				if fp == "" {
+					continue
+				}
+				relays = append(relays, relayInfo{
+					Fingerprint: fp,
+					Nickname:    fields[1],
+					Addr:        net.ParseIP(fields[6]),
+				})
+			case "s":
+				if len(relays) == 0 {
+					continue
+				}
+				relay := &relays[len(relays)-1]
+				for _, flag := range fields[1:] {
+					switch flag {
+					case "Exit":
+						relay.Exit = true
+					case "Guard":
+						relay.Guard = true
+					case "Fast":
+						relay.Fast = true
+					case "Stable":
+						relay.Stable = true
+					}
+				}
+			}
+		}
+	}
+	return relays
+}
+
+// decodeRelayIdentity converts the consensus' unpadded base64 identity
+// to a 40-char hex fingerprint
+func decodeRelayIdentity(identity string) string {
+	raw, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(identity, "="))
+	if err != nil || len(raw) != 20 {
+		return ""
+	}
+	return strings.ToUpper(hex.EncodeToString(raw))
+}
+
+// CreateCircuitInCountry builds a circuit whose exit relay sits in the
+// given country, chosen from the cached consensus via the GeoIP
+// resolver. Unlike SetExitPolicy this affects only the circuit being
+// built. Requires a control connection and SetGeoIP.
+func (cm *CircuitManager) CreateCircuitInCountry(ctx context.Context, purpose, country string) (*Circuit, error) {
+	country = strings.ToUpper(strings.TrimSpace(country))
+	if !isCountryCode(country) {
+		return nil, fmt.Errorf("invalid country code %q: want two letters, e.g. \"DE\"", country)
+	}
+
+	cm.mu.RLock()
+	ctrl := cm.ctrl
+	geoip := cm.geoip
+	cm.mu.RUnlock()
+	if ctrl == nil {
+		return nil, fmt.Errorf("not connected to Tor")
+	}
+	if geoip == nil {
+		return nil, fmt.Errorf("no GeoIP resolver configured")
+	}
+
+	relays, err := cm.cachedConsensus()
+	if err != nil {
+		return nil, err
+	}
+
+	exit, err := pickRelay(relays, func(r relayInfo) bool {
+		return r.Exit && r.Addr != nil && strings.EqualFold(geoip.GetCountry(r.Addr), country)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("no exit relay in %s: %w", country, err)
+	}
+	guard, err := pickRelay(relays, func(r relayInfo) bool {
+		return r.Guard && r.Fast && r.Fingerprint != exit.Fingerprint
+	})
+	if err != nil {
+		return nil, fmt.Errorf("no guard relay available: %w", err)
+	}
+	middle, err := pickRelay(relays, func(r relayInfo) bool {
+		return r.Fast && r.Fingerprint != exit.Fingerprint && r.Fingerprint != guard.Fingerprint
+	})
+	if err != nil {
+		return nil, fmt.Errorf("no middle relay available: %w", err)
+	}
+
+	path := []string{"$" + guard.Fingerprint, "$" + middle.Fingerprint, "$" + exit.Fingerprint}
+	circuit, err := cm.buildCircuit(ctx, ctrl, purpose, path)
+	if err != nil {
+		return nil, err
+	}
+
+	cm.mu.Lock()
+	circuit.ExitCountry = country
+	cm.mu.Unlock()
+	return circuit, nil
+}
+
+// pickRelay returns a uniformly random relay satisfying the filter
+func pickRelay(relays []relayInfo, match func(relayInfo) bool) (relayInfo, error) {
+	var candidates []relayInfo
+	for _, r := range relays {
+		if match(r) {
+			candidates = append(candidates, r)
+		}
+	}
+	if len(candidates) == 0 {
+		return relayInfo{}, fmt.Errorf("no matching relay in consensus")
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(len(candidates))))
+	if err != nil {
+		return candidates[0], nil
+	}
+	return candidates[n.Int64()], nil
+}
+
+// resolveExitCountryLocked maps an exit fingerprint to its country via
+// the cached consensus and GeoIP resolver. The caller holds cm.mu; the
+// consensus is not refreshed here.
+func (cm *CircuitManager) resolveExitCountryLocked(fingerprint string) string {
+	if cm.geoip == nil || fingerprint == "" {
+		return ""
+	}
+	for _, r := range cm.consensus {
+		if r.Fingerprint == fingerprint && r.Addr != nil {
+			return strings.ToUpper(cm.geoip.GetCountry(r.Addr))
+		}
+	}
+	return ""
+}
@@ -0,0 +1,304 @@
+package tor
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cretz/bine/control"
+)
+
+// fakeGeoIP resolves relay addresses to countries from a fixed map
+type fakeGeoIP struct {
+	countries map[string]string // IP string -> country code
+}
+
+func (f *fakeGeoIP) GetCountry(ip net.IP) string {
+	return f.countries[ip.String()]
+}
+
+// relayIdentity converts a hex fingerprint to the unpadded base64 form
+// used in consensus "r" lines
+func relayIdentity(t *testing.T, fingerprint string) string {
+	t.Helper()
+	raw, err := hex.DecodeString(fingerprint)
+	if err != nil {
+		t.Fatalf("bad test fingerprint %q: %v", fingerprint, err)
+	}
+	return base64.RawStdEncoding.EncodeToString(raw)
+}
+
+const (
+	guardFP  = "AAAA1111AAAA1111AAAA1111AAAA1111AAAA1111"
+	middleFP = "BBBB2222BBBB2222BBBB2222BBBB2222BBBB2222"
+	exitNLFP = "CCCC3333CCCC3333CCCC3333CCCC3333CCCC3333"
+	exitUSFP = "DDDD4444DDDD4444DDDD4444DDDD4444DDDD4444"
+)
+
+// testConsensus builds a GETINFO ns/all data block with a guard, a
+// middle and two exits in different countries
+func testConsensus(t *testing.T) []string {
+	t.Helper()
+	lines := []string{
+		"ns/all=",
+		"r guardrelay " + relayIdentity(t, guardFP) + " dig 2026-08-28 01:00:00 10.0.0.1 9001 0",
+		"s Fast Guard Running Stable Valid",
+		"r middlerelay " + relayIdentity(t, middleFP) + " dig 2026-08-28 01:00:00 10.0.0.2 9001 0",
+		"s Fast Running Valid",
+		"r exitnl " + relayIdentity(t, exitNLFP) + " dig 2026-08-28 01:00:00 10.0.0.3 9001 0",
+		"s Exit Fast Running Valid",
+		"r exitus " + relayIdentity(t, exitUSFP) + " dig 2026-08-28 01:00:00 10.0.0.4 9001 0",
+		"s Exit Fast Running Valid",
+	}
+	return []string{strings.Join(lines, "\n")}
+}
+
+func testGeoIP() *fakeGeoIP {
+	return &fakeGeoIP{countries: map[string]string{
+		"10.0.0.1": "DE",
+		"10.0.0.2": "FR",
+		"10.0.0.3": "NL",
+		"10.0.0.4": "US",
+	}}
+}
+
+func TestParseConsensus(t *testing.T) {
+	relays := parseConsensus(testConsensus(t))
+
+	if len(relays) != 4 {
+		t.Fatalf("parsed %d relays, want 4", len(relays))
+	}
+	guard := relays[0]
+	if guard.Fingerprint != guardFP {
+		t.Errorf("fingerprint = %s, want %s", guard.Fingerprint, guardFP)
+	}
+	if guard.Nickname != "guardrelay" {
+		t.Errorf("nickname = %s, want guardrelay", guard.Nickname)
+	}
+	if !guard.Guard || !guard.Fast || !guard.Stable || guard.Exit {
+		t.Errorf("guard flags = %+v", guard)
+	}
+	if guard.Addr.String() != "10.0.0.1" {
+		t.Errorf("address = %s, want 10.0.0.1", guard.Addr)
+	}
+	if !relays[2].Exit {
+		t.Error("exit relay missing Exit flag")
+	}
+}
+
+func TestParseConsensusSkipsMalformed(t *testing.T) {
+	relays := parseConsensus([]string{
+		"r short line\ns Exit\nr badb64 !!!! dig 2026-08-28 01:00:00 10.0.0.9 9001 0\ns Exit",
+	})
+	if len(relays) != 0 {
+		t.Errorf("parsed %d relays from malformed data, want 0", len(relays))
+	}
+}
+
+func TestSetExitPolicy(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+
+	fake := &fakeConfSetter{}
+	cm.conf = fake
+
+	if err := cm.SetExitPolicy([]string{"nl", "de", "ch"}, []string{"us"}); err != nil {
+		t.Fatalf("SetExitPolicy() error = %v", err)
+	}
+
+	if val, _ := fake.get("ExitNodes"); val != "{nl},{de},{ch}" {
+		t.Errorf("ExitNodes = %q, want {nl},{de},{ch}", val)
+	}
+	if val, _ := fake.get("ExcludeExitNodes"); val != "{us}" {
+		t.Errorf("ExcludeExitNodes = %q, want {us}", val)
+	}
+	if val, _ := fake.get("StrictNodes"); val != "1" {
+		t.Errorf("StrictNodes = %q, want 1", val)
+	}
+
+	// New circuits record the requested countries
+	circuit, err := cm.CreateCircuit("test")
+	if err != nil {
+		t.Fatalf("CreateCircuit() error = %v", err)
+	}
+	if circuit.ExitCountry != "NL,DE,CH" {
+		t.Errorf("circuit exit country = %q, want NL,DE,CH", circuit.ExitCountry)
+	}
+
+	if err := cm.ClearExitPolicy(); err != nil {
+		t.Fatalf("ClearExitPolicy() error = %v", err)
+	}
+	if val, _ := fake.get("ExitNodes"); val != "" {
+		t.Errorf("ExitNodes after clear = %q, want empty", val)
+	}
+	if val, _ := fake.get("ExcludeExitNodes"); val != "" {
+		t.Errorf("ExcludeExitNodes after clear = %q, want empty", val)
+	}
+	if val, _ := fake.get("StrictNodes"); val != "0" {
+		t.Errorf("StrictNodes after clear = %q, want 0", val)
+	}
+}
+
+func TestSetExitPolicyExcludeOnly(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+
+	fake := &fakeConfSetter{}
+	cm.conf = fake
+
+	if err := cm.SetExitPolicy(nil, []string{"us", "gb"}); err != nil {
+		t.Fatalf("SetExitPolicy() error = %v", err)
+	}
+
+	if val, _ := fake.get("ExitNodes"); val != "" {
+		t.Errorf("ExitNodes = %q, want empty", val)
+	}
+	if val, _ := fake.get("ExcludeExitNodes"); val != "{us},{gb}" {
+		t.Errorf("ExcludeExitNodes = %q, want {us},{gb}", val)
+	}
+	// Nothing to be strict about without an allow list
+	if val, _ := fake.get("StrictNodes"); val != "0" {
+		t.Errorf("StrictNodes = %q, want 0", val)
+	}
+}
+
+func TestSetExitPolicyRejectsOverlap(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+	cm.conf = &fakeConfSetter{}
+
+	err := cm.SetExitPolicy([]string{"NL", "de"}, []string{"DE"})
+	if err == nil {
+		t.Fatal("SetExitPolicy with overlapping sets should error")
+	}
+	if !strings.Contains(err.Error(), "DE") {
+		t.Errorf("error %q should name the overlapping country", err)
+	}
+	// Nothing was sent to Tor
+	if len(cm.conf.(*fakeConfSetter).entries) != 0 {
+		t.Error("SETCONF issued despite validation failure")
+	}
+}
+
+func TestSetExitPolicyValidation(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+	cm.conf = &fakeConfSetter{}
+
+	for _, bad := range [][]string{{"DEU"}, {""}, {"1!"}} {
+		if err := cm.SetExitPolicy(bad, nil); err == nil {
+			t.Errorf("SetExitPolicy(%v, nil) should error", bad)
+		}
+		if err := cm.SetExitPolicy(nil, bad); err == nil {
+			t.Errorf("SetExitPolicy(nil, %v) should error", bad)
+		}
+	}
+}
+
+func TestCreateCircuitInCountry(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+
+	fake := &fakeCircuitControl{
+		circuitID: "12",
+		infoData:  testConsensus(t),
+		events: []*control.CircuitEvent{
+			{CircuitID: "12", Status: "BUILT", Raw: "12 BUILT $" + guardFP + "~guardrelay,$" + middleFP + "~middlerelay,$" + exitNLFP + "~exitnl"},
+		},
+	}
+	cm.ctrl = fake
+	cm.SetGeoIP(testGeoIP())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	circuit, err := cm.CreateCircuitInCountry(ctx, "general", "nl")
+	if err != nil {
+		t.Fatalf("CreateCircuitInCountry() error = %v", err)
+	}
+
+	if circuit.ExitCountry != "NL" {
+		t.Errorf("exit country = %q, want NL", circuit.ExitCountry)
+	}
+	if circuit.ExitNode != exitNLFP {
+		t.Errorf("exit node = %s, want %s", circuit.ExitNode, exitNLFP)
+	}
+
+	// The EXTENDCIRCUIT path must be three hops ending in the Dutch
+	// exit; guard and middle are drawn randomly from the consensus
+	var extendCmd string
+	fake.mu.Lock()
+	for _, req := range fake.requests {
+		if strings.HasPrefix(req, "EXTENDCIRCUIT 0 ") {
+			extendCmd = req
+		}
+	}
+	fake.mu.Unlock()
+	if extendCmd == "" {
+		t.Fatalf("no EXTENDCIRCUIT issued, requests: %v", fake.requests)
+	}
+	hops := strings.Split(strings.TrimPrefix(extendCmd, "EXTENDCIRCUIT 0 "), ",")
+	if len(hops) != 3 {
+		t.Fatalf("path has %d hops, want 3: %q", len(hops), extendCmd)
+	}
+	if hops[0] != "$"+guardFP {
+		t.Errorf("first hop = %s, want the only Guard relay $%s", hops[0], guardFP)
+	}
+	if hops[2] != "$"+exitNLFP {
+		t.Errorf("exit hop = %s, want $%s", hops[2], exitNLFP)
+	}
+}
+
+func TestCreateCircuitInCountryNoExit(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+
+	cm.ctrl = &fakeCircuitControl{circuitID: "12", infoData: testConsensus(t)}
+	cm.SetGeoIP(testGeoIP())
+
+	_, err := cm.CreateCircuitInCountry(context.Background(), "general", "JP")
+	if err == nil || !strings.Contains(err.Error(), "JP") {
+		t.Errorf("want no-exit-in-JP error, got %v", err)
+	}
+}
+
+func TestCreateCircuitInCountryRequiresGeoIP(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+	cm.ctrl = &fakeCircuitControl{circuitID: "12"}
+
+	if _, err := cm.CreateCircuitInCountry(context.Background(), "general", "NL"); err == nil {
+		t.Error("CreateCircuitInCountry without GeoIP should error")
+	}
+}
+
+func TestCachedConsensusReuse(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+
+	fake := &fakeCircuitControl{circuitID: "12", infoData: testConsensus(t)}
+	cm.ctrl = fake
+
+	if _, err := cm.cachedConsensus(); err != nil {
+		t.Fatalf("cachedConsensus() error = %v", err)
+	}
+	if _, err := cm.cachedConsensus(); err != nil {
+		t.Fatalf("cachedConsensus() error = %v", err)
+	}
+
+	fake.mu.Lock()
+	fetches := 0
+	for _, req := range fake.requests {
+		if strings.HasPrefix(req, "GETINFO ns/all") {
+			fetches++
+		}
+	}
+	fake.mu.Unlock()
+	if fetches != 1 {
+		t.Errorf("GETINFO ns/all issued %d times within TTL, want 1", fetches)
+	}
+}
@@ -0,0 +1,180 @@
+// Control-port event tracking for circuits
+package tor
+
+import (
+	"strings"
+	"time"
+
+	"github.com/cretz/bine/control"
+	"github.com/jery0843/torforge/pkg/logger"
+)
+
+// reconcileInterval is how often the GETINFO poller runs when the event
+// listener is active; it only backstops missed events then
+const reconcileInterval = 2 * time.Minute
+
+// startEventListener subscribes to CIRC and STREAM events (SETEVENTS on
+// the control port) so circuit state updates as Tor reports it instead
+// of waiting for the next poll. Returns false when the subscription
+// could not be established; the poller then remains the only source.
+func (cm *CircuitManager) startEventListener() bool {
+	cm.mu.RLock()
+	ctrl := cm.ctrl
+	cm.mu.RUnlock()
+	if ctrl == nil {
+		return false
+	}
+
+	events := make(chan control.Event, 64)
+	if err := ctrl.AddEventListener(events, control.EventCodeCircuit, control.EventCodeStream, control.EventCodeCircuitBandwidth); err != nil {
+		log := logger.WithComponent("circuit")
+		log.Warn().Err(err).Msg("could not subscribe to control events, falling back to polling")
+		return false
+	}
+
+	cm.mu.Lock()
+	cm.eventsActive = true
+	cm.mu.Unlock()
+
+	go cm.eventLoop(ctrl, events)
+	return true
+}
+
+func (cm *CircuitManager) eventLoop(ctrl circuitControl, events chan control.Event) {
+	log := logger.WithComponent("circuit")
+	for {
+		select {
+		case <-cm.stopCh:
+			ctrl.RemoveEventListener(events, control.EventCodeCircuit, control.EventCodeStream, control.EventCodeCircuitBandwidth)
+			log.Debug().Msg("circuit event listener stopped")
+			return
+		case ev := <-events:
+			cm.handleControlEvent(ev)
+		}
+	}
+}
+
+// handleControlEvent folds one asynchronous control-port event into the
+// circuit map
+func (cm *CircuitManager) handleControlEvent(ev control.Event) {
+	switch e := ev.(type) {
+	case *control.CircuitEvent:
+		cm.handleCircuitEvent(e)
+	case *control.StreamEvent:
+		cm.handleStreamEvent(e)
+	case *control.CircuitBandwidthEvent:
+		cm.handleCircuitBandwidth(e)
+	}
+}
+
+// handleCircuitBandwidth attributes a CIRC_BW reading to its circuit and
+// re-checks the rotation thresholds the new totals may have crossed
+func (cm *CircuitManager) handleCircuitBandwidth(ev *control.CircuitBandwidthEvent) {
+	if ev.CircuitID == "" {
+		return
+	}
+
+	cm.mu.Lock()
+	circuit, ok := cm.circuits[ev.CircuitID]
+	if ok {
+		now := cm.now()
+		circuit.BytesRecv += ev.BytesRead
+		circuit.BytesSent += ev.BytesWritten
+		circuit.LastUsed = now
+		circuit.addBWSample(now, ev.BytesRead+ev.BytesWritten)
+	}
+	cm.mu.Unlock()
+
+	if ok {
+		cm.checkRotation()
+	}
+}
+
+// circuitEventPath extracts relay fingerprints from a CIRC event,
+// preferring the raw line because bine splits the common
+// $fingerprint~nickname path form incorrectly
+func circuitEventPath(ev *control.CircuitEvent) []string {
+	for _, field := range splitFields(ev.Raw) {
+		if strings.HasPrefix(field, "$") {
+			return parseCircuitPath(field)
+		}
+	}
+	if len(ev.Path) > 0 {
+		return parseCircuitPath(strings.Join(ev.Path, ","))
+	}
+	return nil
+}
+
+func (cm *CircuitManager) handleCircuitEvent(ev *control.CircuitEvent) {
+	if ev.CircuitID == "" || ev.Purpose == "MEASURE_TIMEOUT" {
+		return
+	}
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	circuit, tracked := cm.circuits[ev.CircuitID]
+
+	switch ev.Status {
+	case "CLOSED", "FAILED":
+		if !tracked {
+			return
+		}
+		delete(cm.circuits, ev.CircuitID)
+		cm.totalClosed++
+		cm.publishEvent(CircuitEvent{CircuitID: ev.CircuitID, Type: "closed", OldStatus: circuit.Status, NewStatus: ev.Status})
+		return
+	}
+
+	if !tracked {
+		// A circuit Tor built on its own initiative
+		created := ev.TimeCreated
+		if created.IsZero() {
+			created = time.Now()
+		}
+		circuit = &Circuit{
+			ID:        ev.CircuitID,
+			Status:    ev.Status,
+			CreatedAt: created,
+			Purpose:   ev.Purpose,
+			LastUsed:  time.Now(),
+		}
+		cm.circuits[ev.CircuitID] = circuit
+		cm.totalCreated++
+		cm.publishEvent(CircuitEvent{CircuitID: ev.CircuitID, Type: "created", NewStatus: ev.Status})
+	}
+
+	old := circuit.Status
+	circuit.Status = ev.Status
+	if ev.Purpose != "" {
+		circuit.Purpose = ev.Purpose
+	}
+	if len(ev.BuildFlags) > 0 {
+		circuit.BuildFlags = ev.BuildFlags
+	}
+	if path := circuitEventPath(ev); len(path) > 0 {
+		circuit.Path = path
+		circuit.ExitNode = path[len(path)-1]
+		if country := cm.resolveExitCountryLocked(circuit.ExitNode); country != "" {
+			circuit.ExitCountry = country
+		}
+	}
+	if tracked && old != ev.Status {
+		cm.publishEvent(CircuitEvent{CircuitID: ev.CircuitID, Type: "status", OldStatus: old, NewStatus: ev.Status})
+	}
+}
+
+// handleStreamEvent marks the carrying circuit as recently used so idle
+// tracking reflects stream activity
+func (cm *CircuitManager) handleStreamEvent(ev *control.StreamEvent) {
+	if ev.CircuitID == "" {
+		return
+	}
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if circuit, ok := cm.circuits[ev.CircuitID]; ok {
+		circuit.LastUsed = time.Now()
+	}
+}
@@ -0,0 +1,251 @@
+package tor
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cretz/bine/control"
+)
+
+func TestHandleCircuitEventLifecycle(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+
+	guard := strings.Repeat("A", 40)
+	middle := strings.Repeat("B", 40)
+	exit := strings.Repeat("C", 40)
+
+	// Captured CIRC event lines as Tor emits them (after the 650 CIRC
+	// prefix bine strips)
+	lines := []string{
+		"5 LAUNCHED PURPOSE=GENERAL TIME_CREATED=2026-08-28T10:30:00.000000",
+		"5 EXTENDED $" + guard + "~guard PURPOSE=GENERAL",
+		"5 BUILT $" + guard + "~guard,$" + middle + "~mid,$" + exit + "~exit PURPOSE=GENERAL",
+	}
+	for _, line := range lines {
+		cm.handleControlEvent(control.ParseCircuitEvent(line))
+	}
+
+	circuits := cm.GetCircuits()
+	if len(circuits) != 1 {
+		t.Fatalf("tracked %d circuits, want 1", len(circuits))
+	}
+	c := circuits[0]
+	if c.ID != "5" || c.Status != "BUILT" {
+		t.Errorf("circuit = %s/%s, want 5/BUILT", c.ID, c.Status)
+	}
+	if len(c.Path) != 3 {
+		t.Fatalf("path = %v, want 3 hops", c.Path)
+	}
+	if c.ExitNode != exit {
+		t.Errorf("exit node = %q, want %q", c.ExitNode, exit)
+	}
+	if c.Purpose != "GENERAL" {
+		t.Errorf("purpose = %q, want GENERAL", c.Purpose)
+	}
+	if c.CreatedAt.Year() != 2026 {
+		t.Errorf("TIME_CREATED not honored, CreatedAt = %v", c.CreatedAt)
+	}
+	if stats := cm.GetStats(); stats.TotalCreated != 1 {
+		t.Errorf("TotalCreated = %d, want 1", stats.TotalCreated)
+	}
+
+	// CLOSED removes the circuit instead of leaving it to linger
+	cm.handleControlEvent(control.ParseCircuitEvent("5 CLOSED $" + guard + "~guard REASON=FINISHED"))
+
+	if got := cm.GetCount(); got != 0 {
+		t.Errorf("GetCount() after CLOSED = %d, want 0", got)
+	}
+	if stats := cm.GetStats(); stats.TotalClosed != 1 {
+		t.Errorf("TotalClosed = %d, want 1", stats.TotalClosed)
+	}
+}
+
+func TestHandleCircuitEventFailedUntracked(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+
+	// FAILED for a circuit we never saw must not create or count anything
+	cm.handleControlEvent(control.ParseCircuitEvent("17 FAILED REASON=TIMEOUT"))
+
+	if got := cm.GetCount(); got != 0 {
+		t.Errorf("GetCount() = %d, want 0", got)
+	}
+	if stats := cm.GetStats(); stats.TotalClosed != 0 {
+		t.Errorf("TotalClosed = %d, want 0", stats.TotalClosed)
+	}
+}
+
+func TestHandleCircuitEventIgnoresMeasurement(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+
+	relay := strings.Repeat("D", 40)
+	cm.handleControlEvent(control.ParseCircuitEvent("9 BUILT $" + relay + "~relay PURPOSE=MEASURE_TIMEOUT"))
+
+	if got := cm.GetCount(); got != 0 {
+		t.Errorf("measurement circuit was tracked, GetCount() = %d", got)
+	}
+}
+
+func TestHandleStreamEventTouchesCircuit(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+
+	relay := strings.Repeat("E", 40)
+	cm.handleControlEvent(control.ParseCircuitEvent("3 BUILT $" + relay + "~relay PURPOSE=GENERAL"))
+
+	past := time.Now().Add(-time.Hour)
+	cm.mu.Lock()
+	cm.circuits["3"].LastUsed = past
+	cm.mu.Unlock()
+
+	cm.handleControlEvent(control.ParseStreamEvent("21 SUCCEEDED 3 example.com:443"))
+
+	cm.mu.RLock()
+	lastUsed := cm.circuits["3"].LastUsed
+	cm.mu.RUnlock()
+	if !lastUsed.After(past) {
+		t.Error("stream activity did not refresh LastUsed")
+	}
+}
+
+func TestEventListenerDeliversToSubscribers(t *testing.T) {
+	fake := &fakeCircuitControl{}
+
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+	cm.ctrl = fake
+
+	if !cm.startEventListener() {
+		t.Fatal("startEventListener() failed with a working control connection")
+	}
+
+	sub, unsubscribe := cm.Subscribe()
+	defer unsubscribe()
+
+	fake.mu.Lock()
+	if len(fake.listeners) != 1 {
+		fake.mu.Unlock()
+		t.Fatalf("listeners = %d, want 1", len(fake.listeners))
+	}
+	ch := fake.listeners[0]
+	fake.mu.Unlock()
+
+	relay := strings.Repeat("F", 40)
+	ch <- control.ParseCircuitEvent("8 BUILT $" + relay + "~relay PURPOSE=GENERAL")
+
+	select {
+	case ev := <-sub:
+		if ev.CircuitID != "8" || ev.Type != "created" {
+			t.Errorf("subscriber saw %+v, want circuit 8 created", ev)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("subscriber never saw the circuit event")
+	}
+
+	if got := cm.GetCount(); got != 1 {
+		t.Errorf("GetCount() = %d, want 1", got)
+	}
+}
+
+func TestCircuitBandwidthAccounting(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+
+	base := time.Now()
+	cm.now = func() time.Time { return base }
+
+	relay := strings.Repeat("A", 40)
+	cm.handleControlEvent(control.ParseCircuitEvent("4 BUILT $" + relay + "~relay PURPOSE=GENERAL"))
+
+	// Two CIRC_BW readings a second apart
+	cm.handleControlEvent(&control.CircuitBandwidthEvent{CircuitID: "4", BytesRead: 1000, BytesWritten: 500})
+	cm.now = func() time.Time { return base.Add(time.Second) }
+	cm.handleControlEvent(&control.CircuitBandwidthEvent{CircuitID: "4", BytesRead: 2000, BytesWritten: 1000})
+
+	stat, err := cm.GetCircuitStats("4")
+	if err != nil {
+		t.Fatalf("GetCircuitStats() error = %v", err)
+	}
+	if stat.BytesRecv != 3000 || stat.BytesSent != 1500 {
+		t.Errorf("bytes = %d/%d, want 3000 recv / 1500 sent", stat.BytesRecv, stat.BytesSent)
+	}
+	if stat.ThroughputBps <= 0 {
+		t.Errorf("ThroughputBps = %f, want > 0", stat.ThroughputBps)
+	}
+
+	stats := cm.GetStats()
+	if stats.TotalBytesRecv != 3000 || stats.TotalBytesSent != 1500 {
+		t.Errorf("aggregate bytes = %d/%d, want 3000/1500", stats.TotalBytesRecv, stats.TotalBytesSent)
+	}
+	if stats.ThroughputBps <= 0 {
+		t.Errorf("aggregate ThroughputBps = %f, want > 0", stats.ThroughputBps)
+	}
+}
+
+func TestCircuitBandwidthUnknownCircuit(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+
+	// Must not panic or create a phantom circuit
+	cm.handleControlEvent(&control.CircuitBandwidthEvent{CircuitID: "99", BytesRead: 100})
+	if cm.GetCount() != 0 {
+		t.Error("bandwidth event created a circuit")
+	}
+
+	if _, err := cm.GetCircuitStats("99"); err == nil {
+		t.Error("GetCircuitStats() with unknown ID should error")
+	}
+}
+
+func TestCircuitBandwidthTriggersRotation(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+
+	cm.SetRotationPolicy(time.Hour, 1024)
+
+	relay := strings.Repeat("B", 40)
+	cm.handleControlEvent(control.ParseCircuitEvent("6 BUILT $" + relay + "~relay PURPOSE=GENERAL"))
+
+	// Crossing the byte limit via a synthetic BW event cycles the circuit
+	cm.handleControlEvent(control.ParseCircuitBandwidthEvent("ID=6 READ=2000 WRITTEN=500"))
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, ok := cm.getCircuit("6"); !ok && cm.GetCount() == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("circuit was not rotated after BW event, count = %d", cm.GetCount())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestThroughputWindowExpires(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+
+	base := time.Now()
+	cm.now = func() time.Time { return base }
+
+	relay := strings.Repeat("C", 40)
+	cm.handleControlEvent(control.ParseCircuitEvent("2 BUILT $" + relay + "~relay PURPOSE=GENERAL"))
+	cm.handleControlEvent(&control.CircuitBandwidthEvent{CircuitID: "2", BytesRead: 5000})
+
+	// Well past the window the reading no longer counts
+	cm.now = func() time.Time { return base.Add(throughputWindow + time.Minute) }
+
+	stat, err := cm.GetCircuitStats("2")
+	if err != nil {
+		t.Fatalf("GetCircuitStats() error = %v", err)
+	}
+	if stat.ThroughputBps != 0 {
+		t.Errorf("ThroughputBps = %f after window expired, want 0", stat.ThroughputBps)
+	}
+	if stat.BytesRecv != 5000 {
+		t.Errorf("BytesRecv = %d, want 5000 (totals are cumulative)", stat.BytesRecv)
+	}
+}
@@ -3,32 +3,53 @@ package tor
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/jery0843/torforge/pkg/logger"
+	"golang.org/x/net/proxy"
 )
 
 // CircuitRaceResult holds results from testing a circuit
 type CircuitRaceResult struct {
 	CircuitID string
 	ExitIP    string
+	ProbeURL  string // Endpoint that finally answered
 	Latency   time.Duration
+	Bandwidth float64 // Bytes per second observed while reading the probe body
 	Success   bool
 	Error     error
 }
 
+// ScoreFunc rates a successful race result; higher is better
+type ScoreFunc func(CircuitRaceResult) float64
+
+// defaultScore ranks circuits purely by latency
+func defaultScore(result CircuitRaceResult) float64 {
+	if result.Latency <= 0 {
+		return 0
+	}
+	return 1 / result.Latency.Seconds()
+}
+
 // CircuitRacer handles circuit racing for optimal speed
 type CircuitRacer struct {
-	manager     *Manager
-	probeURLs   []string
-	timeout     time.Duration
-	results     []CircuitRaceResult
-	bestCircuit *CircuitRaceResult
-	mu          sync.RWMutex
+	manager        *Manager
+	probeURLs      []string
+	timeout        time.Duration
+	maxConcurrency int
+	maxRetries     int
+	scoreFunc      ScoreFunc
+	results        []CircuitRaceResult
+	bestCircuit    *CircuitRaceResult
+	mu             sync.RWMutex
 }
 
 // NewCircuitRacer creates a new circuit racer
@@ -45,9 +66,70 @@ func NewCircuitRacer(manager *Manager) *CircuitRacer {
 			"http://checkip.amazonaws.com",
 			"http://wtfismyip.com/text",
 		},
-		timeout: 10 * time.Second,
-		results: make([]CircuitRaceResult, 0),
+		timeout:        10 * time.Second,
+		maxConcurrency: 8,
+		maxRetries:     3,
+		scoreFunc:      defaultScore,
+		results:        make([]CircuitRaceResult, 0),
+	}
+}
+
+// SetProbeURLs replaces the probe endpoint list. URLs must be non-empty.
+func (r *CircuitRacer) SetProbeURLs(urls []string) error {
+	if len(urls) == 0 {
+		return fmt.Errorf("probe URL list must not be empty")
+	}
+	for _, u := range urls {
+		if strings.TrimSpace(u) == "" {
+			return fmt.Errorf("probe URL must not be empty")
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.probeURLs = append([]string(nil), urls...)
+	return nil
+}
+
+// SetTimeout sets the per-probe timeout
+func (r *CircuitRacer) SetTimeout(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.timeout = d
+}
+
+// SetMaxRetries bounds how many probe URLs a circuit tries before it is
+// declared failed. Values < 1 are ignored.
+func (r *CircuitRacer) SetMaxRetries(n int) {
+	if n < 1 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.maxRetries = n
+}
+
+// SetScoreFunc replaces how race results are ranked. The default scores
+// by latency alone; a custom function can weigh in Bandwidth for
+// fast-but-narrow circuits. Nil restores the default.
+func (r *CircuitRacer) SetScoreFunc(fn ScoreFunc) {
+	if fn == nil {
+		fn = defaultScore
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.scoreFunc = fn
+}
+
+// SetMaxConcurrency bounds how many probes run simultaneously, so large
+// races don't exhaust file descriptors. Values < 1 are ignored.
+func (r *CircuitRacer) SetMaxConcurrency(n int) {
+	if n < 1 {
+		return
 	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.maxConcurrency = n
 }
 
 // RaceCircuits builds and tests multiple circuits, returns the fastest
@@ -58,14 +140,21 @@ func (r *CircuitRacer) RaceCircuits(count int) (*CircuitRaceResult, error) {
 		Int("count", count).
 		Msg("⚡ Circuit Racing: Testing circuits...")
 
-	// Build circuits in parallel
+	r.mu.RLock()
+	maxConcurrency := r.maxConcurrency
+	r.mu.RUnlock()
+
+	// Build circuits in parallel, bounded by the concurrency limit
 	var wg sync.WaitGroup
 	resultsChan := make(chan CircuitRaceResult, count)
+	sem := make(chan struct{}, maxConcurrency)
 
 	for i := 0; i < count; i++ {
 		wg.Add(1)
 		go func(circuitNum int) {
 			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
 			result := r.probeCircuit(circuitNum)
 			resultsChan <- result
 		}(i + 1)
@@ -101,88 +190,190 @@ func (r *CircuitRacer) RaceCircuits(count int) (*CircuitRaceResult, error) {
 	return best, nil
 }
 
-// probeCircuit tests a single circuit's latency
+// probeCircuit tests a single circuit's latency, failing over to the
+// next probe URL when an endpoint is flaky
 func (r *CircuitRacer) probeCircuit(circuitNum int) CircuitRaceResult {
 	log := logger.WithComponent("racing")
 
+	r.mu.RLock()
+	urls := r.probeURLs
+	timeout := r.timeout
+	maxRetries := r.maxRetries
+	r.mu.RUnlock()
+
 	result := CircuitRaceResult{
 		CircuitID: fmt.Sprintf("circuit_%d", circuitNum),
 		Success:   false,
 	}
 
-	// Create HTTP client with timeout
-	client := &http.Client{
-		Timeout: r.timeout,
+	// Create HTTP client that dials through Tor with per-circuit
+	// isolation credentials
+	client, err := r.probeClient(circuitNum)
+	if err != nil {
+		result.Error = err
+		return result
 	}
 
-	// Select probe URL based on circuit number (round-robin)
-	probeURL := r.probeURLs[(circuitNum-1)%len(r.probeURLs)]
+	// One deadline bounds all attempts so retries can't stack timeouts
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-	// Measure latency
-	start := time.Now()
+	for attempt := 0; attempt < maxRetries; attempt++ {
+		// Start offset rotates per circuit, then fails over per attempt
+		probeURL := urls[(circuitNum-1+attempt)%len(urls)]
+
+		latency, bandwidth, exitIP, err := r.tryProbe(ctx, client, probeURL)
+		if err != nil {
+			result.Error = err
+			log.Debug().
+				Int("circuit", circuitNum).
+				Str("url", probeURL).
+				Int("attempt", attempt+1).
+				Err(err).
+				Msg("circuit probe failed")
+			if ctx.Err() != nil {
+				break // Deadline spent; further attempts can't succeed
+			}
+			continue
+		}
 
-	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
-	defer cancel()
+		result.Success = true
+		result.Error = nil
+		result.Latency = latency
+		result.Bandwidth = bandwidth
+		result.ExitIP = exitIP
+		result.ProbeURL = probeURL
+
+		log.Info().
+			Int("circuit", circuitNum).
+			Dur("latency", latency).
+			Str("exit_ip", exitIP).
+			Msg("⚡ Circuit tested")
+		break
+	}
+
+	return result
+}
+
+// tryProbe performs one request against a single probe endpoint
+func (r *CircuitRacer) tryProbe(ctx context.Context, client *http.Client, probeURL string) (time.Duration, float64, string, error) {
+	start := time.Now()
 
 	req, err := http.NewRequestWithContext(ctx, "GET", probeURL, nil)
 	if err != nil {
-		result.Error = err
-		return result
+		return 0, 0, "", err
 	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		result.Error = err
-		log.Debug().
-			Int("circuit", circuitNum).
-			Err(err).
-			Msg("circuit probe failed")
-		return result
+		return 0, 0, "", err
 	}
 	defer resp.Body.Close()
 
 	latency := time.Since(start)
 
-	// Get exit IP from Tor check
-	if resp.StatusCode == http.StatusOK {
-		result.Success = true
-		result.Latency = latency
-		result.ExitIP = "detected" // Will be filled from response
+	if resp.StatusCode != http.StatusOK {
+		return 0, 0, "", fmt.Errorf("status code: %d", resp.StatusCode)
+	}
 
-		log.Info().
-			Int("circuit", circuitNum).
-			Dur("latency", latency).
-			Msg("⚡ Circuit tested")
-	} else {
-		result.Error = fmt.Errorf("status code: %d", resp.StatusCode)
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("failed to read probe body: %w", err)
 	}
 
-	return result
+	exitIP, err := parseExitIP(body)
+	if err != nil {
+		return 0, 0, "", err
+	}
+
+	// Throughput over the whole exchange; the payloads are small, so
+	// this mostly reflects how quickly the circuit moves bytes at all
+	var bandwidth float64
+	if elapsed := time.Since(start); elapsed > 0 {
+		bandwidth = float64(len(body)) / elapsed.Seconds()
+	}
+
+	return latency, bandwidth, exitIP, nil
 }
 
-// findFastest sorts results and returns the fastest successful circuit
-func (r *CircuitRacer) findFastest() *CircuitRaceResult {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
+// parseExitIP extracts an IP address from a probe response body. The
+// plain-IP endpoints return the address bare; check.torproject.org
+// returns JSON like {"IsTor":true,"IP":"x.x.x.x"}.
+func parseExitIP(body []byte) (string, error) {
+	text := strings.TrimSpace(string(body))
 
-	// Filter successful results
-	var successful []CircuitRaceResult
-	for _, result := range r.results {
-		if result.Success {
-			successful = append(successful, result)
+	// JSON response from check.torproject.org
+	if strings.HasPrefix(text, "{") {
+		var check struct {
+			IP string `json:"IP"`
+		}
+		if err := json.Unmarshal(body, &check); err != nil {
+			return "", fmt.Errorf("invalid JSON probe body: %w", err)
 		}
+		text = check.IP
 	}
 
-	if len(successful) == 0 {
-		return nil
+	if net.ParseIP(text) == nil {
+		return "", fmt.Errorf("probe body is not an IP: %q", text)
 	}
+	return text, nil
+}
 
-	// Sort by latency (ascending)
-	sort.Slice(successful, func(i, j int) bool {
-		return successful[i].Latency < successful[j].Latency
-	})
+// probeClient builds an HTTP client that dials through Tor's SOCKS port.
+// Each probe uses distinct SOCKS credentials; Tor's IsolateSOCKSAuth
+// (on by default for SocksPort) then builds a separate circuit per
+// credential pair, so latencies actually compare different circuits.
+func (r *CircuitRacer) probeClient(circuitNum int) (*http.Client, error) {
+	if r.manager == nil {
+		// No Tor to route through; measure the default transport
+		return &http.Client{Timeout: r.timeout}, nil
+	}
+
+	auth := &proxy.Auth{
+		User:     fmt.Sprintf("race-%d", circuitNum),
+		Password: fmt.Sprintf("probe-%d", circuitNum),
+	}
+	dialer, err := proxy.SOCKS5("tcp", r.manager.GetSOCKSAddr(), auth, proxy.Direct)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SOCKS dialer: %w", err)
+	}
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if cd, ok := dialer.(proxy.ContextDialer); ok {
+				return cd.DialContext(ctx, network, addr)
+			}
+			return dialer.Dial(network, addr)
+		},
+		DisableKeepAlives: true, // Keep-alive would reuse one circuit
+	}
+
+	return &http.Client{
+		Timeout:   r.timeout,
+		Transport: transport,
+	}, nil
+}
+
+// findFastest returns the successful circuit with the best score
+func (r *CircuitRacer) findFastest() *CircuitRaceResult {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var best *CircuitRaceResult
+	bestScore := 0.0
+	for i := range r.results {
+		result := r.results[i]
+		if !result.Success {
+			continue
+		}
+		score := r.scoreFunc(result)
+		if best == nil || score > bestScore {
+			best = &r.results[i]
+			bestScore = score
+		}
+	}
 
-	return &successful[0]
+	return best
 }
 
 // GetResults returns all race results
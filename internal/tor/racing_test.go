@@ -0,0 +1,355 @@
+package tor
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jery0843/torforge/pkg/config"
+)
+
+// stubSOCKSServer speaks enough SOCKS5 with username/password auth to
+// record which credentials each probe used, then answers the proxied
+// HTTP request itself
+type stubSOCKSServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	users    map[string]bool
+}
+
+func startStubSOCKS(t *testing.T) *stubSOCKSServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	s := &stubSOCKSServer{
+		listener: listener,
+		users:    make(map[string]bool),
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *stubSOCKSServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *stubSOCKSServer) seenUsers() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := make([]string, 0, len(s.users))
+	for u := range s.users {
+		users = append(users, u)
+	}
+	return users
+}
+
+func (s *stubSOCKSServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	// Greeting: VER NMETHODS METHODS...
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(conn, head); err != nil || head[0] != 5 {
+		return
+	}
+	methods := make([]byte, head[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return
+	}
+	// Require username/password auth
+	conn.Write([]byte{5, 2})
+
+	// RFC 1929 auth: VER ULEN UNAME PLEN PASSWD
+	authHead := make([]byte, 2)
+	if _, err := io.ReadFull(conn, authHead); err != nil {
+		return
+	}
+	user := make([]byte, authHead[1])
+	if _, err := io.ReadFull(conn, user); err != nil {
+		return
+	}
+	plen := make([]byte, 1)
+	if _, err := io.ReadFull(conn, plen); err != nil {
+		return
+	}
+	pass := make([]byte, plen[0])
+	if _, err := io.ReadFull(conn, pass); err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.users[string(user)] = true
+	s.mu.Unlock()
+
+	conn.Write([]byte{1, 0})
+
+	// CONNECT request: VER CMD RSV ATYP ...
+	reqHead := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reqHead); err != nil {
+		return
+	}
+	var addrLen int
+	switch reqHead[3] {
+	case 1:
+		addrLen = 4
+	case 3:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(conn, l); err != nil {
+			return
+		}
+		addrLen = int(l[0])
+	case 4:
+		addrLen = 16
+	default:
+		return
+	}
+	skip := make([]byte, addrLen+2) // Address plus port
+	if _, err := io.ReadFull(conn, skip); err != nil {
+		return
+	}
+
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+
+	// Act as the probe target: consume the HTTP request, answer with an IP
+	buf := make([]byte, 1024)
+	conn.Read(buf)
+	conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 7\r\nConnection: close\r\n\r\n1.2.3.4"))
+}
+
+func TestParseExitIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    string
+		wantErr bool
+	}{
+		{"plain ip", "93.184.216.34", "93.184.216.34", false},
+		{"trailing newline", "93.184.216.34\n", "93.184.216.34", false},
+		{"ipv6", "2001:db8::1\n", "2001:db8::1", false},
+		{"torproject json", `{"IsTor":true,"IP":"185.220.101.1"}`, "185.220.101.1", false},
+		{"html error page", "<html>503</html>", "", true},
+		{"empty", "", "", true},
+		{"json without ip", `{"IsTor":false}`, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseExitIP([]byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseExitIP(%q) error = %v, wantErr %v", tt.body, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseExitIP(%q) = %q, want %q", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbePopulatesExitIP(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "93.184.216.34")
+	}))
+	defer ts.Close()
+
+	// No manager: probes use the default transport straight to the server
+	racer := NewCircuitRacer(nil)
+	racer.probeURLs = []string{ts.URL}
+
+	best, err := racer.RaceCircuits(1)
+	if err != nil {
+		t.Fatalf("RaceCircuits() error = %v", err)
+	}
+	if best.ExitIP != "93.184.216.34" {
+		t.Errorf("ExitIP = %q, want 93.184.216.34", best.ExitIP)
+	}
+}
+
+func TestProbeFailsOnInvalidBody(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "<html>blocked</html>")
+	}))
+	defer ts.Close()
+
+	racer := NewCircuitRacer(nil)
+	racer.probeURLs = []string{ts.URL}
+
+	if _, err := racer.RaceCircuits(1); err == nil {
+		t.Error("expected race to fail when probes return garbage")
+	}
+}
+
+func TestSetProbeURLsValidation(t *testing.T) {
+	racer := NewCircuitRacer(nil)
+
+	if err := racer.SetProbeURLs(nil); err == nil {
+		t.Error("empty URL list should error")
+	}
+	if err := racer.SetProbeURLs([]string{"http://a.example", "  "}); err == nil {
+		t.Error("blank URL should error")
+	}
+	if err := racer.SetProbeURLs([]string{"http://a.example"}); err != nil {
+		t.Errorf("valid URLs should not error: %v", err)
+	}
+}
+
+func TestRaceCircuitsBoundedConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+
+		fmt.Fprintln(w, "93.184.216.34")
+	}))
+	defer ts.Close()
+
+	racer := NewCircuitRacer(nil)
+	if err := racer.SetProbeURLs([]string{ts.URL}); err != nil {
+		t.Fatal(err)
+	}
+	racer.SetMaxConcurrency(4)
+
+	if _, err := racer.RaceCircuits(50); err != nil {
+		t.Fatalf("RaceCircuits() error = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if maxInFlight > 4 {
+		t.Errorf("observed %d concurrent probes, want at most 4", maxInFlight)
+	}
+	if maxInFlight == 0 {
+		t.Error("no probes observed")
+	}
+}
+
+func TestProbeFailsOverToNextURL(t *testing.T) {
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer bad.Close()
+
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "93.184.216.34")
+	}))
+	defer good.Close()
+
+	racer := NewCircuitRacer(nil)
+	if err := racer.SetProbeURLs([]string{bad.URL, good.URL}); err != nil {
+		t.Fatal(err)
+	}
+
+	best, err := racer.RaceCircuits(1)
+	if err != nil {
+		t.Fatalf("RaceCircuits() error = %v", err)
+	}
+	if best.ProbeURL != good.URL {
+		t.Errorf("ProbeURL = %q, want the healthy endpoint %q", best.ProbeURL, good.URL)
+	}
+}
+
+func TestProbeExhaustsRetries(t *testing.T) {
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer bad.Close()
+
+	racer := NewCircuitRacer(nil)
+	if err := racer.SetProbeURLs([]string{bad.URL}); err != nil {
+		t.Fatal(err)
+	}
+	racer.SetMaxRetries(2)
+
+	if _, err := racer.RaceCircuits(1); err == nil {
+		t.Error("expected race to fail once retries are exhausted")
+	}
+}
+
+func TestFindFastestDefaultScore(t *testing.T) {
+	racer := NewCircuitRacer(nil)
+	racer.results = []CircuitRaceResult{
+		{CircuitID: "a", Latency: 300 * time.Millisecond, Success: true},
+		{CircuitID: "b", Latency: 100 * time.Millisecond, Success: true},
+		{CircuitID: "c", Latency: 50 * time.Millisecond, Success: false},
+	}
+
+	best := racer.findFastest()
+	if best == nil || best.CircuitID != "b" {
+		t.Errorf("default score should pick the lowest-latency success, got %+v", best)
+	}
+}
+
+func TestFindFastestCustomScore(t *testing.T) {
+	racer := NewCircuitRacer(nil)
+	racer.results = []CircuitRaceResult{
+		{CircuitID: "fast-narrow", Latency: 100 * time.Millisecond, Bandwidth: 1000, Success: true},
+		{CircuitID: "slow-wide", Latency: 200 * time.Millisecond, Bandwidth: 100000, Success: true},
+	}
+
+	// Weigh bandwidth heavily so the wider circuit wins despite latency
+	racer.SetScoreFunc(func(r CircuitRaceResult) float64 {
+		return r.Bandwidth / r.Latency.Seconds()
+	})
+
+	best := racer.findFastest()
+	if best == nil || best.CircuitID != "slow-wide" {
+		t.Errorf("custom score should pick the wider circuit, got %+v", best)
+	}
+
+	// Nil restores the default latency-only ranking
+	racer.SetScoreFunc(nil)
+	best = racer.findFastest()
+	if best == nil || best.CircuitID != "fast-narrow" {
+		t.Errorf("default score should pick the faster circuit, got %+v", best)
+	}
+}
+
+func TestRaceCircuitsUsesDistinctSOCKSCredentials(t *testing.T) {
+	stub := startStubSOCKS(t)
+
+	mgr := NewManager(&config.TorConfig{SOCKSPort: stub.port()})
+	racer := NewCircuitRacer(mgr)
+
+	best, err := racer.RaceCircuits(3)
+	if err != nil {
+		t.Fatalf("RaceCircuits() error = %v", err)
+	}
+	if best == nil || !best.Success {
+		t.Fatal("expected a successful fastest circuit")
+	}
+
+	users := stub.seenUsers()
+	if len(users) != 3 {
+		t.Errorf("expected 3 distinct SOCKS usernames, got %d: %v", len(users), users)
+	}
+}
@@ -0,0 +1,167 @@
+// Pre-warmed circuit pool to hide build latency
+package tor
+
+import (
+	"context"
+	"fmt"
+	mrand "math/rand"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/jery0843/torforge/pkg/logger"
+)
+
+const (
+	// defaultWarmTarget is how many pre-built circuits the pool keeps
+	// when EnableWarmPool is called without an explicit size
+	defaultWarmTarget = 3
+
+	// warmReplenishInterval is the backstop cadence for topping up the
+	// pool when no consumption kick arrives
+	warmReplenishInterval = 30 * time.Second
+
+	// warmBuildDelayBase/Jitter pace consecutive pool builds so a burst
+	// of consumption does not hammer the Tor network with EXTENDCIRCUIT
+	warmBuildDelayBase   = 100 * time.Millisecond
+	warmBuildDelayJitter = 400 * time.Millisecond
+)
+
+// EnableWarmPool starts maintaining target pre-built general-purpose
+// circuits (defaultWarmTarget when target <= 0). GetCircuitForDomain
+// and rotation consume from the pool instead of building on demand, and
+// the pool is replenished in the background with jittered scheduling.
+func (cm *CircuitManager) EnableWarmPool(target int) {
+	if target <= 0 {
+		target = defaultWarmTarget
+	}
+
+	cm.mu.Lock()
+	cm.warmTarget = target
+	started := cm.warmLoopStarted
+	cm.warmLoopStarted = true
+	cm.mu.Unlock()
+
+	if !started {
+		go cm.warmLoop()
+	}
+	cm.kickWarmPool()
+}
+
+// WaitReady blocks until at least one warm circuit exists, the context
+// is cancelled, or the manager stops
+func (cm *CircuitManager) WaitReady(ctx context.Context) error {
+	ticker := time.NewTicker(25 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		if cm.warmCount() > 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-cm.stopCh:
+			return fmt.Errorf("circuit manager stopped")
+		case <-ticker.C:
+		}
+	}
+}
+
+// warmCount returns how many unconsumed warm circuits exist
+func (cm *CircuitManager) warmCount() int {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return cm.warmCountLocked()
+}
+
+// warmCountLocked is warmCount for callers already holding cm.mu
+func (cm *CircuitManager) warmCountLocked() int {
+	n := 0
+	for _, c := range cm.circuits {
+		if c.warm {
+			n++
+		}
+	}
+	return n
+}
+
+// takeWarmCircuit hands out one warm circuit rebound to the given
+// purpose, or nil when the pool is empty. Consumption kicks the
+// replenisher.
+func (cm *CircuitManager) takeWarmCircuit(purpose string) *Circuit {
+	cm.mu.Lock()
+	var taken *Circuit
+	for _, c := range cm.circuits {
+		if c.warm {
+			c.warm = false
+			c.Purpose = purpose
+			c.LastUsed = cm.now()
+			taken = c
+			break
+		}
+	}
+	cm.mu.Unlock()
+
+	if taken != nil {
+		cm.kickWarmPool()
+	}
+	return taken
+}
+
+// kickWarmPool nudges the replenisher without blocking
+func (cm *CircuitManager) kickWarmPool() {
+	select {
+	case cm.warmKick <- struct{}{}:
+	default:
+	}
+}
+
+// warmLoop keeps the pool at its target size, waking on consumption
+// kicks with a slow ticker as backstop
+func (cm *CircuitManager) warmLoop() {
+	log := logger.WithComponent("circuit")
+	ticker := time.NewTicker(warmReplenishInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cm.stopCh:
+			return
+		case <-cm.warmKick:
+		case <-ticker.C:
+		}
+		cm.replenishWarmPool(log)
+	}
+}
+
+// replenishWarmPool builds circuits one at a time until the pool is
+// back at target, pausing a jittered delay between builds
+func (cm *CircuitManager) replenishWarmPool(log zerolog.Logger) {
+	for {
+		cm.mu.RLock()
+		need := cm.warmTarget - cm.warmCountLocked()
+		cm.mu.RUnlock()
+		if need <= 0 {
+			return
+		}
+
+		circuit, err := cm.CreateCircuit("warm")
+		if err != nil {
+			log.Warn().Err(err).Msg("failed to build warm circuit")
+			return
+		}
+		cm.mu.Lock()
+		circuit.warm = true
+		cm.mu.Unlock()
+		log.Debug().Str("circuit_id", circuit.ID).Msg("warm circuit ready")
+
+		// Jittered pacing between consecutive builds
+		delay := warmBuildDelayBase + time.Duration(mrand.Int63n(int64(warmBuildDelayJitter)))
+		select {
+		case <-cm.stopCh:
+			return
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package tor
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// waitFor polls until the condition holds or the deadline passes
+func waitFor(t *testing.T, d time.Duration, cond func() bool, msg string) {
+	t.Helper()
+	deadline := time.Now().Add(d)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal(msg)
+}
+
+func TestWarmPoolFillsToTarget(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+
+	cm.EnableWarmPool(3)
+
+	waitFor(t, 5*time.Second, func() bool {
+		return cm.GetStats().WarmCircuits == 3
+	}, "pool never reached its target of 3")
+
+	stats := cm.GetStats()
+	if stats.WarmTarget != 3 {
+		t.Errorf("WarmTarget = %d, want 3", stats.WarmTarget)
+	}
+	if !stats.PoolReady {
+		t.Error("PoolReady should be true with warm circuits available")
+	}
+	if stats.ActiveCount != 3 {
+		t.Errorf("ActiveCount = %d, want 3", stats.ActiveCount)
+	}
+}
+
+func TestWarmPoolDefaultTarget(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+
+	cm.EnableWarmPool(0)
+
+	if got := cm.GetStats().WarmTarget; got != defaultWarmTarget {
+		t.Errorf("WarmTarget = %d, want default %d", got, defaultWarmTarget)
+	}
+}
+
+func TestWarmPoolConsumptionReplenishes(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+
+	cm.EnableWarmPool(2)
+	waitFor(t, 5*time.Second, func() bool {
+		return cm.GetStats().WarmCircuits == 2
+	}, "pool never filled")
+
+	// Consumption hands out a pre-built circuit instead of building
+	circuit, err := cm.GetCircuitForDomain("warm.example")
+	if err != nil {
+		t.Fatalf("GetCircuitForDomain() error = %v", err)
+	}
+	if circuit.Purpose != "domain:warm.example" {
+		t.Errorf("purpose = %q, want domain:warm.example", circuit.Purpose)
+	}
+	if circuit.Domain != "warm.example" {
+		t.Errorf("domain = %q, want warm.example", circuit.Domain)
+	}
+	if circuit.SocksUser == "" || circuit.SocksPass == "" {
+		t.Error("consumed circuit missing isolation credentials")
+	}
+
+	// The pool refills to its floor while the consumed circuit lives on
+	waitFor(t, 5*time.Second, func() bool {
+		return cm.GetStats().WarmCircuits == 2
+	}, "pool not replenished after consumption")
+
+	if _, ok := cm.getCircuit(circuit.ID); !ok {
+		t.Error("consumed circuit disappeared during replenishment")
+	}
+}
+
+func TestRotationConsumesWarmCircuit(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+
+	victim, err := cm.CreateCircuit("general")
+	if err != nil {
+		t.Fatalf("CreateCircuit() error = %v", err)
+	}
+
+	cm.EnableWarmPool(1)
+	waitFor(t, 5*time.Second, func() bool {
+		return cm.GetStats().PoolReady
+	}, "pool never became ready")
+
+	created := cm.GetStats().TotalCreated
+	if err := cm.RotateCircuit(victim.ID); err != nil {
+		t.Fatalf("RotateCircuit() error = %v", err)
+	}
+
+	// The replacement came from the pool, not a fresh build
+	if got := cm.GetStats().TotalCreated; got != created {
+		t.Errorf("TotalCreated = %d, want %d (rotation should consume the pool)", got, created)
+	}
+}
+
+func TestWaitReady(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+
+	cm.EnableWarmPool(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := cm.WaitReady(ctx); err != nil {
+		t.Fatalf("WaitReady() error = %v", err)
+	}
+	if !cm.GetStats().PoolReady {
+		t.Error("PoolReady should be true after WaitReady returns")
+	}
+}
+
+func TestWaitReadyContextCancelled(t *testing.T) {
+	cm := NewCircuitManager(nil)
+	defer cm.Stop()
+
+	// No pool enabled, so readiness never arrives
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if err := cm.WaitReady(ctx); err != context.DeadlineExceeded {
+		t.Errorf("WaitReady() error = %v, want context.DeadlineExceeded", err)
+	}
+}
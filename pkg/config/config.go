@@ -2,9 +2,13 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/spf13/viper"
@@ -44,6 +48,8 @@ type ProxyConfig struct {
 	AllowedPorts    []int    `mapstructure:"allowed_ports"`    // Allow specific ports without Tor
 	BlockUDP        bool     `mapstructure:"block_udp"`        // Block all UDP (except DNS)
 	InterfaceBypass []string `mapstructure:"interface_bypass"` // Interfaces to bypass
+	TProxyMark      int      `mapstructure:"tproxy_mark"`      // Packet mark for TProxy routing
+	TProxyTable     int      `mapstructure:"tproxy_table"`     // Routing table for TProxy (1-252)
 }
 
 // BypassConfig configures traffic bypass rules
@@ -51,6 +57,8 @@ type BypassConfig struct {
 	Enabled      bool         `mapstructure:"enabled"`
 	Domains      []string     `mapstructure:"domains"`      // Domain patterns to bypass
 	CIDRs        []string     `mapstructure:"cidrs"`        // CIDR ranges to bypass
+	DenyDomains  []string     `mapstructure:"deny_domains"` // Domain patterns to block entirely
+	DenyCIDRs    []string     `mapstructure:"deny_cidrs"`   // CIDR ranges to block entirely
 	Protocols    []string     `mapstructure:"protocols"`    // Protocols to bypass (icmp, ntp)
 	Applications []string     `mapstructure:"applications"` // Application names to bypass
 	GeoIP        GeoIPConfig  `mapstructure:"geoip"`
@@ -59,9 +67,12 @@ type BypassConfig struct {
 
 // GeoIPConfig configures GeoIP-based bypass
 type GeoIPConfig struct {
-	Enabled      bool     `mapstructure:"enabled"`
-	DatabasePath string   `mapstructure:"database_path"` // Path to GeoLite2 database
-	Countries    []string `mapstructure:"countries"`     // Country codes to bypass
+	Enabled         bool     `mapstructure:"enabled"`
+	DatabasePath    string   `mapstructure:"database_path"`     // Path to GeoLite2 country database
+	ASNDatabasePath string   `mapstructure:"asn_database_path"` // Path to GeoLite2 ASN database
+	Countries       []string `mapstructure:"countries"`         // Country codes to bypass
+	ASNs            []uint   `mapstructure:"asns"`              // Autonomous system numbers to bypass
+	ExcludeASNs     []uint   `mapstructure:"exclude_asns"`      // ASNs never bypassed, even on a country match
 }
 
 // BypassRule represents a custom bypass rule
@@ -69,7 +80,8 @@ type BypassRule struct {
 	Name        string `mapstructure:"name"`
 	Type        string `mapstructure:"type"` // domain, cidr, port, protocol
 	Pattern     string `mapstructure:"pattern"`
-	Action      string `mapstructure:"action"` // bypass, block, tor
+	Action      string `mapstructure:"action"`   // bypass, block, tor
+	Priority    int    `mapstructure:"priority"` // Lower values evaluate first
 	Description string `mapstructure:"description"`
 }
 
@@ -96,12 +108,28 @@ type MonitoringConfig struct {
 
 // SecurityConfig configures security features
 type SecurityConfig struct {
-	DNSLeakProtection     bool `mapstructure:"dns_leak_protection"`
-	KillSwitch            bool `mapstructure:"kill_switch"` // Block all traffic if Tor fails
-	ExitNodeReputation    bool `mapstructure:"exit_node_reputation"`
-	FingerprintResistance bool `mapstructure:"fingerprint_resistance"`
-	SandboxEnabled        bool `mapstructure:"sandbox_enabled"`
-	NetworkNamespace      bool `mapstructure:"network_namespace"`
+	DNSLeakProtection     bool          `mapstructure:"dns_leak_protection"`
+	KillSwitch            bool          `mapstructure:"kill_switch"` // Block all traffic if Tor fails
+	ExitNodeReputation    bool          `mapstructure:"exit_node_reputation"`
+	FingerprintResistance bool          `mapstructure:"fingerprint_resistance"`
+	SandboxEnabled        bool          `mapstructure:"sandbox_enabled"`
+	NetworkNamespace      bool          `mapstructure:"network_namespace"`
+	Stego                 StegoConfig   `mapstructure:"stego"`
+	Quantum               QuantumConfig `mapstructure:"quantum"`
+}
+
+// StegoConfig configures traffic steganography
+type StegoConfig struct {
+	Enabled     bool   `mapstructure:"enabled"`
+	Mode        string `mapstructure:"mode"`         // "https", "dns", "icmp"
+	CoverDomain string `mapstructure:"cover_domain"` // Domain to mimic
+}
+
+// QuantumConfig configures the post-quantum encryption layer
+type QuantumConfig struct {
+	Enabled   bool   `mapstructure:"enabled"`
+	Algorithm string `mapstructure:"algorithm"` // "kyber512", "kyber768", "kyber1024"
+	Hybrid    bool   `mapstructure:"hybrid"`    // Combine X25519 ECDH with Kyber
 }
 
 // APIConfig configures the REST/WebSocket API
@@ -127,11 +155,13 @@ func DefaultConfig() *Config {
 			UseSystemTor: false,
 		},
 		Proxy: ProxyConfig{
-			Enabled:  true,
-			Mode:     "iptables",
-			IPv6:     false,
-			UID:      0,
-			BlockUDP: true,
+			Enabled:     true,
+			Mode:        "iptables",
+			IPv6:        false,
+			UID:         0,
+			BlockUDP:    true,
+			TProxyMark:  100,
+			TProxyTable: 100,
 		},
 		Bypass: BypassConfig{
 			Enabled: true,
@@ -140,6 +170,9 @@ func DefaultConfig() *Config {
 				"10.0.0.0/8",
 				"172.16.0.0/12",
 				"192.168.0.0/16",
+				"::1/128",   // IPv6 loopback
+				"fe80::/10", // IPv6 link-local
+				"fc00::/7",  // IPv6 unique-local (RFC1918 analogue)
 			},
 			Protocols: []string{},
 		},
@@ -165,6 +198,8 @@ func DefaultConfig() *Config {
 			FingerprintResistance: true,
 			SandboxEnabled:        false,
 			NetworkNamespace:      false,
+			Stego:                 StegoConfig{Mode: "https"},
+			Quantum:               QuantumConfig{Algorithm: "kyber768"},
 		},
 		API: APIConfig{
 			Enabled:    false,
@@ -173,8 +208,31 @@ func DefaultConfig() *Config {
 	}
 }
 
-// Load loads configuration from file and environment
+// Load loads configuration from file and environment. Values are
+// resolved with precedence env > profile > file > defaults: a
+// TORFORGE_-prefixed environment variable named after the key with dots
+// replaced by underscores (e.g. TORFORGE_TOR_SOCKS_PORT for
+// tor.socks_port) overrides both the config file and the built-in
+// default, and is type-checked before validation runs.
+//
+// A config file may define named profiles under a `profiles:` map and
+// select one with `active_profile` (or TORFORGE_ACTIVE_PROFILE); the
+// selected profile is deep-merged over the base config before
+// validation.
 func Load(configPath string) (*Config, error) {
+	return loadWithProfile(configPath, "")
+}
+
+// LoadProfile is Load with the profile chosen by the caller instead of
+// the config file's active_profile key
+func LoadProfile(configPath, profile string) (*Config, error) {
+	if profile == "" {
+		return nil, fmt.Errorf("profile name must not be empty")
+	}
+	return loadWithProfile(configPath, profile)
+}
+
+func loadWithProfile(configPath, profile string) (*Config, error) {
 	cfg := DefaultConfig()
 
 	v := viper.New()
@@ -200,10 +258,27 @@ func Load(configPath string) (*Config, error) {
 		_ = v.ReadInConfig()
 	}
 
-	// Environment variable overrides
+	// Environment variable overrides: highest precedence, applied to
+	// any key viper knows about (defaults or file)
 	v.SetEnvPrefix("TORFORGE")
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	v.AutomaticEnv()
 
+	// Profile selection: explicit argument wins, then active_profile
+	// from the environment or config file
+	if profile == "" {
+		profile = v.GetString("active_profile")
+	}
+	if profile != "" {
+		settings := v.GetStringMap("profiles." + profile)
+		if len(settings) == 0 {
+			return nil, fmt.Errorf("unknown config profile %q", profile)
+		}
+		if err := v.MergeConfigMap(settings); err != nil {
+			return nil, fmt.Errorf("failed to merge profile %q: %w", profile, err)
+		}
+	}
+
 	if err := v.Unmarshal(cfg); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
@@ -215,22 +290,71 @@ func Load(configPath string) (*Config, error) {
 	return cfg, nil
 }
 
-// Validate validates the configuration
+// Validate validates the configuration, collecting every problem into
+// one combined error so a broken config file reports all of its issues
+// at once
 func (c *Config) Validate() error {
+	var problems []error
+
 	if c.Tor.ControlPort < 1 || c.Tor.ControlPort > 65535 {
-		return fmt.Errorf("invalid control port: %d", c.Tor.ControlPort)
+		problems = append(problems, fmt.Errorf("invalid control port: %d", c.Tor.ControlPort))
 	}
 	if c.Tor.SOCKSPort < 1 || c.Tor.SOCKSPort > 65535 {
-		return fmt.Errorf("invalid SOCKS port: %d", c.Tor.SOCKSPort)
+		problems = append(problems, fmt.Errorf("invalid SOCKS port: %d", c.Tor.SOCKSPort))
 	}
 	if c.Tor.TransPort < 1 || c.Tor.TransPort > 65535 {
-		return fmt.Errorf("invalid transparent proxy port: %d", c.Tor.TransPort)
+		problems = append(problems, fmt.Errorf("invalid transparent proxy port: %d", c.Tor.TransPort))
 	}
 	if c.Circuits.MaxCircuits < 1 {
-		return fmt.Errorf("max_circuits must be at least 1")
+		problems = append(problems, fmt.Errorf("max_circuits must be at least 1"))
 	}
 	if c.Proxy.Mode != "iptables" && c.Proxy.Mode != "nftables" {
-		return fmt.Errorf("proxy mode must be 'iptables' or 'nftables'")
+		problems = append(problems, fmt.Errorf("proxy mode must be 'iptables' or 'nftables'"))
+	}
+	if c.Proxy.TProxyMark < 1 || c.Proxy.TProxyMark > 0xFFFFFFFF {
+		problems = append(problems, fmt.Errorf("invalid tproxy mark: %d", c.Proxy.TProxyMark))
+	}
+	if c.Proxy.TProxyTable < 1 || c.Proxy.TProxyTable > 252 {
+		problems = append(problems, fmt.Errorf("invalid tproxy routing table: %d (1-252, 253-255 are reserved)", c.Proxy.TProxyTable))
+	}
+
+	for _, cidr := range c.Bypass.CIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			problems = append(problems, fmt.Errorf("invalid bypass CIDR %q: %w", cidr, err))
+		}
+	}
+	for _, pattern := range c.Bypass.Domains {
+		if err := validateDomainGlob(pattern); err != nil {
+			problems = append(problems, err)
+		}
+	}
+
+	switch c.Security.Stego.Mode {
+	case "", "https", "dns", "icmp":
+	default:
+		problems = append(problems, fmt.Errorf("invalid stego mode %q (want https, dns, or icmp)", c.Security.Stego.Mode))
+	}
+
+	switch c.Security.Quantum.Algorithm {
+	case "", "kyber512", "kyber768", "kyber1024":
+	default:
+		problems = append(problems, fmt.Errorf("unknown quantum algorithm %q (want kyber512, kyber768, or kyber1024)", c.Security.Quantum.Algorithm))
+	}
+
+	return errors.Join(problems...)
+}
+
+// validateDomainGlob checks one bypass domain pattern for glob syntax
+// errors and catch-all patterns that would bypass everything
+func validateDomainGlob(pattern string) error {
+	if pattern == "" {
+		return fmt.Errorf("empty bypass domain pattern")
+	}
+	if _, err := path.Match(pattern, "example.com"); err != nil {
+		return fmt.Errorf("invalid bypass domain glob %q: %w", pattern, err)
+	}
+	if strings.Trim(pattern, "*.?") == "" {
+		return fmt.Errorf("catch-all bypass domain pattern %q would bypass all traffic", pattern)
 	}
 	return nil
 }
@@ -266,12 +390,19 @@ func setViperDefaults(v *viper.Viper, cfg *Config) {
 	v.SetDefault("tor.socks_port", cfg.Tor.SOCKSPort)
 	v.SetDefault("tor.trans_port", cfg.Tor.TransPort)
 	v.SetDefault("tor.dns_port", cfg.Tor.DNSPort)
+	v.SetDefault("tor.use_system_tor", cfg.Tor.UseSystemTor)
 	v.SetDefault("proxy.enabled", cfg.Proxy.Enabled)
 	v.SetDefault("proxy.mode", cfg.Proxy.Mode)
+	v.SetDefault("proxy.tproxy_mark", cfg.Proxy.TProxyMark)
+	v.SetDefault("proxy.tproxy_table", cfg.Proxy.TProxyTable)
+	v.SetDefault("bypass.enabled", cfg.Bypass.Enabled)
 	v.SetDefault("circuits.max_circuits", cfg.Circuits.MaxCircuits)
 	v.SetDefault("circuits.rotation_interval", cfg.Circuits.RotationInterval)
 	v.SetDefault("monitoring.enabled", cfg.Monitoring.Enabled)
+	v.SetDefault("monitoring.prometheus_port", cfg.Monitoring.PrometheusPort)
 	v.SetDefault("monitoring.log_level", cfg.Monitoring.LogLevel)
 	v.SetDefault("security.dns_leak_protection", cfg.Security.DNSLeakProtection)
 	v.SetDefault("security.kill_switch", cfg.Security.KillSwitch)
+	v.SetDefault("api.enabled", cfg.API.Enabled)
+	v.SetDefault("api.listen_addr", cfg.API.ListenAddr)
 }
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -195,3 +196,298 @@ func TestGetDataDir(t *testing.T) {
 		t.Error("GetDataDir returned empty string")
 	}
 }
+
+// TestValidateTProxySettings tests tproxy mark/table validation
+func TestValidateTProxySettings(t *testing.T) {
+	cfg := DefaultConfig()
+	if cfg.Proxy.TProxyMark != 100 || cfg.Proxy.TProxyTable != 100 {
+		t.Errorf("default tproxy mark/table = %d/%d, want 100/100",
+			cfg.Proxy.TProxyMark, cfg.Proxy.TProxyTable)
+	}
+
+	cfg.Proxy.TProxyMark = 0
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for tproxy mark 0")
+	}
+
+	cfg = DefaultConfig()
+	cfg.Proxy.TProxyTable = 254
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for reserved routing table 254")
+	}
+
+	cfg = DefaultConfig()
+	cfg.Proxy.TProxyMark = 200
+	cfg.Proxy.TProxyTable = 201
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() error = %v for valid overrides", err)
+	}
+}
+
+func TestLoadEnvOverridesDefault(t *testing.T) {
+	t.Setenv("TORFORGE_TOR_SOCKS_PORT", "9060")
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if cfg.Tor.SOCKSPort != 9060 {
+		t.Errorf("socks_port = %d, want env override 9060", cfg.Tor.SOCKSPort)
+	}
+	// Untouched keys keep their defaults
+	if cfg.Tor.ControlPort != 9051 {
+		t.Errorf("control_port = %d, want default 9051", cfg.Tor.ControlPort)
+	}
+}
+
+func TestLoadEnvOverridesFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "torforge.yaml")
+	content := "tor:\n  socks_port: 9150\n  control_port: 9151\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	t.Setenv("TORFORGE_TOR_SOCKS_PORT", "9250")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	// Env beats file, file beats default
+	if cfg.Tor.SOCKSPort != 9250 {
+		t.Errorf("socks_port = %d, want env override 9250", cfg.Tor.SOCKSPort)
+	}
+	if cfg.Tor.ControlPort != 9151 {
+		t.Errorf("control_port = %d, want file value 9151", cfg.Tor.ControlPort)
+	}
+}
+
+func TestLoadEnvTypeError(t *testing.T) {
+	t.Setenv("TORFORGE_TOR_SOCKS_PORT", "not-a-port")
+
+	if _, err := Load(""); err == nil {
+		t.Error("Load() should error for a non-numeric port override")
+	}
+}
+
+func TestLoadEnvFailsValidation(t *testing.T) {
+	t.Setenv("TORFORGE_TOR_SOCKS_PORT", "99999")
+
+	if _, err := Load(""); err == nil {
+		t.Error("Load() should reject an out-of-range port from the environment")
+	}
+}
+
+func TestValidateSubSections(t *testing.T) {
+	tests := []struct {
+		name    string
+		mutate  func(*Config)
+		wantErr string
+	}{
+		{
+			name:   "defaults are valid",
+			mutate: func(c *Config) {},
+		},
+		{
+			name:    "bad bypass CIDR",
+			mutate:  func(c *Config) { c.Bypass.CIDRs = append(c.Bypass.CIDRs, "10.0.0.0/99") },
+			wantErr: "10.0.0.0/99",
+		},
+		{
+			name:    "bad domain glob",
+			mutate:  func(c *Config) { c.Bypass.Domains = []string{"[invalid"} },
+			wantErr: "[invalid",
+		},
+		{
+			name:    "catch-all domain glob",
+			mutate:  func(c *Config) { c.Bypass.Domains = []string{"*"} },
+			wantErr: "catch-all",
+		},
+		{
+			name:    "empty domain pattern",
+			mutate:  func(c *Config) { c.Bypass.Domains = []string{""} },
+			wantErr: "empty bypass domain",
+		},
+		{
+			name:   "valid domain globs",
+			mutate: func(c *Config) { c.Bypass.Domains = []string{"example.com", "*.example.org"} },
+		},
+		{
+			name:    "bad stego mode",
+			mutate:  func(c *Config) { c.Security.Stego.Mode = "smtp" },
+			wantErr: "stego mode",
+		},
+		{
+			name:   "empty stego mode allowed",
+			mutate: func(c *Config) { c.Security.Stego.Mode = "" },
+		},
+		{
+			name:    "unknown quantum algorithm",
+			mutate:  func(c *Config) { c.Security.Quantum.Algorithm = "kyber9000" },
+			wantErr: "kyber9000",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			tt.mutate(cfg)
+
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("Validate() should error")
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error %q should mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateCombinesProblems(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Bypass.CIDRs = append(cfg.Bypass.CIDRs, "not-a-cidr")
+	cfg.Security.Stego.Mode = "smtp"
+	cfg.Security.Quantum.Algorithm = "rsa"
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Validate() should error")
+	}
+
+	// All three problems show up in one pass
+	for _, want := range []string{"not-a-cidr", "stego mode", "rsa"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("combined error %q should mention %q", err, want)
+		}
+	}
+}
+
+func writeProfileConfig(t *testing.T) string {
+	t.Helper()
+	content := `
+bypass:
+  domains:
+    - base.example
+profiles:
+  home:
+    bypass:
+      domains:
+        - home.example
+        - "*.lan.example"
+  lab:
+    tor:
+      socks_port: 9150
+    bypass:
+      domains:
+        - lab.example
+`
+	path := filepath.Join(t.TempDir(), "torforge.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	return path
+}
+
+func TestLoadWithoutProfileKeepsBase(t *testing.T) {
+	cfg, err := Load(writeProfileConfig(t))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if len(cfg.Bypass.Domains) != 1 || cfg.Bypass.Domains[0] != "base.example" {
+		t.Errorf("domains = %v, want [base.example]", cfg.Bypass.Domains)
+	}
+}
+
+func TestLoadProfileMerges(t *testing.T) {
+	path := writeProfileConfig(t)
+
+	home, err := LoadProfile(path, "home")
+	if err != nil {
+		t.Fatalf("LoadProfile(home) error = %v", err)
+	}
+	if len(home.Bypass.Domains) != 2 || home.Bypass.Domains[0] != "home.example" {
+		t.Errorf("home domains = %v, want [home.example *.lan.example]", home.Bypass.Domains)
+	}
+	// Keys the profile leaves alone keep base/default values
+	if home.Tor.SOCKSPort != 9050 {
+		t.Errorf("home socks_port = %d, want default 9050", home.Tor.SOCKSPort)
+	}
+
+	lab, err := LoadProfile(path, "lab")
+	if err != nil {
+		t.Fatalf("LoadProfile(lab) error = %v", err)
+	}
+	if len(lab.Bypass.Domains) != 1 || lab.Bypass.Domains[0] != "lab.example" {
+		t.Errorf("lab domains = %v, want [lab.example]", lab.Bypass.Domains)
+	}
+	if lab.Tor.SOCKSPort != 9150 {
+		t.Errorf("lab socks_port = %d, want 9150", lab.Tor.SOCKSPort)
+	}
+}
+
+func TestLoadActiveProfileKey(t *testing.T) {
+	content := `
+active_profile: home
+bypass:
+  domains:
+    - base.example
+profiles:
+  home:
+    bypass:
+      domains:
+        - home.example
+`
+	path := filepath.Join(t.TempDir(), "torforge.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(cfg.Bypass.Domains) != 1 || cfg.Bypass.Domains[0] != "home.example" {
+		t.Errorf("domains = %v, want [home.example]", cfg.Bypass.Domains)
+	}
+}
+
+func TestLoadProfileUnknown(t *testing.T) {
+	if _, err := LoadProfile(writeProfileConfig(t), "office"); err == nil {
+		t.Error("LoadProfile with unknown profile should error")
+	}
+	if _, err := LoadProfile(writeProfileConfig(t), ""); err == nil {
+		t.Error("LoadProfile with empty profile should error")
+	}
+}
+
+func TestLoadProfileValidatesMergedResult(t *testing.T) {
+	content := `
+profiles:
+  broken:
+    proxy:
+      mode: pf
+`
+	path := filepath.Join(t.TempDir(), "torforge.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	_, err := LoadProfile(path, "broken")
+	if err == nil {
+		t.Fatal("LoadProfile should reject an invalid merged config")
+	}
+	if !strings.Contains(err.Error(), "proxy mode") {
+		t.Errorf("error %q should mention the invalid proxy mode", err)
+	}
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/jery0843/torforge/pkg/logger"
+)
+
+// watchDebounce coalesces the burst of filesystem events editors emit
+// for a single save into one reload
+const watchDebounce = 200 * time.Millisecond
+
+// WatchConfig watches the config file at path and invokes onChange with
+// the freshly loaded *Config whenever it is modified and passes
+// validation. Invalid edits are logged and ignored, so the last good
+// configuration stays in effect. The returned stop function shuts the
+// watcher down.
+func WatchConfig(path string, onChange func(*Config)) (func(), error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create config watcher: %w", err)
+	}
+
+	// Watch the directory rather than the file itself: most editors
+	// save via rename, which drops a watch placed directly on the file
+	dir := filepath.Dir(path)
+	if err := watcher.Add(dir); err != nil {
+		watcher.Close()
+		return nil, fmt.Errorf("failed to watch %s: %w", dir, err)
+	}
+
+	log := logger.WithComponent("config")
+	target, err := filepath.Abs(path)
+	if err != nil {
+		target = path
+	}
+
+	stopCh := make(chan struct{})
+	var stopOnce sync.Once
+	stop := func() {
+		stopOnce.Do(func() {
+			close(stopCh)
+			watcher.Close()
+		})
+	}
+
+	go func() {
+		var debounce *time.Timer
+		var debounceCh <-chan time.Time
+
+		for {
+			select {
+			case <-stopCh:
+				return
+
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				abs, err := filepath.Abs(event.Name)
+				if err != nil {
+					abs = event.Name
+				}
+				if abs != target {
+					continue
+				}
+				if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) && !event.Has(fsnotify.Rename) {
+					continue
+				}
+				// Restart the debounce window on every event
+				if debounce == nil {
+					debounce = time.NewTimer(watchDebounce)
+					debounceCh = debounce.C
+				} else {
+					if !debounce.Stop() {
+						select {
+						case <-debounce.C:
+						default:
+						}
+					}
+					debounce.Reset(watchDebounce)
+				}
+
+			case <-debounceCh:
+				debounce = nil
+				debounceCh = nil
+
+				cfg, err := Load(path)
+				if err != nil {
+					log.Warn().
+						Err(err).
+						Str("path", path).
+						Msg("ignoring invalid config change")
+					continue
+				}
+				log.Info().
+					Str("path", path).
+					Msg("config reloaded")
+				onChange(cfg)
+
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Warn().Err(err).Msg("config watcher error")
+			}
+		}
+	}()
+
+	return stop, nil
+}
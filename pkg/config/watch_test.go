@@ -0,0 +1,91 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, path string, socksPort int) {
+	t.Helper()
+	content := fmt.Sprintf("tor:\n  socks_port: %d\n", socksPort)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+}
+
+func TestWatchConfigReloads(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "torforge.yaml")
+	writeConfigFile(t, path, 9050)
+
+	changes := make(chan *Config, 4)
+	stop, err := WatchConfig(path, func(cfg *Config) {
+		changes <- cfg
+	})
+	if err != nil {
+		t.Fatalf("WatchConfig() error = %v", err)
+	}
+	defer stop()
+
+	writeConfigFile(t, path, 9150)
+
+	select {
+	case cfg := <-changes:
+		if cfg.Tor.SOCKSPort != 9150 {
+			t.Errorf("reloaded socks_port = %d, want 9150", cfg.Tor.SOCKSPort)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("callback did not fire after config change")
+	}
+}
+
+func TestWatchConfigIgnoresInvalidEdit(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "torforge.yaml")
+	writeConfigFile(t, path, 9050)
+
+	changes := make(chan *Config, 4)
+	stop, err := WatchConfig(path, func(cfg *Config) {
+		changes <- cfg
+	})
+	if err != nil {
+		t.Fatalf("WatchConfig() error = %v", err)
+	}
+	defer stop()
+
+	// An out-of-range port fails validation and must not reach the callback
+	writeConfigFile(t, path, 99999)
+
+	select {
+	case cfg := <-changes:
+		t.Errorf("callback fired for invalid config (socks_port=%d)", cfg.Tor.SOCKSPort)
+	case <-time.After(time.Second):
+	}
+}
+
+func TestWatchConfigStop(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "torforge.yaml")
+	writeConfigFile(t, path, 9050)
+
+	changes := make(chan *Config, 4)
+	stop, err := WatchConfig(path, func(cfg *Config) {
+		changes <- cfg
+	})
+	if err != nil {
+		t.Fatalf("WatchConfig() error = %v", err)
+	}
+	stop()
+	stop() // Idempotent
+
+	writeConfigFile(t, path, 9151)
+
+	select {
+	case <-changes:
+		t.Error("callback fired after stop")
+	case <-time.After(500 * time.Millisecond):
+	}
+}
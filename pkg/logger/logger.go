@@ -14,18 +14,28 @@ import (
 var (
 	// Log is the global logger instance
 	Log zerolog.Logger
-	
+
 	// AuditLog is for security audit events
 	AuditLog zerolog.Logger
 )
 
 // Config configures the logger
 type Config struct {
-	Level       string // debug, info, warn, error
-	Console     bool   // Pretty console output
-	File        string // Log file path
-	AuditFile   string // Audit log file path (JSONL)
-	JSONFormat  bool   // Use JSON format
+	Level      string // debug, info, warn, error
+	Console    bool   // Pretty console output
+	File       string // Log file path
+	AuditFile  string // Audit log file path (JSONL)
+	JSONFormat bool   // Use JSON format
+	MaxSizeMB  int    // Rotate log files past this size (0 = never rotate)
+	MaxBackups int    // Rotated files to keep (0 = keep all)
+	MaxAgeDays int    // Days to keep rotated files (0 = forever)
+	Compress   bool   // Gzip rotated files
+	Syslog     bool   // Also log to the local syslog daemon
+	SyslogTag  string // Syslog tag (default "torforge")
+
+	// RedactFields lists field keys whose values are replaced with
+	// "[REDACTED]" in all outputs, including the audit log
+	RedactFields []string
 }
 
 // Init initializes the global logger
@@ -40,19 +50,24 @@ func Init(cfg Config) error {
 
 	var writers []io.Writer
 
-	// Console output
+	// Console output: pretty by default, raw JSON when JSONFormat is
+	// set (file and audit writers are always JSON)
 	if cfg.Console {
-		consoleWriter := zerolog.ConsoleWriter{
-			Out:        os.Stderr,
-			TimeFormat: "15:04:05",
-			FormatLevel: func(i interface{}) string {
-				return colorLevel(i)
-			},
-			FormatMessage: func(i interface{}) string {
-				return fmt.Sprintf("| %s", i)
-			},
+		if cfg.JSONFormat {
+			writers = append(writers, os.Stderr)
+		} else {
+			consoleWriter := zerolog.ConsoleWriter{
+				Out:        os.Stderr,
+				TimeFormat: "15:04:05",
+				FormatLevel: func(i interface{}) string {
+					return colorLevel(i)
+				},
+				FormatMessage: func(i interface{}) string {
+					return fmt.Sprintf("| %s", i)
+				},
+			}
+			writers = append(writers, consoleWriter)
 		}
-		writers = append(writers, consoleWriter)
 	}
 
 	// File output
@@ -60,24 +75,40 @@ func Init(cfg Config) error {
 		if err := os.MkdirAll(filepath.Dir(cfg.File), 0755); err != nil {
 			return fmt.Errorf("failed to create log directory: %w", err)
 		}
-		file, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		file, err := openLogFile(cfg.File, 0644, cfg)
 		if err != nil {
 			return fmt.Errorf("failed to open log file: %w", err)
 		}
 		writers = append(writers, file)
 	}
 
+	// Syslog output, e.g. for systemd journal deployments
+	if cfg.Syslog {
+		syslogWriter, err := newSyslogWriter(cfg.SyslogTag)
+		if err != nil {
+			return fmt.Errorf("failed to connect to syslog: %w", err)
+		}
+		writers = append(writers, syslogWriter)
+	}
+
 	// Default to stderr if no writers
 	if len(writers) == 0 {
 		writers = append(writers, os.Stderr)
 	}
 
+	// Redaction sits in front of every sink
+	if len(cfg.RedactFields) > 0 {
+		for i := range writers {
+			writers[i] = newRedactWriter(writers[i], cfg.RedactFields)
+		}
+	}
+
 	multi := zerolog.MultiLevelWriter(writers...)
 	Log = zerolog.New(multi).With().Timestamp().Caller().Logger()
 
 	// Audit log (always JSON, always to file)
 	if cfg.AuditFile != "" {
-		if err := initAuditLog(cfg.AuditFile); err != nil {
+		if err := initAuditLog(cfg.AuditFile, cfg); err != nil {
 			return fmt.Errorf("failed to init audit log: %w", err)
 		}
 	} else {
@@ -87,37 +118,50 @@ func Init(cfg Config) error {
 	return nil
 }
 
-func initAuditLog(path string) error {
+func initAuditLog(path string, cfg Config) error {
 	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 		return err
 	}
-	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	file, err := openLogFile(path, 0600, cfg)
 	if err != nil {
 		return err
 	}
-	AuditLog = zerolog.New(file).With().Timestamp().Logger()
+	var w io.Writer = file
+	if len(cfg.RedactFields) > 0 {
+		w = newRedactWriter(w, cfg.RedactFields)
+	}
+	AuditLog = zerolog.New(w).With().Timestamp().Logger()
 	return nil
 }
 
+// openLogFile opens a log file for appending, wrapping it in a rotating
+// writer when rotation is configured
+func openLogFile(path string, perm os.FileMode, cfg Config) (io.Writer, error) {
+	if cfg.MaxSizeMB > 0 {
+		return newRotatingWriter(path, perm, cfg.MaxSizeMB, cfg.MaxBackups, cfg.MaxAgeDays, cfg.Compress)
+	}
+	return os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, perm)
+}
+
 func colorLevel(i interface{}) string {
 	level, ok := i.(string)
 	if !ok {
 		return "???"
 	}
-	
+
 	switch level {
 	case "debug":
-		return "\033[36mDBG\033[0m"  // Cyan
+		return "\033[36mDBG\033[0m" // Cyan
 	case "info":
-		return "\033[32mINF\033[0m"  // Green
+		return "\033[32mINF\033[0m" // Green
 	case "warn":
-		return "\033[33mWRN\033[0m"  // Yellow
+		return "\033[33mWRN\033[0m" // Yellow
 	case "error":
-		return "\033[31mERR\033[0m"  // Red
+		return "\033[31mERR\033[0m" // Red
 	case "fatal":
-		return "\033[35mFTL\033[0m"  // Magenta
+		return "\033[35mFTL\033[0m" // Magenta
 	case "panic":
-		return "\033[35mPNC\033[0m"  // Magenta
+		return "\033[35mPNC\033[0m" // Magenta
 	default:
 		return level
 	}
@@ -158,21 +202,31 @@ func WithComponent(component string) zerolog.Logger {
 	return Log.With().Str("component", component).Logger()
 }
 
-// CircuitEvent logs circuit-related events
+// CircuitEvent logs circuit-related events, subject to the "circuit"
+// sample rate
 func CircuitEvent(circuitID string, event string) {
+	if !sampleEvent("circuit") {
+		return
+	}
+
 	Log.Info().
 		Str("circuit_id", circuitID).
 		Str("event", event).
 		Msg("circuit event")
-	
+
 	Audit("circuit").
 		Str("circuit_id", circuitID).
 		Str("event", event).
 		Msg("")
 }
 
-// ConnectionEvent logs connection events
+// ConnectionEvent logs connection events, subject to the "connection"
+// sample rate — it fires per connection and floods the logs during scans
 func ConnectionEvent(srcIP, dstIP string, dstPort int, action string) {
+	if !sampleEvent("connection") {
+		return
+	}
+
 	Log.Debug().
 		Str("src_ip", srcIP).
 		Str("dst_ip", dstIP).
@@ -181,13 +235,36 @@ func ConnectionEvent(srcIP, dstIP string, dstPort int, action string) {
 		Msg("connection")
 }
 
+// RouteDecision logs a split-tunnel routing decision, subject to the
+// "route" sample rate — one fires per evaluated flow
+func RouteDecision(app, domain string, useTor bool, confidence float64, reason string) {
+	if !sampleEvent("route") {
+		return
+	}
+
+	Log.Info().
+		Str("app", app).
+		Str("domain", domain).
+		Bool("use_tor", useTor).
+		Float64("confidence", confidence).
+		Str("reason", reason).
+		Msg("route decision")
+
+	Audit("route_decision").
+		Str("app", app).
+		Str("domain", domain).
+		Bool("use_tor", useTor).
+		Str("reason", reason).
+		Msg("")
+}
+
 // SecurityEvent logs security-related events
 func SecurityEvent(event, details string) {
 	Log.Warn().
 		Str("security_event", event).
 		Str("details", details).
 		Msg("security alert")
-	
+
 	Audit("security").
 		Str("event", event).
 		Str("details", details).
@@ -200,7 +277,7 @@ func LeakEvent(leakType, destination string) {
 		Str("leak_type", leakType).
 		Str("destination", destination).
 		Msg("POTENTIAL LEAK DETECTED")
-	
+
 	Audit("leak").
 		Str("type", leakType).
 		Str("destination", destination).
@@ -1,9 +1,13 @@
 package logger
 
 import (
+	"encoding/json"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 // TestLoggerInit tests initializing the logger from user perspective
@@ -167,6 +171,45 @@ func TestLoggerLeakEvent(t *testing.T) {
 	LeakEvent("dns_leak", "query to 8.8.8.8")
 }
 
+// TestLoggerRouteDecision tests the split-tunnel decision helper
+func TestLoggerRouteDecision(t *testing.T) {
+	tmpDir := t.TempDir()
+	logFile := filepath.Join(tmpDir, "test.log")
+	auditFile := filepath.Join(tmpDir, "audit.log")
+
+	cfg := Config{
+		Level:     "debug",
+		Console:   false,
+		File:      logFile,
+		AuditFile: auditFile,
+	}
+
+	if err := Init(cfg); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+
+	RouteDecision("firefox", "example.onion", true, 0.92, "sensitive_domain")
+
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("reading log file: %v", err)
+	}
+	out := string(data)
+	for _, want := range []string{`"app":"firefox"`, `"domain":"example.onion"`, `"use_tor":true`, `"confidence":0.92`, `"reason":"sensitive_domain"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log output missing %s, got %s", want, out)
+		}
+	}
+
+	audit, err := os.ReadFile(auditFile)
+	if err != nil {
+		t.Fatalf("reading audit file: %v", err)
+	}
+	if !strings.Contains(string(audit), `"audit_event":"route_decision"`) {
+		t.Errorf("audit output missing route_decision event, got %s", audit)
+	}
+}
+
 // TestLoggerLevelParsing tests different log levels
 func TestLoggerLevelParsing(t *testing.T) {
 	tests := []struct {
@@ -238,6 +281,305 @@ func TestLoggerConsoleOutput(t *testing.T) {
 	Info().Msg("console test message")
 }
 
+// TestLoggerSyslog tests wiring the syslog sink, skipping where no
+// syslog socket is available (containers, CI)
+func TestLoggerSyslog(t *testing.T) {
+	if _, err := newSyslogWriter("torforge-test"); err != nil {
+		t.Skipf("syslog unavailable: %v", err)
+	}
+
+	cfg := Config{
+		Level:     "debug",
+		Console:   false,
+		Syslog:    true,
+		SyslogTag: "torforge-test",
+	}
+
+	if err := Init(cfg); err != nil {
+		t.Fatalf("Init() with syslog error = %v", err)
+	}
+
+	// Should reach the daemon without panicking
+	Info().Msg("syslog test message")
+	Warn().Msg("syslog warning message")
+}
+
+// TestLoggerJSONFormatConsole tests that JSONFormat switches console
+// output from the pretty writer to raw JSON
+func TestLoggerJSONFormatConsole(t *testing.T) {
+	oldStderr := os.Stderr
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe() error = %v", err)
+	}
+	os.Stderr = w
+	defer func() { os.Stderr = oldStderr }()
+
+	cfg := Config{
+		Level:      "info",
+		Console:    true,
+		JSONFormat: true,
+	}
+	if err := Init(cfg); err != nil {
+		os.Stderr = oldStderr
+		t.Fatalf("Init() error = %v", err)
+	}
+
+	Info().Msg("json console message")
+
+	w.Close()
+	os.Stderr = oldStderr
+
+	// Point the global logger away from the closed pipe before any
+	// later test logs
+	defer Init(Config{Level: "info"})
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("reading captured console output: %v", err)
+	}
+	line := strings.TrimSpace(string(data))
+	if line == "" {
+		t.Fatal("no console output captured")
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &decoded); err != nil {
+		t.Fatalf("console output %q is not JSON: %v", line, err)
+	}
+	if decoded["message"] != "json console message" {
+		t.Errorf("decoded message = %v, want %q", decoded["message"], "json console message")
+	}
+	if _, ok := decoded["level"]; !ok {
+		t.Error("decoded output has no level field")
+	}
+}
+
+// TestLoggerRedactFields tests that configured field values never reach
+// the log file or audit log
+func TestLoggerRedactFields(t *testing.T) {
+	tmpDir := t.TempDir()
+	logFile := filepath.Join(tmpDir, "test.log")
+	auditFile := filepath.Join(tmpDir, "audit.log")
+
+	cfg := Config{
+		Level:        "debug",
+		Console:      false,
+		File:         logFile,
+		AuditFile:    auditFile,
+		RedactFields: []string{"cookie", "onion"},
+	}
+
+	if err := Init(cfg); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+
+	Info().
+		Str("cookie", "86c6f8a5secretcookievalue").
+		Str("onion", "vww6ybal4bd7szmgncyruucpgfkqahzddi37ktceo3ah7ngmcopnpyyd.onion").
+		Str("visible", "keep-me").
+		Msg("auth attempt")
+	Audit("auth").Str("cookie", "86c6f8a5secretcookievalue").Msg("")
+
+	for _, path := range []string{logFile, auditFile} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("reading %s: %v", path, err)
+		}
+		out := string(data)
+		if strings.Contains(out, "secretcookievalue") {
+			t.Errorf("%s contains the raw cookie value", filepath.Base(path))
+		}
+		if !strings.Contains(out, "[REDACTED]") {
+			t.Errorf("%s has no [REDACTED] marker", filepath.Base(path))
+		}
+	}
+
+	data, _ := os.ReadFile(logFile)
+	if strings.Contains(string(data), "vww6ybal") {
+		t.Error("log file contains the raw onion address")
+	}
+	if !strings.Contains(string(data), "keep-me") {
+		t.Error("non-redacted field was lost")
+	}
+
+	// Redacted output must still be valid JSON
+	line := strings.TrimSpace(strings.SplitN(string(data), "\n", 2)[0])
+	var decoded map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &decoded); err != nil {
+		t.Fatalf("redacted output %q is not JSON: %v", line, err)
+	}
+	if decoded["cookie"] != "[REDACTED]" {
+		t.Errorf("cookie = %v, want [REDACTED]", decoded["cookie"])
+	}
+}
+
+// TestRedactHelper tests the ad-hoc masking helper
+func TestRedactHelper(t *testing.T) {
+	if got := Redact("86c6f8a5e4b12f90"); got != "86c***90" {
+		t.Errorf("Redact(long) = %q, want 86c***90", got)
+	}
+	if got := Redact("short"); got != "[REDACTED]" {
+		t.Errorf("Redact(short) = %q, want [REDACTED]", got)
+	}
+	if got := Redact(""); got != "[REDACTED]" {
+		t.Errorf("Redact(empty) = %q, want [REDACTED]", got)
+	}
+}
+
+// TestConnectionEventSampling tests that a burst of connection events is
+// capped at the configured rate and followed by a suppression summary
+func TestConnectionEventSampling(t *testing.T) {
+	tmpDir := t.TempDir()
+	logFile := filepath.Join(tmpDir, "test.log")
+
+	if err := Init(Config{Level: "debug", File: logFile}); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+
+	SetSampleRate("connection", 10)
+	defer SetSampleRate("connection", 0)
+
+	// Freeze the sampler's clock so the whole burst lands in one window
+	v, _ := samplers.Load("connection")
+	s := v.(*sampler)
+	base := time.Now()
+	s.now = func() time.Time { return base }
+
+	for i := 0; i < 1000; i++ {
+		ConnectionEvent("192.168.1.1", "10.0.0.1", 8080, "scan")
+	}
+
+	// Roll into the next window; the first event there carries the summary
+	s.now = func() time.Time { return base.Add(2 * time.Second) }
+	ConnectionEvent("192.168.1.1", "10.0.0.1", 8080, "scan")
+
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("reading log file: %v", err)
+	}
+
+	events := 0
+	summaries := 0
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		switch {
+		case strings.Contains(line, `"action"`):
+			events++
+		case strings.Contains(line, "suppressed 990 messages"):
+			summaries++
+		}
+	}
+
+	if events != 11 {
+		t.Errorf("logged %d connection events, want 11 (10 in burst + 1 after)", events)
+	}
+	if summaries != 1 {
+		t.Errorf("saw %d suppression summaries, want 1", summaries)
+	}
+}
+
+// TestSampleRateUnsetIsUnlimited tests that event types without a
+// configured rate are never dropped
+func TestSampleRateUnsetIsUnlimited(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if allowed, _ := allowEvent("circuit"); !allowed {
+			t.Fatal("event dropped without a configured sample rate")
+		}
+	}
+}
+
+// TestLoggerRotation tests that the main and audit logs rotate once
+// they pass the configured size
+func TestLoggerRotation(t *testing.T) {
+	tmpDir := t.TempDir()
+	logFile := filepath.Join(tmpDir, "torforge.log")
+	auditFile := filepath.Join(tmpDir, "audit.log")
+
+	cfg := Config{
+		Level:      "debug",
+		Console:    false,
+		File:       logFile,
+		AuditFile:  auditFile,
+		MaxSizeMB:  1,
+		MaxBackups: 2,
+	}
+
+	if err := Init(cfg); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+
+	// Write past the 1 MB threshold on both logs
+	payload := strings.Repeat("x", 1024)
+	for i := 0; i < 1536; i++ {
+		Info().Str("payload", payload).Msg("rotation filler")
+		Audit("rotation_test").Str("payload", payload).Msg("")
+	}
+
+	backups, err := filepath.Glob(filepath.Join(tmpDir, "torforge-*.log"))
+	if err != nil {
+		t.Fatalf("Glob() error = %v", err)
+	}
+	if len(backups) == 0 {
+		t.Error("no rotated backup of the main log appeared")
+	}
+
+	auditBackups, err := filepath.Glob(filepath.Join(tmpDir, "audit-*.log"))
+	if err != nil {
+		t.Fatalf("Glob() error = %v", err)
+	}
+	if len(auditBackups) == 0 {
+		t.Error("no rotated backup of the audit log appeared")
+	}
+
+	// The active files are still in place after rotation
+	if _, err := os.Stat(logFile); err != nil {
+		t.Errorf("active log file missing after rotation: %v", err)
+	}
+	if _, err := os.Stat(auditFile); err != nil {
+		t.Errorf("active audit file missing after rotation: %v", err)
+	}
+}
+
+// TestRotatingWriterPruneAndCompress tests backup pruning and gzip
+// compression at the writer level, where the size threshold can be
+// small enough to force many rotations
+func TestRotatingWriterPruneAndCompress(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "small.log")
+
+	w := &rotatingWriter{
+		path:       path,
+		perm:       0644,
+		maxBytes:   256,
+		maxBackups: 2,
+		compress:   true,
+		now:        time.Now,
+	}
+	if err := w.openLocked(); err != nil {
+		t.Fatalf("openLocked() error = %v", err)
+	}
+
+	line := []byte(strings.Repeat("y", 100) + "\n")
+	for i := 0; i < 20; i++ {
+		if _, err := w.Write(line); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+
+	backups := w.backups()
+	if len(backups) == 0 {
+		t.Fatal("no backups after repeated rotations")
+	}
+	if len(backups) > 2 {
+		t.Errorf("kept %d backups, want at most 2", len(backups))
+	}
+	for _, backup := range backups {
+		if !strings.HasSuffix(backup, ".gz") {
+			t.Errorf("backup %s was not compressed", backup)
+		}
+	}
+}
+
 // TestLoggerCreateDirectory tests auto-creation of log directory
 func TestLoggerCreateDirectory(t *testing.T) {
 	tmpDir := t.TempDir()
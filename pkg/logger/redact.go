@@ -0,0 +1,91 @@
+package logger
+
+import (
+	"bytes"
+	"io"
+)
+
+var redactedValue = []byte(`"[REDACTED]"`)
+
+// redactWriter rewrites configured field values to "[REDACTED]" in each
+// JSON event before handing it to the underlying sink. It sits between
+// zerolog and every writer so sensitive values never reach disk, console
+// or syslog.
+type redactWriter struct {
+	next   io.Writer
+	fields []string
+}
+
+func newRedactWriter(next io.Writer, fields []string) *redactWriter {
+	return &redactWriter{next: next, fields: fields}
+}
+
+func (w *redactWriter) Write(p []byte) (int, error) {
+	if _, err := w.next.Write(redactJSON(p, w.fields)); err != nil {
+		return 0, err
+	}
+	// Report the original length; zerolog checks it against the event size
+	return len(p), nil
+}
+
+// redactJSON replaces the value of every occurrence of the given field
+// keys in a JSON-encoded event. Scalar values only — the fields worth
+// redacting (cookies, onion addresses, credentials) are strings.
+func redactJSON(line []byte, fields []string) []byte {
+	for _, field := range fields {
+		key := []byte(`"` + field + `":`)
+		offset := 0
+		for {
+			idx := bytes.Index(line[offset:], key)
+			if idx < 0 {
+				break
+			}
+			start := offset + idx + len(key)
+			end := jsonValueEnd(line, start)
+			if end < 0 {
+				break
+			}
+			var buf []byte
+			buf = append(buf, line[:start]...)
+			buf = append(buf, redactedValue...)
+			buf = append(buf, line[end:]...)
+			line = buf
+			offset = start + len(redactedValue)
+		}
+	}
+	return line
+}
+
+// jsonValueEnd returns the index just past the scalar value starting at
+// start, or -1 when it cannot be determined
+func jsonValueEnd(line []byte, start int) int {
+	if start >= len(line) {
+		return -1
+	}
+	if line[start] == '"' {
+		for i := start + 1; i < len(line); i++ {
+			switch line[i] {
+			case '\\':
+				i++
+			case '"':
+				return i + 1
+			}
+		}
+		return -1
+	}
+	for i := start; i < len(line); i++ {
+		if line[i] == ',' || line[i] == '}' {
+			return i
+		}
+	}
+	return -1
+}
+
+// Redact masks a sensitive value for ad-hoc logging, keeping just enough
+// of the ends to correlate entries
+func Redact(value string) string {
+	if len(value) < 8 {
+		return "[REDACTED]"
+	}
+	return value[:3] + "***" + value[len(value)-2:]
+}
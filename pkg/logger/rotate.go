@@ -0,0 +1,171 @@
+package logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat names rotated files down to the nanosecond so two
+// rotations in quick succession never collide
+const backupTimeFormat = "20060102T150405.000000000"
+
+// rotatingWriter is a lumberjack-style size-rotating file writer. When
+// a write would push the file past maxBytes the current file is renamed
+// to a timestamped backup (optionally gzipped) and a fresh one opened;
+// old backups are pruned by count and age.
+type rotatingWriter struct {
+	mu         sync.Mutex
+	path       string
+	perm       os.FileMode
+	maxBytes   int64         // 0 disables rotation
+	maxBackups int           // 0 keeps all backups
+	maxAge     time.Duration // 0 keeps backups forever
+	compress   bool
+	file       *os.File
+	size       int64
+	now        func() time.Time // Injectable for tests
+}
+
+func newRotatingWriter(path string, perm os.FileMode, maxSizeMB, maxBackups, maxAgeDays int, compress bool) (*rotatingWriter, error) {
+	w := &rotatingWriter{
+		path:       path,
+		perm:       perm,
+		maxBytes:   int64(maxSizeMB) * 1024 * 1024,
+		maxBackups: maxBackups,
+		maxAge:     time.Duration(maxAgeDays) * 24 * time.Hour,
+		compress:   compress,
+		now:        time.Now,
+	}
+	if err := w.openLocked(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *rotatingWriter) openLocked() error {
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, w.perm)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.file = file
+	w.size = info.Size()
+	return nil
+}
+
+func (w *rotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.maxBytes > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxBytes {
+		if err := w.rotateLocked(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *rotatingWriter) rotateLocked() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+
+	backup := w.backupName(w.now())
+	if err := os.Rename(w.path, backup); err != nil {
+		return err
+	}
+	if w.compress {
+		// Best effort: a failed compression keeps the plain backup
+		if err := compressFile(backup); err == nil {
+			os.Remove(backup)
+		}
+	}
+
+	w.pruneLocked()
+	return w.openLocked()
+}
+
+// backupName turns "torforge.log" into
+// "torforge-20060102T150405.000000000.log"
+func (w *rotatingWriter) backupName(t time.Time) string {
+	ext := filepath.Ext(w.path)
+	prefix := strings.TrimSuffix(w.path, ext)
+	return fmt.Sprintf("%s-%s%s", prefix, t.Format(backupTimeFormat), ext)
+}
+
+// backups lists existing backup files for this writer, newest first
+func (w *rotatingWriter) backups() []string {
+	ext := filepath.Ext(w.path)
+	prefix := strings.TrimSuffix(w.path, ext)
+
+	matches, _ := filepath.Glob(prefix + "-*" + ext)
+	gzipped, _ := filepath.Glob(prefix + "-*" + ext + ".gz")
+	matches = append(matches, gzipped...)
+
+	// The timestamp in the name sorts lexically, so a reverse sort puts
+	// the newest backup first
+	sort.Sort(sort.Reverse(sort.StringSlice(matches)))
+	return matches
+}
+
+// pruneLocked removes backups beyond the configured count or age
+func (w *rotatingWriter) pruneLocked() {
+	backups := w.backups()
+
+	cutoff := time.Time{}
+	if w.maxAge > 0 {
+		cutoff = w.now().Add(-w.maxAge)
+	}
+
+	for i, backup := range backups {
+		if w.maxBackups > 0 && i >= w.maxBackups {
+			os.Remove(backup)
+			continue
+		}
+		if !cutoff.IsZero() {
+			if info, err := os.Stat(backup); err == nil && info.ModTime().Before(cutoff) {
+				os.Remove(backup)
+			}
+		}
+	}
+}
+
+// compressFile gzips src into src.gz, preserving permissions
+func compressFile(src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	perm := os.FileMode(0644)
+	if info, err := in.Stat(); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	out, err := os.OpenFile(src+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		return err
+	}
+	return gz.Close()
+}
@@ -0,0 +1,72 @@
+package logger
+
+import (
+	"sync"
+	"time"
+)
+
+// sampler enforces a per-second budget for one event type, counting what
+// it drops so the gap is visible in the logs
+type sampler struct {
+	mu         sync.Mutex
+	perSecond  int
+	window     time.Time // Start of the current one-second window
+	count      int
+	suppressed int64
+	now        func() time.Time // Injectable for tests
+}
+
+// samplers maps event type ("connection", "circuit", ...) to its budget
+var samplers sync.Map
+
+// SetSampleRate caps how many events of the given type are logged per
+// second; the rest are dropped and summarised. A rate of 0 or less
+// removes the cap.
+func SetSampleRate(eventType string, perSecond int) {
+	if perSecond <= 0 {
+		samplers.Delete(eventType)
+		return
+	}
+	samplers.Store(eventType, &sampler{perSecond: perSecond, now: time.Now})
+}
+
+// allowEvent reports whether an event of this type fits the current
+// window's budget. When a new window starts it also returns how many
+// events the previous window suppressed, so the caller can log a summary.
+func allowEvent(eventType string) (allowed bool, suppressed int64) {
+	v, ok := samplers.Load(eventType)
+	if !ok {
+		return true, 0
+	}
+	s := v.(*sampler)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if now.Sub(s.window) >= time.Second {
+		suppressed = s.suppressed
+		s.window = now
+		s.count = 0
+		s.suppressed = 0
+	}
+	if s.count < s.perSecond {
+		s.count++
+		return true, suppressed
+	}
+	s.suppressed++
+	return false, suppressed
+}
+
+// sampleEvent applies the budget for eventType, emitting the suppression
+// summary for the window that just closed
+func sampleEvent(eventType string) bool {
+	allowed, suppressed := allowEvent(eventType)
+	if suppressed > 0 {
+		Log.Info().
+			Str("event_type", eventType).
+			Int64("suppressed", suppressed).
+			Msgf("suppressed %d messages", suppressed)
+	}
+	return allowed
+}
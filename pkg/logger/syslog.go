@@ -0,0 +1,24 @@
+//go:build !windows && !plan9
+
+package logger
+
+import (
+	"io"
+	"log/syslog"
+
+	"github.com/rs/zerolog"
+)
+
+// newSyslogWriter connects to the local syslog daemon and wraps the
+// connection so zerolog levels map onto syslog priorities (debug→DEBUG,
+// info→INFO, warn→WARNING, error→ERR, fatal/panic→CRIT/EMERG)
+func newSyslogWriter(tag string) (io.Writer, error) {
+	if tag == "" {
+		tag = "torforge"
+	}
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, err
+	}
+	return zerolog.SyslogLevelWriter(w), nil
+}
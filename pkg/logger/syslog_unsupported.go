@@ -0,0 +1,15 @@
+//go:build windows || plan9
+
+package logger
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+)
+
+// newSyslogWriter reports that syslog is unavailable on this platform so
+// Init can fail gracefully instead of at link time
+func newSyslogWriter(tag string) (io.Writer, error) {
+	return nil, fmt.Errorf("syslog is not supported on %s", runtime.GOOS)
+}